@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ti-community-infra/devstats/internal/pkg/api"
@@ -91,8 +94,10 @@ func main() {
 
 	router.GET("/teams/:team_name/", func(c *gin.Context) {
 		teamName := c.Param("team_name")
-		team, err := teamHandler.GetTeam(teamName)
-		if err != nil {
+		team, err := teamHandler.GetTeam(c.Query("project"), teamName)
+		if teamLookupFailed(c, err, teamName) {
+			return
+		} else if err != nil {
 			msg := fmt.Sprintf("Failed to get team %s.", teamName)
 			api.ErrorMsgf(c, 500, err, msg)
 			return
@@ -100,6 +105,49 @@ func main() {
 		c.JSON(http.StatusOK, &team)
 	})
 
+	router.GET("/teams/:team_name/metrics/", func(c *gin.Context) {
+		teamName := c.Param("team_name")
+		// The period ending now is truncated to the hour, so that the cached metrics are shared by the requests.
+		now := time.Now().Truncate(time.Hour)
+		from, to, err := identifier.ParseTeamMetricsPeriod(c.Query("from"), c.Query("to"), ctx.TeamMetricsDays, now)
+		if err != nil {
+			api.ErrorMsgf(c, 400, err, "Wrong period parameters.")
+			return
+		}
+		inactiveDays := ctx.TeamInactiveDays
+		if value, ok := c.GetQuery("inactive_days"); ok {
+			inactiveDays, err = strconv.Atoi(value)
+			if err != nil || inactiveDays < 0 {
+				e := fmt.Errorf("wrong inactive_days parameter: %s", value)
+				api.ErrorMsgf(c, 400, e, "Wrong inactive_days parameter, it must be a non-negative integer.")
+				return
+			}
+		}
+
+		metrics, err := teamHandler.GetTeamMetrics(c.Query("project"), teamName, from, to, inactiveDays)
+		if teamLookupFailed(c, err, teamName) {
+			return
+		} else if err != nil {
+			msg := fmt.Sprintf("Failed to get metrics of team %s.", teamName)
+			api.ErrorMsgf(c, 500, err, msg)
+			return
+		}
+		c.JSON(http.StatusOK, metrics)
+	})
+
+	router.GET("/teams/:team_name/promotions/", func(c *gin.Context) {
+		teamName := c.Param("team_name")
+		report, err := teamHandler.GetPromotionCandidates(c.Query("project"), teamName, ctx.PromotionRulesPath)
+		if teamLookupFailed(c, err, teamName) {
+			return
+		} else if err != nil {
+			msg := fmt.Sprintf("Failed to get promotion candidates of team %s.", teamName)
+			api.ErrorMsgf(c, 500, err, msg)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
 	// Handle /members endpoint.
 	router.GET("/members/", func(c *gin.Context) {
 		// Check if the level parameter.
@@ -155,6 +203,156 @@ func main() {
 		c.JSON(http.StatusOK, &contributors)
 	})
 
+	// Handle /organizations endpoint.
+	organizationHandler := api.OrganizationHandler{}
+	organizationHandler.Init(identifierDB, projectDBs, ctx.DevstatsAPIBaseURL)
+
+	parseOrgQuery := func(c *gin.Context) (time.Time, bool, bool) {
+		date := time.Now()
+		if value, ok := c.GetQuery("date"); ok {
+			parsed, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				api.ErrorMsgf(c, 400, err, "Wrong date parameter, date must be in the format of `2006-01-02`.")
+				return date, false, false
+			}
+			date = parsed
+		}
+
+		// Notice: The subsidiaries are rolled up to the parent by default.
+		rollup := true
+		if value, ok := c.GetQuery("rollup"); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				api.ErrorMsgf(c, 400, err, "Wrong rollup parameter, rollup must be `true` or `false`.")
+				return date, false, false
+			}
+			rollup = parsed
+		}
+
+		return date, rollup, true
+	}
+
+	router.GET("/organizations/", func(c *gin.Context) {
+		date, rollup, ok := parseOrgQuery(c)
+		if !ok {
+			return
+		}
+
+		organizations, err := organizationHandler.GetOrganizations(date, rollup)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get organizations.")
+			api.ErrorMsgf(c, 500, err, msg)
+			return
+		}
+		c.JSON(http.StatusOK, &organizations)
+	})
+
+	router.GET("/organizations/:org_name/", func(c *gin.Context) {
+		orgName := c.Param("org_name")
+		date, rollup, ok := parseOrgQuery(c)
+		if !ok {
+			return
+		}
+
+		organization, err := organizationHandler.GetOrganization(orgName, date, rollup)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get organization %s.", orgName)
+			api.ErrorMsgf(c, 500, err, msg)
+			return
+		}
+		c.JSON(http.StatusOK, &organization)
+	})
+
+	// Handle /identities endpoint.
+	identityHandler := api.IdentityHandler{}
+	identityHandler.Init(identifierDB, projectDBs, ctx.DevstatsAPIBaseURL)
+
+	router.POST("/identities/opt-out/", func(c *gin.Context) {
+		// Notice: The endpoint erases the personal information, so it is only available with the admin token.
+		if len(ctx.APIAdminToken) == 0 ||
+			subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+ctx.APIAdminToken)) != 1 {
+			api.ErrorMsgf(c, 403, nil, "The admin token is required.")
+			return
+		}
+
+		var req identifier.OptOutRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil || (len(req.UUID) == 0 && len(req.GitHubLogin) == 0) {
+			api.ErrorMsgf(c, 400, err, "Wrong request body, uuid or github_login is required.")
+			return
+		}
+
+		result, err := identityHandler.OptOut(req)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to opt out the identity.")
+			api.ErrorMsgf(c, 500, err, msg)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// Handle /quality endpoint.
+	qualityHandler := api.QualityHandler{}
+	qualityHandler.Init(identifierDB, projectDBs)
+
+	router.GET("/quality/", func(c *gin.Context) {
+		params := map[string]int{
+			"active_days": ctx.QualityActiveDays,
+			"stale_days":  ctx.QualityStaleDays,
+			"top_n":       ctx.QualityTopN,
+		}
+		limits := map[string]int{
+			"active_days": identifier.MaxQualityActiveDays,
+			"top_n":       identifier.MaxQualityTopN,
+		}
+		for name := range params {
+			value, ok := c.GetQuery(name)
+			if !ok {
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				e := fmt.Errorf("wrong %s parameter: %s", name, value)
+				api.ErrorMsgf(c, 400, e, "Wrong %s parameter, it must be a non-negative integer.", name)
+				return
+			}
+			if limit, ok := limits[name]; ok && n > limit {
+				e := fmt.Errorf("wrong %s parameter: %s", name, value)
+				api.ErrorMsgf(c, 400, e, "Wrong %s parameter, it must not be greater than %d.", name, limit)
+				return
+			}
+			params[name] = n
+		}
+		// The defaults from the environment are bounded too.
+		for name, limit := range limits {
+			if params[name] > limit {
+				params[name] = limit
+			}
+		}
+
+		report, err := qualityHandler.GetQuality(params["active_days"], params["stale_days"], params["top_n"])
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get data quality report.")
+			api.ErrorMsgf(c, 500, err, msg)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
 	err = router.Run()
 	lib.FatalOnError(err)
 }
+
+// teamLookupFailed - respond 404 to the unknown team and 409 to the team name used by more than one project, which
+// requires the project query parameter.
+func teamLookupFailed(c *gin.Context, err error, teamName string) bool {
+	if errors.Is(err, identifier.ErrTeamNotFound) {
+		api.ErrorMsgf(c, 404, err, "Team %s is not found.", teamName)
+		return true
+	}
+	if errors.Is(err, identifier.ErrTeamAmbiguous) {
+		api.ErrorMsgf(c, 409, err, "Team %s exists in more than one project, the project parameter is required.", teamName)
+		return true
+	}
+	return false
+}
@@ -1,17 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
-	"net/http"
 	"os"
 	"regexp"
-	"runtime"
-	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
@@ -21,207 +21,292 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+// rowWriter - destination of the rows of GHA events
+// into is "table(col1, col2, ..., colN)" and the values are given in the order of the columns
+type rowWriter interface {
+	insert(into string, args ...interface{})
+	insertIgnore(into string, args ...interface{})
+}
+
+// sqlWriter - insert rows one by one, inside the transaction once it is started (old pre 2015 format)
+type sqlWriter struct {
+	ctx *lib.Ctx
+	db  *sql.DB
+	con *sql.Tx
+}
+
+func (w *sqlWriter) exec(query string, args []interface{}) {
+	if w.con != nil {
+		lib.ExecSQLTxWithErr(w.con, w.ctx, query, args...)
+		return
+	}
+	lib.ExecSQLWithErr(w.db, w.ctx, query, args...)
+}
+
+func (w *sqlWriter) insert(into string, args ...interface{}) {
+	w.exec("insert into "+into+" "+lib.NValues(len(args)), args)
+}
+
+func (w *sqlWriter) insertIgnore(into string, args ...interface{}) {
+	w.exec(lib.InsertIgnore("into "+into+" "+lib.NValues(len(args))), args)
+}
+
+// ghaBatch - rows of GHA events written by multi-row inserts in a single transaction every ctx.WriteBatch events
+// Events and labels not written yet are remembered, so they are found the same way as the ones already in the DB
+// Rows inserted without "on conflict do nothing" are keyed by the current event, so the event written by another
+// process meanwhile can be dropped from the batch when it is written
+type ghaBatch struct {
+	ctx     *lib.Ctx
+	db      *sql.DB
+	rows    lib.BatchInsert
+	events  map[string]struct{}
+	labels  map[[2]string]interface{}
+	current string
+}
+
+func newGHABatch(db *sql.DB, ctx *lib.Ctx) *ghaBatch {
+	return &ghaBatch{
+		ctx:    ctx,
+		db:     db,
+		events: make(map[string]struct{}),
+		labels: make(map[[2]string]interface{}),
+	}
+}
+
+func (b *ghaBatch) insert(into string, args ...interface{}) {
+	b.rows.AddKeyed(b.current, into, false, args...)
+}
+
+func (b *ghaBatch) insertIgnore(into string, args ...interface{}) {
+	b.rows.Add(into, true, args...)
+}
+
+// addLabel - remember ID of the label inserted by the batch, first one wins
+func (b *ghaBatch) addLabel(name, color string, lid interface{}) {
+	key := [2]string{name, color}
+	if _, ok := b.labels[key]; !ok {
+		b.labels[key] = lid
+	}
+}
+
+// startEvent - rows added from now on belong to the event
+func (b *ghaBatch) startEvent(eventID string) {
+	b.current = eventID
+}
+
+// addEvent - all rows of the event are added, write the batch when it is full
+func (b *ghaBatch) addEvent(eventID string) {
+	b.events[eventID] = struct{}{}
+	b.current = ""
+	if len(b.events) >= b.ctx.WriteBatch {
+		b.flush()
+	}
+}
+
+// hasEvent - check if the event is already added to the batch
+func (b *ghaBatch) hasEvent(eventID string) bool {
+	_, ok := b.events[eventID]
+	return ok
+}
+
+// flush - write all the rows added so far
+// Events are checked again in the transaction, the ones written since they were added are dropped from the batch
+func (b *ghaBatch) flush() {
+	b.rows.FlushWith(b.db, b.ctx, func(con *sql.Tx) {
+		existing := existingEvents(con, b.ctx, b.events)
+		if len(existing) == 0 {
+			return
+		}
+		n := b.rows.Drop(existing)
+		lib.Printf("%d events were already written, skipped %d rows\n", len(existing), n)
+	})
+	b.events = make(map[string]struct{})
+	b.labels = make(map[[2]string]interface{})
+}
+
 // Inserts single GHA Actor
-func ghaActor(con *sql.Tx, ctx *lib.Ctx, actor *lib.Actor, maybeHide func(string) string) {
+func ghaActor(w rowWriter, ctx *lib.Ctx, actor *lib.Actor, maybeHide func(string) string) {
 	// gha_actors
 	// {"id:Fixnum"=>48592, "login:String"=>48592, "display_login:String"=>48592,
 	// "gravatar_id:String"=>48592, "url:String"=>48592, "avatar_url:String"=>48592}
 	// {"id"=>8, "login"=>34, "display_login"=>34, "gravatar_id"=>0, "url"=>63, "avatar_url"=>49}
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		lib.InsertIgnore("into gha_actors(id, login, name) "+lib.NValues(3)),
-		lib.AnyArray{actor.ID, maybeHide(actor.Login), ""}...,
+	w.insertIgnore(
+		"gha_actors(id, login, name)",
+		actor.ID, maybeHide(actor.Login), "",
 	)
 }
 
 // Inserts single GHA Repo
-func ghaRepo(db *sql.DB, ctx *lib.Ctx, repo *lib.Repo, orgID, orgLogin interface{}) {
+func ghaRepo(w rowWriter, ctx *lib.Ctx, repo *lib.Repo, orgID, orgLogin interface{}) {
 	// gha_repos
 	// {"id:Fixnum"=>48592, "name:String"=>48592, "url:String"=>48592}
 	// {"id"=>8, "name"=>111, "url"=>140}
-	lib.ExecSQLWithErr(
-		db,
-		ctx,
-		lib.InsertIgnore("into gha_repos(id, name, org_id, org_login) "+lib.NValues(4)),
-		lib.AnyArray{repo.ID, repo.Name, orgID, orgLogin}...,
+	w.insertIgnore(
+		"gha_repos(id, name, org_id, org_login)",
+		repo.ID, repo.Name, orgID, orgLogin,
 	)
 }
 
 // Inserts single GHA Org
-func ghaOrg(db *sql.DB, ctx *lib.Ctx, org *lib.Org) {
+func ghaOrg(w rowWriter, ctx *lib.Ctx, org *lib.Org) {
 	// gha_orgs
 	// {"id:Fixnum"=>18494, "login:String"=>18494, "gravatar_id:String"=>18494,
 	// "url:String"=>18494, "avatar_url:String"=>18494}
 	// {"id"=>8, "login"=>38, "gravatar_id"=>0, "url"=>66, "avatar_url"=>49}
 	if org != nil {
-		lib.ExecSQLWithErr(
-			db,
-			ctx,
-			lib.InsertIgnore("into gha_orgs(id, login) "+lib.NValues(2)),
-			lib.AnyArray{org.ID, org.Login}...,
+		w.insertIgnore(
+			"gha_orgs(id, login)",
+			org.ID, org.Login,
 		)
 	}
 }
 
 // Inserts single GHA Milestone
-func ghaMilestone(con *sql.Tx, ctx *lib.Ctx, eid string, milestone *lib.Milestone, ev *lib.Event, maybeHide func(string) string) {
+func ghaMilestone(w rowWriter, ctx *lib.Ctx, eid string, milestone *lib.Milestone, ev *lib.Event, maybeHide func(string) string) {
 	// creator
 	if milestone.Creator != nil {
-		ghaActor(con, ctx, milestone.Creator, maybeHide)
+		ghaActor(w, ctx, milestone.Creator, maybeHide)
 	}
 
 	// gha_milestones
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		"insert into gha_milestones("+
+	w.insert(
+		"gha_milestones("+
 			"id, event_id, closed_at, closed_issues, created_at, creator_id, "+
 			"description, due_on, number, open_issues, state, title, updated_at, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-			"dupn_creator_login) "+lib.NValues(20),
-		lib.AnyArray{
-			milestone.ID,
-			eid,
-			lib.TimeOrNil(milestone.ClosedAt),
-			milestone.ClosedIssues,
-			milestone.CreatedAt,
-			lib.ActorIDOrNil(milestone.Creator),
-			lib.TruncStringOrNil(milestone.Description, 0xffff),
-			lib.TimeOrNil(milestone.DueOn),
-			milestone.Number,
-			milestone.OpenIssues,
-			milestone.State,
-			lib.TruncToBytes(milestone.Title, 200),
-			milestone.UpdatedAt,
-			ev.Actor.ID,
-			maybeHide(ev.Actor.Login),
-			ev.Repo.ID,
-			ev.Repo.Name,
-			ev.Type,
-			ev.CreatedAt,
-			lib.ActorLoginOrNil(milestone.Creator, maybeHide),
-		}...,
+			"dupn_creator_login)",
+		milestone.ID,
+		eid,
+		lib.TimeOrNil(milestone.ClosedAt),
+		milestone.ClosedIssues,
+		milestone.CreatedAt,
+		lib.ActorIDOrNil(milestone.Creator),
+		lib.TruncStringOrNil(milestone.Description, 0xffff),
+		lib.TimeOrNil(milestone.DueOn),
+		milestone.Number,
+		milestone.OpenIssues,
+		milestone.State,
+		lib.TruncToBytes(milestone.Title, 200),
+		milestone.UpdatedAt,
+		ev.Actor.ID,
+		maybeHide(ev.Actor.Login),
+		ev.Repo.ID,
+		ev.Repo.Name,
+		ev.Type,
+		ev.CreatedAt,
+		lib.ActorLoginOrNil(milestone.Creator, maybeHide),
 	)
 }
 
 // Inserts single GHA Forkee (old format < 2015)
-func ghaForkeeOld(con *sql.Tx, ctx *lib.Ctx, eid string, forkee *lib.ForkeeOld, actor *lib.Actor, repo *lib.Repo, ev *lib.EventOld, maybeHide func(string) string) {
+func ghaForkeeOld(w *sqlWriter, ctx *lib.Ctx, eid string, forkee *lib.ForkeeOld, actor *lib.Actor, repo *lib.Repo, ev *lib.EventOld, maybeHide func(string) string) {
 	// Lookup author by GitHub login
-	aid := lookupActorTx(con, ctx, forkee.Owner, maybeHide)
+	aid := lookupActorTx(w.con, ctx, forkee.Owner, maybeHide)
 
 	// Owner
 	owner := lib.Actor{ID: aid, Login: forkee.Owner}
-	ghaActor(con, ctx, &owner, maybeHide)
+	ghaActor(w, ctx, &owner, maybeHide)
 
 	// gha_forkees
 	// Table details and analysis in `analysis/analysis.txt` and `analysis/forkee_*.json`
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		"insert into gha_forkees("+
+	w.insert(
+		"gha_forkees("+
 			"id, event_id, name, full_name, owner_id, description, fork, "+
 			"created_at, updated_at, pushed_at, homepage, size, language, organization, "+
 			"stargazers_count, has_issues, has_projects, has_downloads, "+
 			"has_wiki, has_pages, forks, default_branch, open_issues, watchers, public, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-			"dup_owner_login) "+lib.NValues(32),
-		lib.AnyArray{
-			forkee.ID,
-			eid,
-			lib.TruncToBytes(forkee.Name, 80),
-			lib.TruncToBytes(forkee.Name, 200), // ForkeeOld has no FullName
-			owner.ID,
-			lib.TruncStringOrNil(forkee.Description, 0xffff),
-			forkee.Fork,
-			forkee.CreatedAt,
-			forkee.CreatedAt, // ForkeeOld has no UpdatedAt
-			lib.TimeOrNil(forkee.PushedAt),
-			lib.StringOrNil(forkee.Homepage),
-			forkee.Size,
-			lib.StringOrNil(forkee.Language),
-			lib.StringOrNil(forkee.Organization),
-			forkee.Stargazers,
-			forkee.HasIssues,
-			nil,
-			forkee.HasDownloads,
-			forkee.HasWiki,
-			nil,
-			forkee.Forks,
-			lib.TruncToBytes(forkee.DefaultBranch, 200),
-			forkee.OpenIssues,
-			forkee.Watchers,
-			lib.NegatedBoolOrNil(forkee.Private),
-			actor.ID,
-			maybeHide(actor.Login),
-			repo.ID,
-			repo.Name,
-			ev.Type,
-			ev.CreatedAt,
-			maybeHide(owner.Login),
-		}...,
+			"dup_owner_login)",
+		forkee.ID,
+		eid,
+		lib.TruncToBytes(forkee.Name, 80),
+		lib.TruncToBytes(forkee.Name, 200), // ForkeeOld has no FullName
+		owner.ID,
+		lib.TruncStringOrNil(forkee.Description, 0xffff),
+		forkee.Fork,
+		forkee.CreatedAt,
+		forkee.CreatedAt, // ForkeeOld has no UpdatedAt
+		lib.TimeOrNil(forkee.PushedAt),
+		lib.StringOrNil(forkee.Homepage),
+		forkee.Size,
+		lib.StringOrNil(forkee.Language),
+		lib.StringOrNil(forkee.Organization),
+		forkee.Stargazers,
+		forkee.HasIssues,
+		nil,
+		forkee.HasDownloads,
+		forkee.HasWiki,
+		nil,
+		forkee.Forks,
+		lib.TruncToBytes(forkee.DefaultBranch, 200),
+		forkee.OpenIssues,
+		forkee.Watchers,
+		lib.NegatedBoolOrNil(forkee.Private),
+		actor.ID,
+		maybeHide(actor.Login),
+		repo.ID,
+		repo.Name,
+		ev.Type,
+		ev.CreatedAt,
+		maybeHide(owner.Login),
 	)
 }
 
 // Inserts single GHA Forkee
-func ghaForkee(con *sql.Tx, ctx *lib.Ctx, eid string, forkee *lib.Forkee, ev *lib.Event, maybeHide func(string) string) {
+func ghaForkee(w rowWriter, ctx *lib.Ctx, eid string, forkee *lib.Forkee, ev *lib.Event, maybeHide func(string) string) {
 	// owner
-	ghaActor(con, ctx, &forkee.Owner, maybeHide)
+	ghaActor(w, ctx, &forkee.Owner, maybeHide)
 
 	// gha_forkees
 	// Table details and analysis in `analysis/analysis.txt` and `analysis/forkee_*.json`
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		"insert into gha_forkees("+
+	w.insert(
+		"gha_forkees("+
 			"id, event_id, name, full_name, owner_id, description, fork, "+
 			"created_at, updated_at, pushed_at, homepage, size, language, organization, "+
 			"stargazers_count, has_issues, has_projects, has_downloads, "+
 			"has_wiki, has_pages, forks, default_branch, open_issues, watchers, public, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-			"dup_owner_login) "+lib.NValues(32),
-		lib.AnyArray{
-			forkee.ID,
-			eid,
-			lib.TruncToBytes(forkee.Name, 80),
-			lib.TruncToBytes(forkee.FullName, 200),
-			forkee.Owner.ID,
-			lib.TruncStringOrNil(forkee.Description, 0xffff),
-			forkee.Fork,
-			forkee.CreatedAt,
-			forkee.UpdatedAt,
-			lib.TimeOrNil(forkee.PushedAt),
-			lib.StringOrNil(forkee.Homepage),
-			forkee.Size,
-			nil,
-			nil,
-			forkee.StargazersCount,
-			forkee.HasIssues,
-			lib.BoolOrNil(forkee.HasProjects),
-			forkee.HasDownloads,
-			forkee.HasWiki,
-			lib.BoolOrNil(forkee.HasPages),
-			forkee.Forks,
-			lib.TruncToBytes(forkee.DefaultBranch, 200),
-			forkee.OpenIssues,
-			forkee.Watchers,
-			lib.BoolOrNil(forkee.Public),
-			ev.Actor.ID,
-			maybeHide(ev.Actor.Login),
-			ev.Repo.ID,
-			ev.Repo.Name,
-			ev.Type,
-			ev.CreatedAt,
-			maybeHide(forkee.Owner.Login),
-		}...,
+			"dup_owner_login)",
+		forkee.ID,
+		eid,
+		lib.TruncToBytes(forkee.Name, 80),
+		lib.TruncToBytes(forkee.FullName, 200),
+		forkee.Owner.ID,
+		lib.TruncStringOrNil(forkee.Description, 0xffff),
+		forkee.Fork,
+		forkee.CreatedAt,
+		forkee.UpdatedAt,
+		lib.TimeOrNil(forkee.PushedAt),
+		lib.StringOrNil(forkee.Homepage),
+		forkee.Size,
+		nil,
+		nil,
+		forkee.StargazersCount,
+		forkee.HasIssues,
+		lib.BoolOrNil(forkee.HasProjects),
+		forkee.HasDownloads,
+		forkee.HasWiki,
+		lib.BoolOrNil(forkee.HasPages),
+		forkee.Forks,
+		lib.TruncToBytes(forkee.DefaultBranch, 200),
+		forkee.OpenIssues,
+		forkee.Watchers,
+		lib.BoolOrNil(forkee.Public),
+		ev.Actor.ID,
+		maybeHide(ev.Actor.Login),
+		ev.Repo.ID,
+		ev.Repo.Name,
+		ev.Type,
+		ev.CreatedAt,
+		maybeHide(forkee.Owner.Login),
 	)
 }
 
 // Inserts single GHA Branch
-func ghaBranch(con *sql.Tx, ctx *lib.Ctx, eid string, branch *lib.Branch, ev *lib.Event, skipIDs []int, maybeHide func(string) string) {
+func ghaBranch(w rowWriter, ctx *lib.Ctx, eid string, branch *lib.Branch, ev *lib.Event, skipIDs []int, maybeHide func(string) string) {
 	// user
 	if branch.User != nil {
-		ghaActor(con, ctx, branch.User, maybeHide)
+		ghaActor(w, ctx, branch.User, maybeHide)
 	}
 
 	// repo
@@ -235,38 +320,37 @@ func ghaBranch(con *sql.Tx, ctx *lib.Ctx, eid string, branch *lib.Branch, ev *li
 			}
 		}
 		if insert {
-			ghaForkee(con, ctx, eid, branch.Repo, ev, maybeHide)
+			ghaForkee(w, ctx, eid, branch.Repo, ev, maybeHide)
 		}
 	}
 
 	// gha_branches
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		"insert into gha_branches("+
+	w.insert(
+		"gha_branches("+
 			"sha, event_id, user_id, repo_id, label, ref, "+
 			"dup_type, dup_created_at, dupn_user_login, dupn_forkee_name"+
-			") "+lib.NValues(10),
-		lib.AnyArray{
-			branch.SHA,
-			eid,
-			lib.ActorIDOrNil(branch.User),
-			lib.ForkeeIDOrNil(branch.Repo), // GitHub uses JSON "repo" but it conatins Forkee
-			lib.TruncToBytes(branch.Label, 200),
-			lib.TruncToBytes(branch.Ref, 200),
-			ev.Type,
-			ev.CreatedAt,
-			lib.ActorLoginOrNil(branch.User, maybeHide),
-			lib.ForkeeNameOrNil(branch.Repo),
-		}...,
+			")",
+		branch.SHA,
+		eid,
+		lib.ActorIDOrNil(branch.User),
+		lib.ForkeeIDOrNil(branch.Repo), // GitHub uses JSON "repo" but it conatins Forkee
+		lib.TruncToBytes(branch.Label, 200),
+		lib.TruncToBytes(branch.Ref, 200),
+		ev.Type,
+		ev.CreatedAt,
+		lib.ActorLoginOrNil(branch.User, maybeHide),
+		lib.ForkeeNameOrNil(branch.Repo),
 	)
 }
 
-// Search for given label using name & color
+// Search for given label using name & color, labels not written yet come first
 // If not found, return hash as its ID
-func lookupLabel(con *sql.Tx, ctx *lib.Ctx, name string, color string) int {
-	rows := lib.QuerySQLTxWithErr(
-		con,
+func lookupLabel(w *ghaBatch, ctx *lib.Ctx, name string, color string) interface{} {
+	if lid, ok := w.labels[[2]string{name, color}]; ok {
+		return lid
+	}
+	rows := lib.QuerySQLWithErr(
+		w.db,
 		ctx,
 		fmt.Sprintf(
 			"select id from gha_labels where name=%s and color=%s",
@@ -404,185 +488,194 @@ func eventExists(db *sql.DB, ctx *lib.Ctx, eventID string) bool {
 	return exists
 }
 
+// existingEvents - events of the set already in the DB, checked in chunks to keep the number of arguments low
+func existingEvents(con *sql.Tx, ctx *lib.Ctx, events map[string]struct{}) map[string]struct{} {
+	ids := make([]interface{}, 0, len(events))
+	for eventID := range events {
+		ids = append(ids, eventID)
+	}
+	existing := make(map[string]struct{})
+	chunk := 1000
+	for from := 0; from < len(ids); from += chunk {
+		to := from + chunk
+		if to > len(ids) {
+			to = len(ids)
+		}
+		rows := lib.QuerySQLTxWithErr(
+			con,
+			ctx,
+			"select id from gha_events where id in "+lib.NArray(to-from, 0),
+			ids[from:to]...,
+		)
+		var eventID string
+		for rows.Next() {
+			lib.FatalOnError(rows.Scan(&eventID))
+			existing[eventID] = struct{}{}
+		}
+		lib.FatalOnError(rows.Err())
+		lib.FatalOnError(rows.Close())
+	}
+	return existing
+}
+
 // Process GHA pages
 // gha_pages
 // {"page_name:String"=>370, "title:String"=>370, "summary:NilClass"=>370,
 // "action:String"=>370, "sha:String"=>370, "html_url:String"=>370}
 // {"page_name"=>65, "title"=>65, "summary"=>0, "action"=>7, "sha"=>40, "html_url"=>130}
 // 370
-func ghaPages(con *sql.Tx, ctx *lib.Ctx, payloadPages *[]lib.Page, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
+func ghaPages(w rowWriter, ctx *lib.Ctx, payloadPages *[]lib.Page, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
 	pages := []lib.Page{}
 	if payloadPages != nil {
 		pages = *payloadPages
 	}
 	for _, page := range pages {
 		sha := page.SHA
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			lib.InsertIgnore(
-				"into gha_pages(sha, event_id, action, title, "+
-					"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at"+
-					") "+lib.NValues(10)),
-			lib.AnyArray{
-				sha,
-				eventID,
-				page.Action,
-				lib.TruncToBytes(page.Title, 300),
-				actor.ID,
-				maybeHide(actor.Login),
-				repo.ID,
-				repo.Name,
-				eType,
-				eCreatedAt,
-			}...,
+		w.insertIgnore(
+			"gha_pages(sha, event_id, action, title, "+
+				"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at"+
+				")",
+			sha,
+			eventID,
+			page.Action,
+			lib.TruncToBytes(page.Title, 300),
+			actor.ID,
+			maybeHide(actor.Login),
+			repo.ID,
+			repo.Name,
+			eType,
+			eCreatedAt,
 		)
 	}
 }
 
 // gha_comments
 // Table details and analysis in `analysis/analysis.txt` and `analysis/comment_*.json`
-func ghaComment(con *sql.Tx, ctx *lib.Ctx, payloadComment *lib.Comment, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
+func ghaComment(w rowWriter, ctx *lib.Ctx, payloadComment *lib.Comment, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
 	if payloadComment == nil {
 		return
 	}
 	comment := *payloadComment
 
 	// user
-	ghaActor(con, ctx, &comment.User, maybeHide)
+	ghaActor(w, ctx, &comment.User, maybeHide)
 
 	// comment
 	cid := comment.ID
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		lib.InsertIgnore(
-			"into gha_comments("+
-				"id, event_id, body, created_at, updated_at, user_id, "+
-				"commit_id, original_commit_id, diff_hunk, position, "+
-				"original_position, path, pull_request_review_id, line, "+
-				"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-				"dup_user_login) "+lib.NValues(21),
-		),
-		lib.AnyArray{
-			cid,
-			eventID,
-			lib.TruncToBytes(comment.Body, 0xffff),
-			comment.CreatedAt,
-			comment.UpdatedAt,
-			comment.User.ID,
-			lib.StringOrNil(comment.CommitID),
-			lib.StringOrNil(comment.OriginalCommitID),
-			lib.StringOrNil(comment.DiffHunk),
-			lib.IntOrNil(comment.Position),
-			lib.IntOrNil(comment.OriginalPosition),
-			lib.StringOrNil(comment.Path),
-			lib.IntOrNil(comment.PullRequestReviewID),
-			lib.IntOrNil(comment.Line),
-			actor.ID,
-			maybeHide(actor.Login),
-			repo.ID,
-			repo.Name,
-			eType,
-			eCreatedAt,
-			maybeHide(comment.User.Login),
-		}...,
+	w.insertIgnore(
+		"gha_comments("+
+			"id, event_id, body, created_at, updated_at, user_id, "+
+			"commit_id, original_commit_id, diff_hunk, position, "+
+			"original_position, path, pull_request_review_id, line, "+
+			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
+			"dup_user_login)",
+		cid,
+		eventID,
+		lib.TruncToBytes(comment.Body, 0xffff),
+		comment.CreatedAt,
+		comment.UpdatedAt,
+		comment.User.ID,
+		lib.StringOrNil(comment.CommitID),
+		lib.StringOrNil(comment.OriginalCommitID),
+		lib.StringOrNil(comment.DiffHunk),
+		lib.IntOrNil(comment.Position),
+		lib.IntOrNil(comment.OriginalPosition),
+		lib.StringOrNil(comment.Path),
+		lib.IntOrNil(comment.PullRequestReviewID),
+		lib.IntOrNil(comment.Line),
+		actor.ID,
+		maybeHide(actor.Login),
+		repo.ID,
+		repo.Name,
+		eType,
+		eCreatedAt,
+		maybeHide(comment.User.Login),
 	)
 }
 
 // gha_releases
 // Table details and analysis in `analysis/analysis.txt` and `analysis/release_*.json`
-func ghaRelease(con *sql.Tx, ctx *lib.Ctx, payloadRelease *lib.Release, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
+func ghaRelease(w rowWriter, ctx *lib.Ctx, payloadRelease *lib.Release, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
 	if payloadRelease == nil {
 		return
 	}
 	release := *payloadRelease
 
 	// author
-	ghaActor(con, ctx, &release.Author, maybeHide)
+	ghaActor(w, ctx, &release.Author, maybeHide)
 
 	// release
 	rid := release.ID
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		"insert into gha_releases("+
+	w.insert(
+		"gha_releases("+
 			"id, event_id, tag_name, target_commitish, name, draft, "+
 			"author_id, prerelease, created_at, published_at, body, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-			"dup_author_login) "+lib.NValues(18),
-		lib.AnyArray{
-			rid,
-			eventID,
-			lib.TruncToBytes(release.TagName, 200),
-			lib.TruncToBytes(release.TargetCommitish, 200),
-			lib.TruncStringOrNil(release.Name, 200),
-			release.Draft,
-			release.Author.ID,
-			release.Prerelease,
-			release.CreatedAt,
-			lib.TimeOrNil(release.PublishedAt),
-			lib.TruncStringOrNil(release.Body, 0xffff),
-			actor.ID,
-			maybeHide(actor.Login),
-			repo.ID,
-			repo.Name,
-			eType,
-			eCreatedAt,
-			maybeHide(release.Author.Login),
-		}...,
+			"dup_author_login)",
+		rid,
+		eventID,
+		lib.TruncToBytes(release.TagName, 200),
+		lib.TruncToBytes(release.TargetCommitish, 200),
+		lib.TruncStringOrNil(release.Name, 200),
+		release.Draft,
+		release.Author.ID,
+		release.Prerelease,
+		release.CreatedAt,
+		lib.TimeOrNil(release.PublishedAt),
+		lib.TruncStringOrNil(release.Body, 0xffff),
+		actor.ID,
+		maybeHide(actor.Login),
+		repo.ID,
+		repo.Name,
+		eType,
+		eCreatedAt,
+		maybeHide(release.Author.Login),
 	)
 
 	// Assets
 	for _, asset := range release.Assets {
 		// uploader
-		ghaActor(con, ctx, &asset.Uploader, maybeHide)
+		ghaActor(w, ctx, &asset.Uploader, maybeHide)
 
 		// asset
 		aid := asset.ID
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			"insert into gha_assets("+
+		w.insert(
+			"gha_assets("+
 				"id, event_id, name, label, uploader_id, content_type, "+
 				"state, size, download_count, created_at, updated_at, "+
 				"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-				"dup_uploader_login) "+lib.NValues(18),
-			lib.AnyArray{
-				aid,
-				eventID,
-				lib.TruncToBytes(asset.Name, 200),
-				lib.TruncStringOrNil(asset.Label, 120),
-				asset.Uploader.ID,
-				asset.ContentType,
-				asset.State,
-				asset.Size,
-				asset.DownloadCount,
-				asset.CreatedAt,
-				asset.UpdatedAt,
-				actor.ID,
-				maybeHide(actor.Login),
-				repo.ID,
-				repo.Name,
-				eType,
-				eCreatedAt,
-				maybeHide(asset.Uploader.Login),
-			}...,
+				"dup_uploader_login)",
+			aid,
+			eventID,
+			lib.TruncToBytes(asset.Name, 200),
+			lib.TruncStringOrNil(asset.Label, 120),
+			asset.Uploader.ID,
+			asset.ContentType,
+			asset.State,
+			asset.Size,
+			asset.DownloadCount,
+			asset.CreatedAt,
+			asset.UpdatedAt,
+			actor.ID,
+			maybeHide(actor.Login),
+			repo.ID,
+			repo.Name,
+			eType,
+			eCreatedAt,
+			maybeHide(asset.Uploader.Login),
 		)
 
 		// release-asset connection
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			"insert into gha_releases_assets(release_id, event_id, asset_id) "+lib.NValues(3),
-			lib.AnyArray{rid, eventID, aid}...,
+		w.insert(
+			"gha_releases_assets(release_id, event_id, asset_id)",
+			rid, eventID, aid,
 		)
 	}
 }
 
 // gha_pull_requests
 // Table details and analysis in `analysis/analysis.txt` and `analysis/pull_request_*.json`
-func ghaPullRequest(con *sql.Tx, ctx *lib.Ctx, payloadPullRequest *lib.PullRequest, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, forkeeIDsToSkip []int, maybeHide func(string) string) {
+func ghaPullRequest(w rowWriter, ctx *lib.Ctx, payloadPullRequest *lib.PullRequest, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, forkeeIDsToSkip []int, maybeHide func(string) string) {
 	if payloadPullRequest == nil {
 		return
 	}
@@ -591,7 +684,7 @@ func ghaPullRequest(con *sql.Tx, ctx *lib.Ctx, payloadPullRequest *lib.PullReque
 	pr := *payloadPullRequest
 
 	// user
-	ghaActor(con, ctx, &pr.User, maybeHide)
+	ghaActor(w, ctx, &pr.User, maybeHide)
 
 	baseSHA := pr.Base.SHA
 	headSHA := pr.Head.SHA
@@ -601,83 +694,79 @@ func ghaPullRequest(con *sql.Tx, ctx *lib.Ctx, payloadPullRequest *lib.PullReque
 	ev := lib.Event{Actor: *actor, Repo: *repo, Type: eType, CreatedAt: eCreatedAt}
 
 	// base
-	ghaBranch(con, ctx, eventID, &pr.Base, &ev, forkeeIDsToSkip, maybeHide)
+	ghaBranch(w, ctx, eventID, &pr.Base, &ev, forkeeIDsToSkip, maybeHide)
 
 	// head (if different, and skip its repo if defined and the same as base repo)
 	if baseSHA != headSHA {
 		if baseRepoID != nil {
 			forkeeIDsToSkip = append(forkeeIDsToSkip, baseRepoID.(int))
 		}
-		ghaBranch(con, ctx, eventID, &pr.Head, &ev, forkeeIDsToSkip, maybeHide)
+		ghaBranch(w, ctx, eventID, &pr.Head, &ev, forkeeIDsToSkip, maybeHide)
 	}
 
 	// merged_by
 	if pr.MergedBy != nil {
-		ghaActor(con, ctx, pr.MergedBy, maybeHide)
+		ghaActor(w, ctx, pr.MergedBy, maybeHide)
 	}
 
 	// assignee
 	if pr.Assignee != nil {
-		ghaActor(con, ctx, pr.Assignee, maybeHide)
+		ghaActor(w, ctx, pr.Assignee, maybeHide)
 	}
 
 	// milestone
 	if pr.Milestone != nil {
-		ghaMilestone(con, ctx, eventID, pr.Milestone, &ev, maybeHide)
+		ghaMilestone(w, ctx, eventID, pr.Milestone, &ev, maybeHide)
 	}
 
 	// pull_request
 	prid := pr.ID
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		"insert into gha_pull_requests("+
+	w.insert(
+		"gha_pull_requests("+
 			"id, event_id, user_id, base_sha, head_sha, merged_by_id, assignee_id, milestone_id, "+
 			"number, state, locked, title, body, created_at, updated_at, closed_at, merged_at, "+
 			"merge_commit_sha, merged, mergeable, rebaseable, mergeable_state, comments, "+
 			"review_comments, maintainer_can_modify, commits, additions, deletions, changed_files, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-			"dup_user_login, dupn_assignee_login, dupn_merged_by_login) "+lib.NValues(38),
-		lib.AnyArray{
-			prid,
-			eventID,
-			pr.User.ID,
-			baseSHA,
-			headSHA,
-			lib.ActorIDOrNil(pr.MergedBy),
-			lib.ActorIDOrNil(pr.Assignee),
-			lib.MilestoneIDOrNil(pr.Milestone),
-			pr.Number,
-			pr.State,
-			lib.BoolOrNil(pr.Locked),
-			lib.CleanUTF8(pr.Title),
-			lib.TruncStringOrNil(pr.Body, 0xffff),
-			pr.CreatedAt,
-			pr.UpdatedAt,
-			lib.TimeOrNil(pr.ClosedAt),
-			lib.TimeOrNil(pr.MergedAt),
-			lib.StringOrNil(pr.MergeCommitSHA),
-			lib.BoolOrNil(pr.Merged),
-			lib.BoolOrNil(pr.Mergeable),
-			lib.BoolOrNil(pr.Rebaseable),
-			lib.StringOrNil(pr.MergeableState),
-			lib.IntOrNil(pr.Comments),
-			lib.IntOrNil(pr.ReviewComments),
-			lib.BoolOrNil(pr.MaintainerCanModify),
-			lib.IntOrNil(pr.Commits),
-			lib.IntOrNil(pr.Additions),
-			lib.IntOrNil(pr.Deletions),
-			lib.IntOrNil(pr.ChangedFiles),
-			actor.ID,
-			maybeHide(actor.Login),
-			repo.ID,
-			repo.Name,
-			eType,
-			eCreatedAt,
-			maybeHide(pr.User.Login),
-			lib.ActorLoginOrNil(pr.Assignee, maybeHide),
-			lib.ActorLoginOrNil(pr.MergedBy, maybeHide),
-		}...,
+			"dup_user_login, dupn_assignee_login, dupn_merged_by_login)",
+		prid,
+		eventID,
+		pr.User.ID,
+		baseSHA,
+		headSHA,
+		lib.ActorIDOrNil(pr.MergedBy),
+		lib.ActorIDOrNil(pr.Assignee),
+		lib.MilestoneIDOrNil(pr.Milestone),
+		pr.Number,
+		pr.State,
+		lib.BoolOrNil(pr.Locked),
+		lib.CleanUTF8(pr.Title),
+		lib.TruncStringOrNil(pr.Body, 0xffff),
+		pr.CreatedAt,
+		pr.UpdatedAt,
+		lib.TimeOrNil(pr.ClosedAt),
+		lib.TimeOrNil(pr.MergedAt),
+		lib.StringOrNil(pr.MergeCommitSHA),
+		lib.BoolOrNil(pr.Merged),
+		lib.BoolOrNil(pr.Mergeable),
+		lib.BoolOrNil(pr.Rebaseable),
+		lib.StringOrNil(pr.MergeableState),
+		lib.IntOrNil(pr.Comments),
+		lib.IntOrNil(pr.ReviewComments),
+		lib.BoolOrNil(pr.MaintainerCanModify),
+		lib.IntOrNil(pr.Commits),
+		lib.IntOrNil(pr.Additions),
+		lib.IntOrNil(pr.Deletions),
+		lib.IntOrNil(pr.ChangedFiles),
+		actor.ID,
+		maybeHide(actor.Login),
+		repo.ID,
+		repo.Name,
+		eType,
+		eCreatedAt,
+		maybeHide(pr.User.Login),
+		lib.ActorLoginOrNil(pr.Assignee, maybeHide),
+		lib.ActorLoginOrNil(pr.MergedBy, maybeHide),
 	)
 
 	// Arrays: actors: assignees, requested_reviewers
@@ -701,14 +790,12 @@ func ghaPullRequest(con *sql.Tx, ctx *lib.Ctx, payloadPullRequest *lib.PullReque
 
 	for _, assignee := range assignees {
 		// assignee
-		ghaActor(con, ctx, &assignee, maybeHide)
+		ghaActor(w, ctx, &assignee, maybeHide)
 
 		// pull_request-assignee connection
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			"insert into gha_pull_requests_assignees(pull_request_id, event_id, assignee_id) "+lib.NValues(3),
-			lib.AnyArray{prid, eventID, assignee.ID}...,
+		w.insert(
+			"gha_pull_requests_assignees(pull_request_id, event_id, assignee_id)",
+			prid, eventID, assignee.ID,
 		)
 	}
 
@@ -716,21 +803,19 @@ func ghaPullRequest(con *sql.Tx, ctx *lib.Ctx, payloadPullRequest *lib.PullReque
 	if pr.RequestedReviewers != nil {
 		for _, reviewer := range *pr.RequestedReviewers {
 			// reviewer
-			ghaActor(con, ctx, &reviewer, maybeHide)
+			ghaActor(w, ctx, &reviewer, maybeHide)
 
 			// pull_request-requested_reviewer connection
-			lib.ExecSQLTxWithErr(
-				con,
-				ctx,
-				"insert into gha_pull_requests_requested_reviewers(pull_request_id, event_id, requested_reviewer_id) "+lib.NValues(3),
-				lib.AnyArray{prid, eventID, reviewer.ID}...,
+			w.insert(
+				"gha_pull_requests_requested_reviewers(pull_request_id, event_id, requested_reviewer_id)",
+				prid, eventID, reviewer.ID,
 			)
 		}
 	}
 }
 
 // gha_teams
-func ghaTeam(con *sql.Tx, ctx *lib.Ctx, payloadTeam *lib.Team, payloadRepo *lib.Forkee, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
+func ghaTeam(w rowWriter, ctx *lib.Ctx, payloadTeam *lib.Team, payloadRepo *lib.Forkee, eventID string, actor *lib.Actor, repo *lib.Repo, eType string, eCreatedAt time.Time, maybeHide func(string) string) {
 	if payloadTeam == nil {
 		return
 	}
@@ -738,35 +823,29 @@ func ghaTeam(con *sql.Tx, ctx *lib.Ctx, payloadTeam *lib.Team, payloadRepo *lib.
 
 	// team
 	tid := team.ID
-	lib.ExecSQLTxWithErr(
-		con,
-		ctx,
-		"insert into gha_teams("+
+	w.insert(
+		"gha_teams("+
 			"id, event_id, name, slug, permission, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at"+
-			") "+lib.NValues(11),
-		lib.AnyArray{
-			tid,
-			eventID,
-			lib.TruncToBytes(team.Name, 120),
-			lib.TruncToBytes(team.Slug, 100),
-			lib.TruncToBytes(team.Permission, 20),
-			actor.ID,
-			maybeHide(actor.Login),
-			repo.ID,
-			repo.Name,
-			eType,
-			eCreatedAt,
-		}...,
+			")",
+		tid,
+		eventID,
+		lib.TruncToBytes(team.Name, 120),
+		lib.TruncToBytes(team.Slug, 100),
+		lib.TruncToBytes(team.Permission, 20),
+		actor.ID,
+		maybeHide(actor.Login),
+		repo.ID,
+		repo.Name,
+		eType,
+		eCreatedAt,
 	)
 
 	// team-repository connection
 	if payloadRepo != nil {
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			"insert into gha_teams_repositories(team_id, event_id, repository_id) "+lib.NValues(3),
-			lib.AnyArray{tid, eventID, payloadRepo.ID}...,
+		w.insert(
+			"gha_teams_repositories(team_id, event_id, repository_id)",
+			tid, eventID, payloadRepo.ID,
 		)
 	}
 }
@@ -780,6 +859,9 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 	// To handle GDPR
 	maybeHide := lib.MaybeHideFunc(shas)
 
+	// Rows are inserted one by one, inside the transaction once it is started
+	w := &sqlWriter{ctx: ctx, db: db}
+
 	// Lookup author by GitHub login
 	aid := lookupActor(db, ctx, ev.Actor, maybeHide)
 	actor := lib.Actor{ID: aid, Login: ev.Actor}
@@ -797,24 +879,20 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 	}
 
 	// We defer transaction create until we're inserting data that can be shared between different events
-	lib.ExecSQLWithErr(
-		db,
-		ctx,
-		"insert into gha_events("+
+	w.insert(
+		"gha_events("+
 			"id, type, actor_id, repo_id, public, created_at, "+
-			"dup_actor_login, dup_repo_name, org_id, forkee_id) "+lib.NValues(10),
-		lib.AnyArray{
-			eventID,
-			ev.Type,
-			aid,
-			rid,
-			ev.Public,
-			ev.CreatedAt,
-			maybeHide(ev.Actor),
-			ev.Repository.Name,
-			oid,
-			ev.Repository.ID,
-		}...,
+			"dup_actor_login, dup_repo_name, org_id, forkee_id)",
+		eventID,
+		ev.Type,
+		aid,
+		rid,
+		ev.Public,
+		ev.CreatedAt,
+		maybeHide(ev.Actor),
+		ev.Repository.Name,
+		oid,
+		ev.Repository.ID,
 	)
 
 	// Organization
@@ -823,12 +901,12 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 			h := lib.HashStrings([]string{*repository.Organization})
 			oid = &h
 		}
-		ghaOrg(db, ctx, &lib.Org{ID: *oid, Login: *repository.Organization})
+		ghaOrg(w, ctx, &lib.Org{ID: *oid, Login: *repository.Organization})
 	}
 
 	// Add Repository
 	repo := lib.Repo{ID: rid, Name: repository.Name}
-	ghaRepo(db, ctx, &repo, oid, repository.Organization)
+	ghaRepo(w, ctx, &repo, oid, repository.Organization)
 
 	// Pre 2015 Payload
 	pl := ev.Payload
@@ -842,49 +920,46 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 		cid = lib.IntOrNil(pl.CommentID)
 	}
 
-	lib.ExecSQLWithErr(
-		db,
-		ctx,
-		"insert into gha_payloads("+
+	w.insert(
+		"gha_payloads("+
 			"event_id, push_id, size, ref, head, befor, action, "+
 			"issue_id, pull_request_id, comment_id, ref_type, master_branch, commit, "+
 			"description, number, forkee_id, release_id, member_id, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at"+
-			") "+lib.NValues(24),
-		lib.AnyArray{
-			eventID,
-			nil,
-			lib.IntOrNil(pl.Size),
-			lib.TruncStringOrNil(pl.Ref, 200),
-			lib.StringOrNil(pl.Head),
-			nil,
-			lib.StringOrNil(pl.Action),
-			iid,
-			lib.PullRequestIDOrNil(pl.PullRequest),
-			cid,
-			lib.StringOrNil(pl.RefType),
-			lib.TruncStringOrNil(pl.MasterBranch, 200),
-			lib.StringOrNil(pl.Commit),
-			lib.TruncStringOrNil(pl.Description, 0xffff),
-			lib.IntOrNil(pl.Number),
-			lib.ForkeeIDOrNil(pl.Repository),
-			lib.ReleaseIDOrNil(pl.Release),
-			lib.ActorIDOrNil(pl.Member),
-			actor.ID,
-			maybeHide(actor.Login),
-			repo.ID,
-			repo.Name,
-			ev.Type,
-			ev.CreatedAt,
-		}...,
+			")",
+		eventID,
+		nil,
+		lib.IntOrNil(pl.Size),
+		lib.TruncStringOrNil(pl.Ref, 200),
+		lib.StringOrNil(pl.Head),
+		nil,
+		lib.StringOrNil(pl.Action),
+		iid,
+		lib.PullRequestIDOrNil(pl.PullRequest),
+		cid,
+		lib.StringOrNil(pl.RefType),
+		lib.TruncStringOrNil(pl.MasterBranch, 200),
+		lib.StringOrNil(pl.Commit),
+		lib.TruncStringOrNil(pl.Description, 0xffff),
+		lib.IntOrNil(pl.Number),
+		lib.ForkeeIDOrNil(pl.Repository),
+		lib.ReleaseIDOrNil(pl.Release),
+		lib.ActorIDOrNil(pl.Member),
+		actor.ID,
+		maybeHide(actor.Login),
+		repo.ID,
+		repo.Name,
+		ev.Type,
+		ev.CreatedAt,
 	)
 
 	// Start transaction for data possibly shared between events
 	con, err := db.Begin()
 	lib.FatalOnError(err)
+	w.con = con
 
 	// gha_actors
-	ghaActor(con, ctx, &actor, maybeHide)
+	ghaActor(w, ctx, &actor, maybeHide)
 
 	// Payload's Forkee (it uses new structure, so I'm giving it precedence over
 	// Event's Forkee (which uses older structure)
@@ -893,12 +968,12 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 		// Artificial event is only used to allow duplicating EventOld's data
 		// (passed as Event to avoid code duplication)
 		artificialEv := lib.Event{Actor: actor, Repo: repo, Type: ev.Type, CreatedAt: ev.CreatedAt}
-		ghaForkee(con, ctx, eventID, pl.Repository, &artificialEv, maybeHide)
+		ghaForkee(w, ctx, eventID, pl.Repository, &artificialEv, maybeHide)
 	}
 
 	// Add Forkee in old mode if we didn't added it from payload or if it is a different Forkee
 	if pl.Repository == nil || pl.Repository.ID != ev.Repository.ID {
-		ghaForkeeOld(con, ctx, eventID, &ev.Repository, &actor, &repo, ev, maybeHide)
+		ghaForkeeOld(w, ctx, eventID, &ev.Repository, &actor, &repo, ev, maybeHide)
 	}
 
 	// SHAs - commits
@@ -913,54 +988,50 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 			if !ok {
 				lib.Fatalf("commit[0] is not string: %+v", commit[0])
 			}
-			lib.ExecSQLTxWithErr(
-				con,
-				ctx,
-				"insert into gha_commits("+
+			w.insert(
+				"gha_commits("+
 					"sha, event_id, author_name, encrypted_email, message, is_distinct, "+
 					"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at"+
-					") "+lib.NValues(12),
-				lib.AnyArray{
-					sha,
-					eventID,
-					maybeHide(lib.TruncToBytes(commit[3].(string), 160)),
-					lib.TruncToBytes(commit[1].(string), 160),
-					lib.TruncToBytes(commit[2].(string), 0xffff),
-					commit[4].(bool),
-					actor.ID,
-					maybeHide(actor.Login),
-					repo.ID,
-					repo.Name,
-					ev.Type,
-					ev.CreatedAt,
-				}...,
+					")",
+				sha,
+				eventID,
+				maybeHide(lib.TruncToBytes(commit[3].(string), 160)),
+				lib.TruncToBytes(commit[1].(string), 160),
+				lib.TruncToBytes(commit[2].(string), 0xffff),
+				commit[4].(bool),
+				actor.ID,
+				maybeHide(actor.Login),
+				repo.ID,
+				repo.Name,
+				ev.Type,
+				ev.CreatedAt,
 			)
 		}
 	}
 
 	// Pages
-	ghaPages(con, ctx, pl.Pages, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
+	ghaPages(w, ctx, pl.Pages, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
 
 	// Member
 	if pl.Member != nil {
-		ghaActor(con, ctx, pl.Member, maybeHide)
+		ghaActor(w, ctx, pl.Member, maybeHide)
 	}
 
 	// Comment
-	ghaComment(con, ctx, pl.Comment, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
+	ghaComment(w, ctx, pl.Comment, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
 
 	// Release & assets
-	ghaRelease(con, ctx, pl.Release, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
+	ghaRelease(w, ctx, pl.Release, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
 
 	// Team & Repo connection
-	ghaTeam(con, ctx, pl.Team, pl.Repository, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
+	ghaTeam(w, ctx, pl.Team, pl.Repository, eventID, &actor, &repo, ev.Type, ev.CreatedAt, maybeHide)
 
 	// Pull Request
 	forkeeIDsToSkip := []int{ev.Repository.ID}
 	if pl.Repository != nil {
 		forkeeIDsToSkip = append(forkeeIDsToSkip, pl.Repository.ID)
 	}
-	ghaPullRequest(con, ctx, pl.PullRequest, eventID, &actor, &repo, ev.Type, ev.CreatedAt, forkeeIDsToSkip, maybeHide)
+	ghaPullRequest(w, ctx, pl.PullRequest, eventID, &actor, &repo, ev.Type, ev.CreatedAt, forkeeIDsToSkip, maybeHide)
 
 	// We need artificial issue
 	// gha_issues
@@ -979,39 +1050,35 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 		if pr.Locked != nil {
 			locked = *pr.Locked
 		}
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			"insert into gha_issues("+
+		w.insert(
+			"gha_issues("+
 				"id, event_id, assignee_id, body, closed_at, comments, created_at, "+
 				"locked, milestone_id, number, state, title, updated_at, user_id, "+
 				"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-				"dup_user_login, dupn_assignee_login, is_pull_request) "+lib.NValues(23),
-			lib.AnyArray{
-				iid,
-				eventID,
-				lib.ActorIDOrNil(pr.Assignee),
-				lib.TruncStringOrNil(pr.Body, 0xffff),
-				lib.TimeOrNil(pr.ClosedAt),
-				comments,
-				pr.CreatedAt,
-				locked,
-				lib.MilestoneIDOrNil(pr.Milestone),
-				pr.Number,
-				pr.State,
-				lib.CleanUTF8(pr.Title),
-				pr.UpdatedAt,
-				pr.User.ID,
-				actor.ID,
-				maybeHide(actor.Login),
-				repo.ID,
-				repo.Name,
-				ev.Type,
-				ev.CreatedAt,
-				maybeHide(pr.User.Login),
-				lib.ActorLoginOrNil(pr.Assignee, maybeHide),
-				isPR,
-			}...,
+				"dup_user_login, dupn_assignee_login, is_pull_request)",
+			iid,
+			eventID,
+			lib.ActorIDOrNil(pr.Assignee),
+			lib.TruncStringOrNil(pr.Body, 0xffff),
+			lib.TimeOrNil(pr.ClosedAt),
+			comments,
+			pr.CreatedAt,
+			locked,
+			lib.MilestoneIDOrNil(pr.Milestone),
+			pr.Number,
+			pr.State,
+			lib.CleanUTF8(pr.Title),
+			pr.UpdatedAt,
+			pr.User.ID,
+			actor.ID,
+			maybeHide(actor.Login),
+			repo.ID,
+			repo.Name,
+			ev.Type,
+			ev.CreatedAt,
+			maybeHide(pr.User.Login),
+			lib.ActorLoginOrNil(pr.Assignee, maybeHide),
+			isPR,
 		)
 
 		var assignees []lib.Actor
@@ -1033,11 +1100,9 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 
 		for _, assignee := range assignees {
 			// pull_request-assignee connection
-			lib.ExecSQLTxWithErr(
-				con,
-				ctx,
-				"insert into gha_issues_assignees(issue_id, event_id, assignee_id) "+lib.NValues(3),
-				lib.AnyArray{iid, eventID, assignee.ID}...,
+			w.insert(
+				"gha_issues_assignees(issue_id, event_id, assignee_id)",
+				iid, eventID, assignee.ID,
 			)
 		}
 	}
@@ -1048,16 +1113,17 @@ func writeToDBOldFmt(db *sql.DB, ctx *lib.Ctx, eventID string, ev *lib.EventOld,
 }
 
 // Write entire GHA event (in a new 2015+ format) into Postgres DB
-func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string) int {
+// Rows are added to the batch, which writes them in a single transaction when it is full
+func writeToDB(w *ghaBatch, ctx *lib.Ctx, ev *lib.Event, shas map[string]string) int {
 	eventID := ev.ID
-	if eventExists(db, ctx, eventID) {
+	if w.hasEvent(eventID) || eventExists(w.db, ctx, eventID) {
 		return 0
 	}
+	w.startEvent(eventID)
 
 	// To handle GDPR
 	maybeHide := lib.MaybeHideFunc(shas)
 
-	// We defer transaction create until we're inserting data that can be shared between different events
 	// gha_events
 	// {"id:String"=>48592, "type:String"=>48592, "actor:Hash"=>48592, "repo:Hash"=>48592,
 	// "payload:Hash"=>48592, "public:TrueClass"=>48592, "created_at:String"=>48592,
@@ -1066,34 +1132,30 @@ func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string)
 	// "created_at"=>20, "org"=>230}
 	// Fields dup_actor_login, dup_repo_name are copied from (gha_actors and gha_repos) to save
 	// joins on complex queries (MySQL has no hash joins and is very slow on big tables joins)
-	lib.ExecSQLWithErr(
-		db,
-		ctx,
-		"insert into gha_events("+
+	w.insert(
+		"gha_events("+
 			"id, type, actor_id, repo_id, public, created_at, "+
-			"dup_actor_login, dup_repo_name, org_id, forkee_id) "+lib.NValues(10),
-		lib.AnyArray{
-			eventID,
-			ev.Type,
-			ev.Actor.ID,
-			ev.Repo.ID,
-			ev.Public,
-			ev.CreatedAt,
-			maybeHide(ev.Actor.Login),
-			ev.Repo.Name,
-			lib.OrgIDOrNil(ev.Org),
-			nil,
-		}...,
+			"dup_actor_login, dup_repo_name, org_id, forkee_id)",
+		eventID,
+		ev.Type,
+		ev.Actor.ID,
+		ev.Repo.ID,
+		ev.Public,
+		ev.CreatedAt,
+		maybeHide(ev.Actor.Login),
+		ev.Repo.Name,
+		lib.OrgIDOrNil(ev.Org),
+		nil,
 	)
 
 	// Repository
 	repo := ev.Repo
 	org := ev.Org
-	ghaRepo(db, ctx, &repo, lib.OrgIDOrNil(org), lib.OrgLoginOrNil(org))
+	ghaRepo(w, ctx, &repo, lib.OrgIDOrNil(org), lib.OrgLoginOrNil(org))
 
 	// Organization
 	if org != nil {
-		ghaOrg(db, ctx, org)
+		ghaOrg(w, ctx, org)
 	}
 
 	// gha_payloads
@@ -1112,49 +1174,41 @@ func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string)
 	// using exec_stmt (without select), because payload are per event_id.
 	// Columns duplicated from gha_events starts with "dup_"
 	pl := ev.Payload
-	lib.ExecSQLWithErr(
-		db,
-		ctx,
-		"insert into gha_payloads("+
+	w.insert(
+		"gha_payloads("+
 			"event_id, push_id, size, ref, head, befor, action, "+
 			"issue_id, pull_request_id, comment_id, ref_type, master_branch, commit, "+
 			"description, number, forkee_id, release_id, member_id, "+
 			"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at"+
-			") "+lib.NValues(24),
-		lib.AnyArray{
-			eventID,
-			lib.IntOrNil(pl.PushID),
-			lib.IntOrNil(pl.Size),
-			lib.TruncStringOrNil(pl.Ref, 200),
-			lib.StringOrNil(pl.Head),
-			lib.StringOrNil(pl.Before),
-			lib.StringOrNil(pl.Action),
-			lib.IssueIDOrNil(pl.Issue),
-			lib.PullRequestIDOrNil(pl.PullRequest),
-			lib.CommentIDOrNil(pl.Comment),
-			lib.StringOrNil(pl.RefType),
-			lib.TruncStringOrNil(pl.MasterBranch, 200),
-			nil,
-			lib.TruncStringOrNil(pl.Description, 0xffff),
-			lib.IntOrNil(pl.Number),
-			lib.ForkeeIDOrNil(pl.Forkee),
-			lib.ReleaseIDOrNil(pl.Release),
-			lib.ActorIDOrNil(pl.Member),
-			ev.Actor.ID,
-			maybeHide(ev.Actor.Login),
-			ev.Repo.ID,
-			ev.Repo.Name,
-			ev.Type,
-			ev.CreatedAt,
-		}...,
+			")",
+		eventID,
+		lib.IntOrNil(pl.PushID),
+		lib.IntOrNil(pl.Size),
+		lib.TruncStringOrNil(pl.Ref, 200),
+		lib.StringOrNil(pl.Head),
+		lib.StringOrNil(pl.Before),
+		lib.StringOrNil(pl.Action),
+		lib.IssueIDOrNil(pl.Issue),
+		lib.PullRequestIDOrNil(pl.PullRequest),
+		lib.CommentIDOrNil(pl.Comment),
+		lib.StringOrNil(pl.RefType),
+		lib.TruncStringOrNil(pl.MasterBranch, 200),
+		nil,
+		lib.TruncStringOrNil(pl.Description, 0xffff),
+		lib.IntOrNil(pl.Number),
+		lib.ForkeeIDOrNil(pl.Forkee),
+		lib.ReleaseIDOrNil(pl.Release),
+		lib.ActorIDOrNil(pl.Member),
+		ev.Actor.ID,
+		maybeHide(ev.Actor.Login),
+		ev.Repo.ID,
+		ev.Repo.Name,
+		ev.Type,
+		ev.CreatedAt,
 	)
 
-	// Start transaction for data possibly shared between events
-	con, err := db.Begin()
-	lib.FatalOnError(err)
-
 	// gha_actors
-	ghaActor(con, ctx, &ev.Actor, maybeHide)
+	ghaActor(w, ctx, &ev.Actor, maybeHide)
 
 	// Make sure that entry is gha_actors is most up-to-date
 	/*
@@ -1186,40 +1240,36 @@ func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string)
 	}
 	for _, commit := range commits {
 		sha := commit.SHA
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			"insert into gha_commits("+
+		w.insert(
+			"gha_commits("+
 				"sha, event_id, author_name, encrypted_email, message, is_distinct, "+
 				"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at"+
-				") "+lib.NValues(12),
-			lib.AnyArray{
-				sha,
-				eventID,
-				maybeHide(lib.TruncToBytes(commit.Author.Name, 160)),
-				lib.TruncToBytes(commit.Author.Email, 160),
-				lib.TruncToBytes(commit.Message, 0xffff),
-				commit.Distinct,
-				ev.Actor.ID,
-				maybeHide(ev.Actor.Login),
-				ev.Repo.ID,
-				ev.Repo.Name,
-				ev.Type,
-				ev.CreatedAt,
-			}...,
+				")",
+			sha,
+			eventID,
+			maybeHide(lib.TruncToBytes(commit.Author.Name, 160)),
+			lib.TruncToBytes(commit.Author.Email, 160),
+			lib.TruncToBytes(commit.Message, 0xffff),
+			commit.Distinct,
+			ev.Actor.ID,
+			maybeHide(ev.Actor.Login),
+			ev.Repo.ID,
+			ev.Repo.Name,
+			ev.Type,
+			ev.CreatedAt,
 		)
 	}
 
 	// Pages
-	ghaPages(con, ctx, pl.Pages, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, maybeHide)
+	ghaPages(w, ctx, pl.Pages, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, maybeHide)
 
 	// Member
 	if pl.Member != nil {
-		ghaActor(con, ctx, pl.Member, maybeHide)
+		ghaActor(w, ctx, pl.Member, maybeHide)
 	}
 
 	// Comment
-	ghaComment(con, ctx, pl.Comment, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, maybeHide)
+	ghaComment(w, ctx, pl.Comment, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, maybeHide)
 
 	// gha_issues
 	// Table details and analysis in `analysis/analysis.txt` and `analysis/issue_*.json`
@@ -1227,9 +1277,9 @@ func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string)
 		issue := *pl.Issue
 
 		// user, assignee
-		ghaActor(con, ctx, &issue.User, maybeHide)
+		ghaActor(w, ctx, &issue.User, maybeHide)
 		if issue.Assignee != nil {
-			ghaActor(con, ctx, issue.Assignee, maybeHide)
+			ghaActor(w, ctx, issue.Assignee, maybeHide)
 		}
 
 		// issue
@@ -1238,44 +1288,40 @@ func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string)
 		if issue.PullRequest != nil {
 			isPR = true
 		}
-		lib.ExecSQLTxWithErr(
-			con,
-			ctx,
-			"insert into gha_issues("+
+		w.insert(
+			"gha_issues("+
 				"id, event_id, assignee_id, body, closed_at, comments, created_at, "+
 				"locked, milestone_id, number, state, title, updated_at, user_id, "+
 				"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-				"dup_user_login, dupn_assignee_login, is_pull_request) "+lib.NValues(23),
-			lib.AnyArray{
-				iid,
-				eventID,
-				lib.ActorIDOrNil(issue.Assignee),
-				lib.TruncStringOrNil(issue.Body, 0xffff),
-				lib.TimeOrNil(issue.ClosedAt),
-				issue.Comments,
-				issue.CreatedAt,
-				issue.Locked,
-				lib.MilestoneIDOrNil(issue.Milestone),
-				issue.Number,
-				issue.State,
-				lib.CleanUTF8(issue.Title),
-				issue.UpdatedAt,
-				issue.User.ID,
-				ev.Actor.ID,
-				maybeHide(ev.Actor.Login),
-				ev.Repo.ID,
-				ev.Repo.Name,
-				ev.Type,
-				ev.CreatedAt,
-				maybeHide(issue.User.Login),
-				lib.ActorLoginOrNil(issue.Assignee, maybeHide),
-				isPR,
-			}...,
+				"dup_user_login, dupn_assignee_login, is_pull_request)",
+			iid,
+			eventID,
+			lib.ActorIDOrNil(issue.Assignee),
+			lib.TruncStringOrNil(issue.Body, 0xffff),
+			lib.TimeOrNil(issue.ClosedAt),
+			issue.Comments,
+			issue.CreatedAt,
+			issue.Locked,
+			lib.MilestoneIDOrNil(issue.Milestone),
+			issue.Number,
+			issue.State,
+			lib.CleanUTF8(issue.Title),
+			issue.UpdatedAt,
+			issue.User.ID,
+			ev.Actor.ID,
+			maybeHide(ev.Actor.Login),
+			ev.Repo.ID,
+			ev.Repo.Name,
+			ev.Type,
+			ev.CreatedAt,
+			maybeHide(issue.User.Login),
+			lib.ActorLoginOrNil(issue.Assignee, maybeHide),
+			isPR,
 		)
 
 		// milestone
 		if issue.Milestone != nil {
-			ghaMilestone(con, ctx, eventID, issue.Milestone, ev, maybeHide)
+			ghaMilestone(w, ctx, eventID, issue.Milestone, ev, maybeHide)
 		}
 
 		pAid := lib.ActorIDOrNil(issue.Assignee)
@@ -1286,14 +1332,12 @@ func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string)
 			}
 
 			// assignee
-			ghaActor(con, ctx, &assignee, maybeHide)
+			ghaActor(w, ctx, &assignee, maybeHide)
 
 			// issue-assignee connection
-			lib.ExecSQLTxWithErr(
-				con,
-				ctx,
-				"insert into gha_issues_assignees(issue_id, event_id, assignee_id) "+lib.NValues(3),
-				lib.AnyArray{iid, eventID, aid}...,
+			w.insert(
+				"gha_issues_assignees(issue_id, event_id, assignee_id)",
+				iid, eventID, aid,
 			)
 		}
 
@@ -1301,65 +1345,59 @@ func writeToDB(db *sql.DB, ctx *lib.Ctx, ev *lib.Event, shas map[string]string)
 		for _, label := range issue.Labels {
 			lid := lib.IntOrNil(label.ID)
 			if lid == nil {
-				lid = lookupLabel(con, ctx, lib.TruncToBytes(label.Name, 160), label.Color)
+				lid = lookupLabel(w, ctx, lib.TruncToBytes(label.Name, 160), label.Color)
 			}
 
 			// label
-			lib.ExecSQLTxWithErr(
-				con,
-				ctx,
-				lib.InsertIgnore("into gha_labels(id, name, color, is_default) "+lib.NValues(4)),
-				lib.AnyArray{lid, lib.TruncToBytes(label.Name, 160), label.Color, lib.BoolOrNil(label.Default)}...,
+			w.insertIgnore(
+				"gha_labels(id, name, color, is_default)",
+				lid, lib.TruncToBytes(label.Name, 160), label.Color, lib.BoolOrNil(label.Default),
 			)
+			w.addLabel(lib.TruncToBytes(label.Name, 160), label.Color, lid)
 
 			// issue-label connection
-			lib.ExecSQLTxWithErr(
-				con,
-				ctx,
-				lib.InsertIgnore(
-					"into gha_issues_labels(issue_id, event_id, label_id, "+
-						"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
-						"dup_issue_number, dup_label_name"+
-						") "+lib.NValues(11)),
-				lib.AnyArray{
-					iid,
-					eventID,
-					lid,
-					ev.Actor.ID,
-					maybeHide(ev.Actor.Login),
-					ev.Repo.ID,
-					ev.Repo.Name,
-					ev.Type,
-					ev.CreatedAt,
-					issue.Number,
-					label.Name,
-				}...,
+			w.insertIgnore(
+				"gha_issues_labels(issue_id, event_id, label_id, "+
+					"dup_actor_id, dup_actor_login, dup_repo_id, dup_repo_name, dup_type, dup_created_at, "+
+					"dup_issue_number, dup_label_name"+
+					")",
+				iid,
+				eventID,
+				lid,
+				ev.Actor.ID,
+				maybeHide(ev.Actor.Login),
+				ev.Repo.ID,
+				ev.Repo.Name,
+				ev.Type,
+				ev.CreatedAt,
+				issue.Number,
+				label.Name,
 			)
 		}
 	}
 
 	// gha_forkees
 	if pl.Forkee != nil {
-		ghaForkee(con, ctx, eventID, pl.Forkee, ev, maybeHide)
+		ghaForkee(w, ctx, eventID, pl.Forkee, ev, maybeHide)
 	}
 
 	// Release & assets
-	ghaRelease(con, ctx, pl.Release, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, maybeHide)
+	ghaRelease(w, ctx, pl.Release, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, maybeHide)
 
 	// Pull Request
-	ghaPullRequest(con, ctx, pl.PullRequest, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, []int{}, maybeHide)
+	ghaPullRequest(w, ctx, pl.PullRequest, eventID, &ev.Actor, &ev.Repo, ev.Type, ev.CreatedAt, []int{}, maybeHide)
 
-	// Final commit
-	lib.FatalOnError(con.Commit())
+	// Event is complete
+	w.addEvent(eventID)
 	return 1
 }
 
 // parseJSON - parse signle GHA JSON event
-func parseJSON(con *sql.DB, ctx *lib.Ctx, idx, njsons int, jsonStr []byte, dt time.Time, forg, frepo map[string]struct{}, orgRE, repoRE *regexp.Regexp, shas map[string]string) (f int, e int) {
+// Unmarshal error is returned, so the caller can put the event into the dead-letter table
+func parseJSON(w *ghaBatch, ctx *lib.Ctx, jsonStr []byte, dt time.Time, forg, frepo map[string]struct{}, orgRE, repoRE *regexp.Regexp, shas map[string]string) (f int, e int, err error) {
 	var (
 		h         lib.Event
 		hOld      lib.EventOld
-		err       error
 		fullName  string
 		eid       string
 		actorName string
@@ -1371,25 +1409,8 @@ func parseJSON(con *sql.DB, ctx *lib.Ctx, idx, njsons int, jsonStr []byte, dt ti
 	}
 	// jsonStr = bytes.Replace(jsonStr, []byte("\x00"), []byte(""), -1)
 	if err != nil {
-		lib.Printf("Error(%v): %v\n", lib.ToGHADate(dt), err)
-		_, err := os.Stat("./jsons")
-		if err != nil {
-			if os.IsNotExist(err) {
-				lib.FatalOnError(os.Mkdir("./jsons", 0777))
-			}
-		}
-		ofn := fmt.Sprintf("./jsons/error_%v-%d-%d.json", lib.ToGHADate(dt), idx+1, njsons)
-		lib.FatalOnError(os.WriteFile(ofn, jsonStr, 0644))
-		lib.Printf("%v: Cannot unmarshal:\n%s\n%v\n", dt, string(jsonStr), err)
-		fmt.Fprintf(os.Stderr, "%v: Cannot unmarshal:\n%s\n%v\n", dt, string(jsonStr), err)
-		if ctx.AllowBrokenJSON {
-			return
-		}
-		pretty := lib.PrettyPrintJSON(jsonStr)
-		lib.Printf("%v: JSON Unmarshal failed for:\n'%v'\n", dt, string(pretty))
-		fmt.Fprintf(os.Stderr, "%v: JSON Unmarshal failed for:\n'%v'\n", dt, string(pretty))
+		return
 	}
-	lib.FatalOnError(err)
 	if ctx.OldFormat {
 		fullName = lib.MakeOldRepoName(&hOld.Repository)
 		actorName = hOld.Actor
@@ -1411,9 +1432,9 @@ func parseJSON(con *sql.DB, ctx *lib.Ctx, idx, njsons int, jsonStr []byte, dt ti
 		}
 		if ctx.DBOut {
 			if ctx.OldFormat {
-				e = writeToDBOldFmt(con, ctx, eid, &hOld, shas)
+				e = writeToDBOldFmt(w.db, ctx, eid, &hOld, shas)
 			} else {
-				e = writeToDB(con, ctx, &h, shas)
+				e = writeToDB(w, ctx, &h, shas)
 			}
 		}
 		if ctx.Debug >= 1 {
@@ -1424,6 +1445,46 @@ func parseJSON(con *sql.DB, ctx *lib.Ctx, idx, njsons int, jsonStr []byte, dt ti
 	return
 }
 
+// parseJSONs - stream GHA JSON lines from the decompressed reader, only one line is kept in memory at a time
+// Events not matching org/repo/actor are discarded by a cheap check, without unmarshalling them
+// Events that cannot be unmarshalled are dead-lettered, they only stop processing when broken JSONs are not allowed
+// Events already written are skipped when the hour is retried after a read error
+func parseJSONs(w *ghaBatch, dlw *deadLetterWriter, ctx *lib.Ctx, reader io.Reader, dt time.Time, forg, frepo map[string]struct{}, orgRE, repoRE *regexp.Regexp, shas map[string]string) (n, f, e, d int, err error) {
+	lineReader := bufio.NewReaderSize(reader, 1<<20)
+	for idx := 0; ; idx++ {
+		var line []byte
+		line, err = lineReader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return
+		}
+		eof := err == io.EOF
+		err = nil
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) > 0 {
+			n++
+			if lib.EventHit(ctx, line, forg, frepo, orgRE, repoRE) {
+				fi, ei, errJSON := parseJSON(w, ctx, line, dt, forg, frepo, orgRE, repoRE, shas)
+				if errJSON != nil {
+					lib.Printf("%v: cannot unmarshal JSON line %d, dead-lettered: %v\n", dt, idx+1, errJSON)
+					dlw.write(dt, idx+1, line, errJSON)
+					d++
+					if !ctx.AllowBrokenJSON {
+						pretty := lib.PrettyPrintJSON(line)
+						lib.Printf("%v: JSON Unmarshal failed for:\n'%v'\n", dt, string(pretty))
+						fmt.Fprintf(os.Stderr, "%v: JSON Unmarshal failed for:\n'%v'\n", dt, string(pretty))
+						lib.FatalOnError(errJSON)
+					}
+				}
+				f += fi
+				e += ei
+			}
+		}
+		if eof {
+			return
+		}
+	}
+}
+
 // markAsProcessed mark maximum processed date
 func markAsProcessed(con *sql.DB, ctx *lib.Ctx, dt time.Time) {
 	if !ctx.DBOut {
@@ -1440,7 +1501,7 @@ func markAsProcessed(con *sql.DB, ctx *lib.Ctx, dt time.Time) {
 // getGHAJSON - This is a work for single go routine - 1 hour of GHA data
 // Usually such JSON conatin about 15000 - 60000 singe GHA events
 // Boolean channel `ch` is used to synchronize go routines
-func getGHAJSON(ch chan time.Time, ctx *lib.Ctx, dt time.Time, forg, frepo map[string]struct{}, orgRE, repoRE *regexp.Regexp, shas map[string]string, skipDates map[string]struct{}) {
+func getGHAJSON(ch chan time.Time, ctx *lib.Ctx, src lib.GHASource, dt time.Time, forg, frepo map[string]struct{}, orgRE, repoRE *regexp.Regexp, shas map[string]string, skipDates map[string]struct{}) {
 	lib.Printf("Working on %v\n", dt)
 
 	// Connect to Postgres DB
@@ -1458,33 +1519,52 @@ func getGHAJSON(ch chan time.Time, ctx *lib.Ctx, dt time.Time, forg, frepo map[s
 		return
 	}
 
-	fn := fmt.Sprintf("http://data.gharchive.org/%s.json.gz", lib.ToGHADate(dt))
+	fn := src.Location(dt)
 
-	// Get gzipped JSON array via HTTP
+	// Events are written in batches, the rest of them after the hour is parsed
+	batch := newGHABatch(con, ctx)
+
+	// Dead-letter table is in the devstats database, it is only connected when an event is dead-lettered
+	dlw := &deadLetterWriter{ctx: ctx}
+	defer dlw.close()
+
+	// Get gzipped JSON lines from the GHA source
 	trials := 0
-	var jsonsBytes []byte
+	n, f, e, d := 0, 0, 0, 0
 	for {
 		trials++
 		if trials > 1 {
 			lib.Printf("Retry(%d) %+v\n", trials, dt)
 		}
-		httpClient := &http.Client{Timeout: time.Minute * time.Duration(trials*ctx.HTTPTimeout)}
-		response, err := httpClient.Get(fn)
+		body, err := src.Open(dt, time.Minute*time.Duration(trials*ctx.HTTPTimeout))
+		if errors.Is(err, lib.ErrGHANotAvailable) || errors.Is(err, lib.ErrGHAInvalid) {
+			lib.Printf("%v: No data yet, open:\n%v\n", dt, err)
+			if trials < ctx.HTTPRetry {
+				time.Sleep(time.Duration((1+rand.Intn(3))*trials) * time.Second)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%v: No data yet, open:\n%v\n", dt, err)
+			if ch != nil {
+				ch <- dt
+			}
+			lib.Printf("Gave up on %+v\n", dt)
+			return
+		}
 		if err != nil {
-			lib.Printf("%v: Error http.Get:\n%v\n", dt, err)
+			lib.Printf("%v: Error open:\n%v\n", dt, err)
 			if trials < ctx.HTTPRetry {
 				time.Sleep(time.Duration((1+rand.Intn(20))*trials) * time.Second)
 				continue
 			}
-			fmt.Fprintf(os.Stderr, "%v: Error http.Get:\n%v\n", dt, err)
+			fmt.Fprintf(os.Stderr, "%v: Error open:\n%v\n", dt, err)
 		}
 		lib.FatalOnError(err)
 
 		// Decompress Gzipped response
-		reader, err := gzip.NewReader(response.Body)
+		reader, err := gzip.NewReader(body)
 		//lib.FatalOnError(err)
 		if err != nil {
-			_ = response.Body.Close()
+			_ = body.Close()
 			lib.Printf("%v: No data yet, gzip reader:\n%v\n", dt, err)
 			if trials < ctx.HTTPRetry {
 				time.Sleep(time.Duration((1+rand.Intn(3))*trials) * time.Second)
@@ -1499,17 +1579,19 @@ func getGHAJSON(ch chan time.Time, ctx *lib.Ctx, dt time.Time, forg, frepo map[s
 		}
 		lib.Printf("Opened %s\n", fn)
 
-		jsonsBytes, err = ioutil.ReadAll(reader)
+		// Stream JSONs one by one
+		n, f, e, d, err = parseJSONs(batch, dlw, ctx, reader, dt, forg, frepo, orgRE, repoRE, shas)
 		_ = reader.Close()
-		_ = response.Body.Close()
+		_ = body.Close()
+		batch.flush()
 		//lib.FatalOnError(err)
 		if err != nil {
-			lib.Printf("%v: Error (no data yet, ioutil readall):\n%v\n", dt, err)
+			lib.Printf("%v: Error (no data yet, stream read):\n%v\n", dt, err)
 			if trials < ctx.HTTPRetry {
 				time.Sleep(time.Duration((1+rand.Intn(20))*trials) * time.Second)
 				continue
 			}
-			fmt.Fprintf(os.Stderr, "%v: Error (no data yet, ioutil readall):\n%v\n", dt, err)
+			fmt.Fprintf(os.Stderr, "%v: Error (no data yet, stream read):\n%v\n", dt, err)
 			if ch != nil {
 				ch <- dt
 			}
@@ -1518,31 +1600,12 @@ func getGHAJSON(ch chan time.Time, ctx *lib.Ctx, dt time.Time, forg, frepo map[s
 		}
 		if trials > 1 {
 			lib.Printf("Recovered(%d) & decompressed %s\n", trials, fn)
-		} else {
-			lib.Printf("Decompressed %s\n", fn)
 		}
 		break
 	}
-
-	// Split JSON array into separate JSONs
-	jsonsArray := bytes.Split(jsonsBytes, []byte("\n"))
-	lib.Printf("Split %s, %d JSONs\n", fn, len(jsonsArray))
-
-	// Process JSONs one by one
-	n, f, e := 0, 0, 0
-	njsons := len(jsonsArray)
-	for i, json := range jsonsArray {
-		if len(json) < 1 {
-			continue
-		}
-		fi, ei := parseJSON(con, ctx, i, njsons, json, dt, forg, frepo, orgRE, repoRE, shas)
-		n++
-		f += fi
-		e += ei
-	}
 	lib.Printf(
-		"Parsed: %s: %d JSONs, found %d matching, events %d\n",
-		fn, n, f, e,
+		"Parsed: %s: %d JSONs, found %d matching, events %d, dead-lettered %d\n",
+		fn, n, f, e, d,
 	)
 	// Mark date as computed, to skip fetching this JSON again when it contains no events for a current project
 	markAsProcessed(con, ctx, dt)
@@ -1551,16 +1614,155 @@ func getGHAJSON(ch chan time.Time, ctx *lib.Ctx, dt time.Time, forg, frepo map[s
 	}
 }
 
-func getMemUsage() string {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	return fmt.Sprintf("alloc:%dM heap-alloc:%dM(%dk objs) total:%dM sys:%dM #gc:%d", m.Alloc>>20, m.HeapAlloc>>20, m.HeapObjects>>10, m.TotalAlloc>>20, m.Sys>>20, m.NumGC)
+// parseFilters - parse optional 'org1,org2,...,orgN' and 'repo1,repo2,...,repoN' args, "regexp:" prefix means regexp
+func parseFilters(args []string) (org map[string]struct{}, orgRE *regexp.Regexp, repo map[string]struct{}, repoRE *regexp.Regexp) {
+	// Strip function to be used by MapString
+	stripFunc := func(x string) string { return strings.TrimSpace(x) }
+
+	// Stripping whitespace from org and repo params
+	if len(args) >= 1 {
+		if strings.HasPrefix(args[0], "regexp:") {
+			orgRE = regexp.MustCompile(args[0][7:])
+		} else {
+			org = lib.StringsMapToSet(
+				stripFunc,
+				strings.Split(args[0], ","),
+			)
+		}
+	}
+	if len(args) >= 2 {
+		if strings.HasPrefix(args[1], "regexp:") {
+			repoRE = regexp.MustCompile(args[1][7:])
+		} else {
+			repo = lib.StringsMapToSet(
+				stripFunc,
+				strings.Split(args[1], ","),
+			)
+		}
+	}
+	return
+}
+
+// deadLetterWriter - dead-letter the events of an hour, the devstats database is connected on the first event
+type deadLetterWriter struct {
+	ctx *lib.Ctx
+	con *sql.DB
 }
 
-func runGC() {
-	lib.Printf(getMemUsage() + "\n")
-	runtime.GC()
-	lib.Printf(getMemUsage() + "\n")
+// write - store the event in the dead-letter table, it is saved as jsons/error_YYYY-MM-DD-h-n.json instead when
+// the table cannot be written, for example it is not created yet
+func (w *deadLetterWriter) write(dt time.Time, line int, event []byte, errJSON error) {
+	if w.con == nil {
+		w.con = deadLettersConn(w.ctx)
+	}
+	err := lib.WriteDeadLetter(w.con, w.ctx, dt, line, event, errJSON)
+	if err == nil {
+		return
+	}
+	ofn := fmt.Sprintf("./jsons/error_%v-%d.json", lib.ToGHADate(dt), line)
+	lib.Printf("%v: cannot dead-letter JSON line %d, saving it as %s: %v\n", dt, line, ofn, err)
+	_, err = os.Stat("./jsons")
+	if os.IsNotExist(err) {
+		lib.FatalOnError(os.Mkdir("./jsons", 0777))
+	}
+	lib.FatalOnError(os.WriteFile(ofn, event, 0644))
+}
+
+// close - close the connection to the devstats database if it was connected
+func (w *deadLetterWriter) close() {
+	if w.con != nil {
+		lib.FatalOnError(w.con.Close())
+	}
+}
+
+// deadLettersConn - connect to the devstats database, which holds the dead-letter table of all projects
+func deadLettersConn(ctx *lib.Ctx) *sql.DB {
+	dctx := *ctx
+	dctx.PgDB = lib.Devstats
+	return lib.PgConn(&dctx)
+}
+
+// replayDeadLetters - reprocess dead-lettered events of the current project, usually after lib.Event is fixed
+// Events still failing stay in the dead-letter table with the new error, org and repo filters are the same as gha2db's
+func replayDeadLetters(args []string) {
+	var ctx lib.Ctx
+	ctx.Init()
+
+	org, orgRE, repo, repoRE := parseFilters(args)
+	shaMap := lib.GetHidden(lib.HideCfgFile)
+
+	con := lib.PgConn(&ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+	dlc := deadLettersConn(&ctx)
+	defer func() { lib.FatalOnError(dlc.Close()) }()
+
+	project := lib.DeadLetterProject(&ctx)
+	letters := lib.PendingDeadLetters(dlc, &ctx, project)
+	lib.Printf("Replaying %d dead-lettered events of %s\n", len(letters), project)
+
+	// Events are marked as replayed only after they are written or found already written, the events skipped by
+	// the filters stay pending
+	batch := newGHABatch(con, &ctx)
+	replayed := []int{}
+	failed, skipped, existing, events := 0, 0, 0, 0
+	for _, letter := range letters {
+		f, e, err := parseJSON(batch, &ctx, letter.Event, letter.Hour, org, repo, orgRE, repoRE, shaMap)
+		if err != nil {
+			lib.Printf("%v: JSON line %d still cannot be unmarshalled: %v\n", letter.Hour, letter.Line, err)
+			lib.DeadLetterFailed(dlc, &ctx, letter.ID, err)
+			failed++
+			continue
+		}
+		if f == 1 && e == 0 && ctx.DBOut {
+			// The event passed the filters, but it is already in the database (or in the batch)
+			existing++
+			replayed = append(replayed, letter.ID)
+			continue
+		}
+		if e == 0 {
+			lib.Printf("%v: JSON line %d is not written, it stays pending\n", letter.Hour, letter.Line)
+			skipped++
+			continue
+		}
+		events += e
+		replayed = append(replayed, letter.ID)
+	}
+	batch.flush()
+	for _, id := range replayed {
+		lib.DeadLetterReplayed(dlc, &ctx, id)
+	}
+	lib.Printf(
+		"Replayed %d, written events %d, already existing %d, not written %d, still failing %d\n",
+		len(replayed), events, existing, skipped, failed,
+	)
+}
+
+// deadLetterSummary - print dead-lettered events by project and error class, all projects when no project is given
+func deadLetterSummary(args []string) {
+	var ctx lib.Ctx
+	ctx.Init()
+
+	project := ""
+	if len(args) >= 1 {
+		project = args[0]
+	}
+
+	dlc := deadLettersConn(&ctx)
+	defer func() { lib.FatalOnError(dlc.Close()) }()
+
+	classes := lib.DeadLetterSummary(dlc, &ctx, project)
+	fmt.Printf("%-24s %-48s %8s %8s %-14s %-14s\n", "Project", "Error class", "Events", "Pending", "First hour", "Last hour")
+	for _, class := range classes {
+		fmt.Printf(
+			"%-24s %-48s %8d %8d %-14s %-14s\n",
+			class.Project,
+			class.ErrorClass,
+			class.Events,
+			class.Pending,
+			lib.ToYMDHDate(class.FirstHour),
+			lib.ToYMDHDate(class.LastHour),
+		)
+	}
 }
 
 // gha2db - main work horse
@@ -1577,7 +1779,6 @@ func gha2db(args []string) {
 	// Current date
 	now := time.Now()
 	// Init stuff
-	debug.SetGCPercent(25)
 	ctx.Init()
 	rand.Seed(time.Now().UnixNano())
 
@@ -1624,39 +1825,8 @@ func gha2db(args []string) {
 	}
 	dateToFunc()
 
-	// Strip function to be used by MapString
-	stripFunc := func(x string) string { return strings.TrimSpace(x) }
-
-	// Stripping whitespace from org and repo params
-	var (
-		org   map[string]struct{}
-		orgRE *regexp.Regexp
-	)
-	if len(args) >= 5 {
-		if strings.HasPrefix(args[4], "regexp:") {
-			orgRE = regexp.MustCompile(args[4][7:])
-		} else {
-			org = lib.StringsMapToSet(
-				stripFunc,
-				strings.Split(args[4], ","),
-			)
-		}
-	}
-
-	var (
-		repo   map[string]struct{}
-		repoRE *regexp.Regexp
-	)
-	if len(args) >= 6 {
-		if strings.HasPrefix(args[5], "regexp:") {
-			repoRE = regexp.MustCompile(args[5][7:])
-		} else {
-			repo = lib.StringsMapToSet(
-				stripFunc,
-				strings.Split(args[5], ","),
-			)
-		}
-	}
+	// Org and repo filters
+	org, orgRE, repo, repoRE := parseFilters(args[4:])
 
 	// Get number of CPUs available
 	thrN := lib.GetThreadsNum(&ctx)
@@ -1667,6 +1837,10 @@ func gha2db(args []string) {
 		strings.Join(lib.StringsSetKeys(repo), "+"),
 	)
 
+	// Source of GHA files
+	src, err := lib.NewGHASource(&ctx)
+	lib.FatalOnError(err)
+
 	// GDPR data hiding
 	shaMap := lib.GetHidden(lib.HideCfgFile)
 
@@ -1691,14 +1865,6 @@ func gha2db(args []string) {
 		skipDates[lib.ToYMDHDate(date)] = struct{}{}
 	}
 
-	igc := 0
-	maybeGC := func() {
-		igc++
-		if igc%24 == 0 {
-			runGC()
-		}
-	}
-
 	dt := dFrom
 	if thrN > 1 {
 		ch := make(chan time.Time)
@@ -1706,7 +1872,7 @@ func gha2db(args []string) {
 		nThreads := 0
 		for dt.Before(dTo) || dt.Equal(dTo) {
 			dateToFunc()
-			go getGHAJSON(ch, &ctx, dt, org, repo, orgRE, repoRE, shaMap, skipDates)
+			go getGHAJSON(ch, &ctx, src, dt, org, repo, orgRE, repoRE, shaMap, skipDates)
 			mp[dt] = struct{}{}
 			dt = dt.Add(time.Hour)
 			nThreads++
@@ -1715,7 +1881,6 @@ func gha2db(args []string) {
 				delete(mp, prcdt)
 				nThreads--
 				dateToFunc()
-				maybeGC()
 			}
 		}
 		lib.Printf("Final threads join\n")
@@ -1731,15 +1896,13 @@ func gha2db(args []string) {
 			delete(mp, prcdt)
 			nThreads--
 			dateToFunc()
-			maybeGC()
 		}
 	} else {
 		lib.Printf("Using single threaded version\n")
 		for dt.Before(dTo) || dt.Equal(dTo) {
 			dateToFunc()
-			getGHAJSON(nil, &ctx, dt, org, repo, orgRE, repoRE, shaMap, skipDates)
+			getGHAJSON(nil, &ctx, src, dt, org, repo, orgRE, repoRE, shaMap, skipDates)
 			dt = dt.Add(time.Hour)
-			maybeGC()
 		}
 	}
 	// Finished
@@ -1748,11 +1911,23 @@ func gha2db(args []string) {
 
 func main() {
 	dtStart := time.Now()
+	// Dead-letter commands
+	if len(os.Args) >= 2 && os.Args[1] == "replay" {
+		replayDeadLetters(os.Args[2:])
+		lib.Printf("Time: %v\n", time.Now().Sub(dtStart))
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "dead-letters" {
+		deadLetterSummary(os.Args[2:])
+		return
+	}
 	// Required args
 	if len(os.Args) < 5 {
 		lib.Printf(
 			"Arguments required: date_from_YYYY-MM-DD hour_from_HH date_to_YYYY-MM-DD hour_to_HH " +
-				"['org1,org2,...,orgN' ['repo1,repo2,...,repoN']]\n",
+				"['org1,org2,...,orgN' ['repo1,repo2,...,repoN']]\n" +
+				"or: replay ['org1,org2,...,orgN' ['repo1,repo2,...,repoN']]\n" +
+				"or: dead-letters [project]\n",
 		)
 		os.Exit(1)
 	}
@@ -1,9 +1,6 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/csv"
-	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -256,35 +253,10 @@ func processHidden(ctx *lib.Ctx) {
 }
 
 func hideData(args []string) {
-	shaMap := lib.GetHidden(lib.HideCfgFile)
-	added := false
-	for _, argo := range args {
-		arg := strings.TrimSpace(argo)
-		hash := sha1.New()
-		hash.Write([]byte(arg))
-		sha := hex.EncodeToString(hash.Sum(nil))
-		_, ok := shaMap[sha]
-		if ok {
-			lib.Printf("Skipping '%s', SHA1 '%s' - already added\n", arg, sha)
-			continue
-		}
-		shaMap[sha] = ""
-		added = true
-	}
-	if !added {
-		return
-	}
-	var writer *csv.Writer
-	oFile, err := os.Create(lib.HideCfgFile)
-	lib.FatalOnError(err)
-	defer func() { _ = oFile.Close() }()
-	writer = csv.NewWriter(oFile)
-	defer writer.Flush()
-	err = writer.Write([]string{"sha1"})
+	skipped, err := lib.AddHidden(lib.HideCfgFile, args)
 	lib.FatalOnError(err)
-	for sha := range shaMap {
-		err = writer.Write([]string{sha})
-		lib.FatalOnError(err)
+	for _, arg := range skipped {
+		lib.Printf("Skipping '%s' - already added\n", arg)
 	}
 }
 
@@ -1,16 +1,18 @@
 package main
 
 import (
-	"encoding/gob"
+	"encoding/json"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/google/go-github/github"
-	"github.com/patrickmn/go-cache"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/ti-community-infra/devstats/internal/pkg/identifier"
 	"github.com/ti-community-infra/devstats/internal/pkg/lib"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -22,9 +24,14 @@ func main() {
 	// Init logger.
 	log := logrus.WithField("program", "identifier")
 
+	// Run the sub command if provided.
+	if len(os.Args) > 1 {
+		runCommand(log, &ctx, os.Args[1], os.Args[2:])
+		return
+	}
+
 	// Init database client.
-	db, err := lib.NewConn(ctx.IDDbDialect, ctx.IDDbHost, ctx.IDDbPort, ctx.IDDbUser, ctx.IDDbPass, ctx.IDDbName)
-	lib.FatalOnError(err)
+	db := newIdentifierConn(&ctx)
 
 	// Init database client used to import data.
 	pgPort, err := strconv.Atoi(ctx.PgPort)
@@ -35,26 +42,10 @@ func main() {
 	)
 	lib.FatalOnError(err)
 
-	// Init the memory cache.
-	memCache := cache.New(15*24*time.Hour, 30*24*time.Hour)
-
-	gob.Register(github.Response{})
-	gob.Register(github.User{})
-	gob.Register(github.RateLimits{})
-	gob.Register(identifier.GitHubGetUserResult{})
-	gob.Register(identifier.LocationCacheEntry{})
-	gob.Register(lib.StringSet{})
-
-	_, err = os.Stat(ctx.CacheFilePath)
-	if err != nil && !os.IsExist(err) {
-		_, err := os.Create(ctx.CacheFilePath)
-		if err != nil {
-			panic(err)
-		}
-	}
-	err = memCache.LoadFile(ctx.CacheFilePath)
-	if err != nil && err.Error() != "EOF" {
-		log.WithError(err).Errorf("Failed to load cache from file: %s", ctx.CacheFilePath)
+	// Init the cache of API lookups.
+	memCache, err := identifier.NewCache(&ctx, db)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to init the cache, backend: %s", ctx.CacheBackend)
 		return
 	}
 
@@ -85,10 +76,10 @@ func main() {
 
 		identifier.AutoImportProfile(log, &ctx, db, dataSource, &gc, &locationClient, &employeeManager, memCache)
 
-		// Save the cache to file.
-		err = memCache.SaveFile(ctx.CacheFilePath)
+		// Persist the cache.
+		err = memCache.Flush()
 		if err != nil {
-			log.WithError(err).Errorf("Failed to save the memory cache to file: %s", ctx.CacheFilePath)
+			log.WithError(err).Errorf("Failed to flush the cache, backend: %s", ctx.CacheBackend)
 			return
 		}
 	}
@@ -97,3 +88,210 @@ func main() {
 		identifier.OutputGitHubUserToJSON(log, &ctx, db)
 	}
 }
+
+// newIdentifierConn - connect to the identifier database.
+func newIdentifierConn(ctx *identifier.Ctx) *gorm.DB {
+	db, err := lib.NewConn(ctx.IDDbDialect, ctx.IDDbHost, ctx.IDDbPort, ctx.IDDbUser, ctx.IDDbPass, ctx.IDDbName)
+	lib.FatalOnError(err)
+	return db
+}
+
+// newProjectConns - connect to the databases of all the projects in the projects config.
+func newProjectConns(ctx *identifier.Ctx) map[string]*gorm.DB {
+	projectDBs := make(map[string]*gorm.DB)
+	projectConfigs := lib.LoadProjectConfigFromFile(ctx.DataDir + ctx.ProjectsYaml)
+	port, err := strconv.Atoi(ctx.PgPort)
+	lib.FatalOnError(err)
+	for _, config := range projectConfigs {
+		if config.Disabled {
+			continue
+		}
+		conn, err := lib.NewConn("postgresql", ctx.PgHost, port, ctx.PgUser, ctx.PgPass, config.PDB)
+		lib.FatalOnError(err)
+		projectDBs[config.Slug] = conn
+	}
+	return projectDBs
+}
+
+// splitTeamArg - split the "project/team" argument, the project is empty if it is not given.
+func splitTeamArg(arg string) (string, string) {
+	i := strings.Index(arg, "/")
+	if i < 0 {
+		return "", arg
+	}
+	return arg[:i], arg[i+1:]
+}
+
+// runCommand - run the sub command of identifier.
+func runCommand(log *logrus.Entry, ctx *identifier.Ctx, command string, args []string) {
+	switch command {
+	case "report-conflicts":
+		identifier.ReportAffiliationConflicts(log, ctx, newIdentifierConn(ctx))
+	case "invalidate-cache":
+		if len(args) < 1 {
+			log.Fatalf("Required argument: invalidate-cache <key prefix>")
+		}
+		memCache, err := identifier.NewCache(ctx, newIdentifierConn(ctx))
+		lib.FatalOnError(err)
+		err = memCache.DeletePrefix(args[0])
+		lib.FatalOnError(err)
+		err = memCache.Flush()
+		lib.FatalOnError(err)
+		log.Infof("Invalidated the cache entries with prefix: %s", args[0])
+	case "validate-orgs":
+		// Notice: The database is only required by the sample mapping, so the yaml file can be validated offline.
+		if len(args) > 0 {
+			ctx.OrganizationsFilePath = args[0]
+		}
+		var db *gorm.DB
+		if ctx.ValidateOrgsSampleSize > 0 {
+			db = newIdentifierConn(ctx)
+		}
+		if !identifier.ValidateOrgs(log, ctx, db, os.Stdout) {
+			os.Exit(1)
+		}
+	case "quality-report":
+		identifier.ReportDataQuality(log, ctx, newIdentifierConn(ctx), newProjectConns(ctx))
+	case "team-metrics":
+		if len(args) < 1 {
+			log.Fatalf("Required argument: team-metrics <[project/]team name> [from YYYY-MM-DD] [to YYYY-MM-DD]")
+		}
+		var fromDate, toDate string
+		if len(args) > 1 {
+			fromDate = args[1]
+		}
+		if len(args) > 2 {
+			toDate = args[2]
+		}
+		from, to, err := identifier.ParseTeamMetricsPeriod(fromDate, toDate, ctx.TeamMetricsDays, time.Now())
+		lib.FatalOnError(err)
+		projectName, teamName := splitTeamArg(args[0])
+		metrics, err := identifier.ComputeTeamMetrics(
+			newIdentifierConn(ctx), newProjectConns(ctx), projectName, teamName, from, to, ctx.TeamInactiveDays,
+		)
+		lib.FatalOnError(err)
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err = encoder.Encode(metrics)
+		lib.FatalOnError(err)
+	case "promotion-report":
+		if len(args) < 1 {
+			log.Fatalf("Required argument: promotion-report <[project/]team name> [promotion rules yaml]")
+		}
+		if len(args) > 1 {
+			ctx.PromotionRulesPath = args[1]
+		}
+		rules, err := identifier.LoadPromotionRules(ctx.PromotionRulesPath)
+		lib.FatalOnError(err)
+		projectName, teamName := splitTeamArg(args[0])
+		report, err := identifier.ComputePromotionCandidates(
+			newIdentifierConn(ctx), newProjectConns(ctx), rules, projectName, teamName, time.Now(),
+		)
+		lib.FatalOnError(err)
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err = encoder.Encode(report)
+		lib.FatalOnError(err)
+	case "sync-org-relations":
+		db := newIdentifierConn(ctx)
+		identifier.EnsureStructure(log, db)
+		err := identifier.SyncOrgRelations(log, ctx, db)
+		lib.FatalOnError(err)
+	case "opt-out":
+		if len(args) < 1 {
+			log.Fatalf("Required argument: opt-out <uuid or github login> [reason]")
+		}
+		req := identifier.OptOutRequest{Operator: os.Getenv("USER")}
+		if _, err := uuid.Parse(args[0]); err == nil {
+			req.UUID = args[0]
+		} else {
+			req.GitHubLogin = args[0]
+		}
+		if len(args) > 1 {
+			req.Reason = strings.Join(args[1:], " ")
+		}
+		db := newIdentifierConn(ctx)
+		identifier.EnsureStructure(log, db)
+		_, err := identifier.OptOutIdentity(log, db, req)
+		lib.FatalOnError(err)
+	case "export-hidden":
+		// Notice: The default file is the one used by hide_data in the current directory.
+		configFile := lib.HideCfgFile
+		if len(args) > 0 {
+			configFile = args[0]
+		}
+		err := identifier.ExportHideDataRequests(log, newIdentifierConn(ctx), configFile)
+		lib.FatalOnError(err)
+	case "sync-accounts":
+		db := newIdentifierConn(ctx)
+		identifier.EnsureStructure(log, db)
+		err := identifier.SyncGitHubAccounts(log, db)
+		lib.FatalOnError(err)
+	case "link-account":
+		if len(args) < 3 {
+			log.Fatalf("Required argument: link-account <uuid or github login> <gitlab|gitee|email> <username or email>")
+		}
+		u, githubLogin := "", args[0]
+		if _, err := uuid.Parse(args[0]); err == nil {
+			u, githubLogin = args[0], ""
+		}
+		db := newIdentifierConn(ctx)
+		identifier.EnsureStructure(log, db)
+		account, err := identifier.LinkAccount(db, u, githubLogin, model.AccountProvider(args[1]), args[2])
+		lib.FatalOnError(err)
+		log.Infof("Linked %s account %s to identity %s.", account.Provider, args[2], account.UUID)
+	case "export-sortinghat":
+		out := os.Stdout
+		if len(args) > 0 {
+			f, err := os.Create(args[0])
+			lib.FatalOnError(err)
+			defer func() { _ = f.Close() }()
+			out = f
+		}
+		err := identifier.ExportSortingHat(log, ctx, newIdentifierConn(ctx), out)
+		lib.FatalOnError(err)
+	case "import-sortinghat":
+		if len(args) < 1 {
+			log.Fatalf("Required argument: import-sortinghat <sortinghat json file>")
+		}
+		f, err := os.Open(args[0])
+		lib.FatalOnError(err)
+		defer func() { _ = f.Close() }()
+		db := newIdentifierConn(ctx)
+		identifier.EnsureStructure(log, db)
+		_, err = identifier.ImportSortingHat(log, ctx, db, f)
+		lib.FatalOnError(err)
+	case "pending-orgs":
+		err := identifier.ListPendingOrgs(newIdentifierConn(ctx), os.Stdout)
+		lib.FatalOnError(err)
+	case "approve-org":
+		if len(args) < 1 {
+			log.Fatalf("Required argument: approve-org <pending org id> [org name]")
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		lib.FatalOnError(err)
+		orgName := ""
+		if len(args) > 1 {
+			orgName = args[1]
+		}
+		org, err := identifier.ApprovePendingOrg(newIdentifierConn(ctx), uint(id), orgName)
+		lib.FatalOnError(err)
+		log.Infof("Approved pending organization %d as %s.", id, org.Name)
+	case "reject-org":
+		if len(args) < 1 {
+			log.Fatalf("Required argument: reject-org <pending org id>")
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		lib.FatalOnError(err)
+		err = identifier.RejectPendingOrg(newIdentifierConn(ctx), uint(id))
+		lib.FatalOnError(err)
+		log.Infof("Rejected pending organization %d.", id)
+	default:
+		log.Fatalf(
+			"Unknown command: %s, supported commands: report-conflicts, invalidate-cache, validate-orgs, "+
+				"quality-report, team-metrics, promotion-report, sync-org-relations, pending-orgs, approve-org, reject-org, opt-out, export-hidden, "+
+				"export-sortinghat, import-sortinghat, sync-accounts, link-account",
+			command,
+		)
+	}
+}
@@ -31,6 +31,7 @@ type gitHubUser struct {
 	Tz          *string  `json:"tz"`
 	SexProb     *float64 `json:"sex_prob"`
 	Age         *int     `json:"age"`
+	TzOffset    *int     `json:"tz_offset"`
 }
 
 // AllAcquisitions contain all company acquisitions data
@@ -549,6 +550,10 @@ func importAffs(jsonFN string) int {
 			TzOffset:  tzOffset(con, &ctx, user.Tz, tzCache),
 			Age:       user.Age,
 		}
+		// The identifier exports the offset inferred from the activities without the tz name.
+		if newCsd.TzOffset == nil {
+			newCsd.TzOffset = user.TzOffset
+		}
 		csd, ok := loginCSData[login]
 		if ok {
 			newScore := scoreCSD(&newCsd)
@@ -1005,6 +1010,7 @@ func importAffs(jsonFN string) int {
 		// Affiliation has a form "com1 < dt1, com2 < dt2, ..., com(N-1) < dt(N-1), comN"
 		// We have array of companies affiliation with eventual end date: array item is:
 		// "company name" or "company name < date", lets iterate and parse it
+		// The same company can be listed more than once when somebody returned to it, each item is a separate period
 		prevDate := defaultStartDate
 		for _, aff := range affsAry {
 			var dtFrom, dtTo time.Time
@@ -3,12 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 
-	"github.com/emirpasic/gods/stacks/linkedliststack"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/ti-community-infra/devstats/internal/pkg/identifier"
@@ -18,31 +17,6 @@ import (
 	"gorm.io/gorm/clause"
 )
 
-const DefaultTeamsFolderPath = "teams"
-const DefaultMembershipFileName = "membership.json"
-
-type ProjectConfig struct {
-	Name             string
-	DisplayName      string
-	DefaultOrgName   string
-	CommunityRepoURL string
-
-	// UseTeamJSON means this project use json format to store community teams information.
-	UseTeamJSON        bool
-	TeamsFolderPath    string
-	MembershipFileName string
-	StartCommitSHA     string
-}
-
-type TeamMembership struct {
-	Name         string   `json:"name,omitempty"`
-	Description  string   `json:"description,omitempty"`
-	Maintainers  []string `json:"maintainers"`
-	Committers   []string `json:"committers"`
-	Reviewers    []string `json:"reviewers"`
-	Repositories []string `json:"repositories,omitempty"`
-}
-
 func main() {
 	var ctx identifier.Ctx
 	err := ctx.Init()
@@ -51,6 +25,17 @@ func main() {
 	// Init logger.
 	log := logrus.WithField("program", "sync_teams")
 
+	// Load the community projects.
+	communityConfig, err := identifier.LoadCommunityConfig(ctx.CommunityConfigPath)
+	lib.FatalOnError(err)
+
+	// Preview the changes of a ref without touching the database.
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		err = diffCommunityRefs(communityConfig, os.Args[2:])
+		lib.FatalOnError(err)
+		return
+	}
+
 	// Init database client.
 	conn, err := lib.NewConn(ctx.IDDbDialect, ctx.IDDbHost, ctx.IDDbPort, ctx.IDDbUser, ctx.IDDbPass, ctx.IDDbName)
 	lib.FatalOnError(err)
@@ -66,196 +51,356 @@ func main() {
 	// Make sure the table structure is existed.
 	identifier.EnsureStructure(log, conn)
 
-	// ProjectConfig Config.
-	projects := []ProjectConfig{
-		{
-			DisplayName:        "TiDB",
-			Name:               "tidb",
-			DefaultOrgName:     "pingcap",
-			CommunityRepoURL:   "https://github.com/pingcap/community",
-			TeamsFolderPath:    DefaultTeamsFolderPath,
-			MembershipFileName: DefaultMembershipFileName,
-			StartCommitSHA:     "fe66c24508d4fecbee903d7a78df2f16cdfbb13b",
-			UseTeamJSON:        true,
-		},
-		{
-			DisplayName:        "TiKV",
-			Name:               "tikv",
-			DefaultOrgName:     "tikv",
-			CommunityRepoURL:   "https://github.com/tikv/community",
-			TeamsFolderPath:    DefaultTeamsFolderPath,
-			MembershipFileName: "team.json",
-			StartCommitSHA:     "e7679a47047c1f042abf4e53c112e564bd5008ee",
-			UseTeamJSON:        true,
-		},
-	}
-
-	for _, projectConfig := range projects {
-		if projectConfig.UseTeamJSON {
-			getCommunityInfoByTeams(conn, &gc, projectConfig)
+	// Only sync the projects given by the arguments if any, "--full" means replaying all the commits.
+	full := false
+	onlyProjects := make(lib.StringSet)
+	for _, arg := range os.Args[1:] {
+		if arg == "--full" {
+			full = true
+			continue
+		}
+		onlyProjects[arg] = struct{}{}
+	}
+
+	synced := make([]string, 0)
+	failed := make([]string, 0)
+	skipped := make([]string, 0)
+	for _, projectConfig := range communityConfig.Projects {
+		if _, ok := onlyProjects[projectConfig.Name]; len(onlyProjects) != 0 && !ok {
+			continue
+		}
+		delete(onlyProjects, projectConfig.Name)
+		if projectConfig.Disabled {
+			skipped = append(skipped, projectConfig.Name)
+			continue
+		}
+
+		err = getCommunityInfoByTeams(conn, &gc, projectConfig, ctx.ReposDir, full)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to sync the teams of %s.", projectConfig.Name)
+			failed = append(failed, projectConfig.Name)
+			continue
 		}
+		synced = append(synced, projectConfig.Name)
+	}
+	for name := range onlyProjects {
+		log.Warnf("Project %s is not found in %s.", name, ctx.CommunityConfigPath)
+	}
+
+	log.Infof("Synced projects: %v, failed projects: %v, disabled projects: %v.", synced, failed, skipped)
+	if len(failed) != 0 {
+		os.Exit(1)
 	}
 }
 
-func getCommunityInfoByTeams(db *gorm.DB, gc *identifier.GitHubClient, projectConfig ProjectConfig) {
-	logrus.Infof("Cloning the repository: %s", projectConfig.CommunityRepoURL)
-	r, err := git.Clone(memory.NewStorage(), nil, &git.CloneOptions{
-		URL:               projectConfig.CommunityRepoURL,
-		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
-	})
-	lib.FatalOnError(err)
+// diffCommunityRefs - print the changes of the teams made by the head ref (default HEAD) since it forked from the
+// base ref in the local community repository, the arguments are "<project> <repo path> <base ref> [head ref] [--json]".
+func diffCommunityRefs(communityConfig identifier.CommunityConfig, args []string) error {
+	outputJSON := false
+	positional := make([]string, 0)
+	for _, arg := range args {
+		if arg == "--json" {
+			outputJSON = true
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) < 3 || len(positional) > 4 {
+		return fmt.Errorf("usage: sync_teams diff <project> <repo path> <base ref> [head ref] [--json]")
+	}
+	projectName, repoPath, baseRef, headRef := positional[0], positional[1], positional[2], "HEAD"
+	if len(positional) == 4 {
+		headRef = positional[3]
+	}
+
+	var projectConfig *identifier.CommunityProject
+	for i := range communityConfig.Projects {
+		if communityConfig.Projects[i].Name == projectName {
+			projectConfig = &communityConfig.Projects[i]
+		}
+	}
+	if projectConfig == nil {
+		return fmt.Errorf("project %s is not found", projectName)
+	}
+
+	r, err := git.PlainOpenWithOptions(repoPath, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	// The head ref is compared with the merge base, like the changes of a pull request.
+	mergeBase, err := identifier.CommunityMergeBase(r, baseRef, headRef)
+	if err != nil {
+		return err
+	}
+	before, err := identifier.ParseCommunityRef(r, *projectConfig, mergeBase)
+	if err != nil {
+		return err
+	}
+	after, err := identifier.ParseCommunityRef(r, *projectConfig, headRef)
+	if err != nil {
+		return err
+	}
+
+	if before == nil || after == nil {
+		return fmt.Errorf("the membership file %s is missing or broken", projectConfig.MembershipFileName)
+	}
+
+	diff := identifier.DiffTeamMemberships(before, after)
+	if outputJSON {
+		bytesJSON, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytesJSON))
+		return nil
+	}
+	fmt.Print(diff.Text())
+	return nil
+}
+
+// getCommunityInfoByTeams - replay the new commits of the membership files to sync the teams, members and
+// repositories, all the commits are replayed from the start commit in the full mode.
+func getCommunityInfoByTeams(
+	db *gorm.DB, gc *identifier.GitHubClient, projectConfig identifier.CommunityProject, reposDir string, full bool,
+) error {
+	parser, err := identifier.NewMembershipParser(projectConfig.Format)
+	if err != nil {
+		return err
+	}
+
+	repoDir := reposDir + "sync_teams/" + projectConfig.Name
+	logrus.Infof("Syncing the repository %s to %s.", projectConfig.CommunityRepoURL, repoDir)
+	r, err := identifier.SyncCommunityRepo(repoDir, projectConfig)
+	if err != nil {
+		return err
+	}
 
 	// Ensure the project existed in the database.
 	var project model.Project
 	project.Name = projectConfig.Name
 	project.DisplayName = projectConfig.DisplayName
 	err = db.Where("name = ?", projectConfig.Name).FirstOrCreate(&project).Error
-	lib.FatalOnError(err)
+	if err != nil {
+		return err
+	}
 
-	// Get commits related to the teams directory.
-	gitLog, err := r.Log(&git.LogOptions{
-		Order: git.LogOrderCommitterTime,
-		PathFilter: func(p string) bool {
-			return strings.HasPrefix(p, projectConfig.TeamsFolderPath)
-		},
-	})
-	lib.FatalOnError(err)
+	lastCommitSHA := project.CommunitySyncCommit
+	if full {
+		lastCommitSHA = ""
+	}
 
-	// Sort by commit date in ascending order.
-	commitStack := linkedliststack.New()
-	err = gitLog.ForEach(func(commit *object.Commit) error {
-		commitStack.Push(commit)
-		return nil
-	})
-	lib.FatalOnError(err)
+	// Get the new commits related to the membership files.
+	commits, err := identifier.CommunityCommits(
+		r, parser.Paths(projectConfig), projectConfig.StartCommitSHA, lastCommitSHA,
+	)
+	if err != nil && len(lastCommitSHA) != 0 {
+		return fmt.Errorf("%w, the history may be rewritten, run with --full to replay all the commits", err)
+	} else if err != nil {
+		return err
+	}
+	logrus.Infof("Found %d new commits since %q.", len(commits), lastCommitSHA)
 
-	nCommit := commitStack.Size()
-	logrus.Infof("Found %d commits.", nCommit)
+	if !full {
+		return replayCommunityCommits(db, gc, r, parser, projectConfig, project, lastCommitSHA, commits)
+	}
 
-	// Traverse all commits to get the historical data of the membership file.
-	for i := 0; i < nCommit; i++ {
-		item, _ := commitStack.Pop()
-		if item == nil {
-			logrus.Warnf("Got nil commit.")
-			continue
+	// The members and changelogs are rebuilt from scratch in one transaction, so that the readers never see the
+	// half rebuilt teams, and the former ones are kept if the replay fails.
+	return db.Transaction(func(tx *gorm.DB) error {
+		teamIDs := tx.Model(&model.Team{}).Select("id").Where("project_id = ?", project.ID)
+		err := tx.Where("team_id in (?)", teamIDs).Delete(&model.TeamMember{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("team_id in (?)", teamIDs).Delete(&model.TeamMemberChangeLog{}).Error
+		if err != nil {
+			return err
 		}
-		commit := item.(*object.Commit)
+		return replayCommunityCommits(tx, gc, r, parser, projectConfig, project, lastCommitSHA, commits)
+	})
+}
+
+// replayCommunityCommits - sync the teams, members and repositories by the membership of each commit in order,
+// the progress is persisted after each commit.
+func replayCommunityCommits(
+	db *gorm.DB, gc *identifier.GitHubClient, r *git.Repository, parser identifier.MembershipParser,
+	projectConfig identifier.CommunityProject, project model.Project, lastCommitSHA string, commits []*object.Commit,
+) error {
+	var err error
+
+	// The deleted teams are found by comparing the teams with the previous commit, the teams of the last synced
+	// commit are the baseline of the first new commit.
+	var previousMemberships []identifier.TeamMembership
+	if len(lastCommitSHA) != 0 && len(commits) != 0 {
+		previousMemberships, err = identifier.ParseCommunityRef(r, projectConfig, lastCommitSHA)
+		if err != nil {
+			return err
+		}
+	}
+	if len(lastCommitSHA) != 0 && len(commits) != 0 && previousMemberships == nil {
+		// The membership file of the last synced commit is broken, the teams in the database are the baseline.
+		teamsInDB := make([]model.Team, 0)
+		err = db.Where("project_id = ?", project.ID).Find(&teamsInDB).Error
+		if err != nil {
+			return err
+		}
+		for _, team := range teamsInDB {
+			previousMemberships = append(previousMemberships, identifier.TeamMembership{Name: team.Name})
+		}
+	}
+
+	// Traverse the commits to get the historical data of the membership file.
+	for _, commit := range commits {
 		logrus.Infof("Handling commit %s <%s, %s>", commit.Hash, commit.Committer.Name, commit.Committer.When)
 
-		// Switch to the teams directory.
-		tree, err := commit.Tree()
-		lib.FatalOnError(err)
-		teamsPath := projectConfig.TeamsFolderPath
-		tree, err = tree.Tree(teamsPath)
+		// Notice: Every commit is replayed in a transaction with its progress, so a commit is never marked as synced
+		// when any of its members could not be resolved or written, and it is replayed again in the next run.
+		var teamMemberships []identifier.TeamMembership
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			teamMemberships, err = replayCommunityCommit(tx, gc, parser, projectConfig, project, previousMemberships, commit)
+			return err
+		})
 		if err != nil {
-			logrus.WithError(err).Errorf("Failed to found directory: %s", teamsPath)
+			return fmt.Errorf("failed to replay commit %s: %w", commit.Hash, err)
 		}
-		lib.FatalOnError(err)
+		previousMemberships = teamMemberships
+	}
 
-		// Traverse all the team folders.
-		teamNames := make(lib.StringSet)
-		for _, entry := range tree.Entries {
-			if entry.Mode.IsFile() {
-				continue
+	return nil
+}
+
+// replayCommunityCommit - sync the teams, members and repositories by the membership of the commit, and persist
+// the commit as the progress, the memberships of the commit are returned as the baseline of the next commit.
+func replayCommunityCommit(
+	db *gorm.DB, gc *identifier.GitHubClient, parser identifier.MembershipParser,
+	projectConfig identifier.CommunityProject, project model.Project,
+	previousMemberships []identifier.TeamMembership, commit *object.Commit,
+) ([]identifier.TeamMembership, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	teamMemberships, err := parser.Parse(identifier.GitTreeFiles{Tree: tree}, projectConfig)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to parse the membership of commit %s.", commit.Hash)
+		return nil, err
+	}
+	if teamMemberships == nil {
+		// The membership file is missing or broken, the teams of the previous commit are kept unchanged.
+		teamMemberships = identifier.UnknownMemberships(previousMemberships)
+	}
+
+	// Traverse all the teams.
+	for _, teamMembership := range teamMemberships {
+		// Ensure team existed in the database, the team deleted before is restored.
+		var team model.Team
+		team.Name = teamMembership.Name
+		team.ProjectID = project.ID
+		err = db.Unscoped().Where("project_id = ? and name = ?", project.ID, teamMembership.Name).
+			FirstOrCreate(&team).Error
+		if err != nil {
+			return nil, err
+		}
+		if team.DeletedAt.Valid {
+			err = db.Unscoped().Model(&team).Update("deleted_at", nil).Error
+			if err != nil {
+				return nil, err
 			}
+			team.DeletedAt = gorm.DeletedAt{}
+		}
 
-			teamName := entry.Name
-			teamNames[teamName] = struct{}{}
-			teamTree, err := tree.Tree(teamName)
-			lib.FatalOnError(err)
-
-			// Ensure team existed in the database.
-			var team model.Team
-			team.Name = teamName
-			team.ProjectID = project.ID
-			db.Where("project_id = ? and name = ?", project.ID, teamName).FirstOrCreate(&team)
-
-			files := teamTree.Files()
-			_ = files.ForEach(func(file *object.File) error {
-				if file.Name == projectConfig.MembershipFileName {
-					contents, err := file.Contents()
-					if err != nil {
-						logrus.WithError(err).Errorf("Failed to get the content of membership file.")
-						return err
-					}
-
-					var teamMembership TeamMembership
-					err = json.Unmarshal([]byte(contents), &teamMembership)
-					if err != nil {
-						logrus.WithError(err).Errorf("Failed to parse the membership file.")
-						return err
-					}
-
-					team.Description = teamMembership.Description
-					db.Updates(&team)
-
-					saveTeamMembersToDB(db, gc, team.ID, team.Name, teamMembership, commit)
-
-					repositories := teamMembership.Repositories
-					if len(repositories) != 0 {
-						saveTeamRepositoryToDB(db, gc, project.ID, team, projectConfig.DefaultOrgName, repositories)
-					}
-				}
-				return nil
-			})
-		}
-
-		// Handle the deleted teams.
-		teamsInDB := make([]model.Team, 0)
-		db.Where("project_id = ? and updated_at < ?", project.ID, commit.Committer.When).Find(&teamsInDB)
-		teamNamesInDB := make(lib.StringSet)
-		for _, team := range teamsInDB {
-			teamNamesInDB[team.Name] = struct{}{}
+		// The members are kept unchanged if the membership of the team is unknown.
+		if teamMembership.Members == nil {
+			continue
 		}
 
-		// Exclude the teams existed in current commit, and the rest is the team that has been deleted.
-		for teamName := range teamNames {
-			delete(teamNamesInDB, teamName)
+		team.Description = teamMembership.Description
+		team.Metadata = teamMembership.Metadata
+		err = db.Model(&team).Select("description", "metadata").Updates(&team).Error
+		if err != nil {
+			return nil, err
 		}
 
-		for teamDeletedName := range teamNamesInDB {
-			db.Where("name = ? and project_id = ?", teamDeletedName, project.ID).Delete(&model.Team{})
-			logrus.Infof("team %s has been deleted.", teamDeletedName)
+		err = saveTeamMembersToDB(db, gc, team.ID, team.Name, teamMembership.Members, commit)
+		if err != nil {
+			return nil, err
+		}
+
+		repositories := teamMembership.Repositories
+		if len(repositories) != 0 {
+			saveTeamRepositoryToDB(db, gc, project.ID, team, projectConfig.DefaultOrgName, repositories)
 		}
 	}
+
+	// Handle the teams deleted since the previous commit, the members of the deleted team are all retired.
+	for _, teamName := range identifier.DiffTeamMemberships(previousMemberships, teamMemberships).TeamsDeleted {
+		var team model.Team
+		err = db.Where("project_id = ? and name = ?", project.ID, teamName).Limit(1).Find(&team).Error
+		if err != nil {
+			return nil, err
+		}
+		if team.ID == 0 {
+			continue
+		}
+		err = identifier.RetireTeamMembers(
+			logrus.WithField("program", "sync_teams"), db, team.ID, team.Name,
+			make(lib.StringSet), make(lib.StringSet), commit,
+		)
+		if err != nil {
+			return nil, err
+		}
+		err = db.Delete(&team).Error
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("team %s has been deleted.", team.Name)
+	}
+
+	// Persist the progress, so that the next run continues from the commit.
+	err = db.Model(&project).Update("community_sync_commit", commit.Hash.String()).Error
+	if err != nil {
+		return nil, err
+	}
+	return teamMemberships, nil
 }
 
+// saveTeamMembersToDB - save the members of the team at the commit, and retire the members not present any more,
+// the error is returned if any member could not be resolved or written.
 func saveTeamMembersToDB(
 	db *gorm.DB, gc *identifier.GitHubClient,
-	teamID uint, teamName string, teamMembership TeamMembership, commit *object.Commit,
-) {
-	member2level := make(map[string]model.TeamLevel)
-
-	for _, reviewer := range teamMembership.Reviewers {
-		member2level[reviewer] = model.TeamReviewer
-	}
-	for _, committer := range teamMembership.Committers {
-		member2level[committer] = model.TeamCommitter
-	}
-	for _, maintainer := range teamMembership.Maintainers {
-		member2level[maintainer] = model.TeamMaintainer
+	teamID uint, teamName string, member2level map[string]model.TeamLevel, commit *object.Commit,
+) error {
+	presentUUIDs := make(lib.StringSet)
+	presentLogins := make(lib.StringSet)
+	for login := range member2level {
+		presentLogins[strings.ToLower(login)] = struct{}{}
 	}
 
 	for login, newLevel := range member2level {
 		var githubUser model.GitHubUser
-		db.Raw(`
+		err := db.Raw(`
 select * from github_users u where exists(
     select * from github_user_logins ul where u.id = ul.github_user_id and ul.login = ?
 )
-`, login).Scan(&githubUser)
+`, login).Scan(&githubUser).Error
+		if err != nil {
+			return err
+		}
 
 		if githubUser.ID == 0 {
 			githubUserData, _, err := gc.GetUserByLogin(login)
 			if err != nil {
-				logrus.WithError(err).Errorf("Failed to get user by login.")
-				continue
+				return fmt.Errorf("failed to get the GitHub user of team member %s: %w", login, err)
 			}
 
 			// Ensure unique identity existed in the database.
 			var uniqueIdentity model.UniqueIdentity
 			uniqueIdentity.UUID = uuid.NewString()
 			err = db.Create(&uniqueIdentity).Error
-			lib.FatalOnError(err)
+			if err != nil {
+				return err
+			}
 
 			// Ensure GitHub user existed in the database.
 			var newGitHubUser model.GitHubUser
@@ -275,14 +420,24 @@ select * from github_users u where exists(
 			newGitHubUser.UUID = uniqueIdentity.UUID
 
 			err = db.Where("id = ?", githubUserID).FirstOrCreate(&newGitHubUser).Error
-			lib.FatalOnError(err)
+			if err != nil {
+				return err
+			}
 			githubUser = newGitHubUser
 		}
 
-		// Find existed team member.
+		presentUUIDs[githubUser.UUID] = struct{}{}
+
+		// Find existed team member, the member who has left the team is regarded as joining again.
 		var teamMember model.TeamMember
-		db.Where("team_id = ? and uuid = ?", teamID, githubUser.UUID).First(&teamMember)
+		err = db.Where("team_id = ? and uuid = ?", teamID, githubUser.UUID).Limit(1).Find(&teamMember).Error
+		if err != nil {
+			return err
+		}
 		oldLevel := teamMember.Level
+		if teamMember.LeaveDate != nil {
+			oldLevel = ""
+		}
 
 		// Skip not level change member.
 		if newLevel == oldLevel {
@@ -297,33 +452,33 @@ select * from github_users u where exists(
 		teamMember.Level = newLevel
 		teamMember.LastUpdateDate = commit.Committer.When
 
+		teamMember.LeaveDate = nil
+
 		if len(oldLevel) == 0 {
 			teamMember.JoinDate = commit.Committer.When
 			logrus.Infof(
 				"[Joinning] %s joined %s team as %s on %s.",
 				login, teamName, newLevel, teamMember.JoinDate.Format("2006-01-02"),
 			)
-		} else if len(newLevel) != 0 {
-			logrus.Infof(
-				"[Promoation] %s is promoated from %s to %s in %s team on %s.",
-				login, teamName, oldLevel, newLevel, teamMember.JoinDate.Format("2006-01-02"),
-			)
-		} else if len(newLevel) == 0 {
+		} else {
 			logrus.Infof(
-				"[Retirement] %s retired from the %s team on %s.",
-				login, teamName, teamMember.JoinDate.Format("2006-01-02"),
+				"[Promotion] %s is promoted from %s to %s in %s team on %s.",
+				login, oldLevel, newLevel, teamName, commit.Committer.When.Format("2006-01-02"),
 			)
 		}
 
 		// Add team member relationship.
-		db.Clauses(clause.OnConflict{
+		err = db.Clauses(clause.OnConflict{
 			Columns: []clause.Column{
 				{Name: "team_id"}, {Name: "uuid"},
 			},
 			DoUpdates: clause.AssignmentColumns([]string{
-				"dup_github_id", "dup_github_login", "level", "last_update_date",
+				"dup_github_id", "dup_github_login", "level", "join_date", "last_update_date", "leave_date",
 			}),
-		}).Create(&teamMember)
+		}).Create(&teamMember).Error
+		if err != nil {
+			return err
+		}
 
 		// Add team member changelog.
 		changeLog := model.TeamMemberChangeLog{
@@ -337,13 +492,20 @@ select * from github_users u where exists(
 			DupGitHubID:    githubUser.ID,
 			DupGitHubLogin: login,
 		}
-		db.Clauses(clause.OnConflict{
+		err = db.Clauses(clause.OnConflict{
 			Columns: []clause.Column{
 				{Name: "team_id"}, {Name: "uuid"}, {Name: "commit_sha"},
 			},
 			DoNothing: true,
-		}).Create(&changeLog)
+		}).Create(&changeLog).Error
+		if err != nil {
+			return err
+		}
 	}
+
+	return identifier.RetireTeamMembers(
+		logrus.WithField("program", "sync_teams"), db, teamID, teamName, presentUUIDs, presentLogins, commit,
+	)
 }
 
 func saveTeamRepositoryToDB(
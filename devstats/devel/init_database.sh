@@ -75,6 +75,7 @@ then
   ./devel/db.sh psql postgres -c "alter user gha_admin createdb" || exit 10
   ./devel/db.sh psql devstats < ./util_sql/devstats_log_table.sql
   ./devel/db.sh psql devstats < ./util_sql/devstats_flags_table.sql
+  ./devel/db.sh psql devstats < ./util_sql/devstats_dead_letters_table.sql
   PG_USER="${user}" ./devel/db.sh psql devstats < ./util_sql/devstats_log_table_as_owner.sql
   PG_USER="${user}" ./devel/ro_user_grants.sh devstats || exit 11
   PG_USER="${user}" ./devel/psql_user_grants.sh devstats_team devstats || exit 12
@@ -0,0 +1,16 @@
+CREATE TABLE gha_dead_letters (
+    id serial NOT NULL PRIMARY KEY,
+    dt timestamp without time zone NOT NULL DEFAULT now(),
+    proj character varying(32) NOT NULL,
+    hour timestamp without time zone NOT NULL,
+    line integer NOT NULL,
+    error_class text NOT NULL,
+    error text NOT NULL,
+    event bytea NOT NULL,
+    attempts integer NOT NULL DEFAULT 1,
+    replayed_at timestamp without time zone,
+    UNIQUE (proj, hour, line)
+);
+ALTER TABLE gha_dead_letters OWNER TO gha_admin;
+CREATE INDEX dead_letters_proj_replayed_at_idx ON gha_dead_letters USING btree (proj, replayed_at);
+CREATE INDEX dead_letters_error_class_idx ON gha_dead_letters USING btree (error_class);
@@ -28,7 +28,6 @@ require (
 )
 
 require (
-	github.com/emirpasic/gods v1.12.0
 	github.com/gin-gonic/gin v1.7.4
 	github.com/go-git/go-git/v5 v5.4.2
 	github.com/go-playground/validator/v10 v10.9.0 // indirect
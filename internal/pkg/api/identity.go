@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/identifier"
+	"gorm.io/gorm"
+)
+
+type IdentityHandler struct {
+	BaseURL      string
+	identifierDB *gorm.DB
+	projectDBs   map[string]*gorm.DB
+}
+
+func (h *IdentityHandler) Init(identifierDB *gorm.DB, projectDBs map[string]*gorm.DB, baseURL string) {
+	h.identifierDB = identifierDB
+	h.projectDBs = projectDBs
+	h.BaseURL = baseURL
+}
+
+func (h *IdentityHandler) OptOut(req identifier.OptOutRequest) (*identifier.OptOutResult, error) {
+	log := logrus.WithField("program", "apiserver")
+	return identifier.OptOutIdentity(log, h.identifierDB, req)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ti-community-infra/devstats/internal/pkg/identifier"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+type OrganizationItem struct {
+	ID      uint             `json:"id"`
+	Name    string           `json:"name"`
+	Type    string           `json:"type"`
+	URL     string           `json:"url"`
+	Parent  *OrgRelationItem `json:"parent"`
+	Members int              `json:"members"`
+}
+
+type OrgRelationItem struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+}
+
+type OrganizationDetail struct {
+	ID           uint              `json:"id"`
+	Name         string            `json:"name"`
+	Fullname     string            `json:"fullname"`
+	Type         string            `json:"type"`
+	Website      string            `json:"website"`
+	Parent       *OrgRelationItem  `json:"parent"`
+	Subsidiaries []OrgRelationItem `json:"subsidiaries"`
+	Members      []OrgMemberItem   `json:"members"`
+}
+
+type OrgMemberItem struct {
+	GitHubID    uint   `json:"github_id"`
+	GitHubLogin string `json:"github_login"`
+	Name        string `json:"name"`
+	// OrgName is the organization which the member enrolled in, it may be a subsidiary.
+	OrgName string `json:"org_name"`
+}
+
+type OrganizationHandler struct {
+	BaseURL      string
+	identifierDB *gorm.DB
+	projectDBs   map[string]*gorm.DB
+}
+
+func (h *OrganizationHandler) Init(identifierDB *gorm.DB, projectDBs map[string]*gorm.DB, baseURL string) {
+	h.identifierDB = identifierDB
+	h.projectDBs = projectDBs
+	h.BaseURL = baseURL
+}
+
+// GetOrganizations - get the organizations with members on the date, the subsidiaries are rolled up to the
+// parent if rollup is true.
+func (h *OrganizationHandler) GetOrganizations(date time.Time, rollup bool) ([]OrganizationItem, error) {
+	hierarchy, err := identifier.LoadOrgHierarchy(h.identifierDB)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []struct {
+		OrgID uint
+		UUID  string `gorm:"column:uuid"`
+	}
+	err = h.identifierDB.Raw(`
+select distinct e.org_id as org_id, e.uuid as uuid
+from enrollments e
+where e.invalid = ? and e.start_date <= ? and e.end_date > ?
+`, false, date, date).Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Notice: The member enrolled in more than one subsidiaries is only counted once after rolled up.
+	org2uuids := make(map[uint]map[string]struct{})
+	for _, member := range members {
+		orgID := member.OrgID
+		if rollup {
+			orgID = hierarchy.Root(orgID, date)
+		}
+		uuids, ok := org2uuids[orgID]
+		if !ok {
+			uuids = make(map[string]struct{})
+			org2uuids[orgID] = uuids
+		}
+		uuids[member.UUID] = struct{}{}
+	}
+	org2members := make(map[uint]int)
+	for orgID, uuids := range org2uuids {
+		org2members[orgID] = len(uuids)
+	}
+
+	orgItems := make([]OrganizationItem, 0, len(org2members))
+	for orgID, members := range org2members {
+		org, ok := hierarchy.Org(orgID)
+		if !ok || org.Invalid {
+			continue
+		}
+		orgItems = append(orgItems, OrganizationItem{
+			ID:      org.ID,
+			Name:    org.Name,
+			Type:    string(org.Type),
+			URL:     fmt.Sprintf("%s/organizations/%s", h.BaseURL, org.Name),
+			Parent:  newParentItem(hierarchy, org.ID, date),
+			Members: members,
+		})
+	}
+
+	sort.Slice(orgItems, func(i, j int) bool {
+		if orgItems[i].Members == orgItems[j].Members {
+			return strings.Compare(orgItems[i].Name, orgItems[j].Name) < 0
+		}
+		return orgItems[i].Members > orgItems[j].Members
+	})
+
+	return orgItems, nil
+}
+
+// GetOrganization - get the organization detail on the date, the members of subsidiaries are included if rollup
+// is true.
+func (h *OrganizationHandler) GetOrganization(orgName string, date time.Time, rollup bool) (*OrganizationDetail, error) {
+	var org model.Organization
+	err := h.identifierDB.Where("name = ? and invalid = ?", orgName, false).First(&org).Error
+	if err != nil {
+		return nil, err
+	}
+
+	hierarchy, err := identifier.LoadOrgHierarchy(h.identifierDB)
+	if err != nil {
+		return nil, err
+	}
+
+	orgDetail := OrganizationDetail{
+		ID:           org.ID,
+		Name:         org.Name,
+		Fullname:     org.Fullname,
+		Type:         string(org.Type),
+		Website:      org.Website,
+		Parent:       newParentItem(hierarchy, org.ID, date),
+		Subsidiaries: make([]OrgRelationItem, 0),
+		Members:      make([]OrgMemberItem, 0),
+	}
+
+	for _, relation := range hierarchy.Children(org.ID, date) {
+		child, _ := hierarchy.Org(relation.OrgID)
+		orgDetail.Subsidiaries = append(orgDetail.Subsidiaries, newRelationItem(child, relation))
+	}
+
+	orgIDs := []uint{org.ID}
+	if rollup {
+		orgIDs = hierarchy.Descendants(org.ID, date)
+	}
+	err = h.identifierDB.Raw(`
+select
+    gu.id as github_id, gu.login as github_login, ui.name as name, o.name as org_name
+from
+    enrollments e
+    inner join organizations o on e.org_id = o.id
+    inner join unique_identities ui on e.uuid = ui.uuid
+    inner join github_users gu on ui.uuid = gu.uuid
+where e.org_id in ? and e.invalid = ? and e.start_date <= ? and e.end_date > ?
+order by gu.login
+`, orgIDs, false, date, date).Scan(&orgDetail.Members).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &orgDetail, nil
+}
+
+func newParentItem(hierarchy *identifier.OrgHierarchy, orgID uint, date time.Time) *OrgRelationItem {
+	relation := hierarchy.Parent(orgID, date)
+	if relation == nil {
+		return nil
+	}
+	parent, _ := hierarchy.Org(relation.ParentOrgID)
+	item := newRelationItem(parent, *relation)
+	return &item
+}
+
+func newRelationItem(org model.Organization, relation model.OrgRelation) OrgRelationItem {
+	return OrgRelationItem{
+		ID:        org.ID,
+		Name:      org.Name,
+		Type:      string(relation.Type),
+		StartDate: relation.StartDate,
+		EndDate:   relation.EndDate,
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/ti-community-infra/devstats/internal/pkg/identifier"
+	"gorm.io/gorm"
+)
+
+// qualityCacheExpiration - the data quality report scans the events of every project, so it is computed at most
+// once in the period for the same parameters.
+const qualityCacheExpiration = 30 * time.Minute
+
+type QualityHandler struct {
+	identifierDB *gorm.DB
+	projectDBs   map[string]*gorm.DB
+	reports      *cache.Cache
+	mtx          sync.Mutex
+}
+
+func (h *QualityHandler) Init(identifierDB *gorm.DB, projectDBs map[string]*gorm.DB) {
+	h.identifierDB = identifierDB
+	h.projectDBs = projectDBs
+	h.reports = cache.New(qualityCacheExpiration, 2*qualityCacheExpiration)
+}
+
+func (h *QualityHandler) GetQuality(activeDays, staleDays, topN int) (*identifier.DataQualityReport, error) {
+	// Notice: The report is cached with the most top unknown contributors, so that the requests with different
+	// top_n share the same report.
+	key := fmt.Sprintf("%d:%d", activeDays, staleDays)
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	value, ok := h.reports.Get(key)
+	if !ok {
+		report, err := identifier.ComputeDataQuality(
+			h.identifierDB, h.projectDBs, activeDays, staleDays, identifier.MaxQualityTopN,
+		)
+		if err != nil {
+			return nil, err
+		}
+		h.reports.SetDefault(key, report)
+		value = report
+	}
+
+	report := *value.(*identifier.DataQualityReport)
+	if len(report.TopUnknownContributors) > topN {
+		report.TopUnknownContributors = report.TopUnknownContributors[:topN]
+	}
+	return &report, nil
+}
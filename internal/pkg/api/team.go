@@ -2,10 +2,14 @@ package api
 
 import (
 	"fmt"
+	"net/url"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/patrickmn/go-cache"
+	"github.com/ti-community-infra/devstats/internal/pkg/identifier"
 	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
 	"gorm.io/gorm"
 )
@@ -29,8 +33,14 @@ type TeamDetail struct {
 	Description  string               `json:"description"`
 	Maintainers  []TeamMemberItem     `json:"maintainers"`
 	Committers   []TeamMemberItem     `json:"committers"`
+	Approvers    []TeamMemberItem     `json:"approvers"`
 	Reviewers    []TeamMemberItem     `json:"reviewers"`
 	Repositories []TeamRepositoryItem `json:"repositories"`
+
+	Charter  string              `json:"charter"`
+	Channels []model.TeamChannel `json:"channels"`
+	Meetings []model.TeamMeeting `json:"meetings"`
+	Mentors  []TeamMemberItem    `json:"mentors"`
 }
 
 type TeamMemberItem struct {
@@ -60,16 +70,49 @@ type ParticipateTeamItem struct {
 	LastUpdateDate time.Time `json:"last_update_date"`
 }
 
+// teamMetricsCacheExpiration - the team metrics scan the events of the members in the project database, so they
+// are computed at most once in the period for the same parameters.
+const teamMetricsCacheExpiration = 30 * time.Minute
+
 type TeamHandler struct {
 	BaseURL      string
 	identifierDB *gorm.DB
 	projectDBs   map[string]*gorm.DB
+	metrics      *cache.Cache
+	mtx          sync.Mutex
 }
 
 func (h *TeamHandler) Init(identifierDB *gorm.DB, projectDBs map[string]*gorm.DB, baseURL string) {
 	h.identifierDB = identifierDB
 	h.projectDBs = projectDBs
 	h.BaseURL = baseURL
+	h.metrics = cache.New(teamMetricsCacheExpiration, 2*teamMetricsCacheExpiration)
+}
+
+func (h *TeamHandler) GetTeamMetrics(
+	projectName, teamName string, from, to time.Time, inactiveDays int,
+) (*identifier.TeamMetrics, error) {
+	key := fmt.Sprintf("%s:%s:%d:%d:%d", projectName, teamName, from.Unix(), to.Unix(), inactiveDays)
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if value, ok := h.metrics.Get(key); ok {
+		return value.(*identifier.TeamMetrics), nil
+	}
+	metrics, err := identifier.ComputeTeamMetrics(h.identifierDB, h.projectDBs, projectName, teamName, from, to, inactiveDays)
+	if err != nil {
+		return nil, err
+	}
+	h.metrics.SetDefault(key, metrics)
+	return metrics, nil
+}
+
+func (h *TeamHandler) GetPromotionCandidates(projectName, teamName, rulesPath string) (*identifier.PromotionReport, error) {
+	rules, err := identifier.LoadPromotionRules(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	return identifier.ComputePromotionCandidates(h.identifierDB, h.projectDBs, rules, projectName, teamName, time.Now())
 }
 
 func (h *TeamHandler) GetTeams() ([]TeamItem, error) {
@@ -85,7 +128,8 @@ func (h *TeamHandler) GetTeams() ([]TeamItem, error) {
 		teamItem.ID = team.ID
 		teamItem.Name = team.Name
 		teamItem.Description = team.Description
-		teamItem.URL = fmt.Sprintf("%s/teams/%s", h.BaseURL, team.Name)
+		// Notice: The team names are only unique in a project, so the project is always given in the URL.
+		teamItem.URL = fmt.Sprintf("%s/teams/%s?project=%s", h.BaseURL, team.Name, url.QueryEscape(team.Project.Name))
 
 		var projectItem ProjectItem
 		projectItem.ID = team.Project.ID
@@ -99,15 +143,15 @@ func (h *TeamHandler) GetTeams() ([]TeamItem, error) {
 	return teamItems, nil
 }
 
-func (h *TeamHandler) GetTeam(teamName string) (*TeamDetail, error) {
-	var team model.Team
-	err := h.identifierDB.Preload("Repositories").Where("name = ?", teamName).Find(&team).Error
+func (h *TeamHandler) GetTeam(projectName, teamName string) (*TeamDetail, error) {
+	team, err := identifier.FindTeam(h.identifierDB, projectName, teamName)
 	if err != nil {
 		return nil, err
 	}
 
 	var teamMembers []model.TeamMember
-	err = h.identifierDB.Preload("UniqueIdentity").Where("team_id = ?", team.ID).Find(&teamMembers).Error
+	err = h.identifierDB.Preload("UniqueIdentity").Where("team_id = ? and leave_date is null", team.ID).
+		Find(&teamMembers).Error
 	if err != nil {
 		return nil, err
 	}
@@ -120,6 +164,7 @@ func (h *TeamHandler) GetTeam(teamName string) (*TeamDetail, error) {
 	// Group by team role.
 	teamReviewers := make([]TeamMemberItem, 0)
 	teamCommitters := make([]TeamMemberItem, 0)
+	teamApprovers := make([]TeamMemberItem, 0)
 	teamMaintainers := make([]TeamMemberItem, 0)
 	for _, member := range teamMembers {
 		var memberItem TeamMemberItem
@@ -131,12 +176,15 @@ func (h *TeamHandler) GetTeam(teamName string) (*TeamDetail, error) {
 			teamReviewers = append(teamReviewers, memberItem)
 		} else if member.Level == model.TeamCommitter {
 			teamCommitters = append(teamCommitters, memberItem)
+		} else if member.Level == model.TeamApprover {
+			teamApprovers = append(teamApprovers, memberItem)
 		} else if member.Level == model.TeamMaintainer {
 			teamMaintainers = append(teamMaintainers, memberItem)
 		}
 	}
 	teamDetail.Reviewers = teamReviewers
 	teamDetail.Committers = teamCommitters
+	teamDetail.Approvers = teamApprovers
 	teamDetail.Maintainers = teamMaintainers
 
 	// Repositories
@@ -150,9 +198,59 @@ func (h *TeamHandler) GetTeam(teamName string) (*TeamDetail, error) {
 	}
 	teamDetail.Repositories = teamRepositories
 
+	// Metadata
+	teamDetail.Charter = team.Metadata.Charter
+	teamDetail.Channels = make([]model.TeamChannel, 0)
+	teamDetail.Channels = append(teamDetail.Channels, team.Metadata.Channels...)
+	teamDetail.Meetings = make([]model.TeamMeeting, 0)
+	teamDetail.Meetings = append(teamDetail.Meetings, team.Metadata.Meetings...)
+	teamDetail.Mentors, err = h.getTeamMentors(team.Metadata.Mentors)
+	if err != nil {
+		return nil, err
+	}
+
 	return &teamDetail, nil
 }
 
+// getTeamMentors - get the mentors by GitHub logins, the mentor unknown to the identifier DB only has the login.
+func (h *TeamHandler) getTeamMentors(logins []string) ([]TeamMemberItem, error) {
+	mentors := make([]TeamMemberItem, 0)
+	if len(logins) == 0 {
+		return mentors, nil
+	}
+
+	var users []struct {
+		ID    uint
+		Login string
+		Name  string
+	}
+	err := h.identifierDB.Raw(`
+select gu.id, ul.login, ui.name
+from
+    github_user_logins ul
+    inner join github_users gu on gu.id = ul.github_user_id
+    left join unique_identities ui on ui.uuid = gu.uuid
+where
+    ul.login in ?
+`, logins).Scan(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	login2user := make(map[string]TeamMemberItem)
+	for _, user := range users {
+		login2user[strings.ToLower(user.Login)] = TeamMemberItem{ID: user.ID, Login: user.Login, Name: user.Name}
+	}
+	for _, login := range logins {
+		mentor, ok := login2user[strings.ToLower(login)]
+		if !ok {
+			mentor = TeamMemberItem{Login: login}
+		}
+		mentors = append(mentors, mentor)
+	}
+	return mentors, nil
+}
+
 func (h *TeamHandler) GetMembers(level string) ([]MemberItem, error) {
 	var members []struct {
 		GitHubID       uint   `gorm:"column:github_id"`
@@ -173,6 +271,8 @@ from
     left join teams t on t.id = tm.team_id 
     left join unique_identities ui on tm.uuid = ui.uuid 
     left join github_users gu on ui.uuid = gu.uuid
+where
+    tm.leave_date is null
 `)
 	err := query.Find(&members).Error
 	if err != nil {
@@ -0,0 +1,540 @@
+package identifier
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const accountProfileCacheKeyPrefix = "account-profile-result-"
+
+// AccountProfile - the profile of the account fetched from the provider.
+type AccountProfile struct {
+	Username string
+	Name     string
+	Email    string
+	Company  string
+	Location string
+}
+
+// AccountProfileResult - the cached result of fetching the account profile, Err is only set by the former versions
+// which cached the failures.
+type AccountProfileResult struct {
+	Profile AccountProfile
+	Err     string
+}
+
+// AccountEnricher fetches the profile of the accounts of a provider, it is the per provider counterpart of the
+// GitHub profile enrichment in processUniqueIdentity.
+type AccountEnricher interface {
+	Provider() model.AccountProvider
+	GetProfile(account model.Account) (*AccountProfile, error)
+}
+
+// NewAccountEnrichers - create the enrichers of the providers other than GitHub.
+func NewAccountEnrichers(ctx *Ctx, cache Cache) map[model.AccountProvider]AccountEnricher {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	enrichers := []AccountEnricher{
+		&GiteeEnricher{baseURL: ctx.GiteeAPIBaseURL, token: ctx.GiteeToken, httpClient: httpClient, cache: cache},
+		&GitLabEnricher{baseURL: ctx.GitLabAPIBaseURL, token: ctx.GitLabToken, httpClient: httpClient, cache: cache},
+		EmailEnricher{},
+	}
+
+	provider2enricher := make(map[model.AccountProvider]AccountEnricher)
+	for _, enricher := range enrichers {
+		provider2enricher[enricher.Provider()] = enricher
+	}
+	return provider2enricher
+}
+
+// getCachedProfile - get the profile from cache, or fetch it and cache the profile, the errors are not cached so
+// that the transient failures are retried in the next run.
+func getCachedProfile(
+	cache Cache, provider model.AccountProvider, username string, fetch func() (*AccountProfile, error),
+) (*AccountProfile, error) {
+	cacheKey := accountProfileCacheKeyPrefix + string(provider) + "-" + strings.ToLower(username)
+	if cache != nil {
+		if resultCached, ok := cache.Get(cacheKey); ok {
+			// The errors cached by the former versions are ignored.
+			if result := resultCached.(AccountProfileResult); len(result.Err) == 0 {
+				return &result.Profile, nil
+			}
+		}
+	}
+
+	profile, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.Set(cacheKey, AccountProfileResult{Profile: *profile}, DefaultCacheExpiration)
+	}
+	return profile, nil
+}
+
+// getJSON - send the GET request and decode the JSON response.
+func getJSON(httpClient *http.Client, uri string, header http.Header, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s, status: %s", req.URL.Path, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+/*  Gitee Enricher  */
+
+// GiteeEnricher fetches the profile through the Gitee API v5.
+type GiteeEnricher struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	cache      Cache
+}
+
+func (e *GiteeEnricher) Provider() model.AccountProvider {
+	return model.GiteeProvider
+}
+
+func (e *GiteeEnricher) GetProfile(account model.Account) (*AccountProfile, error) {
+	return getCachedProfile(e.cache, model.GiteeProvider, account.Username, func() (*AccountProfile, error) {
+		uri := fmt.Sprintf("%s/users/%s", strings.TrimSuffix(e.baseURL, "/"), url.PathEscape(account.Username))
+		if len(e.token) != 0 {
+			uri += "?access_token=" + url.QueryEscape(e.token)
+		}
+
+		var user struct {
+			Login   string `json:"login"`
+			Name    string `json:"name"`
+			Email   string `json:"email"`
+			Company string `json:"company"`
+		}
+		err := getJSON(e.httpClient, uri, nil, &user)
+		if err != nil {
+			return nil, err
+		}
+		return &AccountProfile{Username: user.Login, Name: user.Name, Email: user.Email, Company: user.Company}, nil
+	})
+}
+
+/*  GitLab Enricher  */
+
+// GitLabEnricher fetches the profile through the GitLab API v4.
+type GitLabEnricher struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	cache      Cache
+}
+
+func (e *GitLabEnricher) Provider() model.AccountProvider {
+	return model.GitLabProvider
+}
+
+func (e *GitLabEnricher) GetProfile(account model.Account) (*AccountProfile, error) {
+	return getCachedProfile(e.cache, model.GitLabProvider, account.Username, func() (*AccountProfile, error) {
+		baseURL := strings.TrimSuffix(e.baseURL, "/")
+		header := http.Header{}
+		if len(e.token) != 0 {
+			header.Set("PRIVATE-TOKEN", e.token)
+		}
+
+		// The detail of the user can only be got by ID.
+		var users []struct {
+			ID int64 `json:"id"`
+		}
+		err := getJSON(e.httpClient, baseURL+"/users?username="+url.QueryEscape(account.Username), header, &users)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, fmt.Errorf("gitlab user %s not found", account.Username)
+		}
+
+		var user struct {
+			Username     string `json:"username"`
+			Name         string `json:"name"`
+			PublicEmail  string `json:"public_email"`
+			Organization string `json:"organization"`
+			Location     string `json:"location"`
+		}
+		err = getJSON(e.httpClient, baseURL+"/users/"+strconv.FormatInt(users[0].ID, 10), header, &user)
+		if err != nil {
+			return nil, err
+		}
+		return &AccountProfile{
+			Username: user.Username,
+			Name:     user.Name,
+			Email:    user.PublicEmail,
+			Company:  user.Organization,
+			Location: user.Location,
+		}, nil
+	})
+}
+
+/*  Email Enricher  */
+
+// EmailEnricher has no remote profile, the name and the address come from the git commits.
+type EmailEnricher struct{}
+
+func (EmailEnricher) Provider() model.AccountProvider {
+	return model.EmailProvider
+}
+
+func (EmailEnricher) GetProfile(account model.Account) (*AccountProfile, error) {
+	profile := &AccountProfile{Email: account.Email}
+	if account.Name != nil {
+		profile.Name = *account.Name
+	}
+	return profile, nil
+}
+
+// emailAccountID - the provider user ID of the email account.
+func emailAccountID(email string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// SyncGitHubAccounts - mirror the GitHub users to the accounts, it is idempotent and keeps github_users as is.
+func SyncGitHubAccounts(log *logrus.Entry, db *gorm.DB) error {
+	n := 0
+	var githubUsers []model.GitHubUser
+	res := db.FindInBatches(&githubUsers, 1000, func(tx *gorm.DB, batch int) error {
+		accounts := make([]model.Account, 0, len(githubUsers))
+		for _, githubUser := range githubUsers {
+			accounts = append(accounts, model.Account{
+				UUID:           githubUser.UUID,
+				Provider:       model.GitHubProvider,
+				ProviderUserID: strconv.FormatUint(uint64(githubUser.ID), 10),
+				Username:       githubUser.Login,
+				Email:          githubUser.Email,
+				Name:           githubUser.Name,
+				Company:        githubUser.Company,
+				Location:       githubUser.Location,
+			})
+		}
+		n += len(accounts)
+		return db.Session(&gorm.Session{NewDB: true}).Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "provider"}, {Name: "provider_user_id"},
+			},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"uuid", "username", "email", "name", "company", "location", "updated_at",
+			}),
+		}).Create(&accounts).Error
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	log.Infof("Synced %d GitHub users to accounts.", n)
+	return nil
+}
+
+// importEmailAccounts - create the email accounts for the commit authors which are not linked to any GitHub user,
+// the addresses known by a unique identity are linked to it. A unique identity is created for the unknown address
+// which authored at least minCommits commits, the others are skipped, 0 means never create identities.
+func importEmailAccounts(log *logrus.Entry, db *gorm.DB, dataSource *gorm.DB, minCommits int) error {
+	var authors []struct {
+		Email   string
+		Name    string
+		Commits int
+	}
+	err := dataSource.Raw(`
+select lower(author_email) as email, max(author_name) as name, count(*) as commits
+from gha_commits
+where author_id is null and author_email != ''
+group by lower(author_email)
+`).Scan(&authors).Error
+	if err != nil {
+		return err
+	}
+
+	var knownEmails []struct {
+		Email string
+		UUID  string
+	}
+	err = db.Raw(`
+select lower(gue.email) as email, gu.uuid as uuid
+from github_user_emails gue inner join github_users gu on gue.github_user_id = gu.id
+where gu.uuid != ''
+union
+select lower(a.email) as email, a.uuid as uuid
+from accounts a
+where a.email != '' and a.uuid != ''
+`).Scan(&knownEmails).Error
+	if err != nil {
+		return err
+	}
+	email2uuid := make(map[string]string)
+	for _, known := range knownEmails {
+		email2uuid[known.Email] = known.UUID
+	}
+
+	var existingIDs []string
+	err = db.Model(&model.Account{}).Where("provider = ?", model.EmailProvider).
+		Pluck("provider_user_id", &existingIDs).Error
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, id := range existingIDs {
+		existing[id] = true
+	}
+
+	nLinked, nCreated, nUnknown := 0, 0, 0
+	for _, author := range authors {
+		email := strings.TrimSpace(author.Email)
+		if !strings.Contains(email, "@") || strings.HasSuffix(email, GitHubNoReplyEmailSuffix) {
+			continue
+		}
+		accountID := emailAccountID(email)
+		if existing[accountID] {
+			continue
+		}
+
+		name := author.Name
+		account := model.Account{
+			Provider:       model.EmailProvider,
+			ProviderUserID: accountID,
+			Username:       email,
+			Email:          email,
+			Name:           &name,
+		}
+		if u, ok := email2uuid[email]; ok {
+			account.UUID = u
+			err = db.Create(&account).Error
+			if err != nil {
+				return err
+			}
+			nLinked++
+		} else {
+			// Notice: Creating an identity for every unknown address would flood the identities with the authors
+			// who never show up again, they can be linked manually by LinkAccount.
+			if minCommits <= 0 || author.Commits < minCommits {
+				nUnknown++
+				continue
+			}
+			account.UUID = uuid.NewString()
+			err = db.Transaction(func(tx *gorm.DB) error {
+				err := tx.Create(&model.UniqueIdentity{
+					UUID:        account.UUID,
+					Name:        name,
+					NameSource:  model.GitCommitSource,
+					Email:       email,
+					EmailSource: model.GitCommitSource,
+				}).Error
+				if err != nil {
+					return err
+				}
+				return tx.Create(&account).Error
+			})
+			if err != nil {
+				return err
+			}
+			email2uuid[email] = account.UUID
+			nCreated++
+		}
+		existing[accountID] = true
+	}
+
+	log.Infof("Imported email accounts of commit authors, %d linked to existing identities, %d identities created, "+
+		"%d unknown skipped.", nLinked, nCreated, nUnknown)
+	return nil
+}
+
+// LinkAccount - link the account of the provider to the unique identity found by UUID or GitHub login.
+func LinkAccount(db *gorm.DB, u, githubLogin string, provider model.AccountProvider, username string) (*model.Account, error) {
+	switch provider {
+	case model.GitLabProvider, model.GiteeProvider, model.EmailProvider:
+	case model.GitHubProvider:
+		return nil, errors.New("github accounts are imported from devstats automatically")
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+	if len(strings.TrimSpace(username)) == 0 {
+		return nil, errors.New("username is required")
+	}
+
+	u, err := findIdentityUUID(db, strings.TrimSpace(u), strings.TrimSpace(githubLogin))
+	if err != nil {
+		return nil, err
+	}
+
+	account := model.Account{
+		UUID:           u,
+		Provider:       provider,
+		ProviderUserID: strings.ToLower(strings.TrimSpace(username)),
+		Username:       strings.TrimSpace(username),
+	}
+	if provider == model.EmailProvider {
+		account.Email = account.ProviderUserID
+		account.ProviderUserID = emailAccountID(account.Email)
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "provider"}, {Name: "provider_user_id"},
+		},
+		DoUpdates: clause.AssignmentColumns([]string{"uuid", "username", "updated_at"}),
+	}).Create(&account).Error
+	return &account, err
+}
+
+// EnrichAccounts - fetch the profiles of the accounts other than GitHub, fill the empty profile fields of the
+// unique identities, and infer the enrollments of the identities without GitHub users from the profiles.
+func EnrichAccounts(
+	log *logrus.Entry, db *gorm.DB, enrichers map[model.AccountProvider]AccountEnricher,
+	orgMatcher *OrgMatcher, domain2org map[string]model.Organization, report *RunReport,
+) error {
+	var accounts []model.Account
+	err := db.Where("provider != ? and uuid != ''", model.GitHubProvider).Order("id").Find(&accounts).Error
+	if err != nil {
+		return err
+	}
+
+	var githubUUIDs []string
+	err = db.Model(&model.GitHubUser{}).Where("uuid != ''").Distinct().Pluck("uuid", &githubUUIDs).Error
+	if err != nil {
+		return err
+	}
+	hasGitHubUser := make(map[string]bool)
+	for _, u := range githubUUIDs {
+		hasGitHubUser[u] = true
+	}
+
+	nEnriched := 0
+	for _, account := range accounts {
+		enricher, ok := enrichers[account.Provider]
+		if !ok {
+			continue
+		}
+
+		var uniqueIdentity model.UniqueIdentity
+		err = db.Where("uuid = ?", account.UUID).First(&uniqueIdentity).Error
+		if err != nil {
+			log.WithError(err).Errorf("Failed to find the unique identity of account %d.", account.ID)
+			continue
+		}
+		// The opted out identity is never fetched, updated or observed again.
+		if uniqueIdentity.OptedOut {
+			continue
+		}
+
+		profile, err := enricher.GetProfile(account)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to get the profile of %s account %s.", account.Provider, account.Username)
+			report.apiFailed(string(account.Provider))
+			continue
+		}
+
+		err = db.Model(&model.Account{}).Where("id = ?", account.ID).Updates(map[string]interface{}{
+			"name":     optionalString(profile.Name),
+			"email":    profile.Email,
+			"company":  optionalString(profile.Company),
+			"location": optionalString(profile.Location),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		updates := make(map[string]interface{})
+		if len(uniqueIdentity.Name) == 0 && len(profile.Name) != 0 {
+			updates["name"] = profile.Name
+			updates["name_source"] = model.AccountProfileSource
+		}
+		if len(uniqueIdentity.Email) == 0 && len(profile.Email) != 0 {
+			updates["email"] = profile.Email
+			updates["email_source"] = model.AccountProfileSource
+		}
+		if len(updates) != 0 {
+			err = db.Model(&model.UniqueIdentity{}).Where("uuid = ?", account.UUID).Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// Notice: The enrollments of the identities with GitHub users are inferred from the GitHub profiles, the
+		// observations from other providers would make the timeline flip between organizations.
+		if !hasGitHubUser[account.UUID] {
+			err = observeAccountProfile(db, account, profile, orgMatcher, domain2org)
+			if err != nil {
+				return err
+			}
+		}
+		nEnriched++
+	}
+
+	log.Infof("Enriched %d/%d accounts of other providers.", nEnriched, len(accounts))
+	return nil
+}
+
+// observeAccountProfile - record the observation of the account profile and infer the enrollments from it.
+func observeAccountProfile(
+	db *gorm.DB, account model.Account, profile *AccountProfile,
+	orgMatcher *OrgMatcher, domain2org map[string]model.Organization,
+) error {
+	observation := model.ProfileObservation{
+		UUID:        account.UUID,
+		AccountID:   account.ID,
+		Company:     profile.Company,
+		EmailDomain: getEmailDomain(profile.Email),
+	}
+	if org := orgMatcher.mapNameToOrg(profile.Company); org != nil {
+		observation.OrgID = org.ID
+		observation.Source = model.AccountProfileSource
+	} else if org, ok := domain2org[observation.EmailDomain]; ok {
+		observation.OrgID = org.ID
+		observation.Source = model.EmailDomainSource
+	}
+	err := recordProfileObservation(db, &observation)
+	if err != nil {
+		return err
+	}
+
+	enrollments := make([]model.Enrollment, 0)
+	db.Where("uuid = ?", account.UUID).Find(&enrollments)
+	observations := make([]model.ProfileObservation, 0)
+	db.Where("uuid = ?", account.UUID).Order("observed_at").Find(&observations)
+	enrollments = mergeObservedEnrollments(enrollments, inferEnrollments(account.UUID, observations))
+
+	for _, enrollment := range enrollments {
+		err = db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "uuid"}, {Name: "org_id"},
+			},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"start_date", "end_date", "source",
+			}),
+		}).Create(&enrollment).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
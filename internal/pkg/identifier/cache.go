@@ -0,0 +1,498 @@
+package identifier
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/lib"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MemoryCacheBackend keeps the cache in memory and dumps it to ID_CACHE_FILE_PATH.
+	MemoryCacheBackend = "memory"
+	// FileCacheBackend stores every cache entry as a file under ID_CACHE_DIR.
+	FileCacheBackend = "file"
+	// SQLCacheBackend stores the cache entries in a table of the identifier database.
+	SQLCacheBackend = "sql"
+)
+
+const (
+	// DefaultCacheExpiration means use the default expiration of the cache.
+	DefaultCacheExpiration time.Duration = 0
+	// NoCacheExpiration means the cache entry never expires.
+	NoCacheExpiration time.Duration = -1
+
+	defaultCacheExpiration = 15 * 24 * time.Hour
+	cacheCleanupInterval   = 30 * 24 * time.Hour
+)
+
+// Cache is used to store the results of the API lookups, such as GitHub users, geocoding and Lark logins.
+type Cache interface {
+	// Get the value of the key, the expired entry is regarded as not found.
+	Get(key string) (interface{}, bool)
+	// Set the value of the key with the TTL, DefaultCacheExpiration and NoCacheExpiration are supported.
+	Set(key string, value interface{}, ttl time.Duration)
+	// DeletePrefix invalidates all the entries whose key starts with the prefix.
+	DeletePrefix(prefix string) error
+	// Flush persists the entries which have not been persisted.
+	Flush() error
+}
+
+// cacheSetter is implemented by the persistent caches, whose writes may fail.
+type cacheSetter interface {
+	set(key string, value interface{}, ttl time.Duration) error
+}
+
+// cacheItem is the gob encoded entry of the persistent cache.
+type cacheItem struct {
+	Key      string
+	Value    interface{}
+	ExpireAt time.Time
+}
+
+var registerCacheTypesOnce sync.Once
+
+// registerCacheTypes - register the types of cached values, so that they can be gob encoded.
+func registerCacheTypes() {
+	registerCacheTypesOnce.Do(func() {
+		gob.Register(github.Response{})
+		gob.Register(github.User{})
+		gob.Register(github.RateLimits{})
+		gob.Register(GitHubGetUserResult{})
+		gob.Register(GitHubGetRepositoryResult{})
+		gob.Register(LocationCacheEntry{})
+		gob.Register(AccountProfileResult{})
+		gob.Register(lib.StringSet{})
+	})
+}
+
+// NewCache - create the cache according to the backend config, the hits and misses are counted for the run report.
+func NewCache(ctx *Ctx, db *gorm.DB) (Cache, error) {
+	var backend Cache
+	var err error
+	switch ctx.CacheBackend {
+	case MemoryCacheBackend:
+		backend, err = NewMemoryCache(ctx.CacheFilePath)
+	case FileCacheBackend:
+		backend, err = NewFileCache(ctx.CacheDir)
+	case SQLCacheBackend:
+		backend, err = NewSQLCache(db)
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %s", ctx.CacheBackend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewStatsCache(backend), nil
+}
+
+/*  Stats Cache  */
+
+// cacheKeyPrefixes - the prefixes of the cache keys, the stats are grouped by them.
+var cacheKeyPrefixes = []string{
+	"github-get-user-by-id-result-",
+	"github-get-user-by-login-result-",
+	"github-repository-result-",
+	locationCacheKeyPrefix,
+	LarkContactGitHubLoginsCacheKey,
+	accountProfileCacheKeyPrefix,
+}
+
+// CacheStats - the hits, misses and failed writes of a kind of cache keys.
+type CacheStats struct {
+	Hits      int     `json:"hits"`
+	Misses    int     `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+	SetErrors int     `json:"set_errors"`
+}
+
+// StatsCache counts the hits and misses of the wrapped cache.
+type StatsCache struct {
+	Cache
+	mtx   sync.Mutex
+	stats map[string]*CacheStats
+}
+
+func NewStatsCache(cache Cache) *StatsCache {
+	return &StatsCache{
+		Cache: cache,
+		stats: make(map[string]*CacheStats),
+	}
+}
+
+func (c *StatsCache) Get(key string) (interface{}, bool) {
+	value, ok := c.Cache.Get(key)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	stats := c.kindStats(key)
+	if ok {
+		stats.Hits++
+	} else {
+		stats.Misses++
+	}
+	return value, ok
+}
+
+func (c *StatsCache) Set(key string, value interface{}, ttl time.Duration) {
+	setter, ok := c.Cache.(cacheSetter)
+	if !ok {
+		c.Cache.Set(key, value, ttl)
+		return
+	}
+
+	err := setter.set(key, value, ttl)
+	if err == nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	stats := c.kindStats(key)
+	// Notice: Only the first failure of every kind of keys is logged, the others are counted in the stats.
+	if stats.SetErrors == 0 {
+		logrus.WithError(err).Warnf("Failed to write the cache entry %s, the later failures are only counted.", key)
+	}
+	stats.SetErrors++
+}
+
+// kindStats - get the stats of the kind of the cache key, the caller must hold the lock.
+func (c *StatsCache) kindStats(key string) *CacheStats {
+	kind := cacheKeyKind(key)
+	stats, exists := c.stats[kind]
+	if !exists {
+		stats = &CacheStats{}
+		c.stats[kind] = stats
+	}
+	return stats
+}
+
+// Stats - get the stats grouped by the kind of cache keys.
+func (c *StatsCache) Stats() map[string]CacheStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	result := make(map[string]CacheStats, len(c.stats))
+	for kind, stats := range c.stats {
+		s := *stats
+		if s.Hits+s.Misses > 0 {
+			s.HitRate = float64(s.Hits) / float64(s.Hits+s.Misses)
+		}
+		result[kind] = s
+	}
+	return result
+}
+
+// cacheKeyKind - get the kind of the cache key, the unknown keys are regarded as "other".
+func cacheKeyKind(key string) string {
+	for _, prefix := range cacheKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return strings.TrimSuffix(prefix, "-")
+		}
+	}
+	return "other"
+}
+
+// getExpireAt - get the expire time of the TTL, zero time means never expire.
+func getExpireAt(ttl time.Duration) time.Time {
+	if ttl == DefaultCacheExpiration {
+		ttl = defaultCacheExpiration
+	}
+	if ttl < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func isExpired(expireAt time.Time) bool {
+	return !expireAt.IsZero() && time.Now().After(expireAt)
+}
+
+// writeFileAtomic - write the data to a temp file and rename it, so that the file is never half written.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// logCacheSetError - log the first failed write of the cache, the cache is only an optimization, so the failure
+// is not returned, wrap the cache with StatsCache to count all the failures.
+func logCacheSetError(once *sync.Once, key string, err error) {
+	if err == nil {
+		return
+	}
+	once.Do(func() {
+		logrus.WithError(err).Warnf("Failed to write the cache entry %s, the later failures are not logged.", key)
+	})
+}
+
+/*  Memory Cache  */
+
+// MemoryCache keeps the entries in memory, and dumps them to file when flushing.
+type MemoryCache struct {
+	filename string
+	memCache *cache.Cache
+	mtx      sync.Mutex
+}
+
+func NewMemoryCache(filename string) (*MemoryCache, error) {
+	registerCacheTypes()
+	c := &MemoryCache{
+		filename: filename,
+		memCache: cache.New(defaultCacheExpiration, cacheCleanupInterval),
+	}
+
+	err := c.memCache.LoadFile(filename)
+	if err != nil && !os.IsNotExist(err) && err.Error() != "EOF" {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *MemoryCache) Get(key string) (interface{}, bool) {
+	return c.memCache.Get(key)
+}
+
+func (c *MemoryCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.memCache.Set(key, value, ttl)
+}
+
+func (c *MemoryCache) DeletePrefix(prefix string) error {
+	for key := range c.memCache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			c.memCache.Delete(key)
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCache) Flush() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var bf bytes.Buffer
+	err := c.memCache.Save(&bf)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.filename, bf.Bytes())
+}
+
+/*  File Cache  */
+
+// FileCache is an embedded key-value store, every entry is stored as a file named by the hash of the key,
+// so that the entries written by different goroutines or processes never overwrite each other.
+type FileCache struct {
+	dir        string
+	setErrOnce sync.Once
+}
+
+func NewFileCache(dir string) (*FileCache, error) {
+	registerCacheTypes()
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCache{dir: dir}, nil
+}
+
+func (c *FileCache) entryPath(key string) string {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+func (c *FileCache) readItem(path string) (*cacheItem, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var item cacheItem
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&item)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (c *FileCache) Get(key string) (interface{}, bool) {
+	path := c.entryPath(key)
+	item, err := c.readItem(path)
+	if err != nil || item.Key != key {
+		return nil, false
+	}
+	if isExpired(item.ExpireAt) {
+		_ = os.Remove(path)
+		return nil, false
+	}
+	return item.Value, true
+}
+
+func (c *FileCache) Set(key string, value interface{}, ttl time.Duration) {
+	logCacheSetError(&c.setErrOnce, key, c.set(key, value, ttl))
+}
+
+func (c *FileCache) set(key string, value interface{}, ttl time.Duration) error {
+	item := cacheItem{
+		Key:      key,
+		Value:    value,
+		ExpireAt: getExpireAt(ttl),
+	}
+
+	var bf bytes.Buffer
+	err := gob.NewEncoder(&bf).Encode(&item)
+	if err != nil {
+		return err
+	}
+
+	path := c.entryPath(key)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bf.Bytes())
+}
+
+func (c *FileCache) DeletePrefix(prefix string) error {
+	return filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.Contains(info.Name(), ".tmp-") {
+			return nil
+		}
+		item, err := c.readItem(path)
+		if err != nil || strings.HasPrefix(item.Key, prefix) || isExpired(item.ExpireAt) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (c *FileCache) Flush() error {
+	// Notice: The entries are persisted when they are set.
+	return nil
+}
+
+/*  SQL Cache  */
+
+// SQLCache stores the entries in the identifier database, so that the cache can be shared between pods.
+type SQLCache struct {
+	db         *gorm.DB
+	setErrOnce sync.Once
+}
+
+func NewSQLCache(db *gorm.DB) (*SQLCache, error) {
+	registerCacheTypes()
+	err := db.AutoMigrate(&model.CacheEntry{})
+	if err != nil {
+		return nil, err
+	}
+	return &SQLCache{db: db}, nil
+}
+
+func (c *SQLCache) Get(key string) (interface{}, bool) {
+	var entry model.CacheEntry
+	err := c.db.Where("cache_key = ?", key).Limit(1).Find(&entry).Error
+	if err != nil || entry.CacheKey != key {
+		return nil, false
+	}
+	if entry.ExpireAt != nil && isExpired(*entry.ExpireAt) {
+		c.db.Where("cache_key = ?", key).Delete(&model.CacheEntry{})
+		return nil, false
+	}
+
+	var item cacheItem
+	err = gob.NewDecoder(bytes.NewReader(entry.Value)).Decode(&item)
+	if err != nil {
+		return nil, false
+	}
+	return item.Value, true
+}
+
+func (c *SQLCache) Set(key string, value interface{}, ttl time.Duration) {
+	logCacheSetError(&c.setErrOnce, key, c.set(key, value, ttl))
+}
+
+func (c *SQLCache) set(key string, value interface{}, ttl time.Duration) error {
+	item := cacheItem{
+		Key:      key,
+		Value:    value,
+		ExpireAt: getExpireAt(ttl),
+	}
+
+	var bf bytes.Buffer
+	err := gob.NewEncoder(&bf).Encode(&item)
+	if err != nil {
+		return err
+	}
+
+	entry := model.CacheEntry{
+		CacheKey: key,
+		Value:    bf.Bytes(),
+	}
+	if !item.ExpireAt.IsZero() {
+		entry.ExpireAt = &item.ExpireAt
+	}
+
+	// Notice: Insert or update in one statement, so the entry is written atomically.
+	return c.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "cache_key"},
+		},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"value", "expire_at", "updated_at",
+		}),
+	}).Create(&entry).Error
+}
+
+func (c *SQLCache) DeletePrefix(prefix string) error {
+	return c.db.Where("cache_key like ?", escapeLikeStr(prefix)+"%").Delete(&model.CacheEntry{}).Error
+}
+
+func (c *SQLCache) Flush() error {
+	// Notice: Clean up the expired entries, the other entries are persisted when they are set.
+	return c.db.Where("expire_at < ?", time.Now()).Delete(&model.CacheEntry{}).Error
+}
+
+// escapeLikeStr - escape the wildcard symbols of the SQL like pattern.
+func escapeLikeStr(str string) string {
+	// Notice: \ must be in the first place.
+	escapeArr := []string{"\\", "%", "_"}
+	for _, symbol := range escapeArr {
+		str = strings.ReplaceAll(str, symbol, "\\"+symbol)
+	}
+	return str
+}
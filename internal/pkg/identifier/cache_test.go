@@ -0,0 +1,133 @@
+package identifier
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/gob"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFileCache(t *testing.T) {
+	c, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file cache: %v", err)
+	}
+
+	c.Set(locationCacheKeyPrefix+"beijing", LocationCacheEntry{CountryCode: "CN"}, DefaultCacheExpiration)
+	c.Set(locationCacheKeyPrefix+"shanghai", LocationCacheEntry{CountryCode: "CN"}, NoCacheExpiration)
+	c.Set("github-get-user-by-id-result-1", GitHubGetUserResult{Err: "not found"}, DefaultCacheExpiration)
+
+	value, ok := c.Get(locationCacheKeyPrefix + "beijing")
+	if !ok {
+		t.Fatalf("Expect hit the cache.")
+	}
+	if entry := value.(LocationCacheEntry); entry.CountryCode != "CN" {
+		t.Errorf("Expect country code: CN, but got %s", entry.CountryCode)
+	}
+
+	c.Set("github-get-user-by-id-result-3", GitHubGetUserResult{}, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := c.Get("github-get-user-by-id-result-3"); ok {
+		t.Errorf("Expect the expired entry is not found.")
+	}
+
+	err = c.DeletePrefix(locationCacheKeyPrefix)
+	if err != nil {
+		t.Fatalf("Failed to delete by prefix: %v", err)
+	}
+	if _, ok := c.Get(locationCacheKeyPrefix + "beijing"); ok {
+		t.Errorf("Expect the entry is deleted by prefix.")
+	}
+	if _, ok := c.Get(locationCacheKeyPrefix + "shanghai"); ok {
+		t.Errorf("Expect the entry is deleted by prefix.")
+	}
+	if _, ok := c.Get("github-get-user-by-id-result-1"); !ok {
+		t.Errorf("Expect the entry without the prefix is kept.")
+	}
+}
+
+func TestEscapeLikeStr(t *testing.T) {
+	if got := escapeLikeStr(`a_b%c\`); got != `a\_b\%c\\` {
+		t.Errorf("Expect escaped string: %s, but got %s", `a\_b\%c\\`, got)
+	}
+}
+
+func TestStatsCache(t *testing.T) {
+	backend, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file cache: %v", err)
+	}
+	c := NewStatsCache(backend)
+
+	c.Set(locationCacheKeyPrefix+"beijing", LocationCacheEntry{CountryCode: "CN"}, DefaultCacheExpiration)
+	c.Get(locationCacheKeyPrefix + "beijing")
+	c.Get(locationCacheKeyPrefix + "beijing")
+	c.Get(locationCacheKeyPrefix + "shanghai")
+	c.Get("github-get-user-by-id-result-1")
+	c.Get("unknown")
+
+	stats := c.Stats()
+	expect := map[string]CacheStats{
+		"formatted-location":           {Hits: 2, Misses: 1, HitRate: 2.0 / 3},
+		"github-get-user-by-id-result": {Hits: 0, Misses: 1, HitRate: 0},
+		"other":                        {Hits: 0, Misses: 1, HitRate: 0},
+	}
+	if len(stats) != len(expect) {
+		t.Fatalf("Expect %d kinds of keys, but got %d", len(expect), len(stats))
+	}
+	for kind, expectStats := range expect {
+		if stats[kind] != expectStats {
+			t.Errorf("Expect stats of %s: %v, but got %v", kind, expectStats, stats[kind])
+		}
+	}
+}
+
+func TestSQLCache(t *testing.T) {
+	registerCacheTypes()
+	var bf bytes.Buffer
+	err := gob.NewEncoder(&bf).Encode(&cacheItem{
+		Key:   locationCacheKeyPrefix + "beijing",
+		Value: LocationCacheEntry{CountryCode: "CN"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode cache item: %v", err)
+	}
+
+	db, fake := openFakeDB(t, []fakeResult{
+		{
+			pattern: `INSERT INTO "identifier_caches"`,
+			err:     errors.New("connection reset"),
+		},
+		{
+			pattern: `SELECT * FROM "identifier_caches"`,
+			columns: []string{"cache_key", "value", "expire_at", "updated_at"},
+			rows:    [][]driver.Value{{locationCacheKeyPrefix + "beijing", bf.Bytes(), nil, time.Now()}},
+		},
+	})
+	c := NewStatsCache(&SQLCache{db: db})
+
+	value, ok := c.Get(locationCacheKeyPrefix + "beijing")
+	if !ok {
+		t.Fatalf("Expect hit the cache.")
+	}
+	if entry := value.(LocationCacheEntry); entry.CountryCode != "CN" {
+		t.Errorf("Expect country code: CN, but got %s", entry.CountryCode)
+	}
+
+	c.Set(locationCacheKeyPrefix+"shanghai", LocationCacheEntry{CountryCode: "CN"}, DefaultCacheExpiration)
+	c.Set(locationCacheKeyPrefix+"hangzhou", LocationCacheEntry{CountryCode: "CN"}, NoCacheExpiration)
+	inserts := fake.executed(`INSERT INTO "identifier_caches"`, `ON CONFLICT ("cache_key") DO UPDATE`)
+	if len(inserts) != 2 {
+		t.Fatalf("Expect 2 upserts of the cache entries, but got %d", len(inserts))
+	}
+	if inserts[1].args[2] != nil {
+		t.Errorf("Expect the entry without expiration is stored with null expire_at, but got %v", inserts[1].args[2])
+	}
+
+	stats := c.Stats()["formatted-location"]
+	if stats.Hits != 1 || stats.SetErrors != 2 {
+		t.Errorf("Expect 1 hit and 2 failed writes, but got %v", stats)
+	}
+}
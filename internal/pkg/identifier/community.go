@@ -0,0 +1,274 @@
+package identifier
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultTeamsFolderPath and DefaultMembershipFileName are used when the project does not set them.
+	DefaultTeamsFolderPath    = "teams"
+	DefaultMembershipFileName = "membership.json"
+)
+
+// defaultMembershipFileNames - the membership file of each team folder for the team_json and owners formats, and
+// the path of the membership file in the repository for the other formats.
+var defaultMembershipFileNames = map[string]string{
+	TeamJSONFormat:            DefaultMembershipFileName,
+	OwnersFormat:              "OWNERS",
+	CodeOwnersFormat:          ".github/CODEOWNERS",
+	MaintainersMarkdownFormat: "MAINTAINERS.md",
+	SigsYamlFormat:            "sigs.yaml",
+}
+
+var commitSHARegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// CommunityConfig is the data structure of communities.yaml file.
+type CommunityConfig struct {
+	Projects []CommunityProject `yaml:"communities"`
+}
+
+// CommunityProject - the community repository of a project, the teams and members are synced from it.
+type CommunityProject struct {
+	Name             string `yaml:"name"`
+	DisplayName      string `yaml:"display_name"`
+	DefaultOrgName   string `yaml:"default_org"`
+	CommunityRepoURL string `yaml:"repo_url"`
+	Disabled         bool   `yaml:"disabled"`
+
+	// Format is the format of the membership files, "team_json", "owners", "codeowners", "maintainers_md" or
+	// "sigs_yaml", default "team_json".
+	Format             string `yaml:"format"`
+	TeamsFolderPath    string `yaml:"teams_path"`
+	MembershipFileName string `yaml:"membership_file"`
+	StartCommitSHA     string `yaml:"start_commit"`
+}
+
+// LoadCommunityConfig - read the community projects from the YAML file, fill the defaults and validate them.
+func LoadCommunityConfig(filepath string) (CommunityConfig, error) {
+	var config CommunityConfig
+
+	bytesYaml, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return config, err
+	}
+
+	err = yaml.Unmarshal(bytesYaml, &config)
+	if err != nil {
+		return config, err
+	}
+
+	for i := range config.Projects {
+		config.Projects[i].fillDefaults()
+	}
+
+	return config, config.Validate()
+}
+
+func (p *CommunityProject) fillDefaults() {
+	if len(p.DisplayName) == 0 {
+		p.DisplayName = p.Name
+	}
+	if len(p.Format) == 0 {
+		p.Format = TeamJSONFormat
+	}
+	if len(p.TeamsFolderPath) == 0 && (p.Format == TeamJSONFormat || p.Format == OwnersFormat) {
+		p.TeamsFolderPath = DefaultTeamsFolderPath
+	}
+	if len(p.MembershipFileName) == 0 {
+		p.MembershipFileName = defaultMembershipFileNames[p.Format]
+	}
+}
+
+// Validate - check the required fields, the duplicated projects and the formats, all the problems are reported
+// at once.
+func (c CommunityConfig) Validate() error {
+	problems := make([]string, 0)
+	names := make(map[string]struct{})
+	for i, project := range c.Projects {
+		if len(project.Name) == 0 {
+			problems = append(problems, fmt.Sprintf("communities[%d]: name is required", i))
+			continue
+		}
+		if _, ok := names[project.Name]; ok {
+			problems = append(problems, fmt.Sprintf("%s: duplicated project", project.Name))
+		}
+		names[project.Name] = struct{}{}
+
+		if len(project.CommunityRepoURL) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: repo_url is required", project.Name))
+		}
+		if len(project.DefaultOrgName) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: default_org is required", project.Name))
+		}
+		if _, err := NewMembershipParser(project.Format); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", project.Name, err))
+		}
+		if len(project.MembershipFileName) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: membership_file is required", project.Name))
+		}
+		if len(project.StartCommitSHA) != 0 && !commitSHARegexp.MatchString(project.StartCommitSHA) {
+			problems = append(problems, fmt.Sprintf("%s: start_commit %q is not a full commit SHA", project.Name, project.StartCommitSHA))
+		}
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("invalid community config: %v", problems)
+	}
+	return nil
+}
+
+// SyncCommunityRepo - clone the community repository into the directory as a bare repository, or fetch the new
+// commits of all the branches if it has been cloned before.
+func SyncCommunityRepo(dir string, project CommunityProject) (*git.Repository, error) {
+	r, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return git.PlainClone(dir, true, &git.CloneOptions{URL: project.CommunityRepoURL})
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The branches are mirrored, so that the HEAD of the bare repository points to the latest commit.
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/heads/*"},
+		Force:      true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CommunityCommits - get the commits of HEAD touching the paths in ascending order of the committer time. If the
+// last processed commit is given, only the commits after it are returned, otherwise the commits are returned
+// from the start commit (inclusive) if it is given, or from the beginning of the history.
+func CommunityCommits(r *git.Repository, paths []string, startCommitSHA, lastCommitSHA string) ([]*object.Commit, error) {
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	// The commits before the boundary commit are excluded.
+	excluded := make(map[plumbing.Hash]struct{})
+	boundarySHA := lastCommitSHA
+	if len(boundarySHA) == 0 {
+		boundarySHA = startCommitSHA
+	}
+	if len(boundarySHA) != 0 {
+		boundary := plumbing.NewHash(boundarySHA)
+		if _, err = r.CommitObject(boundary); err != nil {
+			return nil, fmt.Errorf("failed to find commit %s: %w", boundarySHA, err)
+		}
+		ancestors, err := r.Log(&git.LogOptions{From: boundary})
+		if err != nil {
+			return nil, err
+		}
+		err = ancestors.ForEach(func(commit *object.Commit) error {
+			excluded[commit.Hash] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(lastCommitSHA) == 0 {
+			delete(excluded, boundary)
+		}
+	}
+
+	gitLog, err := r.Log(&git.LogOptions{
+		From:  head.Hash(),
+		Order: git.LogOrderCommitterTime,
+		PathFilter: func(p string) bool {
+			for _, prefix := range paths {
+				if strings.HasPrefix(p, prefix) {
+					return true
+				}
+			}
+			return false
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]*object.Commit, 0)
+	err = gitLog.ForEach(func(commit *object.Commit) error {
+		if commit.Hash.String() == lastCommitSHA {
+			// Notice: The rest commits are older than the last processed commit, the commits of the merged
+			// branches among them are covered by the merge commits, replaying them would restore the stale state.
+			return storer.ErrStop
+		}
+		if _, ok := excluded[commit.Hash]; !ok {
+			commits = append(commits, commit)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort by commit date in ascending order.
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// CommunityMergeBase - get the SHA of the best common ancestor of the base ref and the head ref, the head ref is
+// compared with it so that the changes merged to the base ref after the head ref forked are not regarded as reverted.
+func CommunityMergeBase(r *git.Repository, baseRef, headRef string) (string, error) {
+	commits := make([]*object.Commit, 0, 2)
+	for _, ref := range []string{baseRef, headRef} {
+		hash, err := r.ResolveRevision(plumbing.Revision(ref))
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+		}
+		commit, err := r.CommitObject(*hash)
+		if err != nil {
+			return "", err
+		}
+		commits = append(commits, commit)
+	}
+
+	mergeBases, err := commits[0].MergeBase(commits[1])
+	if err != nil {
+		return "", err
+	}
+	if len(mergeBases) == 0 {
+		return "", fmt.Errorf("%s and %s have no common ancestor", baseRef, headRef)
+	}
+	return mergeBases[0].Hash.String(), nil
+}
+
+// ParseCommunityRef - parse the teams of the community repository at the revision, such as a branch, a tag or a
+// commit SHA.
+func ParseCommunityRef(r *git.Repository, project CommunityProject, ref string) ([]TeamMembership, error) {
+	parser, err := NewMembershipParser(project.Format)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return parser.Parse(GitTreeFiles{Tree: tree}, project)
+}
@@ -0,0 +1,197 @@
+package identifier
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// commitFile - write the file in the worktree and commit it at the time.
+func commitFile(t *testing.T, r *git.Repository, dir, name, contents string, when time.Time) plumbing.Hash {
+	filePath := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatalf("Failed to create the directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write the file: %v", err)
+	}
+
+	worktree, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get the worktree: %v", err)
+	}
+	if _, err = worktree.Add(name); err != nil {
+		t.Fatalf("Failed to add the file: %v", err)
+	}
+	signature := &object.Signature{Name: "test", Email: "test@example.com", When: when}
+	hash, err := worktree.Commit("Update "+name, &git.CommitOptions{Author: signature, Committer: signature})
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	return hash
+}
+
+func TestCommunityCommits(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "community")
+	if err != nil {
+		t.Fatalf("Failed to create the temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// The community repository is a local bare repository, the commits are pushed from the source repository.
+	bareDir := filepath.Join(tmpDir, "community.git")
+	if _, err = git.PlainInit(bareDir, true); err != nil {
+		t.Fatalf("Failed to init the bare repository: %v", err)
+	}
+	srcDir := filepath.Join(tmpDir, "src")
+	src, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init the source repository: %v", err)
+	}
+	_, err = src.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{bareDir}})
+	if err != nil {
+		t.Fatalf("Failed to create the remote: %v", err)
+	}
+	push := func() {
+		err := src.Push(&git.PushOptions{RemoteName: git.DefaultRemoteName})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			t.Fatalf("Failed to push: %v", err)
+		}
+	}
+
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c1 := commitFile(t, src, srcDir, "teams/sql/membership.json", `{"maintainers": ["alice"]}`, base)
+	commitFile(t, src, srcDir, "README.md", "Community", base.Add(time.Hour))
+	c3 := commitFile(t, src, srcDir, "teams/docs/membership.json", `{"maintainers": ["bob"]}`, base.Add(2*time.Hour))
+	push()
+
+	project := CommunityProject{Name: "test", CommunityRepoURL: bareDir}
+	cloneDir := filepath.Join(tmpDir, "repos", "sync_teams", project.Name)
+	paths := []string{"teams"}
+	hashes := func(commits []*object.Commit) []plumbing.Hash {
+		result := make([]plumbing.Hash, 0, len(commits))
+		for _, commit := range commits {
+			result = append(result, commit.Hash)
+		}
+		return result
+	}
+
+	r, err := SyncCommunityRepo(cloneDir, project)
+	if err != nil {
+		t.Fatalf("Failed to clone the community repository: %v", err)
+	}
+
+	var testcases = []struct {
+		name           string
+		startCommitSHA string
+		lastCommitSHA  string
+
+		expectCommits []plumbing.Hash
+	}{
+		{
+			name:          "all the commits touching the teams",
+			expectCommits: []plumbing.Hash{c1, c3},
+		},
+		{
+			name:           "from the start commit",
+			startCommitSHA: c3.String(),
+			expectCommits:  []plumbing.Hash{c3},
+		},
+		{
+			name:           "after the last processed commit",
+			startCommitSHA: c1.String(),
+			lastCommitSHA:  c1.String(),
+			expectCommits:  []plumbing.Hash{c3},
+		},
+		{
+			name:          "up to date",
+			lastCommitSHA: c3.String(),
+			expectCommits: []plumbing.Hash{},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			commits, err := CommunityCommits(r, paths, tc.startCommitSHA, tc.lastCommitSHA)
+			if err != nil {
+				t.Fatalf("Failed to get the commits: %v", err)
+			}
+			if got := hashes(commits); !reflect.DeepEqual(got, tc.expectCommits) {
+				t.Errorf("Expect commits %v, but got %v.", tc.expectCommits, got)
+			}
+		})
+	}
+
+	// The new commits are fetched incrementally.
+	c4 := commitFile(t, src, srcDir, "teams/sql/membership.json", `{"maintainers": ["alice", "carol"]}`, base.Add(3*time.Hour))
+	push()
+	r, err = SyncCommunityRepo(cloneDir, project)
+	if err != nil {
+		t.Fatalf("Failed to fetch the community repository: %v", err)
+	}
+	commits, err := CommunityCommits(r, paths, "", c3.String())
+	if err != nil {
+		t.Fatalf("Failed to get the commits: %v", err)
+	}
+	if got := hashes(commits); !reflect.DeepEqual(got, []plumbing.Hash{c4}) {
+		t.Errorf("Expect commits %v, but got %v.", []plumbing.Hash{c4}, got)
+	}
+
+	// The unknown last processed commit requires a full replay.
+	_, err = CommunityCommits(r, paths, "", "0000000000000000000000000000000000000000")
+	if err == nil {
+		t.Errorf("Expect error for the unknown commit.")
+	}
+}
+
+func TestCommunityMergeBase(t *testing.T) {
+	srcDir := t.TempDir()
+	src, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init the source repository: %v", err)
+	}
+	worktree, err := src.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get the worktree: %v", err)
+	}
+	checkout := func(branch string, create bool) {
+		err := worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create})
+		if err != nil {
+			t.Fatalf("Failed to checkout %s: %v", branch, err)
+		}
+	}
+
+	// The feature branch forks from c1, and the base branch moves on after that.
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c1 := commitFile(t, src, srcDir, "teams/sql/membership.json", `{"maintainers": ["alice"]}`, base)
+	checkout("feature", true)
+	commitFile(t, src, srcDir, "teams/sql/membership.json", `{"maintainers": ["alice", "bob"]}`, base.Add(time.Hour))
+	checkout("master", false)
+	c3 := commitFile(t, src, srcDir, "teams/docs/membership.json", `{"maintainers": ["carol"]}`, base.Add(2*time.Hour))
+
+	mergeBase, err := CommunityMergeBase(src, "master", "feature")
+	if err != nil {
+		t.Fatalf("Failed to get the merge base: %v", err)
+	}
+	if mergeBase != c1.String() {
+		t.Errorf("Expect the merge base %s, but got %s.", c1, mergeBase)
+	}
+	if mergeBase, _ = CommunityMergeBase(src, c1.String(), "master"); mergeBase != c1.String() {
+		t.Errorf("Expect the merge base %s of the ancestor, but got %s.", c1, mergeBase)
+	}
+	if mergeBase, _ = CommunityMergeBase(src, "master", "master"); mergeBase != c3.String() {
+		t.Errorf("Expect the merge base %s of the same ref, but got %s.", c3, mergeBase)
+	}
+	if _, err = CommunityMergeBase(src, "master", "unknown"); err == nil {
+		t.Errorf("Expect error for the unknown ref.")
+	}
+}
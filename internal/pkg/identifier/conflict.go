@@ -0,0 +1,240 @@
+package identifier
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/lib"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+// AffiliationConflict - a GitHub login whose affiliation in the identifier disagrees with cncf/gitdm.
+type AffiliationConflict struct {
+	Login            string   `json:"login"`
+	UUID             string   `json:"uuid"`
+	Affiliation      string   `json:"affiliation"`
+	Sources          []string `json:"sources"`
+	Priority         int      `json:"priority"`
+	GitdmAffiliation string   `json:"gitdm_affiliation"`
+	GitdmSource      string   `json:"gitdm_source"`
+	GitdmPriority    int      `json:"gitdm_priority"`
+}
+
+// profileSource2priority - map the source of enrollment to the priority of cncf/gitdm affiliation source.
+var profileSource2priority = map[model.ProfileSource]int{
+	model.EmailDomainSource:    source2priority["domain"],
+	model.GitHubProfileSource:  source2priority[""],
+	model.GitHubJSONSource:     source2priority[""],
+	model.AccountProfileSource: source2priority[""],
+	model.SortingHatSource:     source2priority[""],
+	model.LarkContactSource:    source2priority["config"],
+	model.ManualSource:         source2priority["manual"],
+	model.UserManualSource:     source2priority["user_manual"],
+}
+
+// canOverrideSource - whether the enrollment of the source can override the enrollment of the existing source,
+// the manual sources are never overridden by the other sources.
+func canOverrideSource(source, existing model.ProfileSource) bool {
+	if isManualSource(existing) && !isManualSource(source) {
+		return false
+	}
+	return profileSource2priority[source] >= profileSource2priority[existing]
+}
+
+// conflictLogin - a GitHub login known by the identifier.
+type conflictLogin struct {
+	Login string
+	UUID  string `gorm:"column:uuid"`
+	IsBot bool
+}
+
+// ReportAffiliationConflicts - list every login whose affiliation disagrees with cncf/gitdm, and output the
+// report to JSON or CSV file according to the file extension.
+func ReportAffiliationConflicts(log *logrus.Entry, ctx *Ctx, db *gorm.DB) {
+	githubUsersFromJSON := loadGitHubUsersFromJSON(ctx.GitHubUsersJSONSourcePath)
+	log.Infof("Found %d GitHub user profile from json file.", len(githubUsersFromJSON))
+
+	pattern2org, _ := loadOrgMappings(log, db)
+
+	var loginItems []conflictLogin
+	err := db.Raw(`
+select distinct ul.login as login, u.uuid as uuid, ui.is_bot as is_bot
+from
+    github_user_logins ul
+    left join github_users u on u.id = ul.github_user_id
+    left join unique_identities ui on ui.uuid = u.uuid
+where u.uuid is not null and u.uuid != ''
+`).Scan(&loginItems).Error
+	if err != nil {
+		log.WithError(err).Errorf("Failed to get the GitHub logins.")
+		return
+	}
+
+	uuid2enrollments := make(map[string][]EnrollmentWithOrg)
+	getEnrollments := func(uuid string) []EnrollmentWithOrg {
+		enrollments, ok := uuid2enrollments[uuid]
+		if !ok {
+			enrollments = getEnrollmentsWithOrg(db, uuid)
+			uuid2enrollments[uuid] = enrollments
+		}
+		return enrollments
+	}
+	conflicts := findAffiliationConflicts(pattern2org, githubUsersFromJSON, loginItems, getEnrollments)
+
+	log.Infof("Found %d logins whose affiliation disagrees with cncf/gitdm.", len(conflicts))
+
+	var bf bytes.Buffer
+	filename := ctx.AffConflictsReportPath
+	if strings.HasSuffix(strings.ToLower(filename), ".csv") {
+		err = encodeAffiliationConflictsToCSV(&bf, conflicts)
+	} else {
+		encoder := json.NewEncoder(&bf)
+		encoder.SetIndent("", "\t")
+		encoder.SetEscapeHTML(false)
+		err = encoder.Encode(&conflicts)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Failed to encode affiliation conflicts report.")
+		return
+	}
+
+	err = ioutil.WriteFile(filename, bf.Bytes(), 0666)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to output affiliation conflicts report.")
+		return
+	}
+	log.Infof("Output %s successfully.", filename)
+}
+
+// findAffiliationConflicts - compare every entry of cncf/gitdm, regardless of its source, with the affiliation of
+// the same login in the identifier, the logins unknown to the identifier are skipped.
+func findAffiliationConflicts(
+	pattern2org map[*regexp.Regexp]model.Organization, githubUsersFromJSON []GitHubUserFromJSON,
+	loginItems []conflictLogin, getEnrollments func(uuid string) []EnrollmentWithOrg,
+) []AffiliationConflict {
+	login2items := make(map[string][]conflictLogin)
+	for _, item := range loginItems {
+		login2items[item.Login] = append(login2items[item.Login], item)
+	}
+
+	conflicts := make([]AffiliationConflict, 0)
+	reported := make(map[[4]string]struct{})
+	for _, jsonUser := range githubUsersFromJSON {
+		for _, item := range login2items[jsonUser.Login] {
+			enrollments := getEnrollments(item.UUID)
+			affs := getAffStr(enrollments)
+			if item.IsBot {
+				affs = "(Robots)"
+			}
+			gitdmAffs := jsonUser.Affiliation
+			if normalizeAffStr(pattern2org, affs) == normalizeAffStr(pattern2org, gitdmAffs) {
+				continue
+			}
+
+			sourceSet := make(lib.StringSet)
+			priority := source2priority["notfound"]
+			for _, enrollment := range enrollments {
+				sourceSet[string(enrollment.Source)] = struct{}{}
+				if p, ok := profileSource2priority[enrollment.Source]; ok && p > priority {
+					priority = p
+				}
+			}
+			sources := sourceSet.ToArray()
+			sort.Strings(sources)
+
+			gitdmSource := strings.ToLower(jsonUser.Source)
+			conflict := AffiliationConflict{
+				Login:            item.Login,
+				UUID:             item.UUID,
+				Affiliation:      affs,
+				Priority:         priority,
+				GitdmAffiliation: gitdmAffs,
+				GitdmSource:      gitdmSource,
+				GitdmPriority:    source2priority[gitdmSource],
+				Sources:          sources,
+			}
+			// The same entry can be listed more than once in cncf/gitdm.
+			key := [4]string{item.Login, item.UUID, gitdmAffs, gitdmSource}
+			if _, ok := reported[key]; ok {
+				continue
+			}
+			reported[key] = struct{}{}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return strings.Compare(conflicts[i].Login, conflicts[j].Login) < 0
+	})
+	return conflicts
+}
+
+func encodeAffiliationConflictsToCSV(bf *bytes.Buffer, conflicts []AffiliationConflict) error {
+	writer := csv.NewWriter(bf)
+	err := writer.Write([]string{
+		"login", "uuid", "affiliation", "sources", "priority", "gitdm_affiliation", "gitdm_source", "gitdm_priority",
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, conflict := range conflicts {
+		err = writer.Write([]string{
+			conflict.Login,
+			conflict.UUID,
+			conflict.Affiliation,
+			strings.Join(conflict.Sources, ";"),
+			strconv.Itoa(conflict.Priority),
+			conflict.GitdmAffiliation,
+			conflict.GitdmSource,
+			strconv.Itoa(conflict.GitdmPriority),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// normalizeAffStr - normalize the affiliation string so that the results of two sources can be compared,
+// the company names are mapped to the organization names by patterns.
+func normalizeAffStr(pattern2org map[*regexp.Regexp]model.Organization, affs string) string {
+	if isInvalidAffStr(affs) {
+		return ""
+	}
+
+	normalized := make([]string, 0)
+	for _, aff := range strings.Split(affs, ", ") {
+		ary := strings.Split(aff, " < ")
+		company := strings.TrimSpace(ary[0])
+		if company == "" {
+			continue
+		}
+		if org := matchOrgByPattern(pattern2org, company); org != nil {
+			company = org.Name
+		}
+		company = strings.ToLower(company)
+
+		if len(ary) > 1 {
+			company = company + " < " + lib.TimeParseAny(strings.TrimSpace(ary[1])).Format("2006-01-02")
+		}
+		normalized = append(normalized, company)
+	}
+
+	return strings.Join(normalized, ", ")
+}
+
+// isInvalidAffStr - the affiliation string without valid information.
+func isInvalidAffStr(affs string) bool {
+	return affs == "NotFound" || affs == "(Unknown)" || affs == "?" || affs == "-" || affs == ""
+}
@@ -19,12 +19,35 @@ type Ctx struct {
 	SkipBots                 bool // From SKIP_BOTS, default false
 	SkipAutoImportProfile    bool // From SKIP_AUTO_IMPORT_PROFILE, default false.
 	SkipOutputGitHubUserJSON bool // From SKIP_OUTPUT_GITHUB_USER_JSON, default false.
+	SkipOrgRollup            bool // From ID_SKIP_ORG_ROLLUP, default false.
+	SkipTzInference          bool // From ID_SKIP_TZ_INFERENCE, default false.
+	SkipAccounts             bool // From ID_SKIP_ACCOUNTS, default false, skip the accounts of other providers.
 
-	GitHubUsersJSONSourcePath string // From ID_GITHUB_USERS_JSON_SOURCE_PATH
-	GitHubUsersJSONOutputPath string // From ID_GITHUB_USERS_JSON_OUTPUT_PATH
-	CountryCodesFilePath      string // From ID_COUNTRY_CODES_FILE_PATH, default "configs/shared/countries.csv"
-	CacheFilePath             string // From ID_CACHE_FILE_PATH, default "~/dump.out"
-	OrganizationsFilePath     string // From ID_ORGANIZATION_CONFIG_YAML, default "configs/shared/organizations.yaml"
+	GitHubUsersJSONSourcePath string  // From ID_GITHUB_USERS_JSON_SOURCE_PATH
+	GitHubUsersJSONOutputPath string  // From ID_GITHUB_USERS_JSON_OUTPUT_PATH
+	CountryCodesFilePath      string  // From ID_COUNTRY_CODES_FILE_PATH, default "configs/shared/countries.csv"
+	CacheBackend              string  // From ID_CACHE_BACKEND, "memory", "file" or "sql", default "memory"
+	CacheFilePath             string  // From ID_CACHE_FILE_PATH, default "~/dump.out", used by memory backend
+	CacheDir                  string  // From ID_CACHE_DIR, default "identifier_cache", used by file backend
+	OrganizationsFilePath     string  // From ID_ORGANIZATION_CONFIG_YAML, default "configs/shared/organizations.yaml"
+	CommunityConfigPath       string  // From ID_COMMUNITY_CONFIG_YAML, default "configs/shared/communities.yaml"
+	CompanyAcqFilePath        string  // From ID_COMPANY_ACQ_YAML, file or URL of import_affs companies.yaml, empty means skip
+	AffConflictsReportPath    string  // From ID_AFF_CONFLICTS_REPORT_PATH, default "affiliation_conflicts.json"
+	ValidateOrgsSampleSize    int     // From ID_VALIDATE_ORGS_SAMPLE_SIZE, default 100, 0 means skip the sample mapping
+	QualityReportPath         string  // From ID_QUALITY_REPORT_PATH, default "data_quality.md"
+	QualityActiveDays         int     // From ID_QUALITY_ACTIVE_DAYS, default 90
+	QualityStaleDays          int     // From ID_QUALITY_STALE_DAYS, default 180
+	QualityTopN               int     // From ID_QUALITY_TOP_N, default 50
+	TeamMetricsDays           int     // From ID_TEAM_METRICS_DAYS, default 90, the default period of team metrics
+	TeamInactiveDays          int     // From ID_TEAM_INACTIVE_DAYS, default 90
+	PromotionRulesPath        string  // From ID_PROMOTION_RULES_YAML, default "configs/shared/promotion_rules.yaml"
+	RunReportPath             string  // From ID_RUN_REPORT_PATH, the JSON file of the run report, empty means skip
+	TzInferenceDays           int     // From ID_TZ_INFERENCE_DAYS, default 365, 0 means all the events
+	TzMinActivities           int     // From ID_TZ_MIN_ACTIVITIES, default 30
+	DemographicEnricher       string  // From ID_DEMOGRAPHIC_ENRICHER, "none" or "local", default "none"
+	DemographicDatasetPath    string  // From ID_DEMOGRAPHIC_DATASET_PATH, the CSV file used by local enricher
+	OrgSimilarityThreshold    float64 // From ID_ORG_SIMILARITY_THRESHOLD, default 0.9
+	EmailAuthorMinCommits     int     // From ID_EMAIL_AUTHOR_MIN_COMMITS, default 10, 0 means never create identities
 
 	GoogleMapAPIKey string // From GOOGLE_MAP_API_KEY
 
@@ -32,6 +55,11 @@ type Ctx struct {
 	LarkAppID      string // From LARK_APP_ID
 	LarkAppSecret  string // From LARK_APP_SECRET
 
+	GiteeAPIBaseURL  string // From GITEE_API_BASE_URL, default "https://gitee.com/api/v5"
+	GiteeToken       string // From GITEE_TOKEN
+	GitLabAPIBaseURL string // From GITLAB_API_BASE_URL, default "https://gitlab.com/api/v4"
+	GitLabToken      string // From GITLAB_TOKEN
+
 	S3UploadGitHubUsersJSON    bool   // From S3_UPLOAD_GITHUB_USERS_JSON
 	S3GitHubUsersJSONBucket    string // From S3_GITHUB_USERS_JSON_BUCKET
 	S3GitHubUsersJSONBucketKey string // From S3_GITHUB_USERS_JSON_BUCKET_KEY
@@ -40,6 +68,7 @@ type Ctx struct {
 	AwsDefaultRegion           string // From AWS_DEFAULT_REGION
 
 	DevstatsAPIBaseURL string // From DEVSTATS_API_BASE_URL
+	APIAdminToken      string // From API_ADMIN_TOKEN, the admin endpoints are disabled if it is empty
 
 	lib.Ctx
 }
@@ -93,6 +122,13 @@ func (c *Ctx) Init() error {
 	if c.OrganizationsFilePath == "" {
 		c.OrganizationsFilePath = "configs/shared/organizations.yaml"
 	}
+	c.CompanyAcqFilePath = os.Getenv("ID_COMPANY_ACQ_YAML")
+
+	// Community
+	c.CommunityConfigPath = os.Getenv("ID_COMMUNITY_CONFIG_YAML")
+	if c.CommunityConfigPath == "" {
+		c.CommunityConfigPath = "configs/shared/communities.yaml"
+	}
 
 	c.GitHubUsersJSONOutputPath = os.Getenv("ID_GITHUB_USERS_JSON_OUTPUT_PATH")
 	if c.GitHubUsersJSONOutputPath == "" {
@@ -104,11 +140,93 @@ func (c *Ctx) Init() error {
 		c.GitHubUsersJSONSourcePath = "https://media.githubusercontent.com/media/cncf/gitdm/master/src/github_users.json"
 	}
 
+	c.AffConflictsReportPath = os.Getenv("ID_AFF_CONFLICTS_REPORT_PATH")
+	if c.AffConflictsReportPath == "" {
+		c.AffConflictsReportPath = "affiliation_conflicts.json"
+	}
+
+	var err error
+	c.ValidateOrgsSampleSize, err = envInt("ID_VALIDATE_ORGS_SAMPLE_SIZE", 100)
+	if err != nil {
+		return err
+	}
+
+	// Data quality report.
+	c.QualityReportPath = os.Getenv("ID_QUALITY_REPORT_PATH")
+	if c.QualityReportPath == "" {
+		c.QualityReportPath = "data_quality.md"
+	}
+	c.QualityActiveDays, err = envInt("ID_QUALITY_ACTIVE_DAYS", 90)
+	if err != nil {
+		return err
+	}
+	c.QualityStaleDays, err = envInt("ID_QUALITY_STALE_DAYS", 180)
+	if err != nil {
+		return err
+	}
+	c.QualityTopN, err = envInt("ID_QUALITY_TOP_N", 50)
+	if err != nil {
+		return err
+	}
+
+	// Team metrics.
+	c.TeamMetricsDays, err = envInt("ID_TEAM_METRICS_DAYS", 90)
+	if err != nil {
+		return err
+	}
+	c.TeamInactiveDays, err = envInt("ID_TEAM_INACTIVE_DAYS", 90)
+	if err != nil {
+		return err
+	}
+
+	// Promotion eligibility.
+	c.PromotionRulesPath = os.Getenv("ID_PROMOTION_RULES_YAML")
+	if c.PromotionRulesPath == "" {
+		c.PromotionRulesPath = "configs/shared/promotion_rules.yaml"
+	}
+
+	// Run report.
+	c.RunReportPath = os.Getenv("ID_RUN_REPORT_PATH")
+
+	// Timezone inference.
+	c.TzInferenceDays, err = envInt("ID_TZ_INFERENCE_DAYS", 365)
+	if err != nil {
+		return err
+	}
+	c.TzMinActivities, err = envInt("ID_TZ_MIN_ACTIVITIES", 30)
+	if err != nil {
+		return err
+	}
+
+	// Demographic enrichment.
+	c.DemographicEnricher = NoopDemographicEnricher
+	if os.Getenv("ID_DEMOGRAPHIC_ENRICHER") != "" {
+		c.DemographicEnricher = os.Getenv("ID_DEMOGRAPHIC_ENRICHER")
+	}
+	c.DemographicDatasetPath = os.Getenv("ID_DEMOGRAPHIC_DATASET_PATH")
+
+	// Organization matching.
+	c.OrgSimilarityThreshold = 0.9
+	if os.Getenv("ID_ORG_SIMILARITY_THRESHOLD") != "" {
+		c.OrgSimilarityThreshold, err = strconv.ParseFloat(os.Getenv("ID_ORG_SIMILARITY_THRESHOLD"), 64)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Cache
+	c.CacheBackend = MemoryCacheBackend
+	if os.Getenv("ID_CACHE_BACKEND") != "" {
+		c.CacheBackend = os.Getenv("ID_CACHE_BACKEND")
+	}
 	c.CacheFilePath = "~/dump.out"
 	if os.Getenv("ID_CACHE_FILE_PATH") != "" {
 		c.CacheFilePath = os.Getenv("ID_CACHE_FILE_PATH")
 	}
+	c.CacheDir = "identifier_cache"
+	if os.Getenv("ID_CACHE_DIR") != "" {
+		c.CacheDir = os.Getenv("ID_CACHE_DIR")
+	}
 
 	// Skip
 	c.SkipBots = false
@@ -126,6 +244,25 @@ func (c *Ctx) Init() error {
 		c.SkipOutputGitHubUserJSON = true
 	}
 
+	c.SkipOrgRollup = false
+	if os.Getenv("ID_SKIP_ORG_ROLLUP") != "" {
+		c.SkipOrgRollup = true
+	}
+
+	c.SkipTzInference = false
+	if os.Getenv("ID_SKIP_TZ_INFERENCE") != "" {
+		c.SkipTzInference = true
+	}
+
+	c.SkipAccounts = false
+	if os.Getenv("ID_SKIP_ACCOUNTS") != "" {
+		c.SkipAccounts = true
+	}
+	c.EmailAuthorMinCommits, err = envInt("ID_EMAIL_AUTHOR_MIN_COMMITS", 10)
+	if err != nil {
+		return err
+	}
+
 	// Google Maps
 	c.GoogleMapAPIKey = os.Getenv("GOOGLE_MAP_API_KEY")
 
@@ -137,6 +274,18 @@ func (c *Ctx) Init() error {
 	c.LarkAppID = os.Getenv("LARK_APP_ID")
 	c.LarkAppSecret = os.Getenv("LARK_APP_SECRET")
 
+	// Gitee and GitLab
+	c.GiteeAPIBaseURL = os.Getenv("GITEE_API_BASE_URL")
+	if len(c.GiteeAPIBaseURL) == 0 {
+		c.GiteeAPIBaseURL = "https://gitee.com/api/v5"
+	}
+	c.GiteeToken = os.Getenv("GITEE_TOKEN")
+	c.GitLabAPIBaseURL = os.Getenv("GITLAB_API_BASE_URL")
+	if len(c.GitLabAPIBaseURL) == 0 {
+		c.GitLabAPIBaseURL = "https://gitlab.com/api/v4"
+	}
+	c.GitLabToken = os.Getenv("GITLAB_TOKEN")
+
 	// S3
 	c.S3UploadGitHubUsersJSON = false
 	if os.Getenv("S3_UPLOAD_GITHUB_USERS_JSON") != "" {
@@ -150,6 +299,16 @@ func (c *Ctx) Init() error {
 	c.AwsDefaultRegion = os.Getenv("AWS_DEFAULT_REGION")
 
 	c.DevstatsAPIBaseURL = os.Getenv("DEVSTATS_API_BASE_URL")
+	c.APIAdminToken = os.Getenv("API_ADMIN_TOKEN")
 
 	return nil
 }
+
+// envInt - get the integer value of the environment variable, or the default value if it is not set.
+func envInt(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
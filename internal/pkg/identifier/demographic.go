@@ -0,0 +1,189 @@
+package identifier
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// NoopDemographicEnricher disables the demographic enrichment, it is the default.
+	NoopDemographicEnricher = "none"
+	// LocalDemographicEnricher infers the gender from a local dataset of first names.
+	LocalDemographicEnricher = "local"
+)
+
+// DemographicResult - the demographic information inferred by the enricher.
+type DemographicResult struct {
+	Gender    string
+	GenderAcc float64
+	// Provenance describes where the result comes from, such as the enricher and the dataset.
+	Provenance string
+}
+
+// DemographicEnricher is the pluggable stage to infer the demographic information of a person, it only runs
+// when it is explicitly enabled.
+type DemographicEnricher interface {
+	// Enabled returns false if the enricher never infers anything.
+	Enabled() bool
+	// Enrich infers the demographic information from the name and the country code, the country code may be empty.
+	Enrich(name, countryCode string) (*DemographicResult, bool)
+}
+
+// NewDemographicEnricher - create the demographic enricher according to the config.
+func NewDemographicEnricher(ctx *Ctx) (DemographicEnricher, error) {
+	switch ctx.DemographicEnricher {
+	case "", NoopDemographicEnricher:
+		return NoopEnricher{}, nil
+	case LocalDemographicEnricher:
+		return NewLocalDatasetEnricher(ctx.DemographicDatasetPath)
+	default:
+		return nil, fmt.Errorf("unknown demographic enricher: %s", ctx.DemographicEnricher)
+	}
+}
+
+/*  Noop Enricher  */
+
+// NoopEnricher infers nothing.
+type NoopEnricher struct{}
+
+func (NoopEnricher) Enabled() bool {
+	return false
+}
+
+func (NoopEnricher) Enrich(string, string) (*DemographicResult, bool) {
+	return nil, false
+}
+
+/*  Local Dataset Enricher  */
+
+// LocalDatasetEnricher infers the gender by the first name from a CSV file, which has the header
+// `name,gender,probability[,country_code]`, the rows with country code take precedence.
+type LocalDatasetEnricher struct {
+	provenance string
+	// name2result is keyed by the lower case first name, or the first name and country code joined by `/`.
+	name2result map[string]DemographicResult
+}
+
+func NewLocalDatasetEnricher(filename string) (*LocalDatasetEnricher, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return newLocalDatasetEnricher(f, fmt.Sprintf("%s:%s", LocalDemographicEnricher, filepath.Base(filename)))
+}
+
+func newLocalDatasetEnricher(r io.Reader, provenance string) (*LocalDatasetEnricher, error) {
+	e := &LocalDatasetEnricher{
+		provenance:  provenance,
+		name2result: make(map[string]DemographicResult),
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	line := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line++
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(row[0]), "name") {
+			continue
+		}
+		if len(row) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 columns, got %d", line, len(row))
+		}
+
+		gender := strings.ToLower(strings.TrimSpace(row[1]))
+		if gender != "m" && gender != "f" {
+			return nil, fmt.Errorf("line %d: unknown gender %q, expected m or f", line, row[1])
+		}
+		prob, err := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		if err != nil || prob < 0 || prob > 1 {
+			return nil, fmt.Errorf("line %d: invalid probability %q", line, row[2])
+		}
+
+		key := strings.ToLower(strings.TrimSpace(row[0]))
+		if len(row) > 3 && len(strings.TrimSpace(row[3])) != 0 {
+			key = key + "/" + strings.ToUpper(strings.TrimSpace(row[3]))
+		}
+		e.name2result[key] = DemographicResult{Gender: gender, GenderAcc: prob, Provenance: provenance}
+	}
+
+	return e, nil
+}
+
+func (e *LocalDatasetEnricher) Enabled() bool {
+	return true
+}
+
+func (e *LocalDatasetEnricher) Enrich(name, countryCode string) (*DemographicResult, bool) {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	firstName := strings.ToLower(fields[0])
+
+	if len(countryCode) != 0 {
+		if result, ok := e.name2result[firstName+"/"+strings.ToUpper(countryCode)]; ok {
+			return &result, true
+		}
+	}
+	if result, ok := e.name2result[firstName]; ok {
+		return &result, true
+	}
+	return nil, false
+}
+
+// EnrichDemographics - the opt-in stage of AutoImportProfile, which fills the demographic information of the
+// identities, the manually provided values, bots and the opted out identities are skipped.
+func EnrichDemographics(log *logrus.Entry, db *gorm.DB, enricher DemographicEnricher) {
+	if !enricher.Enabled() {
+		log.Infof("Demographic enrichment is disabled.")
+		return
+	}
+
+	var uniqueIdentities []model.UniqueIdentity
+	db.Where(
+		"is_bot = ? and opted_out = ? and (gender_source is null or gender_source not in ?)",
+		false, false, []model.ProfileSource{model.ManualSource, model.UserManualSource},
+	).Find(&uniqueIdentities)
+
+	nEnriched := 0
+	now := time.Now().Format("2006-01-02")
+	for _, uniqueIdentity := range uniqueIdentities {
+		countryCode := ""
+		if uniqueIdentity.CountryCode != nil {
+			countryCode = *uniqueIdentity.CountryCode
+		}
+		result, ok := enricher.Enrich(uniqueIdentity.Name, countryCode)
+		if !ok {
+			continue
+		}
+
+		db.Model(&model.UniqueIdentity{}).Where("uuid = ?", uniqueIdentity.UUID).Updates(map[string]interface{}{
+			"gender":            result.Gender,
+			"gender_acc":        result.GenderAcc,
+			"gender_source":     model.DemographicDatasetSource,
+			"gender_provenance": fmt.Sprintf("%s@%s", result.Provenance, now),
+		})
+		nEnriched++
+	}
+
+	log.Infof("Enriched the demographic information of %d/%d identities.", nEnriched, len(uniqueIdentities))
+}
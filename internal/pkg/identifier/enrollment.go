@@ -0,0 +1,199 @@
+package identifier
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+// isObservedSource returns true if the enrollment of the source is derived from profile observations.
+func isObservedSource(source model.ProfileSource) bool {
+	return source == model.GitHubProfileSource || source == model.EmailDomainSource || source == model.LarkContactSource ||
+		source == model.AccountProfileSource
+}
+
+// isManualSource returns true if the information of the source is provided through manual verification.
+func isManualSource(source model.ProfileSource) bool {
+	return source == model.ManualSource || source == model.UserManualSource
+}
+
+// getEmailDomain - get the domain name part of the email, the GitHub noreply email is ignored.
+func getEmailDomain(email string) string {
+	if strings.HasSuffix(email, GitHubNoReplyEmailSuffix) {
+		return ""
+	}
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[i+1:]))
+}
+
+// findEmailDomainOrg - find the organization of the first email whose domain is known, the emails are checked in
+// the order of preference, the domain of the first email is returned when no domain is known.
+func findEmailDomainOrg(
+	domain2org map[string]model.Organization, emails []string,
+) (string, *model.Organization) {
+	firstDomain := ""
+	for _, email := range emails {
+		domain := getEmailDomain(email)
+		if len(domain) == 0 {
+			continue
+		}
+		if len(firstDomain) == 0 {
+			firstDomain = domain
+		}
+		if org, ok := domain2org[domain]; ok {
+			return domain, &org
+		}
+	}
+	return firstDomain, nil
+}
+
+// sameObservation returns true if the affiliation related information of the observations is the same.
+func sameObservation(a, b model.ProfileObservation) bool {
+	return a.OrgID == b.OrgID && a.Source == b.Source && a.Company == b.Company && a.EmailDomain == b.EmailDomain &&
+		a.IsLarkEmployee == b.IsLarkEmployee
+}
+
+// recordProfileObservation - save the affiliation related information observed at this time.
+//
+// A new observation is only inserted when the information differs from the latest observation of the same profile,
+// otherwise the last seen time of the latest observation is updated. Returning to an earlier organization is a
+// change too, so the history is kept.
+func recordProfileObservation(db *gorm.DB, observation *model.ProfileObservation) error {
+	if observation.ObservedAt.IsZero() {
+		observation.ObservedAt = time.Now()
+	}
+	observation.LastSeenAt = observation.ObservedAt
+
+	var latest model.ProfileObservation
+	err := db.Where(
+		"uuid = ? and github_user_id = ? and account_id = ?",
+		observation.UUID, observation.GitHubUserID, observation.AccountID,
+	).Order("observed_at desc").Limit(1).Find(&latest).Error
+	if err != nil {
+		return err
+	}
+	if latest.ID != 0 && sameObservation(latest, *observation) {
+		return db.Model(&latest).Update("last_seen_at", observation.LastSeenAt).Error
+	}
+	return db.Create(observation).Error
+}
+
+// inferEnrollments - derive the dated enrollments from the profile observations of a unique identity.
+//
+// The consecutive observations of the same organization are merged into one enrollment, which starts at the
+// time of the first observation and ends when another organization is observed, the last enrollment is open.
+// The enrollments are ordered by their start dates.
+// Observations without organization are regarded as no information and are skipped.
+func inferEnrollments(uuid string, observations []model.ProfileObservation) []model.Enrollment {
+	sorted := make([]model.ProfileObservation, 0, len(observations))
+	for _, observation := range observations {
+		if observation.OrgID != 0 {
+			sorted = append(sorted, observation)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ObservedAt.Before(sorted[j].ObservedAt)
+	})
+
+	enrollments := make([]model.Enrollment, 0)
+	for _, observation := range sorted {
+		lastIndex := len(enrollments) - 1
+		if lastIndex >= 0 && enrollments[lastIndex].OrgID == observation.OrgID {
+			continue
+		}
+		if lastIndex >= 0 {
+			enrollments[lastIndex].EndDate = observation.ObservedAt
+		}
+		enrollments = append(enrollments, model.Enrollment{
+			UUID:      uuid,
+			OrgID:     observation.OrgID,
+			StartDate: observation.ObservedAt,
+			EndDate:   model.DefaultEndDate,
+			Source:    observation.Source,
+		})
+	}
+
+	// Notice: One person can only have one enrollment in the same organization, if they returned to an organization,
+	// the enrollment spans from the first to the last period, so no history is lost, the enrollments of the
+	// organizations in between overlap with it, and getAffStr splits it into the periods again.
+	index := make(map[uint]int)
+	result := make([]model.Enrollment, 0, len(enrollments))
+	for _, enrollment := range enrollments {
+		if i, ok := index[enrollment.OrgID]; ok {
+			result[i].EndDate = enrollment.EndDate
+			result[i].Source = enrollment.Source
+			continue
+		}
+		index[enrollment.OrgID] = len(result)
+		result = append(result, enrollment)
+	}
+
+	return result
+}
+
+// mergeObservedEnrollments - merge the enrollments inferred from observations into the existing enrollments.
+//
+// The existing enrollments that have been observed before are replaced, the other enrollments are kept as the
+// history and closed at the time of the first observation. If there is no history before the first observation,
+// the first inferred enrollment starts from the default start date. When an open manual enrollment exists,
+// the observations are ignored because the manual verification has the highest priority.
+func mergeObservedEnrollments(enrollments []model.Enrollment, observed []model.Enrollment) []model.Enrollment {
+	if len(observed) == 0 {
+		return enrollments
+	}
+
+	for _, enrollment := range enrollments {
+		if isManualSource(enrollment.Source) && !enrollment.Invalid && enrollment.EndDate.Equal(model.DefaultEndDate) {
+			return enrollments
+		}
+	}
+
+	observedOrgIDs := make(map[uint]bool)
+	for _, enrollment := range observed {
+		observedOrgIDs[enrollment.OrgID] = true
+	}
+
+	firstObservedAt := observed[0].StartDate
+	hasHistory := false
+	result := make([]model.Enrollment, 0, len(enrollments)+len(observed))
+
+	for _, enrollment := range enrollments {
+		if observedOrgIDs[enrollment.OrgID] {
+			if isObservedSource(enrollment.Source) {
+				// Replaced by the inferred enrollment.
+				continue
+			}
+			// Keep the enrollment of other sources, the inferred enrollment of the same org is dropped.
+			observedOrgIDs[enrollment.OrgID] = false
+		} else if enrollment.StartDate.Before(firstObservedAt) && enrollment.EndDate.After(firstObservedAt) {
+			enrollment.EndDate = firstObservedAt
+		}
+
+		if enrollment.StartDate.Before(firstObservedAt) {
+			hasHistory = true
+		}
+		result = append(result, enrollment)
+	}
+
+	for i, enrollment := range observed {
+		if !observedOrgIDs[enrollment.OrgID] {
+			continue
+		}
+		if i == 0 && !hasHistory {
+			enrollment.StartDate = model.DefaultStartDate
+		}
+		result = append(result, enrollment)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartDate.Before(result[j].StartDate)
+	})
+
+	return result
+}
@@ -0,0 +1,126 @@
+package identifier
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeResult is the rows returned by the fake database for the queries containing the pattern, the statements
+// containing the pattern fail if the error is set.
+type fakeResult struct {
+	pattern string
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// fakeDB records the executed statements, the queries are answered by the first matched result.
+type fakeDB struct {
+	results    []fakeResult
+	statements []fakeStatement
+}
+
+func (d *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: d}, nil }
+func (d *fakeDB) Driver() driver.Driver                        { return nil }
+
+// executed - get the statements containing all the patterns.
+func (d *fakeDB) executed(patterns ...string) []fakeStatement {
+	statements := make([]fakeStatement, 0)
+	for _, statement := range d.statements {
+		matched := true
+		for _, pattern := range patterns {
+			if !strings.Contains(statement.query, pattern) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.statements = append(s.db.statements, fakeStatement{query: s.query, args: args})
+	for _, result := range s.db.results {
+		if strings.Contains(s.query, result.pattern) && result.err != nil {
+			return nil, result.err
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.statements = append(s.db.statements, fakeStatement{query: s.query, args: args})
+	for _, result := range s.db.results {
+		if strings.Contains(s.query, result.pattern) {
+			if result.err != nil {
+				return nil, result.err
+			}
+			return &fakeRows{columns: result.columns, rows: result.rows}, nil
+		}
+	}
+	return &fakeRows{columns: []string{"id"}}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func openFakeDB(t *testing.T, results []fakeResult) (*gorm.DB, *fakeDB) {
+	fake := &fakeDB{results: results}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fake)}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open the fake database: %v", err)
+	}
+	return db, fake
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/google/go-github/github"
-	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 	"github.com/ti-community-infra/devstats/internal/pkg/lib"
 )
@@ -23,7 +22,7 @@ type GitHubClient struct {
 	ghCtx       context.Context
 	mtx         sync.Mutex
 	log         *logrus.Entry
-	memCache    *cache.Cache
+	memCache    Cache
 	clientIndex int
 	maxRetry    int
 }
@@ -38,7 +37,7 @@ type GitHubGetRepositoryResult struct {
 	Err        string
 }
 
-func (c *GitHubClient) Init(ctx *Ctx, log *logrus.Entry, cache *cache.Cache) error {
+func (c *GitHubClient) Init(ctx *Ctx, log *logrus.Entry, cache Cache) error {
 	ghCtx, githubClients := lib.GHClient(&ctx.Ctx)
 	c.gcs = githubClients
 	c.mtx = sync.Mutex{}
@@ -91,7 +90,7 @@ func (c *GitHubClient) GetUserByID(id int64) (*github.User, *github.Response, er
 					User: *user,
 					Err:  "",
 				}
-				c.memCache.Set(cacheKey, resultWithoutError, cache.DefaultExpiration)
+				c.memCache.Set(cacheKey, resultWithoutError, DefaultCacheExpiration)
 			}
 			return user, res, nil
 		}
@@ -103,7 +102,7 @@ func (c *GitHubClient) GetUserByID(id int64) (*github.User, *github.Response, er
 		resultWithError := GitHubGetUserResult{
 			Err: err.Error(),
 		}
-		c.memCache.Set(cacheKey, resultWithError, cache.DefaultExpiration)
+		c.memCache.Set(cacheKey, resultWithError, DefaultCacheExpiration)
 	}
 
 	return nil, nil, err
@@ -149,7 +148,7 @@ func (c *GitHubClient) GetUserByLogin(login string) (*github.User, *github.Respo
 					User: *user,
 					Err:  "",
 				}
-				c.memCache.Set(cacheKey, resultWithoutError, cache.DefaultExpiration)
+				c.memCache.Set(cacheKey, resultWithoutError, DefaultCacheExpiration)
 			}
 			return user, res, nil
 		}
@@ -161,7 +160,7 @@ func (c *GitHubClient) GetUserByLogin(login string) (*github.User, *github.Respo
 		resultWithError := GitHubGetUserResult{
 			Err: err.Error(),
 		}
-		c.memCache.Set(cacheKey, resultWithError, cache.DefaultExpiration)
+		c.memCache.Set(cacheKey, resultWithError, DefaultCacheExpiration)
 	}
 
 	return nil, nil, err
@@ -210,7 +209,7 @@ func (c *GitHubClient) GetRepository(owner, repo string) (*github.Repository, *g
 					Repository: *repository,
 					Err:        "",
 				}
-				c.memCache.Set(cacheKey, resultWithoutError, cache.DefaultExpiration)
+				c.memCache.Set(cacheKey, resultWithoutError, DefaultCacheExpiration)
 			}
 			return repository, res, nil
 		}
@@ -225,7 +224,7 @@ func (c *GitHubClient) GetRepository(owner, repo string) (*github.Repository, *g
 		resultWithError := GitHubGetUserResult{
 			Err: err.Error(),
 		}
-		c.memCache.Set(cacheKey, resultWithError, cache.DefaultExpiration)
+		c.memCache.Set(cacheKey, resultWithError, DefaultCacheExpiration)
 	}
 
 	return nil, nil, err
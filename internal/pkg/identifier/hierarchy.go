@@ -0,0 +1,417 @@
+package identifier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gopkg.in/yaml.v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrgParentMapping - the parent organization defined in the `parents` of the organizations.yaml file, the dates
+// are in the format of 2006-01-02, and the relation is always effective if the dates are empty.
+type OrgParentMapping struct {
+	Name      string                `yaml:"name"`
+	Type      model.OrgRelationType `yaml:"type"`
+	StartDate string                `yaml:"start_date"`
+	EndDate   string                `yaml:"end_date"`
+}
+
+// companyAcquisitions is the data structure of companies.yaml file used by import_affs, every acquisition
+// contains the regular expression of the acquired company names and the name of the new owner.
+type companyAcquisitions struct {
+	Acquisitions [][2]string `yaml:"acquisitions"`
+}
+
+const orgRelationDateLayout = "2006-01-02"
+
+// maxOrgHierarchyDepth is used to prevent the infinite loop caused by the cycle of relations.
+const maxOrgHierarchyDepth = 16
+
+// OrgHierarchy is the parent/child and acquired-by relationships between organizations.
+type OrgHierarchy struct {
+	id2org   map[uint]model.Organization
+	parents  map[uint][]model.OrgRelation
+	children map[uint][]model.OrgRelation
+}
+
+// LoadOrgHierarchy - load the organizations and their relations from the identifier database.
+func LoadOrgHierarchy(db *gorm.DB) (*OrgHierarchy, error) {
+	var organizations []model.Organization
+	err := db.Find(&organizations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var relations []model.OrgRelation
+	err = db.Find(&relations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return newOrgHierarchy(organizations, relations), nil
+}
+
+func newOrgHierarchy(organizations []model.Organization, relations []model.OrgRelation) *OrgHierarchy {
+	h := &OrgHierarchy{
+		id2org:   make(map[uint]model.Organization),
+		parents:  make(map[uint][]model.OrgRelation),
+		children: make(map[uint][]model.OrgRelation),
+	}
+
+	for _, org := range organizations {
+		h.id2org[org.ID] = org
+	}
+
+	for _, relation := range relations {
+		// The relations to invalid organizations are ignored, otherwise the enrollments will be hidden.
+		parent, ok := h.id2org[relation.ParentOrgID]
+		if !ok || parent.Invalid || relation.OrgID == relation.ParentOrgID {
+			continue
+		}
+		h.parents[relation.OrgID] = append(h.parents[relation.OrgID], relation)
+		h.children[relation.ParentOrgID] = append(h.children[relation.ParentOrgID], relation)
+	}
+
+	return h
+}
+
+// Org - get the organization by ID.
+func (h *OrgHierarchy) Org(orgID uint) (model.Organization, bool) {
+	org, ok := h.id2org[orgID]
+	return org, ok
+}
+
+func isRelationActive(relation model.OrgRelation, date time.Time) bool {
+	return !relation.StartDate.After(date) && relation.EndDate.After(date)
+}
+
+// Parent - get the relation to the parent organization which is effective on the date, the latest one is used if
+// there are more than one.
+func (h *OrgHierarchy) Parent(orgID uint, date time.Time) *model.OrgRelation {
+	var parent *model.OrgRelation
+	for _, relation := range h.parents[orgID] {
+		if !isRelationActive(relation, date) {
+			continue
+		}
+		if parent == nil || relation.StartDate.After(parent.StartDate) {
+			r := relation
+			parent = &r
+		}
+	}
+	return parent
+}
+
+// Root - get the top organization that the organization is rolled up to on the date.
+func (h *OrgHierarchy) Root(orgID uint, date time.Time) uint {
+	for i := 0; i < maxOrgHierarchyDepth; i++ {
+		parent := h.Parent(orgID, date)
+		if parent == nil {
+			break
+		}
+		orgID = parent.ParentOrgID
+	}
+	return orgID
+}
+
+// Children - get the relations to the child organizations which are effective on the date.
+func (h *OrgHierarchy) Children(orgID uint, date time.Time) []model.OrgRelation {
+	children := make([]model.OrgRelation, 0)
+	for _, relation := range h.children[orgID] {
+		// Notice: The child is rolled up to another parent if it has more than one parent on the date.
+		if parent := h.Parent(relation.OrgID, date); parent != nil && parent.ParentOrgID == orgID {
+			children = append(children, relation)
+		}
+	}
+	return children
+}
+
+// Descendants - get the organization and all the organizations rolled up to it on the date.
+func (h *OrgHierarchy) Descendants(orgID uint, date time.Time) []uint {
+	visited := map[uint]bool{orgID: true}
+	result := []uint{orgID}
+	for i := 0; i < len(result); i++ {
+		for _, relation := range h.Children(result[i], date) {
+			if !visited[relation.OrgID] {
+				visited[relation.OrgID] = true
+				result = append(result, relation.OrgID)
+			}
+		}
+	}
+	return result
+}
+
+// boundaries - get the dates when the root of the organization may change.
+func (h *OrgHierarchy) boundaries(orgID uint) []time.Time {
+	dates := make([]time.Time, 0)
+	visited := make(map[uint]bool)
+	queue := []uint{orgID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		for _, relation := range h.parents[id] {
+			dates = append(dates, relation.StartDate, relation.EndDate)
+			queue = append(queue, relation.ParentOrgID)
+		}
+	}
+	return dates
+}
+
+// rollupEnrollments - replace the organizations of enrollments with their top organizations, the enrollment is
+// split if the organization is acquired during the enrollment, such as GitHub 2015-2020 becomes
+// GitHub 2015-2018 and Microsoft 2018-2020.
+func (h *OrgHierarchy) rollupEnrollments(enrollments []EnrollmentWithOrg) []EnrollmentWithOrg {
+	result := make([]EnrollmentWithOrg, 0, len(enrollments))
+	for _, enrollment := range enrollments {
+		dates := []time.Time{enrollment.StartDate, enrollment.EndDate}
+		for _, date := range h.boundaries(enrollment.OrgID) {
+			if date.After(enrollment.StartDate) && date.Before(enrollment.EndDate) {
+				dates = append(dates, date)
+			}
+		}
+		sort.Slice(dates, func(i, j int) bool {
+			return dates[i].Before(dates[j])
+		})
+
+		for i := 0; i < len(dates)-1; i++ {
+			if !dates[i].Before(dates[i+1]) {
+				continue
+			}
+
+			segment := enrollment
+			segment.StartDate = dates[i]
+			segment.EndDate = dates[i+1]
+			if root, ok := h.id2org[h.Root(enrollment.OrgID, dates[i])]; ok {
+				segment.OrgID = root.ID
+				segment.OrgName = root.Name
+			}
+
+			// Merge the continuous segments of the same organization.
+			if n := len(result); n > 0 && result[n-1].OrgID == segment.OrgID &&
+				!result[n-1].EndDate.Before(segment.StartDate) {
+				if segment.EndDate.After(result[n-1].EndDate) {
+					result[n-1].EndDate = segment.EndDate
+				}
+				continue
+			}
+			result = append(result, segment)
+		}
+	}
+	return result
+}
+
+// SyncOrgRelations - import the relations of organizations from the organizations.yaml file and the acquisitions
+// from the companies.yaml file of import_affs, so that the two affiliation systems agree with each other.
+func SyncOrgRelations(log *logrus.Entry, ctx *Ctx, db *gorm.DB) error {
+	relations := make([]model.OrgRelation, 0)
+
+	// The acquisitions of companies.yaml have no date, the acquired companies are always rolled up like import_affs.
+	if len(ctx.CompanyAcqFilePath) != 0 {
+		acquisitions, err := loadOrgAcquisitions(log, db, ctx.CompanyAcqFilePath, ctx.OrgSimilarityThreshold)
+		if err != nil {
+			return err
+		}
+		relations = append(relations, acquisitions...)
+	}
+
+	// The relations defined in organizations.yaml take precedence over the acquisitions.
+	orgConfig, err := loadOrgMappingsFromYaml(ctx.OrganizationsFilePath)
+	if err != nil {
+		return err
+	}
+	for _, mapping := range orgConfig.OrgMappings {
+		if len(mapping.Parents) == 0 {
+			continue
+		}
+		org, err := findOrCreateOrgByName(db, mapping.Name)
+		if err != nil {
+			return err
+		}
+		for _, parentMapping := range mapping.Parents {
+			relation, err := newOrgRelation(db, org, parentMapping)
+			if err != nil {
+				return fmt.Errorf("invalid parent %s of organization %s: %v", parentMapping.Name, mapping.Name, err)
+			}
+			relations = append(relations, *relation)
+		}
+	}
+
+	key2relation := make(map[string]model.OrgRelation)
+	for _, relation := range relations {
+		key2relation[fmt.Sprintf("%d-%d", relation.OrgID, relation.ParentOrgID)] = relation
+	}
+
+	ids := make([]uint, 0, len(key2relation))
+	for _, relation := range key2relation {
+		r := relation
+		err = db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "org_id"}, {Name: "parent_org_id"},
+			},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"type", "start_date", "end_date", "updated_at", "deleted_at",
+			}),
+		}).Create(&r).Error
+		if err != nil {
+			return err
+		}
+
+		var saved model.OrgRelation
+		db.Where("org_id = ? and parent_org_id = ?", r.OrgID, r.ParentOrgID).First(&saved)
+		ids = append(ids, saved.ID)
+	}
+
+	// Remove the relations which are no longer in the config files.
+	tx := db.Unscoped()
+	if len(ids) != 0 {
+		tx = tx.Where("id not in ?", ids)
+	} else {
+		tx = tx.Where("1 = 1")
+	}
+	err = tx.Delete(&model.OrgRelation{}).Error
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Synced %d organization relations.", len(ids))
+	return nil
+}
+
+// loadOrgAcquisitions - get the acquisitions from the companies.yaml file, the acquired organizations are
+// matched by name and the new owner is matched like the company names of profiles, the unknown owner is put into
+// the pending-review queue and its acquisitions are skipped until it is approved.
+func loadOrgAcquisitions(
+	log *logrus.Entry, db *gorm.DB, uri string, similarityThreshold float64,
+) ([]model.OrgRelation, error) {
+	data, err := readFileOrURL(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var acqs companyAcquisitions
+	err = yaml.Unmarshal(data, &acqs)
+	if err != nil {
+		return nil, err
+	}
+
+	var organizations []model.Organization
+	db.Where("invalid = ?", false).Find(&organizations)
+	pattern2org, _ := loadOrgMappings(log, db)
+	orgMatcher := newOrgMatcher(db, pattern2org, similarityThreshold)
+
+	relations := make([]model.OrgRelation, 0)
+	for _, acq := range acqs.Acquisitions {
+		reg, err := regexp.Compile(acq[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid acquisition pattern %s: %v", acq[0], err)
+		}
+
+		parent := orgMatcher.mapNameToOrg(acq[1])
+		if parent == nil {
+			log.Warnf("The owner %s of the acquisitions %s is unknown, it is put into the pending-review queue.",
+				acq[1], acq[0])
+			continue
+		}
+
+		for _, org := range organizations {
+			if org.ID == parent.ID || !reg.MatchString(strings.TrimSpace(org.Name)) {
+				continue
+			}
+			relations = append(relations, model.OrgRelation{
+				OrgID:       org.ID,
+				ParentOrgID: parent.ID,
+				Type:        model.OrgRelationAcquisition,
+				StartDate:   model.DefaultStartDate,
+				EndDate:     model.DefaultEndDate,
+			})
+		}
+	}
+
+	return relations, nil
+}
+
+func newOrgRelation(db *gorm.DB, org model.Organization, parentMapping OrgParentMapping) (*model.OrgRelation, error) {
+	relationType := parentMapping.Type
+	if len(relationType) == 0 {
+		relationType = model.OrgRelationSubsidiary
+	}
+	if relationType != model.OrgRelationSubsidiary && relationType != model.OrgRelationAcquisition {
+		return nil, fmt.Errorf("unknown relation type %s", relationType)
+	}
+
+	startDate, err := parseOrgRelationDate(parentMapping.StartDate, model.DefaultStartDate)
+	if err != nil {
+		return nil, err
+	}
+	endDate, err := parseOrgRelationDate(parentMapping.EndDate, model.DefaultEndDate)
+	if err != nil {
+		return nil, err
+	}
+	if !startDate.Before(endDate) {
+		return nil, fmt.Errorf("start date %s is not before end date %s", parentMapping.StartDate, parentMapping.EndDate)
+	}
+
+	parent, err := findOrCreateOrgByName(db, parentMapping.Name)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ID == org.ID {
+		return nil, fmt.Errorf("organization can not be the parent of itself")
+	}
+
+	return &model.OrgRelation{
+		OrgID:       org.ID,
+		ParentOrgID: parent.ID,
+		Type:        relationType,
+		StartDate:   startDate,
+		EndDate:     endDate,
+	}, nil
+}
+
+func parseOrgRelationDate(date string, defaultDate time.Time) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if len(date) == 0 {
+		return defaultDate, nil
+	}
+	return time.Parse(orgRelationDateLayout, date)
+}
+
+func findOrCreateOrgByName(db *gorm.DB, name string) (model.Organization, error) {
+	orgType := model.OrgTypeCompany
+	if isEducationOrgName(name) {
+		orgType = model.OrgTypeEducation
+	}
+	var org model.Organization
+	err := db.Where("name = ?", name).Attrs(model.Organization{Type: orgType}).
+		FirstOrCreate(&org, model.Organization{Name: name}).Error
+	return org, err
+}
+
+// readFileOrURL - read the content of the local file or the remote file.
+func readFileOrURL(uri string) ([]byte, error) {
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		return ioutil.ReadFile(uri)
+	}
+
+	response, err := http.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s, status: %s", uri, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
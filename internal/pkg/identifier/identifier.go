@@ -23,7 +23,6 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/chyroc/lark"
 	"github.com/google/uuid"
-	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 	"github.com/ti-community-infra/devstats/internal/pkg/lib"
 	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
@@ -44,10 +43,13 @@ type GitHubUserFromJSON struct {
 	Source    string   `json:"source"`
 	Name      string   `json:"name"`
 	CountryID *string  `json:"country_id,omitempty"`
-	Sex       *string  `json:"sex,omitempty"`
-	SexProb   *float64 `json:"sex_prob,omitempty"`
+	Sex       *string  `json:"sex"`
+	SexProb   *float64 `json:"sex_prob"`
 	Tz        *string  `json:"tz,omitempty"`
 	Age       *int     `json:"age,omitempty"`
+	// TzOffset is the UTC offset in minutes, TzConfidence is the confidence of the inferred offset.
+	TzOffset     *int     `json:"tz_offset,omitempty"`
+	TzConfidence *float64 `json:"tz_confidence,omitempty"`
 }
 
 const GitHubNoReplyEmailSuffix = "@users.noreply.github.com"
@@ -64,6 +66,7 @@ type OrgMapping struct {
 	Website  string                 `yaml:"website"`
 	Patterns []string               `yaml:"patterns"`
 	Domains  []model.OrgDomain      `yaml:"domains"`
+	Parents  []OrgParentMapping     `yaml:"parents"`
 }
 
 /*  Location Client  */
@@ -72,7 +75,7 @@ type OrgMapping struct {
 type LocationClient struct {
 	log       *logrus.Entry
 	mapClient *maps.Client
-	memCache  *cache.Cache
+	memCache  Cache
 }
 
 type LocationCacheEntry struct {
@@ -85,7 +88,7 @@ const locationCacheKeyPrefix = "formatted-location-"
 
 const locationCacheExpire = 365 * 24 * time.Hour
 
-func (l *LocationClient) Init(ctx *Ctx, log *logrus.Entry, memCache *cache.Cache) error {
+func (l *LocationClient) Init(ctx *Ctx, log *logrus.Entry, memCache Cache) error {
 	mapClient, err := maps.NewClient(maps.WithAPIKey(ctx.GoogleMapAPIKey))
 	if err != nil {
 		return err
@@ -188,12 +191,12 @@ type EmployeeManager struct {
 	background        context.Context
 	log               *logrus.Entry
 	larkClient        *lark.Lark
-	memCache          *cache.Cache
+	memCache          Cache
 	githubLogins      lib.StringSet
 	tenantAccessToken string
 }
 
-func (m *EmployeeManager) Init(ctx Ctx, log *logrus.Entry, memCache *cache.Cache) error {
+func (m *EmployeeManager) Init(ctx Ctx, log *logrus.Entry, memCache Cache) error {
 	m.log = log
 	m.background = context.Background()
 	m.githubLogins = make(lib.StringSet)
@@ -292,7 +295,7 @@ func (m *EmployeeManager) PrepareGitHubLogins() error {
 	}
 
 	m.githubLogins = githubLogins
-	m.memCache.Set(LarkContactGitHubLoginsCacheKey, githubLogins, cache.DefaultExpiration)
+	m.memCache.Set(LarkContactGitHubLoginsCacheKey, githubLogins, DefaultCacheExpiration)
 	m.log.Infof("Found %d github login from lark contact.", len(m.githubLogins))
 
 	return nil
@@ -309,51 +312,47 @@ func (m *EmployeeManager) IsEmployeeLogin(githubLogin string) bool {
 // AutoImportProfile - Import GitHub user info from devstats and fetch their public profile information.
 func AutoImportProfile(
 	log *logrus.Entry, ctx *Ctx, db *gorm.DB, dataSource *gorm.DB,
-	gc *GitHubClient, locationClient *LocationClient, employeeManager *EmployeeManager, memCache *cache.Cache,
+	gc *GitHubClient, locationClient *LocationClient, employeeManager *EmployeeManager, memCache Cache,
 ) {
 	// Ensure the existence of database structure and basic data.
 	EnsureStructure(log, db)
 
+	// Record what has been changed by the run, the report is saved even if the run fails.
+	report := newRunReport()
+	defer func() {
+		report.finish(db, memCache)
+		saveRunReport(log, ctx, db, report)
+	}()
+
 	// Import the init data from file to database.
 	loadInitData(log, ctx, db)
 
+	// Import the relations of organizations, they may change after the init data is imported, the previous
+	// relations are kept if the sync fails.
+	err := SyncOrgRelations(log, ctx, db)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to sync the relations of organizations, continue with the previous ones.")
+	}
+
 	// Get GitHub User profile from json file.
 	githubUsersFromJSON := loadGitHubUsersFromJSON(ctx.GitHubUsersJSONSourcePath)
 	log.Infof("Found %d GitHub user profile from json file.", len(githubUsersFromJSON))
 
 	// Get employee GitHub logins.
-	err := employeeManager.PrepareGitHubLogins()
+	err = employeeManager.PrepareGitHubLogins()
 	if err != nil {
 		log.WithError(err).Errorf("Failed to prepare github logins from lark.")
+		report.apiFailed(LarkContactAPI)
+		report.fail(err)
 		return
 	}
 
 	// Get existed organizations.
 	startTime := time.Now()
 	log.Infof("Establishing the mapping from pattern to org...")
-
-	pattern2org := make(map[*regexp.Regexp]model.Organization)
-	domain2org := make(map[string]model.Organization)
-
-	var organizations []model.Organization
-	db.Preload("Patterns").Preload("Domains").Where("invalid = ?", false).Find(&organizations)
-
-	for _, organization := range organizations {
-		for _, pattern := range organization.Patterns {
-			reg, err := regexp.Compile("(?i)" + pattern.Pattern)
-			if err != nil {
-				log.WithError(err).Errorf("Failed to compile the org pattern: orgID=%d pattern=%s", organization.ID, pattern.Pattern)
-				continue
-			}
-			pattern2org[reg] = organization
-		}
-		for _, domain := range organization.Domains {
-			if len(domain.Name) != 0 && domain.Common == false {
-				domain2org[domain.Name] = organization
-			}
-		}
-	}
-
+	pattern2org, domain2org := loadOrgMappings(log, db)
+	orgMatcher := newOrgMatcher(db, pattern2org, ctx.OrgSimilarityThreshold)
+	orgMatcher.report = report
 	endTime := time.Now()
 	log.Infof("Established the mapping from pattern to org, cost time: %v.", endTime.Sub(startTime))
 
@@ -366,7 +365,11 @@ func AutoImportProfile(
 	var actors []model.GhaActor
 	err = dataSource.Preload("Names").Preload("Emails").
 		Where("gha_actors.id in (select distinct actor_id from gha_events)").Find(&actors).Error
-	lib.FatalOnError(err)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to get the actors from devstats database.")
+		report.fail(err)
+		return
+	}
 	log.Infof("Found %d external identities need to be importd.", len(actors))
 
 	githubID2logins := make(map[uint]lib.StringSet)
@@ -411,30 +414,7 @@ func AutoImportProfile(
 	log.Infof("Found %d github id from devstats datavase.", len(githubID2logins))
 
 	// Merge the affiliation information, and only keep the affiliation with the highest priority.
-	githubLogin2JsonUser := make(map[string]GitHubUserFromJSON)
-	source2priority := map[string]int{
-		"notfound": -20, "domain": -10, "": 0, "config": 10, "manual": 20, "user_manual": 30, "user": 40,
-	}
-	for _, newGitHubUser := range githubUsersFromJSON {
-		source := strings.ToLower(newGitHubUser.Source)
-		if source != "domain" && source != "config" && source != "manual" && source != "user_manual" && source != "user" {
-			continue
-		}
-		login := newGitHubUser.Login
-
-		if oldGitHubUser, ok := githubLogin2JsonUser[login]; ok {
-			newSource := source
-			newSourcePriority := source2priority[newSource]
-			oldSource := strings.ToLower(oldGitHubUser.Source)
-			oldSourcePriority := source2priority[oldSource]
-
-			if newSourcePriority > oldSourcePriority {
-				githubLogin2JsonUser[login] = newGitHubUser
-			}
-		} else {
-			githubLogin2JsonUser[login] = newGitHubUser
-		}
-	}
+	githubLogin2JsonUser := mergeGitHubUsersFromJSON(githubUsersFromJSON)
 
 	// Get via GitHub Profile.
 	nGitHubIds := len(githubID2logins)
@@ -449,19 +429,20 @@ func AutoImportProfile(
 	for githubID, loginSet := range githubID2logins {
 		go processUniqueIdentity(
 			ch, &thMtx, db, log, gc, locationClient, employeeManager,
-			githubID, loginSet, githubID2names, githubID2emails, pattern2org, domain2org,
-			githubLogin2JsonUser,
+			githubID, loginSet, githubID2names, githubID2emails, orgMatcher, domain2org,
+			githubLogin2JsonUser, report,
 		)
 
 		// Save the cache to file.
 		if i%100 == 0 || i == nGitHubIds-1 {
 			log.Infof("Importing %d/%d GitHub Users.", i+1, nGitHubIds)
-			err = memCache.SaveFile(ctx.CacheFilePath)
+			err = memCache.Flush()
 			if err != nil {
-				log.WithError(err).Errorf("Failed to save the memory cache to file: %s", ctx.CacheFilePath)
+				log.WithError(err).Errorf("Failed to flush the cache, backend: %s", ctx.CacheBackend)
+				report.fail(err)
 				return
 			}
-			log.Infof("GitHub user cache stored to file.")
+			log.Infof("GitHub user cache flushed.")
 		}
 
 		i++
@@ -479,6 +460,102 @@ func AutoImportProfile(
 
 	endTime = time.Now()
 	log.Infof("Imported %d GitHub users, cost: %v.", nGitHubIds, endTime.Sub(startTime))
+
+	// Demographic enrichment is opt-in, the default enricher does nothing.
+	enricher, err := NewDemographicEnricher(ctx)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to init the demographic enricher: %s", ctx.DemographicEnricher)
+		report.fail(err)
+		return
+	}
+	EnrichDemographics(log, db, enricher)
+
+	// Mirror the GitHub users to the accounts, and enrich the accounts of other providers.
+	err = SyncGitHubAccounts(log, db)
+	if err != nil {
+		// The accounts are supplementary, the other steps go on without them.
+		log.WithError(err).Errorf("Failed to sync the GitHub accounts.")
+	}
+	if !ctx.SkipAccounts {
+		err = importEmailAccounts(log, db, dataSource, ctx.EmailAuthorMinCommits)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to import the email accounts.")
+		}
+		err = EnrichAccounts(log, db, NewAccountEnrichers(ctx, memCache), orgMatcher, domain2org, report)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to enrich the accounts.")
+		}
+	}
+
+	// Infer the timezones from the activities in devstats database.
+	if !ctx.SkipTzInference {
+		err = InferTimezones(log, ctx, db, dataSource)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to infer the timezones.")
+			report.fail(err)
+			return
+		}
+	}
+
+	report.Status = model.IdentifierRunSucceeded
+}
+
+// source2priority - the priority of the affiliation source in cncf/gitdm `github_users.json`.
+var source2priority = map[string]int{
+	"notfound": -20, "domain": -10, "": 0, "config": 10, "manual": 20, "user_manual": 30, "user": 40,
+}
+
+// loadOrgMappings - establish the mapping from pattern and email domain to the valid organizations.
+func loadOrgMappings(log *logrus.Entry, db *gorm.DB) (map[*regexp.Regexp]model.Organization, map[string]model.Organization) {
+	pattern2org := make(map[*regexp.Regexp]model.Organization)
+	domain2org := make(map[string]model.Organization)
+
+	var organizations []model.Organization
+	db.Preload("Patterns").Preload("Domains").Where("invalid = ?", false).Find(&organizations)
+
+	for _, organization := range organizations {
+		for _, pattern := range organization.Patterns {
+			reg, err := regexp.Compile("(?i)" + pattern.Pattern)
+			if err != nil {
+				log.WithError(err).Errorf("Failed to compile the org pattern: orgID=%d pattern=%s", organization.ID, pattern.Pattern)
+				continue
+			}
+			pattern2org[reg] = organization
+		}
+		for _, domain := range organization.Domains {
+			if len(domain.Name) != 0 && domain.Common == false {
+				domain2org[domain.Name] = organization
+			}
+		}
+	}
+
+	return pattern2org, domain2org
+}
+
+// mergeGitHubUsersFromJSON - merge the GitHub users by login, only keep the affiliation with the highest priority.
+func mergeGitHubUsersFromJSON(githubUsersFromJSON []GitHubUserFromJSON) map[string]GitHubUserFromJSON {
+	githubLogin2JsonUser := make(map[string]GitHubUserFromJSON)
+	for _, newGitHubUser := range githubUsersFromJSON {
+		source := strings.ToLower(newGitHubUser.Source)
+		if source != "domain" && source != "config" && source != "manual" && source != "user_manual" && source != "user" {
+			continue
+		}
+		login := newGitHubUser.Login
+
+		if oldGitHubUser, ok := githubLogin2JsonUser[login]; ok {
+			newSource := source
+			newSourcePriority := source2priority[newSource]
+			oldSource := strings.ToLower(oldGitHubUser.Source)
+			oldSourcePriority := source2priority[oldSource]
+
+			if newSourcePriority > oldSourcePriority {
+				githubLogin2JsonUser[login] = newGitHubUser
+			}
+		} else {
+			githubLogin2JsonUser[login] = newGitHubUser
+		}
+	}
+	return githubLogin2JsonUser
 }
 
 // EnsureStructure is used to ensure the table structure existed in the database.
@@ -500,6 +577,14 @@ func EnsureStructure(log *logrus.Entry, db *gorm.DB) {
 		&model.GitHubUserEmail{},
 		&model.GitHubUserLogin{},
 		&model.GitHubUserName{},
+		&model.ProfileObservation{},
+		&model.CacheEntry{},
+		&model.PendingOrganization{},
+		&model.OrgRelation{},
+		&model.IdentityAuditLog{},
+		&model.HideDataRequest{},
+		&model.IdentifierRun{},
+		&model.Account{},
 	)
 	if err != nil {
 		log.WithError(err).Error("Failed to migrate.")
@@ -507,6 +592,16 @@ func EnsureStructure(log *logrus.Entry, db *gorm.DB) {
 		return
 	}
 
+	// The team names were unique across the projects before, the index is replaced by the one with the project.
+	if db.Migrator().HasIndex(&model.Team{}, "idx_teams_name") {
+		err = db.Migrator().DropIndex(&model.Team{}, "idx_teams_name")
+		if err != nil {
+			log.WithError(err).Errorln("Failed to drop the index: idx_teams_name.")
+			lib.FatalOnError(err)
+			return
+		}
+	}
+
 	err = db.SetupJoinTable(&model.UniqueIdentity{}, "Organizations", &model.Enrollment{})
 	if err != nil {
 		log.WithError(err).Errorln("Failed to setup join table: enrollments.")
@@ -707,8 +802,8 @@ func processUniqueIdentity(
 	ch chan bool, thMtx *sync.Mutex, db *gorm.DB, log *logrus.Entry,
 	gc *GitHubClient, locationClient *LocationClient, employeeManager *EmployeeManager,
 	githubID uint, loginSet lib.StringSet, githubID2names, githubID2emails map[uint]lib.StringSet,
-	pattern2org map[*regexp.Regexp]model.Organization, domain2org map[string]model.Organization,
-	githubLogin2JsonUser map[string]GitHubUserFromJSON,
+	orgMatcher *OrgMatcher, domain2org map[string]model.Organization,
+	githubLogin2JsonUser map[string]GitHubUserFromJSON, report *RunReport,
 ) {
 	if len(loginSet) == 0 {
 		ch <- false
@@ -758,6 +853,8 @@ func processUniqueIdentity(
 	githubProfile, _, err := gc.GetUserByID(int64(githubID))
 	if err != nil {
 		log.WithError(err).Errorln("Failed to get github profile through GitHub API.")
+		report.apiFailed(GitHubUserAPI)
+		report.identityFailed()
 		ch <- false
 		return
 	}
@@ -766,6 +863,7 @@ func processUniqueIdentity(
 	var githubUser model.GitHubUser
 	githubUser.ID = githubID
 	db.Find(&githubUser)
+	created := len(githubUser.UUID) == 0
 
 	// Combine the login, name, and email information in GitHub Profile and devstats.
 	if len(githubProfile.GetLogin()) != 0 {
@@ -814,24 +912,41 @@ func processUniqueIdentity(
 	err = db.Where("uuid = ?", uniqueIdentity.UUID).FirstOrCreate(&uniqueIdentity).Error
 	if err != nil {
 		log.WithError(err).Errorf("Failed to find or create unique identity: %s", uniqueIdentity.UUID)
+		report.identityFailed()
 		ch <- false
 		return
 	}
+	oldIdentity := uniqueIdentity
 
 	// Create or update GitHub Profile.
 	githubCompany := githubProfile.GetCompany()
 	githubLocation := githubProfile.GetLocation()
+
+	// The PII of the opted out identity is never imported again, only the logins and affiliations are kept.
+	if uniqueIdentity.OptedOut {
+		githubName = ""
+		githubEmail = ""
+		githubLocation = ""
+		githubUserNames = githubUserNames[:0]
+		githubUserEmails = githubUserEmails[:0]
+	}
 	githubBlog := githubProfile.GetBlog()
 	githubBio := githubProfile.GetBio()
 	githubAvatarURL := githubProfile.GetAvatarURL()
 
 	githubUser.Login = githubLogin
 	githubUser.Name = &githubName
+	if uniqueIdentity.OptedOut {
+		githubUser.Name = nil
+	}
 	githubUser.Email = githubEmail
 	githubUser.Following = githubProfile.GetFollowing()
 	githubUser.Followers = githubProfile.GetFollowers()
 	githubUser.Company = &githubCompany
 	githubUser.Location = &githubLocation
+	if uniqueIdentity.OptedOut {
+		githubUser.Location = nil
+	}
 	githubUser.Blog = &githubBlog
 	githubUser.Bio = &githubBio
 	githubUser.AvatarURL = &githubAvatarURL
@@ -848,6 +963,7 @@ func processUniqueIdentity(
 	}).Create(&githubUser).Error
 	if err != nil {
 		log.WithError(err).Errorf("Failed to insert github profile (github_id=%d, github_login=%s)", githubUser.ID, githubUser.Login)
+		report.identityFailed()
 		ch <- false
 		return
 	}
@@ -903,6 +1019,7 @@ func processUniqueIdentity(
 		formattedGitHubLocation, countryCode, _, err := locationClient.FormattedLocation(githubLocation)
 		if err != nil {
 			log.WithError(err).Errorf("Failed to format the location: %s.", githubLocation)
+			report.apiFailed(GeocodingAPI)
 		}
 
 		if uniqueIdentity.LocationSource != model.ManualSource && uniqueIdentity.LocationSource != model.UserManualSource &&
@@ -923,26 +1040,25 @@ func processUniqueIdentity(
 	// Handle Company.
 	enrollments := make([]model.Enrollment, 0)
 	db.Where("uuid = ?", uniqueIdentity.UUID).Find(&enrollments)
+	oldEnrollments := append([]model.Enrollment(nil), enrollments...)
 
 	// First, add the affiliation information imported from json to enrollments, because this usually contains historical records.
 	if jsonUser, ok := githubLogin2JsonUser[githubLogin]; ok {
 		jsonSource := jsonUser.Source
 		affs := jsonUser.Affiliation
 
-		if len(uniqueIdentity.Name) == 0 && len(jsonUser.Name) != 0 {
+		if len(uniqueIdentity.Name) == 0 && len(jsonUser.Name) != 0 && !uniqueIdentity.OptedOut {
 			uniqueIdentity.Name = jsonUser.Name
 			uniqueIdentity.NameSource = model.GitHubJSONSource
 		}
 
-		if uniqueIdentity.CountryCode == nil && jsonUser.CountryID != nil {
+		if uniqueIdentity.CountryCode == nil && jsonUser.CountryID != nil && !uniqueIdentity.OptedOut {
 			uniqueIdentity.CountryCode = jsonUser.CountryID
 			uniqueIdentity.CountrySource = model.GitHubJSONSource
 		}
 
 		// Do not process invalid affiliation information.
-		isInvalidAffs := affs == "NotFound" || affs == "(Unknown)" || affs == "?" || affs == "-" || affs == ""
-
-		if !(isInvalidAffs) {
+		if !isInvalidAffStr(affs) {
 			affsAry := strings.Split(affs, ", ")
 			prevDate := model.DefaultStartDate
 
@@ -966,7 +1082,7 @@ func processUniqueIdentity(
 				}
 
 				thMtx.Lock()
-				org := mapNameToOrg(db, pattern2org, company)
+				org := orgMatcher.mapNameToOrg(company)
 				thMtx.Unlock()
 
 				source := model.GitHubJSONSource
@@ -1004,29 +1120,20 @@ func processUniqueIdentity(
 		}
 	}
 
-	// Get organization through email domain.
-	if len(githubUserEmails) != 0 {
-		for _, email := range githubUserEmails {
-			if strings.HasSuffix(email.Email, GitHubNoReplyEmailSuffix) {
-				continue
-			}
-			for domain, org := range domain2org {
-				if strings.HasSuffix(githubEmail, "@"+domain) {
-					enrollments = appendEnrollment(enrollments, uniqueIdentity.UUID, org.ID, model.EmailDomainSource)
-				}
-			}
-		}
+	// Get organization through email domain, the primary email is preferred to the other emails.
+	emails := []string{githubEmail}
+	for _, email := range githubUserEmails {
+		emails = append(emails, email.Email)
 	}
+	emailDomain, emailDomainOrg := findEmailDomainOrg(domain2org, emails)
 
-	// Get organization information through GitHub profile.
+	// Get organization information through GitHub profile, the free-form company names which can not be matched
+	// are put into the pending-review queue.
 	thMtx.Lock()
-	githubProfileOrg := mapNameToOrg(db, pattern2org, githubCompany)
+	githubProfileOrg := orgMatcher.mapNameToOrg(githubCompany)
 	thMtx.Unlock()
-	if githubProfileOrg != nil {
-		if githubProfileOrg.Name == "ING" {
-			log.Warnf("wrong org: %s %d %s", uniqueIdentity.UUID, githubProfileOrg.ID, githubCompany)
-		}
-		enrollments = appendEnrollment(enrollments, uniqueIdentity.UUID, githubProfileOrg.ID, model.GitHubProfileSource)
+	if githubProfileOrg != nil && githubProfileOrg.Name == "ING" {
+		log.Warnf("wrong org: %s %d %s", uniqueIdentity.UUID, githubProfileOrg.ID, githubCompany)
 	}
 
 	// Get organization information through Lark contact.
@@ -1038,22 +1145,54 @@ func processUniqueIdentity(
 		}
 	}
 
+	var larkContactOrg *model.Organization
 	if isEmployee {
 		thMtx.Lock()
-		larkContactOrg := mapNameToOrg(db, pattern2org, LarkCompany)
+		larkContactOrg = orgMatcher.mapNameToOrg(LarkCompany)
 		thMtx.Unlock()
-		if larkContactOrg != nil {
-			enrollments = appendEnrollment(enrollments, uniqueIdentity.UUID, larkContactOrg.ID, model.LarkContactSource)
-		}
 	}
 
+	// Record the observation, the Lark contact is the most reliable, followed by the GitHub profile and email domain.
+	observation := model.ProfileObservation{
+		UUID:           uniqueIdentity.UUID,
+		GitHubUserID:   githubID,
+		Company:        githubCompany,
+		EmailDomain:    emailDomain,
+		IsLarkEmployee: isEmployee,
+	}
+	// The email domain is derived from the emails, which are never imported for the opted out identity.
+	if uniqueIdentity.OptedOut {
+		observation.EmailDomain = ""
+	}
+	if larkContactOrg != nil {
+		observation.OrgID = larkContactOrg.ID
+		observation.Source = model.LarkContactSource
+	} else if githubProfileOrg != nil {
+		observation.OrgID = githubProfileOrg.ID
+		observation.Source = model.GitHubProfileSource
+	} else if emailDomainOrg != nil {
+		observation.OrgID = emailDomainOrg.ID
+		observation.Source = model.EmailDomainSource
+	}
+	err = recordProfileObservation(db, &observation)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to record profile observation: %s", uniqueIdentity.UUID)
+	}
+
+	// Infer the enrollments from all the observations of the unique identity.
+	observations := make([]model.ProfileObservation, 0)
+	db.Where("uuid = ?", uniqueIdentity.UUID).Order("observed_at").Find(&observations)
+	enrollments = mergeObservedEnrollments(enrollments, inferEnrollments(uniqueIdentity.UUID, observations))
+
 	// Save enrollments.
 	for _, enrollment := range enrollments {
 		db.Clauses(clause.OnConflict{
 			Columns: []clause.Column{
 				{Name: "uuid"}, {Name: "org_id"},
 			},
-			DoNothing: true,
+			DoUpdates: clause.AssignmentColumns([]string{
+				"start_date", "end_date", "source",
+			}),
 		}).Create(&enrollment)
 	}
 
@@ -1061,104 +1200,26 @@ func processUniqueIdentity(
 	err = db.Updates(&uniqueIdentity).Error
 	if err != nil {
 		log.WithError(err).Errorf("Failed to save unique identity: %s", uniqueIdentity.UUID)
+		report.identityFailed()
 		ch <- false
 		return
 	}
 
-	ch <- true
-}
-
-func appendEnrollment(
-	enrollments []model.Enrollment, uuid string, newOrgID uint,
-	source model.ProfileSource,
-) []model.Enrollment {
-	if len(enrollments) == 0 {
-		enrollment := model.Enrollment{
-			OrgID:     newOrgID,
-			UUID:      uuid,
-			StartDate: model.DefaultStartDate,
-			EndDate:   model.DefaultEndDate,
-			Source:    source,
-		}
-		enrollments = append(enrollments, enrollment)
-	} else {
-		sort.Slice(enrollments, func(i, j int) bool {
-			return enrollments[i].StartDate.Before(enrollments[j].StartDate) && enrollments[i].EndDate.Before(enrollments[j].EndDate)
-		})
+	opened, closed := diffEnrollments(oldEnrollments, enrollments, time.Now())
+	report.identityProcessed(created, diffProfile(oldIdentity, uniqueIdentity), opened, closed)
 
-		lastIndex := len(enrollments) - 1
-		alreadyIn := false
-		for _, enrollment := range enrollments {
-			if enrollment.OrgID == newOrgID {
-				alreadyIn = true
-				break
-			}
-		}
-
-		if alreadyIn {
-			return enrollments
-		}
-
-		now := time.Now()
-		enrollments[lastIndex].EndDate = now
-		enrollment := model.Enrollment{
-			UUID:      uuid,
-			OrgID:     newOrgID,
-			StartDate: now,
-			EndDate:   model.DefaultEndDate,
-			Source:    source,
-		}
-		enrollments = append(enrollments, enrollment)
-	}
-
-	return enrollments
+	ch <- true
 }
 
-func mapNameToOrg(db *gorm.DB, pattern2org map[*regexp.Regexp]model.Organization, orgName string) *model.Organization {
+// matchOrgByPattern - find the organization whose pattern matches the name, without creating a new one.
+func matchOrgByPattern(pattern2org map[*regexp.Regexp]model.Organization, orgName string) *model.Organization {
 	orgName = strings.TrimSpace(orgName)
-
-	// orgName requires at least two characters.
-	if len(orgName) < 2 {
-		return nil
-	}
-
 	for reg, org := range pattern2org {
 		if reg.MatchString(orgName) {
 			return &org
 		}
 	}
-
-	// If not match, try to create a new organization.
-	orgType := model.OrgTypeCompany
-	if isEducationOrgName(orgName) {
-		orgType = model.OrgTypeEducation
-	}
-	newOrg := model.Organization{
-		Name: orgName,
-		Type: orgType,
-	}
-	db.Where("name = ?", orgName).FirstOrCreate(&newOrg)
-
-	if newOrg.ID == 0 || newOrg.Invalid {
-		return nil
-	}
-
-	// Add name match pattern.
-	pattern := fmt.Sprintf("^\\s*%s\\s*$", escapeRegexStr(orgName))
-	db.Clauses(clause.OnConflict{
-		Columns: []clause.Column{
-			{Name: "org_id"},
-			{Name: "pattern"},
-		},
-		DoNothing: true,
-	}).Create(&model.OrgPattern{
-		OrgID:   newOrg.ID,
-		Pattern: pattern,
-	})
-	reg := regexp.MustCompile("(?i)" + pattern)
-	pattern2org[reg] = newOrg
-
-	return &newOrg
+	return nil
 }
 
 func isEducationOrgName(orgName string) bool {
@@ -1191,12 +1252,67 @@ func escapeRegexStr(str string) string {
 }
 
 type EnrollmentWithOrg struct {
+	OrgID     uint
 	OrgName   string
 	StartDate time.Time
 	EndDate   time.Time
+	Source    model.ProfileSource
+}
+
+// getAllEnrollmentsWithOrg - get the valid enrollments of all the unique identities, keyed by UUID and ordered
+// by date.
+func getAllEnrollmentsWithOrg(db *gorm.DB) (map[string][]EnrollmentWithOrg, error) {
+	var rows []struct {
+		UUID string `gorm:"column:uuid"`
+		EnrollmentWithOrg
+	}
+	err := db.Raw(
+		"select e.uuid as uuid, e.org_id as org_id, o.name as org_name, e.start_date as start_date, "+
+			"e.end_date as end_date, e.source as source "+
+			"from enrollments e "+
+			"left join organizations o on e.org_id = o.id "+
+			"where e.invalid = ? and o.invalid = ? "+
+			"order by e.uuid, e.start_date, e.end_date",
+		false, false,
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	uuid2enrollments := make(map[string][]EnrollmentWithOrg)
+	for _, row := range rows {
+		uuid2enrollments[row.UUID] = append(uuid2enrollments[row.UUID], row.EnrollmentWithOrg)
+	}
+	return uuid2enrollments, nil
+}
+
+// getEnrollmentsWithOrg - get the valid enrollments of the unique identity, ordered by date.
+func getEnrollmentsWithOrg(db *gorm.DB, u string) []EnrollmentWithOrg {
+	enrollments := make([]EnrollmentWithOrg, 0)
+	db.Raw(
+		"select e.org_id as org_id, o.name as org_name, e.start_date as start_date, e.end_date as end_date, e.source as source "+
+			"from enrollments e "+
+			"left join organizations o on e.org_id = o.id "+
+			"where uuid = ? and e.invalid = ? and o.invalid = ? "+
+			"order by e.start_date, e.end_date",
+		u, false, false,
+	).Scan(&enrollments)
+	return enrollments
 }
 
 func OutputGitHubUserToJSON(log *logrus.Entry, ctx *Ctx, db *gorm.DB) {
+	var hierarchy *OrgHierarchy
+	if !ctx.SkipOrgRollup {
+		var err error
+		hierarchy, err = LoadOrgHierarchy(db)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to load the hierarchy of organizations.")
+			return
+		}
+	}
+
+	demographicEnabled := len(ctx.DemographicEnricher) != 0 && ctx.DemographicEnricher != NoopDemographicEnricher
+
 	uniqueIdentities := make([]model.UniqueIdentity, 0)
 	db.Preload("GitHubUsers").Preload("Country").Find(&uniqueIdentities)
 
@@ -1209,16 +1325,35 @@ func OutputGitHubUserToJSON(log *logrus.Entry, ctx *Ctx, db *gorm.DB) {
 		}
 		i++
 
+		// The person who opted out is excluded from the exported data.
+		if uniqueIdentity.OptedOut {
+			continue
+		}
+
 		u := uniqueIdentity.UUID
 
-		enrollments := make([]EnrollmentWithOrg, 0)
-		db.Raw(
-			"select o.name as org_name, e.start_date as start_date, e.end_date as end_date from enrollments e "+
-				"left join organizations o on e.org_id = o.id "+
-				"where uuid = ? and e.invalid = ? and o.invalid = ? "+
-				"order by e.start_date, e.end_date",
-			u, false, false,
-		).Scan(&enrollments)
+		// Notice: The inferred gender is only exported when the demographic enrichment is enabled, otherwise
+		// sex and sex_prob are exported as null.
+		var sex *string
+		var sexProb *float64
+		if len(uniqueIdentity.Gender) != 0 && (demographicEnabled ||
+			uniqueIdentity.GenderSource == model.ManualSource || uniqueIdentity.GenderSource == model.UserManualSource) {
+			gender, genderAcc := uniqueIdentity.Gender, uniqueIdentity.GenderAcc
+			sex, sexProb = &gender, &genderAcc
+		}
+
+		var tzOffset *int
+		var tzConfidence *float64
+		if uniqueIdentity.TzOffset != nil {
+			offset, confidence := *uniqueIdentity.TzOffset, uniqueIdentity.TzConfidence
+			tzOffset, tzConfidence = &offset, &confidence
+		}
+
+		enrollments := getEnrollmentsWithOrg(db, u)
+		if hierarchy != nil {
+			// Roll the subsidiaries and acquired companies up to the parent organization.
+			enrollments = hierarchy.rollupEnrollments(enrollments)
+		}
 
 		githubUsers := make([]model.GitHubUser, 0)
 		db.Preload("Emails").Preload("Logins").Preload("Names").
@@ -1232,9 +1367,11 @@ func OutputGitHubUserToJSON(log *logrus.Entry, ctx *Ctx, db *gorm.DB) {
 					githubUser.Login = login.Login
 					githubUser.Email = email.Email
 					githubUser.Name = uniqueIdentity.Name
-					githubUser.Sex = &uniqueIdentity.Gender
-					githubUser.SexProb = &uniqueIdentity.GenderAcc
+					githubUser.Sex = sex
+					githubUser.SexProb = sexProb
 					githubUser.CountryID = uniqueIdentity.CountryCode
+					githubUser.TzOffset = tzOffset
+					githubUser.TzConfidence = tzConfidence
 					githubUser.Source = "user_manual"
 
 					if uniqueIdentity.IsBot {
@@ -1303,6 +1440,10 @@ func OutputGitHubUserToJSON(log *logrus.Entry, ctx *Ctx, db *gorm.DB) {
 }
 
 // getAffStr - generate affiliation information string based on enrollments.
+//
+// One person can only have one enrollment in the same organization, so the enrollment of an organization they
+// returned to spans the periods in between, which are covered by the enrollments starting later. The innermost
+// enrollment wins at any time, so the periods are listed in order, e.g. "A < 2021-05-01, B < 2021-09-01, A".
 func getAffStr(enrollments []EnrollmentWithOrg) string {
 	if len(enrollments) == 0 {
 		return ""
@@ -1312,12 +1453,50 @@ func getAffStr(enrollments []EnrollmentWithOrg) string {
 		return enrollments[0].OrgName
 	}
 
+	boundaries := make([]time.Time, 0, len(enrollments)*2)
+	for _, enrollment := range enrollments {
+		boundaries = append(boundaries, enrollment.StartDate, enrollment.EndDate)
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].Before(boundaries[j])
+	})
+
+	type period struct {
+		orgName string
+		endDate time.Time
+	}
+	periods := make([]period, 0, len(enrollments))
+	for i := 0; i+1 < len(boundaries); i++ {
+		from, to := boundaries[i], boundaries[i+1]
+		if !from.Before(to) {
+			continue
+		}
+		covering := -1
+		for j, enrollment := range enrollments {
+			if enrollment.StartDate.After(from) || enrollment.EndDate.Before(to) {
+				continue
+			}
+			if covering < 0 || !enrollment.StartDate.Before(enrollments[covering].StartDate) {
+				covering = j
+			}
+		}
+		if covering < 0 {
+			continue
+		}
+		last := len(periods) - 1
+		if last >= 0 && periods[last].orgName == enrollments[covering].OrgName {
+			periods[last].endDate = to
+			continue
+		}
+		periods = append(periods, period{orgName: enrollments[covering].OrgName, endDate: to})
+	}
+
 	affs := ""
-	for i, enrollment := range enrollments {
-		if i == len(enrollments)-1 {
-			affs = affs + enrollment.OrgName
+	for i, p := range periods {
+		if i == len(periods)-1 {
+			affs = affs + p.orgName
 		} else {
-			affs = affs + enrollment.OrgName + " < " + enrollment.EndDate.Format("2006-01-02") + ", "
+			affs = affs + p.orgName + " < " + p.endDate.Format("2006-01-02") + ", "
 		}
 	}
 
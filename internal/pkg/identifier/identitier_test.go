@@ -1,28 +1,289 @@
 package identifier
 
 import (
+	"bufio"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
 )
 
-func TestAppendEnrollment(t *testing.T) {
+func TestInferEnrollments(t *testing.T) {
+	var testcases = []struct {
+		name         string
+		uuid         string
+		observations []model.ProfileObservation
+
+		expectEnrollments []model.Enrollment
+	}{
+		{
+			name:              "no observations",
+			uuid:              "uuid",
+			observations:      []model.ProfileObservation{},
+			expectEnrollments: []model.Enrollment{},
+		},
+		{
+			name: "the same organization is observed repeatedly",
+			uuid: "uuid",
+			observations: []model.ProfileObservation{
+				{
+					OrgID:      1,
+					Source:     model.GitHubProfileSource,
+					ObservedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgID:      1,
+					Source:     model.GitHubProfileSource,
+					ObservedAt: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+
+			expectEnrollments: []model.Enrollment{
+				{
+					UUID:      "uuid",
+					OrgID:     1,
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+					Source:    model.GitHubProfileSource,
+				},
+			},
+		},
+		{
+			name: "the company changed",
+			uuid: "uuid",
+			observations: []model.ProfileObservation{
+				{
+					OrgID:      2,
+					Source:     model.LarkContactSource,
+					ObservedAt: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgID:      1,
+					Source:     model.GitHubProfileSource,
+					ObservedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgID:      0,
+					ObservedAt: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+
+			expectEnrollments: []model.Enrollment{
+				{
+					UUID:      "uuid",
+					OrgID:     1,
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					Source:    model.GitHubProfileSource,
+				},
+				{
+					UUID:      "uuid",
+					OrgID:     2,
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+					Source:    model.LarkContactSource,
+				},
+			},
+		},
+		{
+			name: "returned to the previous company spans the periods in between",
+			uuid: "uuid",
+			observations: []model.ProfileObservation{
+				{
+					OrgID:      1,
+					Source:     model.GitHubProfileSource,
+					ObservedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgID:      2,
+					Source:     model.GitHubProfileSource,
+					ObservedAt: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgID:      1,
+					Source:     model.GitHubProfileSource,
+					ObservedAt: time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+
+			expectEnrollments: []model.Enrollment{
+				{
+					UUID:      "uuid",
+					OrgID:     1,
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+					Source:    model.GitHubProfileSource,
+				},
+				{
+					UUID:      "uuid",
+					OrgID:     2,
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC),
+					Source:    model.GitHubProfileSource,
+				},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			gotEnrollments := inferEnrollments(tc.uuid, tc.observations)
+			assertEnrollments(t, tc.expectEnrollments, gotEnrollments)
+		})
+	}
+}
+
+func TestFindEmailDomainOrg(t *testing.T) {
+	domain2org := map[string]model.Organization{
+		"pingcap.com": {Name: "PingCAP"},
+		"google.com":  {Name: "Google"},
+	}
+
+	var testcases = []struct {
+		name         string
+		emails       []string
+		expectDomain string
+		expectOrg    string
+	}{
+		{
+			name:         "no emails",
+			emails:       []string{""},
+			expectDomain: "",
+			expectOrg:    "",
+		},
+		{
+			name:         "the domain of the primary email is known",
+			emails:       []string{"a@PingCAP.com", "a@google.com"},
+			expectDomain: "pingcap.com",
+			expectOrg:    "PingCAP",
+		},
+		{
+			name:         "only the domain of another email is known",
+			emails:       []string{"a@gmail.com", "a@users.noreply.github.com", "a@google.com"},
+			expectDomain: "google.com",
+			expectOrg:    "Google",
+		},
+		{
+			name:         "no domain is known",
+			emails:       []string{"", "a@gmail.com", "a@qq.com"},
+			expectDomain: "gmail.com",
+			expectOrg:    "",
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			gotDomain, gotOrg := findEmailDomainOrg(domain2org, tc.emails)
+			gotOrgName := ""
+			if gotOrg != nil {
+				gotOrgName = gotOrg.Name
+			}
+			if gotDomain != tc.expectDomain || gotOrgName != tc.expectOrg {
+				t.Errorf("Expect domain %q and org %q, got %q and %q", tc.expectDomain, tc.expectOrg, gotDomain, gotOrgName)
+			}
+		})
+	}
+}
+
+func TestSameObservation(t *testing.T) {
+	observation := model.ProfileObservation{
+		OrgID:       1,
+		Source:      model.GitHubProfileSource,
+		Company:     "PingCAP",
+		EmailDomain: "pingcap.com",
+		ObservedAt:  time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	later := observation
+	later.ObservedAt = time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	if !sameObservation(observation, later) {
+		t.Errorf("Expect observations at different times to be the same")
+	}
+	changed := later
+	changed.IsLarkEmployee = true
+	if sameObservation(observation, changed) {
+		t.Errorf("Expect the change of Lark status to be observed")
+	}
+	changed = later
+	changed.EmailDomain = "gmail.com"
+	if sameObservation(observation, changed) {
+		t.Errorf("Expect the change of email domain to be observed")
+	}
+}
+
+func TestFindAffiliationConflicts(t *testing.T) {
+	pattern2org := map[*regexp.Regexp]model.Organization{
+		regexp.MustCompile(`(?i)^pingcap`): {Name: "PingCAP"},
+	}
+	loginItems := []conflictLogin{
+		{Login: "alice", UUID: "uuid-alice"},
+		{Login: "bob", UUID: "uuid-bob"},
+		{Login: "carol", UUID: "uuid-carol"},
+	}
+	uuid2enrollments := map[string][]EnrollmentWithOrg{
+		"uuid-alice": {{OrgID: 1, OrgName: "PingCAP", Source: model.GitHubProfileSource}},
+		"uuid-bob":   {{OrgID: 1, OrgName: "PingCAP", Source: model.GitHubProfileSource}},
+		"uuid-carol": {{OrgID: 2, OrgName: "Google", Source: model.GitHubProfileSource}},
+	}
+	getEnrollments := func(uuid string) []EnrollmentWithOrg {
+		return uuid2enrollments[uuid]
+	}
+	githubUsersFromJSON := []GitHubUserFromJSON{
+		// The entries without a source or with an unknown source are reported too.
+		{Login: "alice", Affiliation: "Google"},
+		{Login: "alice", Affiliation: "Google"},
+		{Login: "bob", Affiliation: "Microsoft", Source: "Unknown"},
+		{Login: "bob", Affiliation: "PingCAP Inc.", Source: "config"},
+		{Login: "carol", Affiliation: "Google", Source: "user"},
+		{Login: "dave", Affiliation: "Google", Source: "user"},
+	}
+
+	conflicts := findAffiliationConflicts(pattern2org, githubUsersFromJSON, loginItems, getEnrollments)
+	actual := make([]string, 0)
+	for _, conflict := range conflicts {
+		actual = append(actual, fmt.Sprintf(
+			"%s:%s:%s:%d", conflict.Login, conflict.GitdmAffiliation, conflict.GitdmSource, conflict.GitdmPriority,
+		))
+	}
+	expect := []string{"alice:Google::0", "bob:Microsoft:unknown:0"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf("Expect conflicts %v, but got %v", expect, actual)
+	}
+}
+
+func TestMergeObservedEnrollments(t *testing.T) {
 	var testcases = []struct {
 		name        string
 		enrollments []model.Enrollment
-		uuid        string
-		orgID       uint
-		source      model.ProfileSource
+		observed    []model.Enrollment
 
 		expectEnrollments []model.Enrollment
 	}{
 		{
 			name:        "the original enrollments slice is empty",
 			enrollments: []model.Enrollment{},
-			uuid:        "uuid",
-			orgID:       1,
-			source:      model.GitHubProfileSource,
+			observed: []model.Enrollment{
+				{
+					UUID:      "uuid",
+					OrgID:     1,
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+					Source:    model.GitHubProfileSource,
+				},
+			},
 
 			expectEnrollments: []model.Enrollment{
 				{
@@ -35,94 +296,144 @@ func TestAppendEnrollment(t *testing.T) {
 			},
 		},
 		{
-			name: "the original enrollments slice is not empty",
+			name: "the history enrollments are closed at the first observation",
 			enrollments: []model.Enrollment{
 				{
 					UUID:      "uuid",
-					OrgID:     1,
+					OrgID:     3,
 					StartDate: model.DefaultStartDate,
+					EndDate:   time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+					Source:    model.GitHubJSONSource,
+				},
+				{
+					UUID:      "uuid",
+					OrgID:     4,
+					StartDate: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+					Source:    model.GitHubJSONSource,
+				},
+			},
+			observed: []model.Enrollment{
+				{
+					UUID:      "uuid",
+					OrgID:     1,
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					Source:    model.GitHubProfileSource,
+				},
+				{
+					UUID:      "uuid",
+					OrgID:     2,
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
 					EndDate:   model.DefaultEndDate,
 					Source:    model.GitHubProfileSource,
 				},
 			},
-			uuid:   "uuid",
-			orgID:  2,
-			source: model.GitHubProfileSource,
 
 			expectEnrollments: []model.Enrollment{
 				{
 					UUID:      "uuid",
-					OrgID:     1,
+					OrgID:     3,
 					StartDate: model.DefaultStartDate,
-					EndDate:   time.Now(),
+					EndDate:   time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+					Source:    model.GitHubJSONSource,
+				},
+				{
+					UUID:      "uuid",
+					OrgID:     4,
+					StartDate: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					Source:    model.GitHubJSONSource,
+				},
+				{
+					UUID:      "uuid",
+					OrgID:     1,
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
 					Source:    model.GitHubProfileSource,
 				},
 				{
 					UUID:      "uuid",
 					OrgID:     2,
-					StartDate: time.Now(),
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
 					EndDate:   model.DefaultEndDate,
 					Source:    model.GitHubProfileSource,
 				},
 			},
 		},
 		{
-			name: "the original enrollments slice is not empty",
+			name: "the observed enrollments of previous run are replaced",
 			enrollments: []model.Enrollment{
 				{
 					UUID:      "uuid",
 					OrgID:     1,
 					StartDate: model.DefaultStartDate,
-					EndDate:   time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
 					Source:    model.GitHubProfileSource,
 				},
+			},
+			observed: []model.Enrollment{
 				{
 					UUID:      "uuid",
-					OrgID:     2,
-					StartDate: time.Date(2019, 9, 7, 0, 0, 0, 0, time.UTC),
-					EndDate:   model.DefaultEndDate,
+					OrgID:     1,
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
 					Source:    model.GitHubProfileSource,
 				},
 				{
 					UUID:      "uuid",
-					OrgID:     3,
-					StartDate: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
-					EndDate:   time.Date(2019, 9, 7, 0, 0, 0, 0, time.UTC),
+					OrgID:     2,
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
 					Source:    model.GitHubProfileSource,
 				},
 			},
-			uuid:   "uuid",
-			orgID:  4,
-			source: model.GitHubProfileSource,
 
 			expectEnrollments: []model.Enrollment{
 				{
 					UUID:      "uuid",
 					OrgID:     1,
 					StartDate: model.DefaultStartDate,
-					EndDate:   time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
 					Source:    model.GitHubProfileSource,
 				},
 				{
 					UUID:      "uuid",
-					OrgID:     3,
-					StartDate: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
-					EndDate:   time.Date(2019, 9, 7, 0, 0, 0, 0, time.UTC),
+					OrgID:     2,
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
 					Source:    model.GitHubProfileSource,
 				},
+			},
+		},
+		{
+			name: "the open manual enrollment has the highest priority",
+			enrollments: []model.Enrollment{
+				{
+					UUID:      "uuid",
+					OrgID:     1,
+					StartDate: model.DefaultStartDate,
+					EndDate:   model.DefaultEndDate,
+					Source:    model.ManualSource,
+				},
+			},
+			observed: []model.Enrollment{
 				{
 					UUID:      "uuid",
 					OrgID:     2,
-					StartDate: time.Date(2019, 9, 7, 0, 0, 0, 0, time.UTC),
-					EndDate:   time.Now(),
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
 					Source:    model.GitHubProfileSource,
 				},
+			},
+
+			expectEnrollments: []model.Enrollment{
 				{
 					UUID:      "uuid",
-					OrgID:     4,
-					StartDate: time.Now(),
+					OrgID:     1,
+					StartDate: model.DefaultStartDate,
 					EndDate:   model.DefaultEndDate,
-					Source:    model.GitHubProfileSource,
+					Source:    model.ManualSource,
 				},
 			},
 		},
@@ -131,23 +442,1529 @@ func TestAppendEnrollment(t *testing.T) {
 	for _, testcase := range testcases {
 		tc := testcase
 		t.Run(tc.name, func(t *testing.T) {
-			gotEnrollments := appendEnrollment(tc.enrollments, tc.uuid, tc.orgID, tc.source)
+			gotEnrollments := mergeObservedEnrollments(tc.enrollments, tc.observed)
+			assertEnrollments(t, tc.expectEnrollments, gotEnrollments)
+		})
+	}
+}
+
+func TestGetAffStr(t *testing.T) {
+	var testcases = []struct {
+		name        string
+		enrollments []EnrollmentWithOrg
+		expectAffs  string
+	}{
+		{
+			name: "changed the company",
+			enrollments: []EnrollmentWithOrg{
+				{
+					OrgName:   "A",
+					StartDate: model.DefaultStartDate,
+					EndDate:   time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgName:   "B",
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+				},
+			},
+			expectAffs: "A < 2021-05-01, B",
+		},
+		{
+			name: "returned to the previous company",
+			enrollments: []EnrollmentWithOrg{
+				{
+					OrgName:   "A",
+					StartDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+				},
+				{
+					OrgName:   "B",
+					StartDate: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			expectAffs: "A < 2021-05-01, B < 2021-09-01, A",
+		},
+		{
+			name: "returned to the previous company and left again",
+			enrollments: []EnrollmentWithOrg{
+				{
+					OrgName:   "A",
+					StartDate: model.DefaultStartDate,
+					EndDate:   time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgName:   "B",
+					StartDate: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					OrgName:   "C",
+					StartDate: time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC),
+					EndDate:   model.DefaultEndDate,
+				},
+			},
+			expectAffs: "A < 2021-03-01, B < 2021-05-01, A < 2021-09-01, C",
+		},
+	}
 
-			if len(gotEnrollments) != len(tc.expectEnrollments) {
-				t.Errorf("Expect enrollments len: %d, but go %d", len(tc.expectEnrollments), len(gotEnrollments))
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			if gotAffs := getAffStr(tc.enrollments); gotAffs != tc.expectAffs {
+				t.Errorf("Expect affiliations: %s, but got %s", tc.expectAffs, gotAffs)
 			}
+		})
+	}
+}
 
-			for i, expectEnrollment := range tc.expectEnrollments {
-				gotEnrollment := gotEnrollments[i]
+func assertEnrollments(t *testing.T, expectEnrollments, gotEnrollments []model.Enrollment) {
+	t.Helper()
 
-				if gotEnrollment.UUID != expectEnrollment.UUID ||
-					gotEnrollment.OrgID != expectEnrollment.OrgID ||
-					gotEnrollment.Source != expectEnrollment.Source ||
-					gotEnrollment.StartDate.Sub(expectEnrollment.StartDate) > time.Second*3 ||
-					gotEnrollment.EndDate.Sub(expectEnrollment.EndDate) > time.Second*3 {
-					t.Errorf("Expect enrollment: %v, got %v", expectEnrollment, gotEnrollment)
-				}
+	if len(gotEnrollments) != len(expectEnrollments) {
+		t.Fatalf("Expect enrollments len: %d, but got %d", len(expectEnrollments), len(gotEnrollments))
+	}
+
+	for i, expectEnrollment := range expectEnrollments {
+		gotEnrollment := gotEnrollments[i]
+
+		if gotEnrollment.UUID != expectEnrollment.UUID ||
+			gotEnrollment.OrgID != expectEnrollment.OrgID ||
+			gotEnrollment.Source != expectEnrollment.Source ||
+			!gotEnrollment.StartDate.Equal(expectEnrollment.StartDate) ||
+			!gotEnrollment.EndDate.Equal(expectEnrollment.EndDate) {
+			t.Errorf("Expect enrollment: %v, got %v", expectEnrollment, gotEnrollment)
+		}
+	}
+}
+
+func TestNormalizeAffStr(t *testing.T) {
+	pattern2org := map[*regexp.Regexp]model.Organization{
+		regexp.MustCompile(`(?i)^\s*pingcap(\sinc\.?)?\s*$`): {Name: "PingCAP"},
+	}
+
+	var testcases = []struct {
+		name   string
+		affs   string
+		expect string
+	}{
+		{
+			name:   "invalid affiliation",
+			affs:   "NotFound",
+			expect: "",
+		},
+		{
+			name:   "company name is mapped by pattern",
+			affs:   "PingCAP Inc.",
+			expect: "pingcap",
+		},
+		{
+			name:   "affiliation with history",
+			affs:   "Google < 2021-05-01, pingcap",
+			expect: "google < 2021-05-01, pingcap",
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			if got := normalizeAffStr(pattern2org, tc.affs); got != tc.expect {
+				t.Errorf("Expect normalized affiliation: %s, but got %s", tc.expect, got)
 			}
 		})
 	}
 }
+
+func TestValidateOrgConfig(t *testing.T) {
+	orgConfig := OrgConfig{
+		OrgMappings: []OrgMapping{
+			{
+				Name:     "PingCAP",
+				Type:     model.OrgTypeCompany,
+				Patterns: []string{`^\s*pingcap\s*$`, `^\s*(pingcap`},
+				Domains:  []model.OrgDomain{{Name: "pingcap.com"}},
+			},
+			{
+				Name:     "pingCAP",
+				Type:     "startup",
+				Patterns: []string{`^\s*pingcap\sinc\.?\s*$`},
+				Domains:  []model.OrgDomain{{Name: "PingCAP.com"}},
+			},
+		},
+	}
+
+	issues, patterns := validateOrgConfig(orgConfig)
+	if len(patterns) != 2 {
+		t.Errorf("Expect 2 valid patterns, but got %d", len(patterns))
+	}
+
+	expectIssues := []OrgConfigIssue{
+		{Level: OrgIssueError, OrgName: "PingCAP"},
+		{Level: OrgIssueWarning, OrgName: "pingCAP"},
+		{Level: OrgIssueError, OrgName: "pingCAP"},
+		{Level: OrgIssueError, OrgName: "PingCAP, pingCAP"},
+		{Level: OrgIssueWarning, OrgName: "pingCAP"},
+	}
+	if len(issues) != len(expectIssues) {
+		t.Fatalf("Expect %d issues, but got %d: %v", len(expectIssues), len(issues), issues)
+	}
+	for i, expectIssue := range expectIssues {
+		if issues[i].Level != expectIssue.Level || issues[i].OrgName != expectIssue.OrgName {
+			t.Errorf("Expect issue: %v, got %v", expectIssue, issues[i])
+		}
+	}
+}
+
+func TestNormalizeOrgName(t *testing.T) {
+	var testcases = []struct {
+		name   string
+		expect string
+	}{
+		{name: "@pingcap", expect: "pingcap"},
+		{name: "PingCAP Inc.", expect: "pingcap"},
+		{name: "pingcap ltd", expect: "pingcap"},
+		{name: "PingCAP Co., Ltd.", expect: "pingcap"},
+		{name: "PingCAP 平凯星辰", expect: "pingcap 平凯星辰"},
+		{name: "平凯星辰（北京）科技有限公司", expect: "平凯星辰 北京 科技"},
+		{name: "Company", expect: "company"},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			if got := normalizeOrgName(tc.name); got != tc.expect {
+				t.Errorf("Expect normalized name: %s, but got %s", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestOrgMatcherMatchOrg(t *testing.T) {
+	pingcap := model.Organization{Name: "PingCAP", Fullname: "PingCAP 平凯星辰"}
+	pingcap.ID = 1
+	google := model.Organization{Name: "Google"}
+	google.ID = 2
+
+	m := &OrgMatcher{
+		pattern2org: map[*regexp.Regexp]model.Organization{
+			regexp.MustCompile(`(?i)^\s*google\s*$`): google,
+		},
+		name2org:            make(map[string]model.Organization),
+		bigram2names:        make(map[string]map[string]struct{}),
+		shortNames:          make(map[string]struct{}),
+		similarityThreshold: 0.8,
+	}
+	m.addName(pingcap.Name, pingcap)
+	m.addName(pingcap.Fullname, pingcap)
+	m.addName(google.Name, google)
+
+	var testcases = []struct {
+		name        string
+		expectOrgID uint
+		matched     bool
+	}{
+		{name: "Google", expectOrgID: 2, matched: true},
+		{name: "@PingCAP", expectOrgID: 1, matched: true},
+		{name: "pingcap ltd", expectOrgID: 1, matched: true},
+		{name: "平凯星辰", expectOrgID: 1, matched: true},
+		{name: "PingCAPP Inc.", expectOrgID: 1, matched: true},
+		{name: "Ping An", matched: false},
+		{name: "Gogle", expectOrgID: 2, matched: true},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			org, similarity := m.matchOrg(tc.name)
+			matched := org != nil && similarity >= m.similarityThreshold
+			if matched != tc.matched {
+				t.Fatalf("Expect matched: %v, but got %v (similarity %.2f)", tc.matched, matched, similarity)
+			}
+			if matched && org.ID != tc.expectOrgID {
+				t.Errorf("Expect org: %d, but got %d", tc.expectOrgID, org.ID)
+			}
+		})
+	}
+}
+
+func TestOrgMatcherSimilarNames(t *testing.T) {
+	m := &OrgMatcher{
+		name2org:     make(map[string]model.Organization),
+		bigram2names: make(map[string]map[string]struct{}),
+		shortNames:   make(map[string]struct{}),
+	}
+	names := []string{"ibm", "intel", "pingcap", "alibaba", "tencent", "字节跳动", "bytedance", "ab", "xyzw"}
+	for _, name := range names {
+		m.indexName(name, model.Organization{Name: name})
+	}
+
+	// The index must find every name reaching minSuggestSimilarity as the full scan does.
+	candidates := []string{"ibn", "intle", "pincap", "alibab", "tencnet", "字节", "bytdance", "ba", "wzyx", "xy"}
+	for _, candidate := range candidates {
+		similarNames := m.similarNames(candidate)
+		for _, name := range names {
+			if nameSimilarity(candidate, name) < minSuggestSimilarity {
+				continue
+			}
+			if _, ok := similarNames[name]; !ok {
+				t.Errorf("Expect %s to be similar to %s", name, candidate)
+			}
+		}
+	}
+}
+
+func TestRollupEnrollments(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	newOrg := func(id uint, name string) model.Organization {
+		org := model.Organization{Name: name}
+		org.ID = id
+		return org
+	}
+
+	hierarchy := newOrgHierarchy(
+		[]model.Organization{
+			newOrg(1, "GitHub"), newOrg(2, "Microsoft"), newOrg(3, "Azure"), newOrg(4, "PingCAP"),
+		},
+		[]model.OrgRelation{
+			{OrgID: 1, ParentOrgID: 2, Type: model.OrgRelationAcquisition, StartDate: date("2018-10-26"), EndDate: model.DefaultEndDate},
+			{OrgID: 3, ParentOrgID: 2, Type: model.OrgRelationSubsidiary, StartDate: model.DefaultStartDate, EndDate: model.DefaultEndDate},
+		},
+	)
+
+	var testcases = []struct {
+		name        string
+		enrollments []EnrollmentWithOrg
+		expect      string
+	}{
+		{
+			name: "enrollment is split by the acquisition",
+			enrollments: []EnrollmentWithOrg{
+				{OrgID: 1, OrgName: "GitHub", StartDate: date("2015-01-01"), EndDate: date("2020-01-01")},
+				{OrgID: 4, OrgName: "PingCAP", StartDate: date("2020-01-01"), EndDate: model.DefaultEndDate},
+			},
+			expect: "GitHub < 2018-10-26, Microsoft < 2020-01-01, PingCAP",
+		},
+		{
+			name: "subsidiaries are merged into the parent",
+			enrollments: []EnrollmentWithOrg{
+				{OrgID: 3, OrgName: "Azure", StartDate: model.DefaultStartDate, EndDate: date("2019-01-01")},
+				{OrgID: 1, OrgName: "GitHub", StartDate: date("2019-01-01"), EndDate: model.DefaultEndDate},
+			},
+			expect: "Microsoft",
+		},
+		{
+			name: "organization without parent",
+			enrollments: []EnrollmentWithOrg{
+				{OrgID: 4, OrgName: "PingCAP", StartDate: model.DefaultStartDate, EndDate: model.DefaultEndDate},
+			},
+			expect: "PingCAP",
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getAffStr(hierarchy.rollupEnrollments(tc.enrollments)); got != tc.expect {
+				t.Errorf("Expect affiliation: %s, but got %s", tc.expect, got)
+			}
+		})
+	}
+
+	if descendants := hierarchy.Descendants(2, date("2018-01-01")); len(descendants) != 2 {
+		t.Errorf("Expect 2 organizations rolled up to Microsoft before the acquisition, but got %v", descendants)
+	}
+}
+
+func TestLocalDatasetEnricher(t *testing.T) {
+	dataset := `name,gender,probability,country_code
+Andrea,f,0.9,
+Andrea,m,0.95,IT
+Wei,m,0.6
+`
+	enricher, err := newLocalDatasetEnricher(strings.NewReader(dataset), "local:names.csv")
+	if err != nil {
+		t.Fatalf("Failed to load the dataset: %v", err)
+	}
+
+	var testcases = []struct {
+		name        string
+		countryCode string
+		expectOK    bool
+		expect      DemographicResult
+	}{
+		{
+			name:        "Andrea Rossi",
+			countryCode: "it",
+			expectOK:    true,
+			expect:      DemographicResult{Gender: "m", GenderAcc: 0.95, Provenance: "local:names.csv"},
+		},
+		{
+			name:     "andrea",
+			expectOK: true,
+			expect:   DemographicResult{Gender: "f", GenderAcc: 0.9, Provenance: "local:names.csv"},
+		},
+		{
+			name:        "Wei Zhang",
+			countryCode: "CN",
+			expectOK:    true,
+			expect:      DemographicResult{Gender: "m", GenderAcc: 0.6, Provenance: "local:names.csv"},
+		},
+		{
+			name:     "Unknown",
+			expectOK: false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := enricher.Enrich(tc.name, tc.countryCode)
+			if ok != tc.expectOK {
+				t.Fatalf("Expect ok: %v, but got %v", tc.expectOK, ok)
+			}
+			if ok && *got != tc.expect {
+				t.Errorf("Expect result: %v, but got %v", tc.expect, *got)
+			}
+		})
+	}
+
+	if _, err := newLocalDatasetEnricher(strings.NewReader("Alex,x,0.5\n"), "local"); err == nil {
+		t.Errorf("Expect error for the unknown gender.")
+	}
+	if ok := (NoopEnricher{}).Enabled(); ok {
+		t.Errorf("Expect the noop enricher to be disabled.")
+	}
+}
+
+func TestDiffEnrollments(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	past := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	before := []model.Enrollment{
+		{OrgID: 1, StartDate: model.DefaultStartDate, EndDate: model.DefaultEndDate},
+		{OrgID: 2, StartDate: model.DefaultStartDate, EndDate: model.DefaultEndDate},
+		{OrgID: 3, StartDate: model.DefaultStartDate, EndDate: past},
+	}
+	after := []model.Enrollment{
+		{OrgID: 1, StartDate: model.DefaultStartDate, EndDate: model.DefaultEndDate},
+		{OrgID: 2, StartDate: model.DefaultStartDate, EndDate: past},
+		{OrgID: 3, StartDate: model.DefaultStartDate, EndDate: past},
+		{OrgID: 4, StartDate: past, EndDate: model.DefaultEndDate},
+	}
+
+	opened, closed := diffEnrollments(before, after, now)
+	if len(opened) != 1 || opened[0].OrgID != 4 {
+		t.Errorf("Expect the enrollment of org 4 opened, but got %v", opened)
+	}
+	if len(closed) != 1 || closed[0].OrgID != 2 {
+		t.Errorf("Expect the enrollment of org 2 closed, but got %v", closed)
+	}
+}
+
+func TestDiffProfile(t *testing.T) {
+	cn, us := "CN", "US"
+	before := model.UniqueIdentity{Name: "Alice", Email: "a@example.com", CountryCode: &cn}
+	after := model.UniqueIdentity{Name: "Alice", Email: "alice@example.com", Location: "Beijing", CountryCode: &us}
+
+	got := diffProfile(before, after)
+	expect := []string{"email", "location", "country_code"}
+	if strings.Join(got, ",") != strings.Join(expect, ",") {
+		t.Errorf("Expect changed fields: %v, but got %v", expect, got)
+	}
+	if got := diffProfile(before, before); len(got) != 0 {
+		t.Errorf("Expect no changed field, but got %v", got)
+	}
+}
+
+func TestInferUTCOffset(t *testing.T) {
+	// workingHours - the activities between 9:00 and 22:00 local time, recorded in UTC.
+	workingHours := func(offsetMinutes int) [slotsPerDay]int {
+		var slots [slotsPerDay]int
+		for local := 9 * 60; local < 22*60; local += slotMinutes {
+			slots[((local-offsetMinutes)%(24*60)+24*60)%(24*60)/slotMinutes] += 10
+		}
+		return slots
+	}
+
+	var testcases = []struct {
+		name         string
+		slots        [slotsPerDay]int
+		expectOK     bool
+		expectOffset int
+	}{
+		{name: "UTC+8", slots: workingHours(8 * 60), expectOK: true, expectOffset: 8 * 60},
+		{name: "UTC-7", slots: workingHours(-7 * 60), expectOK: true, expectOffset: -7 * 60},
+		{name: "UTC+0", slots: workingHours(0), expectOK: true, expectOffset: 0},
+		{name: "UTC+5:30", slots: workingHours(5*60 + 30), expectOK: true, expectOffset: 5*60 + 30},
+		{name: "UTC+5:45", slots: workingHours(5*60 + 45), expectOK: true, expectOffset: 5*60 + 45},
+		{name: "UTC-3:30", slots: workingHours(-3*60 - 30), expectOK: true, expectOffset: -3*60 - 30},
+		{name: "too few activities", slots: [slotsPerDay]int{3: 5}, expectOK: false},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			offset, confidence, ok := inferUTCOffset(tc.slots, 30)
+			if ok != tc.expectOK {
+				t.Fatalf("Expect ok: %v, but got %v", tc.expectOK, ok)
+			}
+			if !ok {
+				return
+			}
+			if offset != tc.expectOffset {
+				t.Errorf("Expect offset: %d, but got %d", tc.expectOffset, offset)
+			}
+			if confidence <= 0 || confidence > 1 {
+				t.Errorf("Expect confidence in (0, 1], but got %f", confidence)
+			}
+		})
+	}
+
+	var evenly [slotsPerDay]int
+	for slot := range evenly {
+		evenly[slot] = 10
+	}
+	if _, confidence, _ := inferUTCOffset(evenly, 30); confidence > 0.01 {
+		t.Errorf("Expect no confidence for the evenly distributed activities, but got %f", confidence)
+	}
+}
+
+func TestSortingHatIdentityID(t *testing.T) {
+	if got := sortingHatIdentityID("github", "alice@example.com", "Alice Liddell", "alice"); got != "1817b1d76c012333cdea6cf6a4c846fb7b52d486" {
+		t.Errorf("Expect identity id: 1817b1d76c012333cdea6cf6a4c846fb7b52d486, but got %s", got)
+	}
+	if got := sortingHatIdentityID("github", "", "", "bob"); got != "1edcbc5619b89aaaba9e01cf4ce09e9fb110d011" {
+		t.Errorf("Expect identity id: 1edcbc5619b89aaaba9e01cf4ce09e9fb110d011, but got %s", got)
+	}
+	// The accents of the name are removed like SortingHat.
+	if got := sortingHatIdentityID("github", "jose@example.com", "José Ñandú", "jose"); got != "9e470e97d7896e25d5e075b38a9a64ef1899ecf7" {
+		t.Errorf("Expect identity id: 9e470e97d7896e25d5e075b38a9a64ef1899ecf7, but got %s", got)
+	}
+}
+
+func TestMatchSortingHatUIdentity(t *testing.T) {
+	db, fake := openFakeDB(t, []fakeResult{
+		{pattern: "from accounts", columns: []string{"uuid"}, rows: [][]driver.Value{{"uuid-2"}}},
+		{pattern: "github_user_emails", columns: []string{"uuid"}, rows: [][]driver.Value{{"uuid-1"}}},
+	})
+
+	username := "Alice"
+	email := "Alice@Example.com"
+	uidentity := SortingHatUIdentity{
+		UUID: "sortinghat-uuid",
+		Identities: []SortingHatIdentity{
+			{Source: "gitee", Username: &username},
+			{Source: SortingHatGitSource, Email: &email},
+		},
+	}
+	uuids, err := matchSortingHatUIdentity(db, uidentity)
+	if err != nil {
+		t.Fatalf("Failed to match the identity: %v", err)
+	}
+	if !reflect.DeepEqual(uuids, []string{"uuid-1", "uuid-2"}) {
+		t.Errorf("Expect matched identities: [uuid-1 uuid-2], but got %v", uuids)
+	}
+
+	// The identities without GitHub logins are matched by the accounts and the emails.
+	if len(fake.executed("github_user_logins")) != 0 {
+		t.Errorf("Expect no query of GitHub logins")
+	}
+	provider2args := make(map[string][]driver.Value)
+	for _, statement := range fake.executed("from accounts") {
+		provider2args[statement.args[0].(string)] = statement.args[1:]
+	}
+	expectArgs := map[string][]driver.Value{
+		string(model.GiteeProvider): {"alice"},
+		string(model.EmailProvider): {emailAccountID("alice@example.com")},
+	}
+	if !reflect.DeepEqual(provider2args, expectArgs) {
+		t.Errorf("Expect accounts matched by %v, but got %v", expectArgs, provider2args)
+	}
+}
+
+func TestImportSortingHatEnrollments(t *testing.T) {
+	db, fake := openFakeDB(t, []fakeResult{
+		{
+			pattern: `SELECT * FROM "enrollments"`,
+			columns: []string{"org_id", "uuid", "start_date", "end_date", "invalid", "source"},
+			rows: [][]driver.Value{
+				{int64(1), "uuid", model.DefaultStartDate, model.DefaultEndDate, true, string(model.ManualSource)},
+				{int64(2), "uuid", model.DefaultStartDate, model.DefaultEndDate, false, string(model.LarkContactSource)},
+				{int64(3), "uuid", model.DefaultStartDate, model.DefaultEndDate, false, string(model.GitHubProfileSource)},
+			},
+		},
+	})
+
+	name2org := map[string]*model.Organization{
+		"Manual":  {Model: gorm.Model{ID: 1}},
+		"Lark":    {Model: gorm.Model{ID: 2}},
+		"Profile": {Model: gorm.Model{ID: 3}},
+		"New":     {Model: gorm.Model{ID: 4}},
+	}
+	shEnrollments := []SortingHatEnrollment{
+		{Organization: "Manual"}, {Organization: "Lark"}, {Organization: "Profile"}, {Organization: "New"},
+	}
+	n, err := importSortingHatEnrollments(db, "uuid", shEnrollments, nil, name2org)
+	if err != nil {
+		t.Fatalf("Failed to import the enrollments: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expect 2 enrollments imported, but got %d", n)
+	}
+
+	orgIDs := make([]driver.Value, 0)
+	for _, statement := range fake.executed(`INSERT INTO "enrollments"`) {
+		orgIDs = append(orgIDs, statement.args[0])
+	}
+	if !reflect.DeepEqual(orgIDs, []driver.Value{int64(3), int64(4)}) {
+		t.Errorf("Expect the enrollments of orgs [3 4] are written, but got %v", orgIDs)
+	}
+}
+
+func TestCanOverrideSource(t *testing.T) {
+	var testcases = []struct {
+		source   model.ProfileSource
+		existing model.ProfileSource
+		expect   bool
+	}{
+		{source: model.SortingHatSource, existing: model.GitHubProfileSource, expect: true},
+		{source: model.SortingHatSource, existing: model.EmailDomainSource, expect: true},
+		{source: model.SortingHatSource, existing: model.LarkContactSource, expect: false},
+		{source: model.SortingHatSource, existing: model.ManualSource, expect: false},
+		{source: model.SortingHatSource, existing: model.UserManualSource, expect: false},
+		{source: model.ManualSource, existing: model.UserManualSource, expect: false},
+		{source: model.UserManualSource, existing: model.ManualSource, expect: true},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(string(tc.source)+" over "+string(tc.existing), func(t *testing.T) {
+			if got := canOverrideSource(tc.source, tc.existing); got != tc.expect {
+				t.Errorf("Expect %v, but got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestToSortingHatUIdentity(t *testing.T) {
+	name, cn := "Alice", "CN"
+	uniqueIdentity := model.UniqueIdentity{
+		UUID:        "u1",
+		Name:        "Alice",
+		Gender:      "f",
+		GenderAcc:   0.9,
+		CountryCode: &cn,
+		Country:     model.Country{Code: "CN", Name: "China", Alpha3: "CHN"},
+	}
+	githubUsers := []model.GitHubUser{
+		{
+			Login:  "alice",
+			Name:   &name,
+			Emails: []model.GitHubUserEmail{{Email: "a@example.com"}, {Email: "alice@example.com"}},
+			Logins: []model.GitHubUserLogin{{Login: "alice"}},
+		},
+	}
+	enrollments := []EnrollmentWithOrg{
+		{OrgName: "PingCAP", StartDate: model.DefaultStartDate, EndDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	uidentity := toSortingHatUIdentity(uniqueIdentity, githubUsers, nil, enrollments, true)
+	if len(uidentity.Identities) != 2 {
+		t.Fatalf("Expect 2 identities, but got %d", len(uidentity.Identities))
+	}
+	if *uidentity.Identities[0].Username != "alice" || uidentity.Identities[0].Source != SortingHatGitHubSource {
+		t.Errorf("Unexpected identity: %v", uidentity.Identities[0])
+	}
+	if uidentity.Profile.Gender == nil || *uidentity.Profile.Gender != "female" || *uidentity.Profile.GenderAcc != 90 {
+		t.Errorf("Expect gender female with accuracy 90, but got %v", uidentity.Profile)
+	}
+	if uidentity.Profile.Country == nil || uidentity.Profile.Country.Alpha3 != "CHN" {
+		t.Errorf("Expect country CHN, but got %v", uidentity.Profile.Country)
+	}
+	if len(uidentity.Enrollments) != 1 || uidentity.Enrollments[0].End != "2020-01-01T00:00:00" {
+		t.Errorf("Unexpected enrollments: %v", uidentity.Enrollments)
+	}
+
+	if uidentity := toSortingHatUIdentity(uniqueIdentity, githubUsers, nil, enrollments, false); uidentity.Profile.Gender != nil {
+		t.Errorf("Expect no gender exported, but got %s", *uidentity.Profile.Gender)
+	}
+}
+
+func TestSortingHatProfileUpdates(t *testing.T) {
+	name, gender, genderAcc := "Bob", "Male", 80
+	profile := &SortingHatProfile{
+		Name:      &name,
+		Gender:    &gender,
+		GenderAcc: &genderAcc,
+		Country:   &SortingHatCountry{Code: "us"},
+	}
+
+	updates := sortingHatProfileUpdates(model.UniqueIdentity{Name: "Robert"}, profile)
+	if _, ok := updates["name"]; ok {
+		t.Errorf("Expect the existing name is kept.")
+	}
+	if updates["gender"] != "m" || updates["gender_acc"] != 0.8 {
+		t.Errorf("Expect gender m with accuracy 0.8, but got %v %v", updates["gender"], updates["gender_acc"])
+	}
+	if updates["country_code"] != "US" {
+		t.Errorf("Expect country code US, but got %v", updates["country_code"])
+	}
+
+	if _, err := parseSortingHatDate("2015-01-01T00:00:00", model.DefaultStartDate); err != nil {
+		t.Errorf("Failed to parse the date: %v", err)
+	}
+	if date, _ := parseSortingHatDate("", model.DefaultEndDate); !date.Equal(model.DefaultEndDate) {
+		t.Errorf("Expect the default date, but got %v", date)
+	}
+}
+
+func TestAccountEnrichers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/gitee/users/alice":
+			_, _ = fmt.Fprint(w, `{"login": "alice", "name": "Alice", "email": "alice@example.com", "company": "PingCAP"}`)
+		case r.URL.Path == "/gitlab/users" && r.URL.Query().Get("username") == "bob":
+			_, _ = fmt.Fprint(w, `[{"id": 42, "username": "bob"}]`)
+		case r.URL.Path == "/gitlab/users/42":
+			_, _ = fmt.Fprint(w, `{"username": "bob", "name": "Bob", "organization": "GitLab", "location": "Berlin"}`)
+		case r.URL.Path == "/gitlab/users":
+			_, _ = fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cache, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file cache: %v", err)
+	}
+	ctx := &Ctx{GiteeAPIBaseURL: server.URL + "/gitee", GitLabAPIBaseURL: server.URL + "/gitlab/"}
+	enrichers := NewAccountEnrichers(ctx, cache)
+
+	profile, err := enrichers[model.GiteeProvider].GetProfile(model.Account{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to get the gitee profile: %v", err)
+	}
+	if profile.Company != "PingCAP" || profile.Name != "Alice" {
+		t.Errorf("Unexpected gitee profile: %v", profile)
+	}
+
+	profile, err = enrichers[model.GitLabProvider].GetProfile(model.Account{Username: "bob"})
+	if err != nil {
+		t.Fatalf("Failed to get the gitlab profile: %v", err)
+	}
+	if profile.Company != "GitLab" || profile.Location != "Berlin" {
+		t.Errorf("Unexpected gitlab profile: %v", profile)
+	}
+
+	if _, err := enrichers[model.GitLabProvider].GetProfile(model.Account{Username: "nobody"}); err == nil {
+		t.Errorf("Expect error for the unknown gitlab user.")
+	}
+	if _, ok := cache.Get(accountProfileCacheKeyPrefix + "gitlab-nobody"); ok {
+		t.Errorf("Expect the failed result is not cached.")
+	}
+	if _, ok := cache.Get(accountProfileCacheKeyPrefix + "gitlab-bob"); !ok {
+		t.Errorf("Expect the profile is cached.")
+	}
+
+	name := "Carol"
+	profile, _ = enrichers[model.EmailProvider].GetProfile(model.Account{Email: "carol@example.com", Name: &name})
+	if profile.Name != "Carol" || profile.Email != "carol@example.com" {
+		t.Errorf("Unexpected email profile: %v", profile)
+	}
+	if emailAccountID("Carol@Example.com ") != emailAccountID("carol@example.com") {
+		t.Errorf("Expect the email account id is case insensitive.")
+	}
+}
+
+func TestImportEmailAccounts(t *testing.T) {
+	db, fake := openFakeDB(t, []fakeResult{
+		{
+			pattern: "from gha_commits",
+			columns: []string{"email", "name", "commits"},
+			rows: [][]driver.Value{
+				{"alice@example.com", "Alice", int64(1)},
+				{"bob@example.com", "Bob", int64(10)},
+				{"carol@example.com", "Carol", int64(9)},
+			},
+		},
+		{
+			pattern: "union",
+			columns: []string{"email", "uuid"},
+			rows:    [][]driver.Value{{"alice@example.com", "uuid-alice"}},
+		},
+	})
+	log := logrus.WithField("test", "import-email-accounts")
+
+	err := importEmailAccounts(log, db, db, 10)
+	if err != nil {
+		t.Fatalf("Failed to import the email accounts: %v", err)
+	}
+
+	// Only the email-only author with enough commits gets a unique identity.
+	identities := fake.executed(`INSERT INTO "unique_identities"`)
+	if len(identities) != 1 {
+		t.Fatalf("Expect 1 unique identity created, but got %d", len(identities))
+	}
+	args := identities[0].args
+	if args[1] != "Bob" || args[2] != string(model.GitCommitSource) || args[3] != "bob@example.com" {
+		t.Errorf("Expect the identity of Bob from git commits, but got %v", args)
+	}
+
+	// The columns of accounts: created_at, updated_at, deleted_at, uuid, provider, provider_user_id, username, email...
+	email2uuid := make(map[driver.Value]driver.Value)
+	for _, statement := range fake.executed(`INSERT INTO "accounts"`) {
+		if statement.args[4] != string(model.EmailProvider) {
+			t.Errorf("Expect email account, but got %v", statement.args[4])
+		}
+		email2uuid[statement.args[7]] = statement.args[3]
+	}
+	expect := map[driver.Value]driver.Value{
+		"alice@example.com": "uuid-alice",
+		"bob@example.com":   args[0],
+	}
+	if !reflect.DeepEqual(email2uuid, expect) {
+		t.Errorf("Expect email accounts %v, but got %v", expect, email2uuid)
+	}
+}
+
+func TestLoadCommunityConfig(t *testing.T) {
+	config, err := LoadCommunityConfig("../../../configs/shared/communities.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load the community config: %v", err)
+	}
+	if len(config.Projects) == 0 {
+		t.Fatalf("Expect at least one community project.")
+	}
+	for _, project := range config.Projects {
+		if project.Format != TeamJSONFormat || len(project.TeamsFolderPath) == 0 || len(project.MembershipFileName) == 0 {
+			t.Errorf("Expect the defaults of %s are filled, but got %+v.", project.Name, project)
+		}
+	}
+}
+
+func TestValidateCommunityConfig(t *testing.T) {
+	valid := CommunityProject{
+		Name:               "tidb",
+		DisplayName:        "TiDB",
+		DefaultOrgName:     "pingcap",
+		CommunityRepoURL:   "https://github.com/pingcap/community",
+		Format:             TeamJSONFormat,
+		TeamsFolderPath:    DefaultTeamsFolderPath,
+		MembershipFileName: DefaultMembershipFileName,
+		StartCommitSHA:     "fe66c24508d4fecbee903d7a78df2f16cdfbb13b",
+	}
+	withoutRepoURL := valid
+	withoutRepoURL.CommunityRepoURL = ""
+	withUnknownFormat := valid
+	withUnknownFormat.Format = "unknown"
+	withShortSHA := valid
+	withShortSHA.StartCommitSHA = "fe66c24"
+
+	var testcases = []struct {
+		name     string
+		projects []CommunityProject
+
+		expectError bool
+	}{
+		{
+			name:     "valid project",
+			projects: []CommunityProject{valid},
+		},
+		{
+			name:        "duplicated project",
+			projects:    []CommunityProject{valid, valid},
+			expectError: true,
+		},
+		{
+			name:        "missing repo url",
+			projects:    []CommunityProject{withoutRepoURL},
+			expectError: true,
+		},
+		{
+			name:        "unsupported format",
+			projects:    []CommunityProject{withUnknownFormat},
+			expectError: true,
+		},
+		{
+			name:        "abbreviated start commit",
+			projects:    []CommunityProject{withShortSHA},
+			expectError: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			err := CommunityConfig{Projects: tc.projects}.Validate()
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expect error %v, but got %v.", tc.expectError, err)
+			}
+		})
+	}
+}
+
+// mapCommunityFiles - the community files in memory, keyed by the path.
+type mapCommunityFiles map[string]string
+
+func (f mapCommunityFiles) ReadFile(filepath string) ([]byte, error) {
+	contents, ok := f[cleanRepoPath(filepath)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(contents), nil
+}
+
+func (f mapCommunityFiles) ListDirs(dirpath string) ([]string, error) {
+	prefix := cleanRepoPath(dirpath)
+	if len(prefix) != 0 {
+		prefix += "/"
+	}
+	dirSet := make(map[string]struct{})
+	dirs := make([]string, 0)
+	for filepath := range f {
+		if !strings.HasPrefix(filepath, prefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(filepath, prefix), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, ok := dirSet[parts[0]]; !ok {
+			dirSet[parts[0]] = struct{}{}
+			dirs = append(dirs, parts[0])
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+func TestMembershipParsers(t *testing.T) {
+	var testcases = []struct {
+		name    string
+		project CommunityProject
+		files   mapCommunityFiles
+
+		expectTeams []TeamMembership
+	}{
+		{
+			name: "team json",
+			project: CommunityProject{
+				Name: "tidb", CommunityRepoURL: "https://github.com/pingcap/community.git", Format: TeamJSONFormat,
+				TeamsFolderPath: "teams", MembershipFileName: "membership.json",
+			},
+			files: mapCommunityFiles{
+				"teams/sql/membership.json": `{
+  "description": "SQL team",
+  "maintainers": ["alice"],
+  "committers": ["bob", "alice"],
+  "reviewers": ["carol"],
+  "repositories": ["tidb"],
+  "channels": [{"platform": "slack", "name": "#sig-sql"}],
+  "meetings": [{"description": "Weekly sync", "schedule": "Weekly on Tuesday 14:00 UTC+8"}],
+  "mentors": ["alice"]
+}`,
+				"teams/sql/CHARTER.md": "# SQL SIG Charter",
+				"teams/docs/README.md": "The membership file is missing.",
+			},
+			expectTeams: []TeamMembership{
+				{Name: "docs"},
+				{
+					Name:        "sql",
+					Description: "SQL team",
+					Members: map[string]model.TeamLevel{
+						"alice": model.TeamMaintainer, "bob": model.TeamCommitter, "carol": model.TeamReviewer,
+					},
+					Repositories: []string{"tidb"},
+					Metadata: model.TeamMetadata{
+						Charter:  "https://github.com/pingcap/community/blob/HEAD/teams/sql/CHARTER.md",
+						Channels: []model.TeamChannel{{Platform: "slack", Name: "#sig-sql"}},
+						Meetings: []model.TeamMeeting{{Description: "Weekly sync", Schedule: "Weekly on Tuesday 14:00 UTC+8"}},
+						Mentors:  []string{"alice"},
+					},
+				},
+			},
+		},
+		{
+			name: "owners with aliases and filters",
+			project: CommunityProject{
+				Name: "chaos-mesh", Format: OwnersFormat, TeamsFolderPath: ".", MembershipFileName: "OWNERS",
+			},
+			files: mapCommunityFiles{
+				"OWNERS_ALIASES": `aliases:
+  sig-scheduler-leads:
+    - alice
+    - bob
+`,
+				"scheduler/OWNERS": `approvers:
+  - sig-scheduler-leads
+reviewers:
+  - bob
+  - carol
+emeritus_approvers:
+  - dave
+filters:
+  ".*\\.md$":
+    reviewers:
+      - erin
+`,
+				"docs/README.md": "Not a team.",
+			},
+			expectTeams: []TeamMembership{
+				{
+					Name: "scheduler",
+					Members: map[string]model.TeamLevel{
+						"alice": model.TeamApprover, "bob": model.TeamApprover,
+						"carol": model.TeamReviewer, "erin": model.TeamReviewer,
+					},
+				},
+			},
+		},
+		{
+			name:    "codeowners",
+			project: CommunityProject{Name: "ob", Format: CodeOwnersFormat, MembershipFileName: ".github/CODEOWNERS"},
+			files: mapCommunityFiles{
+				".github/CODEOWNERS": `# The default owners.
+*       @alice @ob/admins
+/sql/   @bob carol@example.com
+sql/parser/*.go @carol
+`,
+			},
+			expectTeams: []TeamMembership{
+				{Name: "ob", Members: map[string]model.TeamLevel{"alice": model.TeamApprover}},
+				{Name: "sql", Members: map[string]model.TeamLevel{"bob": model.TeamApprover, "carol": model.TeamApprover}},
+			},
+		},
+		{
+			name: "maintainers markdown",
+			project: CommunityProject{
+				Name: "chaos-mesh", Format: MaintainersMarkdownFormat, MembershipFileName: "MAINTAINERS.md",
+			},
+			files: mapCommunityFiles{
+				"MAINTAINERS.md": `# Maintainers
+
+| Name | GitHub | Company |
+|------|--------|---------|
+| Alice | [@alice](https://github.com/alice) | PingCAP |
+
+## Dashboard
+
+| Name | GitHub ID | Role |
+|:-----|:---------:|------|
+| Bob | bob | Committer |
+| Carol | carol | Reviewer |
+
+### Emeritus Maintainers
+
+| Name | GitHub |
+|------|--------|
+| Dave | @dave |
+`,
+			},
+			expectTeams: []TeamMembership{
+				{Name: "chaos-mesh", Members: map[string]model.TeamLevel{"alice": model.TeamMaintainer}},
+				{Name: "dashboard", Members: map[string]model.TeamLevel{"bob": model.TeamCommitter, "carol": model.TeamReviewer}},
+			},
+		},
+		{
+			name:    "sigs yaml",
+			project: CommunityProject{Name: "kubernetes", Format: SigsYamlFormat, MembershipFileName: "sigs.yaml"},
+			files: mapCommunityFiles{
+				"sigs.yaml": `sigs:
+  - dir: sig-node
+    name: Node
+    mission_statement: >
+      Node components.
+    charter_link: charter.md
+    contact:
+      slack: sig-node
+    meetings:
+      - description: Regular SIG Meeting
+        day: Tuesday
+        time: "10:00"
+        tz: PT (Pacific Time)
+        frequency: weekly
+        url: https://zoom.us/j/1
+    leadership:
+      chairs:
+        - github: alice
+          name: Alice
+      tech_leads:
+        - github: bob
+          name: Bob
+    subprojects:
+      - name: kubelet
+        owners:
+          - https://raw.githubusercontent.com/kubernetes/kubernetes/master/pkg/kubelet/OWNERS
+          - https://raw.githubusercontent.com/kubernetes/kubernetes/master/cmd/kubelet/OWNERS
+workinggroups:
+  - name: Batch Scheduling
+    leadership:
+      chairs:
+        - github: carol
+`,
+			},
+			expectTeams: []TeamMembership{
+				{
+					Name:         "sig-node",
+					Description:  "Node components.",
+					Members:      map[string]model.TeamLevel{"alice": model.TeamMaintainer, "bob": model.TeamMaintainer},
+					Repositories: []string{"kubernetes/kubernetes"},
+					Metadata: model.TeamMetadata{
+						Charter:  "sig-node/charter.md",
+						Channels: []model.TeamChannel{{Platform: "slack", Name: "sig-node"}},
+						Meetings: []model.TeamMeeting{{
+							Description: "Regular SIG Meeting",
+							Schedule:    "weekly Tuesday 10:00 PT (Pacific Time)",
+							URL:         "https://zoom.us/j/1",
+						}},
+					},
+				},
+				{
+					Name:         "batch-scheduling",
+					Members:      map[string]model.TeamLevel{"carol": model.TeamMaintainer},
+					Repositories: []string{},
+				},
+			},
+		},
+		{
+			name:    "broken sigs yaml",
+			project: CommunityProject{Name: "kubernetes", Format: SigsYamlFormat, MembershipFileName: "sigs.yaml"},
+			files: mapCommunityFiles{
+				"sigs.yaml": "sigs:\n  - dir: sig-node\n   name: Node\n",
+			},
+			expectTeams: nil,
+		},
+		{
+			name: "broken maintainers markdown",
+			project: CommunityProject{
+				Name: "chaos-mesh", Format: MaintainersMarkdownFormat, MembershipFileName: "MAINTAINERS.md",
+			},
+			files: mapCommunityFiles{
+				"MAINTAINERS.md": "| Alice | @" + strings.Repeat("a", bufio.MaxScanTokenSize) + " |\n",
+			},
+			expectTeams: nil,
+		},
+		{
+			name:        "missing codeowners",
+			project:     CommunityProject{Name: "ob", Format: CodeOwnersFormat, MembershipFileName: ".github/CODEOWNERS"},
+			files:       mapCommunityFiles{"README.md": "The CODEOWNERS file is removed."},
+			expectTeams: nil,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			parser, err := NewMembershipParser(tc.project.Format)
+			if err != nil {
+				t.Fatalf("Failed to get the parser: %v", err)
+			}
+			teams, err := parser.Parse(tc.files, tc.project)
+			if err != nil {
+				t.Fatalf("Failed to parse the membership: %v", err)
+			}
+			if !reflect.DeepEqual(teams, tc.expectTeams) {
+				t.Errorf("Expect teams %+v, but got %+v.", tc.expectTeams, teams)
+			}
+		})
+	}
+}
+
+func TestDiffTeamMemberships(t *testing.T) {
+	before := []TeamMembership{
+		{
+			Name: "sql",
+			Members: map[string]model.TeamLevel{
+				"alice": model.TeamMaintainer, "bob": model.TeamReviewer, "Carol": model.TeamCommitter,
+				"dave": model.TeamReviewer,
+			},
+			Repositories: []string{"tidb"},
+		},
+		{Name: "legacy", Members: map[string]model.TeamLevel{"erin": model.TeamMaintainer}},
+		{Name: "docs"},
+	}
+	after := []TeamMembership{
+		{
+			Name: "sql",
+			Members: map[string]model.TeamLevel{
+				"alice": model.TeamMaintainer, "bob": model.TeamCommitter, "carol": model.TeamReviewer,
+				"frank": model.TeamReviewer,
+			},
+			Repositories: []string{"tidb", "parser"},
+		},
+		{Name: "docs", Members: map[string]model.TeamLevel{"grace": model.TeamMaintainer}},
+		{Name: "tools", Members: map[string]model.TeamLevel{"heidi": model.TeamMaintainer}},
+	}
+
+	diff := DiffTeamMemberships(before, after)
+	expectDiff := MembershipDiff{
+		TeamsCreated: []string{"tools"},
+		TeamsDeleted: []string{"legacy"},
+		MemberChanges: []MemberChange{
+			{Team: "legacy", Login: "erin", Type: MemberRemoved, LevelFrom: model.TeamMaintainer},
+			{Team: "sql", Login: "bob", Type: MemberPromoted, LevelFrom: model.TeamReviewer, LevelTo: model.TeamCommitter},
+			{Team: "sql", Login: "carol", Type: MemberDemoted, LevelFrom: model.TeamCommitter, LevelTo: model.TeamReviewer},
+			{Team: "sql", Login: "dave", Type: MemberRemoved, LevelFrom: model.TeamReviewer},
+			{Team: "sql", Login: "frank", Type: MemberJoined, LevelTo: model.TeamReviewer},
+			{Team: "tools", Login: "heidi", Type: MemberJoined, LevelTo: model.TeamMaintainer},
+		},
+		RepositoryChanges: []RepositoryChange{
+			{Team: "sql", Repository: "parser", Added: true},
+		},
+	}
+	if !reflect.DeepEqual(diff, expectDiff) {
+		t.Errorf("Expect diff %+v, but got %+v.", expectDiff, diff)
+	}
+
+	if got := DiffTeamMemberships(after, after).Text(); got != "No changes.\n" {
+		t.Errorf("Expect no changes, but got %q.", got)
+	}
+}
+
+func TestTeamMetadataValue(t *testing.T) {
+	metadata := model.TeamMetadata{
+		Charter:  "https://github.com/pingcap/community/blob/HEAD/teams/sql/CHARTER.md",
+		Channels: []model.TeamChannel{{Platform: "slack", Name: "#sig-sql"}},
+		Mentors:  []string{"alice"},
+	}
+	value, err := metadata.Value()
+	if err != nil {
+		t.Fatalf("Failed to encode the team metadata: %v", err)
+	}
+
+	var got model.TeamMetadata
+	if err = got.Scan([]byte(value.(string))); err != nil {
+		t.Fatalf("Failed to decode the team metadata: %v", err)
+	}
+	if !reflect.DeepEqual(got, metadata) {
+		t.Errorf("Expect team metadata %+v, but got %+v.", metadata, got)
+	}
+
+	if err = got.Scan(nil); err != nil || !reflect.DeepEqual(got, model.TeamMetadata{}) {
+		t.Errorf("Expect empty team metadata, but got %+v, %v.", got, err)
+	}
+}
+
+func TestParseTeamMetricsPeriod(t *testing.T) {
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	var testcases = []struct {
+		name     string
+		fromDate string
+		toDate   string
+
+		expectFrom time.Time
+		expectTo   time.Time
+		expectErr  bool
+	}{
+		{
+			name:       "default period",
+			expectFrom: now.AddDate(0, 0, -30),
+			expectTo:   now,
+		},
+		{
+			name:       "inclusive to date",
+			fromDate:   "2021-01-01",
+			toDate:     "2021-01-31",
+			expectFrom: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			expectTo:   time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "only to date",
+			toDate:     "2021-01-31",
+			expectFrom: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC),
+			expectTo:   time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "wrong date",
+			fromDate:  "2021/01/01",
+			expectErr: true,
+		},
+		{
+			name:      "from date after to date",
+			fromDate:  "2021-02-01",
+			toDate:    "2021-01-01",
+			expectErr: true,
+		},
+		{
+			name:       "longest period",
+			fromDate:   "2020-01-01",
+			toDate:     "2020-12-31",
+			expectFrom: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			expectTo:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "too long period",
+			fromDate:  "2019-01-01",
+			toDate:    "2021-01-01",
+			expectErr: true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			from, to, err := ParseTeamMetricsPeriod(tc.fromDate, tc.toDate, 30, now)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("Expect error, but got period %v - %v.", from, to)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse the period: %v", err)
+			}
+			if !from.Equal(tc.expectFrom) || !to.Equal(tc.expectTo) {
+				t.Errorf("Expect period %v - %v, but got %v - %v.", tc.expectFrom, tc.expectTo, from, to)
+			}
+		})
+	}
+}
+
+func TestFindTeam(t *testing.T) {
+	teamColumns := []string{"id", "name", "project_id"}
+	var testcases = []struct {
+		name        string
+		projectName string
+		rows        [][]driver.Value
+		expectErr   error
+	}{
+		{
+			name:        "team of the project",
+			projectName: "tidb",
+			rows:        [][]driver.Value{{int64(1), "sig-planner", int64(1)}},
+		},
+		{
+			name: "team name without project is unique",
+			rows: [][]driver.Value{{int64(1), "sig-planner", int64(1)}},
+		},
+		{
+			name:      "team name without project is ambiguous",
+			rows:      [][]driver.Value{{int64(1), "sig-planner", int64(1)}, {int64(2), "sig-planner", int64(2)}},
+			expectErr: ErrTeamAmbiguous,
+		},
+		{
+			name:        "team is not found",
+			projectName: "tikv",
+			expectErr:   ErrTeamNotFound,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			db, fake := openFakeDB(t, []fakeResult{
+				{pattern: `FROM "teams"`, columns: teamColumns, rows: tc.rows},
+			})
+
+			team, err := FindTeam(db, tc.projectName, "sig-planner")
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("Expect error %v, but got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to find the team: %v", err)
+			}
+			if team.ID != 1 {
+				t.Errorf("Expect team 1, but got %d", team.ID)
+			}
+
+			queries := fake.executed(`FROM "teams"`, `project_id in (SELECT "id" FROM "projects" WHERE name =`)
+			if scoped := len(queries) != 0; scoped != (len(tc.projectName) != 0) {
+				t.Errorf("Expect the query scoped by project: %v, but got %v", len(tc.projectName) != 0, scoped)
+			}
+		})
+	}
+}
+
+func TestSummarizeTeamMembers(t *testing.T) {
+	inactiveSince := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	teamMembers := []model.TeamMember{
+		{DupGitHubID: 1, DupGitHubLogin: "alice", Level: model.TeamMaintainer},
+		{DupGitHubID: 2, DupGitHubLogin: "bob", Level: model.TeamCommitter},
+		{DupGitHubID: 3, DupGitHubLogin: "carol", Level: model.TeamReviewer},
+		{DupGitHubID: 4, DupGitHubLogin: "dave", Level: model.TeamReviewer},
+	}
+	id2load := map[uint]reviewLoad{
+		2: {ActorID: 2, ReviewedPRs: 10, ReviewEvents: 25},
+		3: {ActorID: 3, ReviewedPRs: 3, ReviewEvents: 4},
+	}
+	id2response := map[uint]float64{2: 5.5}
+	recent := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	stale := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	id2lastActivity := map[uint]time.Time{1: stale, 2: recent, 3: recent}
+
+	members, inactiveMembers := summarizeTeamMembers(teamMembers, id2load, id2response, id2lastActivity, inactiveSince)
+
+	expectLogins := []string{"bob", "carol", "alice", "dave"}
+	logins := make([]string, 0, len(members))
+	for _, member := range members {
+		logins = append(logins, member.GitHubLogin)
+	}
+	if !reflect.DeepEqual(logins, expectLogins) {
+		t.Errorf("Expect members ordered by review load %v, but got %v.", expectLogins, logins)
+	}
+	if !reflect.DeepEqual(inactiveMembers, []string{"alice", "dave"}) {
+		t.Errorf("Expect inactive members [alice dave], but got %v.", inactiveMembers)
+	}
+
+	bob := members[0]
+	if bob.ReviewEvents != 25 || bob.MedianResponseHours == nil || *bob.MedianResponseHours != 5.5 || bob.Inactive {
+		t.Errorf("Unexpected metrics of bob: %+v.", bob)
+	}
+	dave := members[3]
+	if dave.LastActivityAt != nil || dave.MedianResponseHours != nil || !dave.Inactive {
+		t.Errorf("Unexpected metrics of dave: %+v.", dave)
+	}
+}
+
+func TestLoadPromotionRules(t *testing.T) {
+	rules, err := LoadPromotionRules("../../../configs/shared/promotion_rules.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load the promotion rules: %v", err)
+	}
+	if rules.PeriodDays <= 0 || len(rules.Defaults) == 0 {
+		t.Errorf("Expect the period and the default thresholds, but got %+v.", rules)
+	}
+
+	invalid := PromotionRules{
+		PeriodDays: 365,
+		Defaults:   map[model.TeamLevel]LadderThreshold{"contributor": {MergedPRs: 1}},
+		Teams: map[string]map[model.TeamLevel]LadderThreshold{
+			"sig-sql": {model.TeamReviewer: {MergedPRs: -1}},
+		},
+	}
+	if err = invalid.Validate(); err == nil {
+		t.Errorf("Expect error for the unknown level and the negative threshold.")
+	}
+}
+
+func TestEligibleLevel(t *testing.T) {
+	rules := PromotionRules{
+		Defaults: map[model.TeamLevel]LadderThreshold{
+			model.TeamReviewer:  {MergedPRs: 5},
+			model.TeamCommitter: {MergedPRs: 20, ReviewedPRs: 20},
+		},
+		Teams: map[string]map[model.TeamLevel]LadderThreshold{
+			"sig-docs": {model.TeamReviewer: {MergedPRs: 2}},
+		},
+	}
+
+	var testcases = []struct {
+		name         string
+		team         string
+		currentLevel model.TeamLevel
+		evidence     PromotionEvidence
+
+		expectLevel model.TeamLevel
+		expectOk    bool
+	}{
+		{
+			name:     "not enough merged PRs",
+			team:     "sig-sql",
+			evidence: PromotionEvidence{MergedPRs: 3},
+		},
+		{
+			name:        "team threshold overrides the default",
+			team:        "sig-docs",
+			evidence:    PromotionEvidence{MergedPRs: 3},
+			expectLevel: model.TeamReviewer,
+			expectOk:    true,
+		},
+		{
+			name:        "highest eligible level",
+			team:        "sig-sql",
+			evidence:    PromotionEvidence{MergedPRs: 25, ReviewedPRs: 30},
+			expectLevel: model.TeamCommitter,
+			expectOk:    true,
+		},
+		{
+			name:        "reviewer without enough reviews",
+			team:        "sig-sql",
+			evidence:    PromotionEvidence{MergedPRs: 25, ReviewedPRs: 10},
+			expectLevel: model.TeamReviewer,
+			expectOk:    true,
+		},
+		{
+			name:         "already at the eligible level",
+			team:         "sig-sql",
+			currentLevel: model.TeamReviewer,
+			evidence:     PromotionEvidence{MergedPRs: 25, ReviewedPRs: 10},
+		},
+		{
+			name:         "promotion of the existing member",
+			team:         "sig-sql",
+			currentLevel: model.TeamReviewer,
+			evidence:     PromotionEvidence{MergedPRs: 25, ReviewedPRs: 30},
+			expectLevel:  model.TeamCommitter,
+			expectOk:     true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			level, ok := eligibleLevel(rules.TeamThresholds(tc.team), tc.currentLevel, tc.evidence)
+			if level != tc.expectLevel || ok != tc.expectOk {
+				t.Errorf("Expect level %q %v, but got %q %v.", tc.expectLevel, tc.expectOk, level, ok)
+			}
+		})
+	}
+}
+
+func TestSummarizeDataQuality(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	countryCode := "CN"
+	id2contributor := map[uint]*activeContributor{
+		1: {GitHubID: 1, Login: "alice", Events: 10, Projects: []string{"tidb"}},
+		2: {GitHubID: 2, Login: "bob", Events: 30, Projects: []string{"tidb", "tikv"}},
+		3: {GitHubID: 3, Login: "carol", Events: 20, Projects: []string{"tikv"}},
+		4: {GitHubID: 4, Login: "ti-chi-bot", Events: 100, Projects: []string{"tidb"}},
+		5: {GitHubID: 5, Login: "dave", Events: 20, Projects: []string{"tidb"}},
+	}
+	id2identity := map[uint]qualityIdentity{
+		1: {
+			GitHubID: 1, UUID: "uuid-alice", Name: "Alice", NameSource: model.GitHubProfileSource,
+			CountryCode: &countryCode, ProfileUpdated: now.AddDate(0, 0, -1),
+		},
+		2: {GitHubID: 2, UUID: "uuid-bob", ProfileUpdated: now.AddDate(-1, 0, 0)},
+		4: {GitHubID: 4, UUID: "uuid-bot", IsBot: true, ProfileUpdated: now},
+		5: {GitHubID: 5, UUID: "uuid-dave", ProfileUpdated: now},
+	}
+	uuid2orgSource := map[string]model.ProfileSource{
+		"uuid-alice": model.GitHubProfileSource,
+	}
+
+	report := summarizeDataQuality(now, 90, 180, 2, id2contributor, id2identity, uuid2orgSource)
+	if report.ActiveContributors != 4 || report.MissingIdentities != 1 || report.StaleProfiles != 1 {
+		t.Errorf(
+			"Expect 4 active contributors, 1 missing identity and 1 stale profile, but got %d, %d and %d",
+			report.ActiveContributors, report.MissingIdentities, report.StaleProfiles,
+		)
+	}
+	if report.Organization.Known != 1 || report.Organization.Ratio != 0.25 ||
+		report.Organization.BySource[string(model.GitHubProfileSource)] != 1 {
+		t.Errorf("Expect 1 of 4 organizations known from GitHub, but got %+v", report.Organization)
+	}
+	if report.Country.Known != 1 || report.Country.BySource["unknown"] != 1 {
+		t.Errorf("Expect 1 country known from unknown source, but got %+v", report.Country)
+	}
+	logins := make([]string, 0)
+	for _, contributor := range report.TopUnknownContributors {
+		logins = append(logins, contributor.Login)
+	}
+	expectLogins := []string{"bob", "carol"}
+	if !reflect.DeepEqual(logins, expectLogins) {
+		t.Errorf("Expect top unknown contributors %v, but got %v", expectLogins, logins)
+	}
+}
@@ -0,0 +1,756 @@
+package identifier
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// TeamJSONFormat means the community stores the membership of each team in a JSON file under the team folder.
+	TeamJSONFormat = "team_json"
+	// OwnersFormat means each team folder has a Kubernetes-style OWNERS file, the aliases are resolved by the
+	// OWNERS_ALIASES file in the root directory.
+	OwnersFormat = "owners"
+	// CodeOwnersFormat means the teams are the top-level directories of the patterns in the CODEOWNERS file.
+	CodeOwnersFormat = "codeowners"
+	// MaintainersMarkdownFormat means the members are listed in the tables of the MAINTAINERS.md file.
+	MaintainersMarkdownFormat = "maintainers_md"
+	// SigsYamlFormat means the teams are the SIGs, working groups and committees in the Kubernetes-style
+	// sigs.yaml file.
+	SigsYamlFormat = "sigs_yaml"
+
+	ownersAliasesFileName = "OWNERS_ALIASES"
+	// charterFileName is the charter document in the team folder, it is used if the charter is not given by the
+	// membership file.
+	charterFileName = "CHARTER.md"
+)
+
+var (
+	githubURLLoginRegexp = regexp.MustCompile(`github\.com/([A-Za-z0-9][A-Za-z0-9-]*)`)
+	mentionLoginRegexp   = regexp.MustCompile(`@([A-Za-z0-9][A-Za-z0-9-]*)`)
+	plainLoginRegexp     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+	rawGitHubRepoRegexp  = regexp.MustCompile(`^https://raw\.githubusercontent\.com/([^/]+)/([^/]+)/`)
+	nonSlugCharRegexp    = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// TeamMembership - the team and its members parsed from the community repository.
+type TeamMembership struct {
+	Name        string
+	Description string
+	// Members is keyed by the GitHub login, it is nil when the membership of the team is unknown, for example the
+	// membership file of the team folder is missing, so that the members are kept unchanged.
+	Members      map[string]model.TeamLevel
+	Repositories []string
+	Metadata     model.TeamMetadata
+}
+
+// CommunityFiles - the files of the community repository at a commit.
+type CommunityFiles interface {
+	// ReadFile - read the file, os.ErrNotExist is returned if the file does not exist.
+	ReadFile(filepath string) ([]byte, error)
+	// ListDirs - list the names of the sub directories, os.ErrNotExist is returned if the directory does not exist.
+	ListDirs(dirpath string) ([]string, error)
+}
+
+// MembershipParser - parse the teams and members of a community from one kind of membership files.
+type MembershipParser interface {
+	// Paths - the path prefixes the membership is parsed from, the commits not touching them are skipped.
+	Paths(project CommunityProject) []string
+	// Parse - parse all the teams of the community, the teams are nil if the membership file of the community is
+	// missing or broken, so that the members of all the teams are kept unchanged.
+	Parse(files CommunityFiles, project CommunityProject) ([]TeamMembership, error)
+}
+
+// UnknownMemberships - the teams with unknown membership, it stands for the teams parsed from a missing or broken
+// membership file.
+func UnknownMemberships(teams []TeamMembership) []TeamMembership {
+	unknown := make([]TeamMembership, 0, len(teams))
+	for _, team := range teams {
+		unknown = append(unknown, TeamMembership{Name: team.Name})
+	}
+	return unknown
+}
+
+// readMembershipFile - read the membership file of the community, nil contents are returned if the file is missing.
+func readMembershipFile(files CommunityFiles, project CommunityProject) ([]byte, error) {
+	contents, err := files.ReadFile(project.MembershipFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		logrus.Errorf("The membership file %s is missing, the members are kept unchanged.", project.MembershipFileName)
+		return nil, nil
+	}
+	return contents, err
+}
+
+// brokenMembershipFile - log the error of the broken membership file, the members are kept unchanged like the
+// broken membership files of the team folders.
+func brokenMembershipFile(project CommunityProject, err error) ([]TeamMembership, error) {
+	logrus.WithError(err).Errorf(
+		"Failed to parse the membership file %s, the members are kept unchanged.", project.MembershipFileName,
+	)
+	return nil, nil
+}
+
+// NewMembershipParser - get the membership parser of the format.
+func NewMembershipParser(format string) (MembershipParser, error) {
+	switch format {
+	case TeamJSONFormat:
+		return teamJSONParser{}, nil
+	case OwnersFormat:
+		return ownersParser{}, nil
+	case CodeOwnersFormat:
+		return codeOwnersParser{}, nil
+	case MaintainersMarkdownFormat:
+		return maintainersMarkdownParser{}, nil
+	case SigsYamlFormat:
+		return sigsYamlParser{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported membership format %q", format)
+	}
+}
+
+// GitTreeFiles - the files of a commit in the git repository.
+type GitTreeFiles struct {
+	Tree *object.Tree
+}
+
+func (f GitTreeFiles) ReadFile(filepath string) ([]byte, error) {
+	file, err := f.Tree.File(cleanRepoPath(filepath))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+func (f GitTreeFiles) ListDirs(dirpath string) ([]string, error) {
+	tree := f.Tree
+	if dirpath = cleanRepoPath(dirpath); len(dirpath) != 0 {
+		var err error
+		tree, err = f.Tree.Tree(dirpath)
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, os.ErrNotExist
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	dirs := make([]string, 0)
+	for _, entry := range tree.Entries {
+		if !entry.Mode.IsFile() {
+			dirs = append(dirs, entry.Name)
+		}
+	}
+	return dirs, nil
+}
+
+// cleanRepoPath - clean the path relative to the root of the repository, the root itself is an empty string.
+func cleanRepoPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// repoFileURL - the URL of the file in the community repository, the file is linked to the default branch.
+func repoFileURL(project CommunityProject, filepath string) string {
+	if strings.HasPrefix(filepath, "http://") || strings.HasPrefix(filepath, "https://") {
+		return filepath
+	}
+	repoURL := strings.TrimSuffix(strings.TrimSuffix(project.CommunityRepoURL, "/"), ".git")
+	if !strings.HasPrefix(repoURL, "http://") && !strings.HasPrefix(repoURL, "https://") {
+		return cleanRepoPath(filepath)
+	}
+	return repoURL + "/blob/HEAD/" + cleanRepoPath(filepath)
+}
+
+// teamCharter - get the URL of the charter, the relative path is relative to the team folder, and the charter
+// file in the team folder is used if the charter is not given.
+func teamCharter(files CommunityFiles, project CommunityProject, teamDir, charter string) string {
+	if len(charter) != 0 {
+		if strings.HasPrefix(charter, "http://") || strings.HasPrefix(charter, "https://") {
+			return charter
+		}
+		return repoFileURL(project, path.Join(teamDir, charter))
+	}
+	if _, err := files.ReadFile(path.Join(teamDir, charterFileName)); err == nil {
+		return repoFileURL(project, path.Join(teamDir, charterFileName))
+	}
+	return ""
+}
+
+// teamLevelRank - the higher level wins when a member has several levels in the same team.
+func teamLevelRank(level model.TeamLevel) int {
+	switch level {
+	case model.TeamMaintainer:
+		return 4
+	case model.TeamCommitter:
+		return 3
+	case model.TeamApprover:
+		return 2
+	case model.TeamReviewer:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// addTeamMember - add the member to the team, the higher level is kept if the member is already in the team.
+func addTeamMember(members map[string]model.TeamLevel, login string, level model.TeamLevel) {
+	login = strings.TrimPrefix(strings.TrimSpace(login), "@")
+	if len(login) == 0 {
+		return
+	}
+	if teamLevelRank(level) > teamLevelRank(members[login]) {
+		members[login] = level
+	}
+}
+
+// parseTeamLevel - get the level from the role text, such as "Maintainers" and "Tech Lead / Committer".
+func parseTeamLevel(text string) (model.TeamLevel, bool) {
+	text = strings.ToLower(text)
+	for _, level := range []model.TeamLevel{
+		model.TeamMaintainer, model.TeamCommitter, model.TeamApprover, model.TeamReviewer,
+	} {
+		if strings.Contains(text, string(level)) {
+			return level, true
+		}
+	}
+	return "", false
+}
+
+// isEmeritusText - whether the heading or role text means the members have left.
+func isEmeritusText(text string) bool {
+	text = strings.ToLower(text)
+	for _, word := range []string{"emeritus", "alumni", "former", "retired", "inactive"} {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// slugTeamName - convert the heading or the directory to the team name, such as "SQL Infra" to "sql-infra".
+func slugTeamName(text string) string {
+	return strings.Trim(nonSlugCharRegexp.ReplaceAllString(strings.ToLower(text), "-"), "-")
+}
+
+// teamJSONParser - the membership.json (or team.json) file in each team folder.
+type teamJSONParser struct{}
+
+type teamJSON struct {
+	Name         string   `json:"name,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Maintainers  []string `json:"maintainers"`
+	Committers   []string `json:"committers"`
+	Reviewers    []string `json:"reviewers"`
+	Repositories []string `json:"repositories,omitempty"`
+
+	// The optional metadata of the team.
+	Charter  string              `json:"charter,omitempty"`
+	Channels []model.TeamChannel `json:"channels,omitempty"`
+	Meetings []model.TeamMeeting `json:"meetings,omitempty"`
+	Mentors  []string            `json:"mentors,omitempty"`
+}
+
+func (teamJSONParser) Paths(project CommunityProject) []string {
+	return []string{cleanRepoPath(project.TeamsFolderPath)}
+}
+
+func (teamJSONParser) Parse(files CommunityFiles, project CommunityProject) ([]TeamMembership, error) {
+	teamNames, err := files.ListDirs(project.TeamsFolderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := make([]TeamMembership, 0, len(teamNames))
+	for _, teamName := range teamNames {
+		team := TeamMembership{Name: teamName}
+		teamDir := path.Join(project.TeamsFolderPath, teamName)
+		contents, err := files.ReadFile(path.Join(teamDir, project.MembershipFileName))
+		if err == nil {
+			team, err = parseTeamJSON(teamName, contents)
+			if err != nil {
+				logrus.WithError(err).Errorf("Failed to parse the membership file of %s team.", teamName)
+				team = TeamMembership{Name: teamName}
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			logrus.WithError(err).Errorf("Failed to get the content of membership file.")
+		}
+		team.Metadata.Charter = teamCharter(files, project, teamDir, team.Metadata.Charter)
+		teams = append(teams, team)
+	}
+	return teams, nil
+}
+
+func parseTeamJSON(teamName string, contents []byte) (TeamMembership, error) {
+	var membership teamJSON
+	err := json.Unmarshal(contents, &membership)
+	if err != nil {
+		return TeamMembership{}, err
+	}
+
+	members := make(map[string]model.TeamLevel)
+	for _, reviewer := range membership.Reviewers {
+		addTeamMember(members, reviewer, model.TeamReviewer)
+	}
+	for _, committer := range membership.Committers {
+		addTeamMember(members, committer, model.TeamCommitter)
+	}
+	for _, maintainer := range membership.Maintainers {
+		addTeamMember(members, maintainer, model.TeamMaintainer)
+	}
+
+	return TeamMembership{
+		Name:         teamName,
+		Description:  membership.Description,
+		Members:      members,
+		Repositories: membership.Repositories,
+		Metadata: model.TeamMetadata{
+			Charter:  membership.Charter,
+			Channels: membership.Channels,
+			Meetings: membership.Meetings,
+			Mentors:  membership.Mentors,
+		},
+	}, nil
+}
+
+// ownersParser - the Kubernetes-style OWNERS file in each team folder.
+type ownersParser struct{}
+
+type ownersRoles struct {
+	Approvers []string `yaml:"approvers"`
+	Reviewers []string `yaml:"reviewers"`
+}
+
+type ownersFile struct {
+	ownersRoles `yaml:",inline"`
+	Filters     map[string]ownersRoles `yaml:"filters"`
+}
+
+type ownersAliasesFile struct {
+	Aliases map[string][]string `yaml:"aliases"`
+}
+
+func (ownersParser) Paths(project CommunityProject) []string {
+	return []string{cleanRepoPath(project.TeamsFolderPath), ownersAliasesFileName}
+}
+
+func (ownersParser) Parse(files CommunityFiles, project CommunityProject) ([]TeamMembership, error) {
+	var aliases ownersAliasesFile
+	contents, err := files.ReadFile(ownersAliasesFileName)
+	if err == nil {
+		err = yaml.Unmarshal(contents, &aliases)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", ownersAliasesFileName, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	teamNames, err := files.ListDirs(project.TeamsFolderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := make([]TeamMembership, 0, len(teamNames))
+	for _, teamName := range teamNames {
+		teamDir := path.Join(project.TeamsFolderPath, teamName)
+		contents, err := files.ReadFile(path.Join(teamDir, project.MembershipFileName))
+		if errors.Is(err, os.ErrNotExist) {
+			// The folders without OWNERS file are not teams.
+			continue
+		}
+
+		team := TeamMembership{Name: teamName}
+		team.Metadata.Charter = teamCharter(files, project, teamDir, "")
+		if err == nil {
+			team.Members, err = parseOwners(contents, aliases.Aliases)
+		}
+		if err != nil {
+			logrus.WithError(err).Errorf("Failed to parse the OWNERS file of %s team.", teamName)
+		}
+		teams = append(teams, team)
+	}
+	return teams, nil
+}
+
+// parseOwners - get the members from the OWNERS file, the emeritus approvers are not members.
+func parseOwners(contents []byte, aliases map[string][]string) (map[string]model.TeamLevel, error) {
+	var owners ownersFile
+	err := yaml.Unmarshal(contents, &owners)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []ownersRoles{owners.ownersRoles}
+	for _, filterRoles := range owners.Filters {
+		roles = append(roles, filterRoles)
+	}
+	members := make(map[string]model.TeamLevel)
+	for _, role := range roles {
+		for _, reviewer := range role.Reviewers {
+			for _, login := range resolveOwnersAlias(aliases, reviewer) {
+				addTeamMember(members, login, model.TeamReviewer)
+			}
+		}
+		for _, approver := range role.Approvers {
+			for _, login := range resolveOwnersAlias(aliases, approver) {
+				addTeamMember(members, login, model.TeamApprover)
+			}
+		}
+	}
+	return members, nil
+}
+
+// resolveOwnersAlias - expand the alias to the logins, the name is regarded as a login if it is not an alias.
+func resolveOwnersAlias(aliases map[string][]string, name string) []string {
+	if logins, ok := aliases[name]; ok {
+		return logins
+	}
+	return []string{name}
+}
+
+// codeOwnersParser - the CODEOWNERS file, the owners are the approvers of the top-level directory of the
+// pattern, the patterns of the root directory belong to the team named by the project.
+type codeOwnersParser struct{}
+
+func (codeOwnersParser) Paths(project CommunityProject) []string {
+	return []string{cleanRepoPath(project.MembershipFileName)}
+}
+
+func (codeOwnersParser) Parse(files CommunityFiles, project CommunityProject) ([]TeamMembership, error) {
+	contents, err := readMembershipFile(files, project)
+	if err != nil || contents == nil {
+		return nil, err
+	}
+
+	teamIndexes := make(map[string]int)
+	teams := make([]TeamMembership, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+
+		teamName := project.Name
+		pattern := strings.TrimPrefix(fields[0], "/")
+		if i := strings.Index(pattern, "/"); i > 0 && !strings.ContainsAny(pattern[:i], "*?[") {
+			teamName = pattern[:i]
+		}
+		index, ok := teamIndexes[teamName]
+		if !ok {
+			index = len(teams)
+			teamIndexes[teamName] = index
+			teams = append(teams, TeamMembership{Name: teamName, Members: make(map[string]model.TeamLevel)})
+		}
+
+		for _, owner := range fields[1:] {
+			// The GitHub teams (@org/team) and the email addresses are not GitHub users.
+			if !strings.HasPrefix(owner, "@") || strings.Contains(owner, "/") {
+				continue
+			}
+			addTeamMember(teams[index].Members, owner, model.TeamApprover)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return brokenMembershipFile(project, err)
+	}
+	return teams, nil
+}
+
+// maintainersMarkdownParser - the tables of the MAINTAINERS.md file. The headings without level words are the
+// teams and the headings with level words are the levels of the following tables, the level column of the table
+// takes precedence, and the default level is maintainer. The members under the emeritus headings are skipped.
+type maintainersMarkdownParser struct{}
+
+func (maintainersMarkdownParser) Paths(project CommunityProject) []string {
+	return []string{cleanRepoPath(project.MembershipFileName)}
+}
+
+func (maintainersMarkdownParser) Parse(files CommunityFiles, project CommunityProject) ([]TeamMembership, error) {
+	contents, err := readMembershipFile(files, project)
+	if err != nil || contents == nil {
+		return nil, err
+	}
+
+	teamIndexes := make(map[string]int)
+	teams := make([]TeamMembership, 0)
+	teamName := project.Name
+	headingLevel := model.TeamMaintainer
+	skipping := false
+
+	var header []string
+	loginColumn, levelColumn := -1, -1
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#") {
+			depth := len(line) - len(strings.TrimLeft(line, "#"))
+			heading := strings.TrimSpace(line[depth:])
+			skipping = isEmeritusText(heading)
+			if level, ok := parseTeamLevel(heading); ok {
+				headingLevel = level
+			} else if depth >= 2 && !skipping {
+				teamName = slugTeamName(heading)
+				headingLevel = model.TeamMaintainer
+			}
+			header = nil
+			continue
+		}
+
+		if !strings.HasPrefix(line, "|") {
+			header = nil
+			continue
+		}
+		cells := splitMarkdownRow(line)
+		if header == nil {
+			header = cells
+			loginColumn, levelColumn = markdownColumns(header)
+			continue
+		}
+		if isMarkdownSeparatorRow(cells) || skipping {
+			continue
+		}
+
+		login := ""
+		if loginColumn >= 0 && loginColumn < len(cells) {
+			login = parseMarkdownLogin(cells[loginColumn], true)
+		} else {
+			for _, cell := range cells {
+				if login = parseMarkdownLogin(cell, false); len(login) != 0 {
+					break
+				}
+			}
+		}
+		if len(login) == 0 {
+			continue
+		}
+
+		level := headingLevel
+		if levelColumn >= 0 && levelColumn < len(cells) {
+			if isEmeritusText(cells[levelColumn]) {
+				continue
+			}
+			if columnLevel, ok := parseTeamLevel(cells[levelColumn]); ok {
+				level = columnLevel
+			}
+		}
+
+		index, ok := teamIndexes[teamName]
+		if !ok {
+			index = len(teams)
+			teamIndexes[teamName] = index
+			teams = append(teams, TeamMembership{Name: teamName, Members: make(map[string]model.TeamLevel)})
+		}
+		addTeamMember(teams[index].Members, login, level)
+	}
+	if err = scanner.Err(); err != nil {
+		return brokenMembershipFile(project, err)
+	}
+	return teams, nil
+}
+
+func splitMarkdownRow(line string) []string {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+func isMarkdownSeparatorRow(cells []string) bool {
+	for _, cell := range cells {
+		if len(strings.Trim(cell, ":- ")) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// markdownColumns - find the columns of the GitHub login and the level by the table header, -1 means not found.
+func markdownColumns(header []string) (loginColumn, levelColumn int) {
+	loginColumn, levelColumn = -1, -1
+	for i, cell := range header {
+		cell = strings.ToLower(cell)
+		switch {
+		case loginColumn < 0 && (strings.Contains(cell, "github") || strings.Contains(cell, "login") ||
+			strings.Contains(cell, "handle") || strings.Contains(cell, "username")):
+			loginColumn = i
+		case levelColumn < 0 && (strings.Contains(cell, "role") || strings.Contains(cell, "level") ||
+			strings.Contains(cell, "position") || strings.Contains(cell, "title")):
+			levelColumn = i
+		}
+	}
+	return loginColumn, levelColumn
+}
+
+// parseMarkdownLogin - get the GitHub login from the GitHub profile link or the mention, the plain text is only
+// accepted in the login column.
+func parseMarkdownLogin(cell string, isLoginColumn bool) string {
+	if match := githubURLLoginRegexp.FindStringSubmatch(cell); match != nil {
+		return match[1]
+	}
+	if match := mentionLoginRegexp.FindStringSubmatch(cell); match != nil {
+		return match[1]
+	}
+	if isLoginColumn {
+		text := strings.Trim(cell, "[]`* ")
+		if plainLoginRegexp.MatchString(text) {
+			return text
+		}
+	}
+	return ""
+}
+
+// sigsYamlParser - the Kubernetes-style sigs.yaml file, the chairs and the tech leads are the maintainers of
+// the groups, and the repositories of the subprojects are the repositories of the groups.
+type sigsYamlParser struct{}
+
+type sigsYamlLeader struct {
+	GitHub string `yaml:"github"`
+	Name   string `yaml:"name"`
+}
+
+type sigsYamlGroup struct {
+	Dir              string `yaml:"dir"`
+	Name             string `yaml:"name"`
+	MissionStatement string `yaml:"mission_statement"`
+	CharterLink      string `yaml:"charter_link"`
+	Contact          struct {
+		Slack       string `yaml:"slack"`
+		MailingList string `yaml:"mailing_list"`
+	} `yaml:"contact"`
+	Meetings []struct {
+		Description string `yaml:"description"`
+		Day         string `yaml:"day"`
+		Time        string `yaml:"time"`
+		TZ          string `yaml:"tz"`
+		Frequency   string `yaml:"frequency"`
+		URL         string `yaml:"url"`
+		ArchiveURL  string `yaml:"archive_url"`
+	} `yaml:"meetings"`
+	Leadership struct {
+		Chairs    []sigsYamlLeader `yaml:"chairs"`
+		TechLeads []sigsYamlLeader `yaml:"tech_leads"`
+	} `yaml:"leadership"`
+	Subprojects []struct {
+		Name   string   `yaml:"name"`
+		Owners []string `yaml:"owners"`
+	} `yaml:"subprojects"`
+}
+
+type sigsYamlFile struct {
+	Sigs          []sigsYamlGroup `yaml:"sigs"`
+	WorkingGroups []sigsYamlGroup `yaml:"workinggroups"`
+	UserGroups    []sigsYamlGroup `yaml:"usergroups"`
+	Committees    []sigsYamlGroup `yaml:"committees"`
+}
+
+func (sigsYamlParser) Paths(project CommunityProject) []string {
+	return []string{cleanRepoPath(project.MembershipFileName)}
+}
+
+func (sigsYamlParser) Parse(files CommunityFiles, project CommunityProject) ([]TeamMembership, error) {
+	contents, err := readMembershipFile(files, project)
+	if err != nil || contents == nil {
+		return nil, err
+	}
+
+	var sigs sigsYamlFile
+	err = yaml.Unmarshal(contents, &sigs)
+	if err != nil {
+		return brokenMembershipFile(project, err)
+	}
+
+	groups := make([]sigsYamlGroup, 0)
+	groups = append(groups, sigs.Sigs...)
+	groups = append(groups, sigs.WorkingGroups...)
+	groups = append(groups, sigs.UserGroups...)
+	groups = append(groups, sigs.Committees...)
+
+	teams := make([]TeamMembership, 0, len(groups))
+	for _, group := range groups {
+		teamName := group.Dir
+		if len(teamName) == 0 {
+			teamName = slugTeamName(group.Name)
+		}
+
+		members := make(map[string]model.TeamLevel)
+		for _, leader := range group.Leadership.Chairs {
+			addTeamMember(members, leader.GitHub, model.TeamMaintainer)
+		}
+		for _, leader := range group.Leadership.TechLeads {
+			addTeamMember(members, leader.GitHub, model.TeamMaintainer)
+		}
+
+		repositories := make([]string, 0)
+		repositorySet := make(map[string]struct{})
+		for _, subproject := range group.Subprojects {
+			for _, owner := range subproject.Owners {
+				match := rawGitHubRepoRegexp.FindStringSubmatch(owner)
+				if match == nil {
+					continue
+				}
+				repository := match[1] + "/" + match[2]
+				if _, ok := repositorySet[repository]; !ok {
+					repositorySet[repository] = struct{}{}
+					repositories = append(repositories, repository)
+				}
+			}
+		}
+
+		teams = append(teams, TeamMembership{
+			Name:         teamName,
+			Description:  strings.TrimSpace(group.MissionStatement),
+			Members:      members,
+			Repositories: repositories,
+			Metadata:     sigsYamlMetadata(files, project, group),
+		})
+	}
+	return teams, nil
+}
+
+// sigsYamlMetadata - get the charter, the contacts and the meetings of the group, the charter link is relative to
+// the directory of the group.
+func sigsYamlMetadata(files CommunityFiles, project CommunityProject, group sigsYamlGroup) model.TeamMetadata {
+	var metadata model.TeamMetadata
+	if len(group.Dir) != 0 || len(group.CharterLink) != 0 {
+		metadata.Charter = teamCharter(files, project, group.Dir, group.CharterLink)
+	}
+	if len(group.Contact.Slack) != 0 {
+		metadata.Channels = append(metadata.Channels, model.TeamChannel{Platform: "slack", Name: group.Contact.Slack})
+	}
+	if len(group.Contact.MailingList) != 0 {
+		metadata.Channels = append(metadata.Channels, model.TeamChannel{
+			Platform: "mailing_list", Name: group.Contact.MailingList, URL: group.Contact.MailingList,
+		})
+	}
+	for _, meeting := range group.Meetings {
+		schedule := strings.TrimSpace(fmt.Sprintf("%s %s %s %s", meeting.Frequency, meeting.Day, meeting.Time, meeting.TZ))
+		metadata.Meetings = append(metadata.Meetings, model.TeamMeeting{
+			Description: meeting.Description,
+			Schedule:    strings.Join(strings.Fields(schedule), " "),
+			URL:         meeting.URL,
+			ArchiveURL:  meeting.ArchiveURL,
+		})
+	}
+	return metadata
+}
@@ -0,0 +1,198 @@
+package identifier
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+)
+
+// MemberChangeType - the kind of the change of a team member.
+type MemberChangeType string
+
+// The member changes are classified by the levels before and after.
+const (
+	MemberJoined   MemberChangeType = "join"
+	MemberPromoted MemberChangeType = "promotion"
+	MemberDemoted  MemberChangeType = "demotion"
+	MemberRemoved  MemberChangeType = "removal"
+)
+
+// MemberChange - the change of a member in a team, LevelFrom is empty for the join and LevelTo is empty for the
+// removal.
+type MemberChange struct {
+	Team      string           `json:"team"`
+	Login     string           `json:"login"`
+	Type      MemberChangeType `json:"type"`
+	LevelFrom model.TeamLevel  `json:"level_from,omitempty"`
+	LevelTo   model.TeamLevel  `json:"level_to,omitempty"`
+}
+
+// RepositoryChange - a repository added to or removed from a team.
+type RepositoryChange struct {
+	Team       string `json:"team"`
+	Repository string `json:"repository"`
+	Added      bool   `json:"added"`
+}
+
+// MembershipDiff - the changes of the teams between two versions of the community repository.
+type MembershipDiff struct {
+	TeamsCreated      []string           `json:"teams_created"`
+	TeamsDeleted      []string           `json:"teams_deleted"`
+	MemberChanges     []MemberChange     `json:"member_changes"`
+	RepositoryChanges []RepositoryChange `json:"repository_changes"`
+}
+
+// DiffTeamMemberships - compare the teams before and after, the logins are matched case-insensitively. The members
+// of the deleted teams are removed, and the member changes of the teams whose membership is unknown are skipped.
+func DiffTeamMemberships(before, after []TeamMembership) MembershipDiff {
+	diff := MembershipDiff{
+		TeamsCreated:      make([]string, 0),
+		TeamsDeleted:      make([]string, 0),
+		MemberChanges:     make([]MemberChange, 0),
+		RepositoryChanges: make([]RepositoryChange, 0),
+	}
+
+	beforeTeams := make(map[string]TeamMembership)
+	for _, team := range before {
+		beforeTeams[team.Name] = team
+	}
+	afterTeams := make(map[string]TeamMembership)
+	for _, team := range after {
+		afterTeams[team.Name] = team
+	}
+
+	for _, team := range after {
+		beforeTeam, ok := beforeTeams[team.Name]
+		if !ok {
+			diff.TeamsCreated = append(diff.TeamsCreated, team.Name)
+		}
+		if team.Members == nil || (ok && beforeTeam.Members == nil) {
+			continue
+		}
+		diff.MemberChanges = append(diff.MemberChanges, diffTeamMembers(team.Name, beforeTeam.Members, team.Members)...)
+		diff.RepositoryChanges = append(
+			diff.RepositoryChanges, diffTeamRepositories(team.Name, beforeTeam.Repositories, team.Repositories)...,
+		)
+	}
+	for _, team := range before {
+		if _, ok := afterTeams[team.Name]; ok {
+			continue
+		}
+		diff.TeamsDeleted = append(diff.TeamsDeleted, team.Name)
+		diff.MemberChanges = append(diff.MemberChanges, diffTeamMembers(team.Name, team.Members, nil)...)
+	}
+
+	sort.Strings(diff.TeamsCreated)
+	sort.Strings(diff.TeamsDeleted)
+	sort.SliceStable(diff.MemberChanges, func(i, j int) bool {
+		a, b := diff.MemberChanges[i], diff.MemberChanges[j]
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		return strings.ToLower(a.Login) < strings.ToLower(b.Login)
+	})
+	sort.SliceStable(diff.RepositoryChanges, func(i, j int) bool {
+		a, b := diff.RepositoryChanges[i], diff.RepositoryChanges[j]
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		return a.Repository < b.Repository
+	})
+	return diff
+}
+
+func diffTeamMembers(teamName string, before, after map[string]model.TeamLevel) []MemberChange {
+	changes := make([]MemberChange, 0)
+
+	beforeLevels := make(map[string]model.TeamLevel)
+	for login, level := range before {
+		beforeLevels[strings.ToLower(login)] = level
+	}
+	afterLogins := make(map[string]struct{})
+	for login, levelTo := range after {
+		afterLogins[strings.ToLower(login)] = struct{}{}
+		levelFrom, ok := beforeLevels[strings.ToLower(login)]
+		change := MemberChange{Team: teamName, Login: login, LevelFrom: levelFrom, LevelTo: levelTo}
+		switch {
+		case !ok:
+			change.Type = MemberJoined
+		case teamLevelRank(levelTo) > teamLevelRank(levelFrom):
+			change.Type = MemberPromoted
+		case teamLevelRank(levelTo) < teamLevelRank(levelFrom):
+			change.Type = MemberDemoted
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	for login, levelFrom := range before {
+		if _, ok := afterLogins[strings.ToLower(login)]; !ok {
+			changes = append(changes, MemberChange{Team: teamName, Login: login, Type: MemberRemoved, LevelFrom: levelFrom})
+		}
+	}
+	return changes
+}
+
+func diffTeamRepositories(teamName string, before, after []string) []RepositoryChange {
+	changes := make([]RepositoryChange, 0)
+	beforeSet := make(map[string]struct{})
+	for _, repository := range before {
+		beforeSet[repository] = struct{}{}
+	}
+	afterSet := make(map[string]struct{})
+	for _, repository := range after {
+		afterSet[repository] = struct{}{}
+		if _, ok := beforeSet[repository]; !ok {
+			changes = append(changes, RepositoryChange{Team: teamName, Repository: repository, Added: true})
+		}
+	}
+	for _, repository := range before {
+		if _, ok := afterSet[repository]; !ok {
+			changes = append(changes, RepositoryChange{Team: teamName, Repository: repository})
+		}
+	}
+	return changes
+}
+
+// IsEmpty - whether nothing is changed.
+func (d MembershipDiff) IsEmpty() bool {
+	return len(d.TeamsCreated) == 0 && len(d.TeamsDeleted) == 0 &&
+		len(d.MemberChanges) == 0 && len(d.RepositoryChanges) == 0
+}
+
+// Text - the human-readable description of the changes, one change per line.
+func (d MembershipDiff) Text() string {
+	if d.IsEmpty() {
+		return "No changes.\n"
+	}
+
+	var b strings.Builder
+	for _, team := range d.TeamsCreated {
+		fmt.Fprintf(&b, "[%s] team created\n", team)
+	}
+	for _, team := range d.TeamsDeleted {
+		fmt.Fprintf(&b, "[%s] team deleted\n", team)
+	}
+	for _, change := range d.MemberChanges {
+		switch change.Type {
+		case MemberJoined:
+			fmt.Fprintf(&b, "[%s] %s joins as %s\n", change.Team, change.Login, change.LevelTo)
+		case MemberPromoted:
+			fmt.Fprintf(&b, "[%s] %s is promoted from %s to %s\n", change.Team, change.Login, change.LevelFrom, change.LevelTo)
+		case MemberDemoted:
+			fmt.Fprintf(&b, "[%s] %s is demoted from %s to %s\n", change.Team, change.Login, change.LevelFrom, change.LevelTo)
+		case MemberRemoved:
+			fmt.Fprintf(&b, "[%s] %s is removed (was %s)\n", change.Team, change.Login, change.LevelFrom)
+		}
+	}
+	for _, change := range d.RepositoryChanges {
+		if change.Added {
+			fmt.Fprintf(&b, "[%s] repository %s added\n", change.Team, change.Repository)
+		} else {
+			fmt.Fprintf(&b, "[%s] repository %s removed\n", change.Team, change.Repository)
+		}
+	}
+	return b.String()
+}
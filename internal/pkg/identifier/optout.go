@@ -0,0 +1,222 @@
+package identifier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/lib"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OptOutRequest - the request of removing the personal information, the identity is found by UUID or GitHub login.
+type OptOutRequest struct {
+	UUID        string `json:"uuid"`
+	GitHubLogin string `json:"github_login"`
+	Operator    string `json:"operator"`
+	Reason      string `json:"reason"`
+}
+
+// OptOutResult - the result of the opt-out, the logins are enqueued for hide_data.
+type OptOutResult struct {
+	UUID           string   `json:"uuid"`
+	Logins         []string `json:"logins"`
+	ScrubbedNames  int64    `json:"scrubbed_names"`
+	ScrubbedEmails int64    `json:"scrubbed_emails"`
+}
+
+// findIdentityUUID - find the UUID of the unique identity by UUID or any of the GitHub logins.
+func findIdentityUUID(db *gorm.DB, uuid, githubLogin string) (string, error) {
+	if len(uuid) != 0 {
+		var uniqueIdentity model.UniqueIdentity
+		err := db.Where("uuid = ?", uuid).First(&uniqueIdentity).Error
+		return uniqueIdentity.UUID, err
+	}
+
+	if len(githubLogin) == 0 {
+		return "", errors.New("uuid or github login is required")
+	}
+
+	var uuids []string
+	err := db.Raw(`
+select distinct gu.uuid
+from
+    github_users gu
+    left join github_user_logins gul on gul.github_user_id = gu.id
+where (gu.login = ? or gul.login = ?) and gu.uuid != ''
+`, githubLogin, githubLogin).Scan(&uuids).Error
+	if err != nil {
+		return "", err
+	}
+	if len(uuids) == 0 {
+		return "", fmt.Errorf("no identity found for github login %s", githubLogin)
+	}
+	if len(uuids) > 1 {
+		return "", fmt.Errorf("github login %s belongs to %d identities, use uuid instead", githubLogin, len(uuids))
+	}
+	return uuids[0], nil
+}
+
+// OptOutIdentity - scrub the PII of the unique identity, enqueue the GitHub logins for hide_data, and record
+// the audit log, the later imports will not import the PII of the identity again.
+func OptOutIdentity(log *logrus.Entry, db *gorm.DB, req OptOutRequest) (*OptOutResult, error) {
+	u, err := findIdentityUUID(db, strings.TrimSpace(req.UUID), strings.TrimSpace(req.GitHubLogin))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OptOutResult{UUID: u, Logins: make([]string, 0)}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&model.UniqueIdentity{}).Where("uuid = ?", u).Updates(map[string]interface{}{
+			"name":            "",
+			"name_source":     model.ManualSource,
+			"email":           "",
+			"email_source":    model.ManualSource,
+			"location":        "",
+			"location_source": model.ManualSource,
+			"country_code":    nil,
+			"country_source":  model.ManualSource,
+			"gender":          "",
+			"gender_acc":      0,
+			"gender_source":   model.ManualSource,
+			"opted_out":       true,
+			"opted_out_at":    now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&model.Account{}).Where("uuid = ?", u).Updates(map[string]interface{}{
+			"name":     nil,
+			"email":    "",
+			"location": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+		// Notice: The username of the email account is the address.
+		err = tx.Model(&model.Account{}).Where("uuid = ? and provider = ?", u, model.EmailProvider).
+			Update("username", "").Error
+		if err != nil {
+			return err
+		}
+
+		// The email domains of the observations are derived from the emails, the organizations are kept.
+		err = tx.Model(&model.ProfileObservation{}).Where("uuid = ?", u).Update("email_domain", "").Error
+		if err != nil {
+			return err
+		}
+
+		var githubUsers []model.GitHubUser
+		err = tx.Preload("Logins").Where("uuid = ?", u).Find(&githubUsers).Error
+		if err != nil {
+			return err
+		}
+
+		githubIDs := make([]uint, 0, len(githubUsers))
+		loginSet := make(lib.StringSet)
+		for _, githubUser := range githubUsers {
+			githubIDs = append(githubIDs, githubUser.ID)
+			loginSet[githubUser.Login] = struct{}{}
+			for _, login := range githubUser.Logins {
+				loginSet[login.Login] = struct{}{}
+			}
+		}
+
+		if len(githubIDs) != 0 {
+			err = tx.Model(&model.GitHubUser{}).Where("id in ?", githubIDs).Updates(map[string]interface{}{
+				"name":     nil,
+				"email":    "",
+				"location": nil,
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			// Notice: The rows are deleted permanently instead of soft deleted.
+			res := tx.Unscoped().Where("github_user_id in ?", githubIDs).Delete(&model.GitHubUserName{})
+			if res.Error != nil {
+				return res.Error
+			}
+			result.ScrubbedNames = res.RowsAffected
+
+			res = tx.Unscoped().Where("github_user_id in ?", githubIDs).Delete(&model.GitHubUserEmail{})
+			if res.Error != nil {
+				return res.Error
+			}
+			result.ScrubbedEmails = res.RowsAffected
+		}
+
+		for login := range loginSet {
+			if len(login) == 0 {
+				continue
+			}
+			result.Logins = append(result.Logins, login)
+			err = tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{
+					{Name: "login"},
+				},
+				DoNothing: true,
+			}).Create(&model.HideDataRequest{Login: login, UUID: u}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&model.IdentityAuditLog{
+			UUID:     u,
+			Action:   model.AuditOptOut,
+			Operator: req.Operator,
+			Reason:   req.Reason,
+			Detail: fmt.Sprintf(
+				"scrubbed %d names and %d emails of %d github users, enqueued %d logins for hide_data",
+				result.ScrubbedNames, result.ScrubbedEmails, len(githubIDs), len(result.Logins),
+			),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Identity %s opted out, %d logins enqueued for hide_data.", u, len(result.Logins))
+	return result, nil
+}
+
+// ExportHideDataRequests - add the GitHub logins waiting to be anonymized to the hide_data config file, then the
+// hide_data tool can anonymize them in the project databases.
+func ExportHideDataRequests(log *logrus.Entry, db *gorm.DB, configFile string) error {
+	var requests []model.HideDataRequest
+	err := db.Where("exported_at is null").Find(&requests).Error
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		log.Infof("No hide data request to export.")
+		return nil
+	}
+
+	logins := make([]string, 0, len(requests))
+	ids := make([]uint, 0, len(requests))
+	for _, request := range requests {
+		logins = append(logins, request.Login)
+		ids = append(ids, request.ID)
+	}
+
+	skipped, err := lib.AddHidden(configFile, logins)
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(&model.HideDataRequest{}).Where("id in ?", ids).Update("exported_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Exported %d hide data requests to %s, %d already exist.", len(logins), configFile, len(skipped))
+	return nil
+}
@@ -0,0 +1,71 @@
+package identifier
+
+import (
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestOptOutIdentity(t *testing.T) {
+	db, fake := openFakeDB(t, []fakeResult{
+		{
+			pattern: `FROM "unique_identities"`,
+			columns: []string{"uuid", "name"},
+			rows:    [][]driver.Value{{"uuid-1", "Alice"}},
+		},
+		{
+			pattern: `FROM "github_user_logins"`,
+			columns: []string{"id", "github_user_id", "login"},
+			rows:    [][]driver.Value{{int64(1), int64(10), "alice-old"}},
+		},
+		{
+			pattern: `FROM "github_users"`,
+			columns: []string{"id", "login", "uuid"},
+			rows:    [][]driver.Value{{int64(10), "alice", "uuid-1"}},
+		},
+	})
+
+	log := logrus.NewEntry(logrus.New())
+	log.Logger.SetOutput(io.Discard)
+	result, err := OptOutIdentity(log, db, OptOutRequest{UUID: "uuid-1", Operator: "admin", Reason: "GDPR"})
+	if err != nil {
+		t.Fatalf("Failed to opt out the identity: %v", err)
+	}
+	if result.UUID != "uuid-1" || len(result.Logins) != 2 {
+		t.Errorf("Expect 2 logins of uuid-1 enqueued, but got %+v", result)
+	}
+
+	var testcases = []struct {
+		name     string
+		patterns []string
+	}{
+		{name: "scrub the profile", patterns: []string{`UPDATE "unique_identities"`, `"name"=`, `"opted_out"=`}},
+		{name: "scrub the country", patterns: []string{`UPDATE "unique_identities"`, `"country_code"=`}},
+		{name: "scrub the accounts", patterns: []string{`UPDATE "accounts"`, `"email"=`}},
+		{name: "scrub the email domains", patterns: []string{`UPDATE "profile_observations"`, `"email_domain"=`}},
+		{name: "scrub the github users", patterns: []string{`UPDATE "github_users"`, `"name"=`}},
+		{name: "delete the names", patterns: []string{`DELETE FROM "github_user_names"`}},
+		{name: "delete the emails", patterns: []string{`DELETE FROM "github_user_emails"`}},
+		{name: "enqueue the logins", patterns: []string{`INSERT INTO "hide_data_requests"`}},
+		{name: "record the audit log", patterns: []string{`INSERT INTO "identity_audit_logs"`}},
+	}
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			if len(fake.executed(tc.patterns...)) == 0 {
+				t.Errorf("Expect the statement with %v to be executed", tc.patterns)
+			}
+		})
+	}
+
+	// The email domains are only scrubbed for the opted out identity.
+	for _, statement := range fake.executed(`UPDATE "profile_observations"`) {
+		lastArg := statement.args[len(statement.args)-1]
+		if !strings.Contains(statement.query, "WHERE uuid = ") || lastArg != "uuid-1" {
+			t.Errorf("Expect the observations of uuid-1 to be scrubbed, but got %s %v", statement.query, statement.args)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package identifier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// legalSuffixes are the suffixes of company names which are meaningless when matching organizations.
+var legalSuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "ltd": true, "limited": true, "llc": true, "llp": true, "lp": true,
+	"corp": true, "corporation": true, "co": true, "company": true, "plc": true, "pty": true, "pte": true,
+	"gmbh": true, "ag": true, "kg": true, "se": true, "sa": true, "sas": true, "sarl": true, "srl": true,
+	"spa": true, "bv": true, "nv": true, "oy": true, "ab": true, "as": true, "kk": true, "group": true,
+}
+
+// cjkLegalSuffixes are the legal suffixes of Chinese and Japanese company names, the longer ones come first.
+var cjkLegalSuffixes = []string{
+	"股份有限公司", "有限责任公司", "有限公司", "株式会社", "集团", "公司",
+}
+
+// minSuggestSimilarity is the minimum similarity for an existing org to be suggested for a pending name.
+const minSuggestSimilarity = 0.6
+
+// maxShortNameLen is the max length of the names which may reach minSuggestSimilarity without sharing any bigram
+// with the other name, by the q-gram lemma two names sharing no bigram have the distance of at least
+// (maxLen - 1) / 2, which is larger than (1 - minSuggestSimilarity) * maxLen when maxLen is larger than 5.
+const maxShortNameLen = 5
+
+// OrgMatcher maps company names to organizations by patterns, normalized names and similarity, the names that
+// can not be matched are put into the pending-review queue instead of creating new organizations.
+type OrgMatcher struct {
+	db          *gorm.DB
+	pattern2org map[*regexp.Regexp]model.Organization
+	name2org    map[string]model.Organization
+	// bigram2names and shortNames index the names of name2org, so that only the names which may be similar to
+	// the candidate are compared.
+	bigram2names        map[string]map[string]struct{}
+	shortNames          map[string]struct{}
+	similarityThreshold float64
+	// report records the queued names, it may be nil.
+	report *RunReport
+}
+
+// newOrgMatcher - build the index of normalized names from the valid organizations and approved pending names.
+func newOrgMatcher(
+	db *gorm.DB, pattern2org map[*regexp.Regexp]model.Organization, similarityThreshold float64,
+) *OrgMatcher {
+	m := &OrgMatcher{
+		db:                  db,
+		pattern2org:         pattern2org,
+		name2org:            make(map[string]model.Organization),
+		bigram2names:        make(map[string]map[string]struct{}),
+		shortNames:          make(map[string]struct{}),
+		similarityThreshold: similarityThreshold,
+	}
+
+	var organizations []model.Organization
+	db.Where("invalid = ?", false).Find(&organizations)
+	id2org := make(map[uint]model.Organization)
+	for _, org := range organizations {
+		id2org[org.ID] = org
+		m.addName(org.Name, org)
+		m.addName(org.Fullname, org)
+	}
+
+	var approvedOrgs []model.PendingOrganization
+	db.Where("status = ? and org_id is not null", model.PendingOrgApproved).Find(&approvedOrgs)
+	for _, approved := range approvedOrgs {
+		if org, ok := id2org[*approved.OrgID]; ok {
+			m.indexName(approved.NormalizedName, org)
+		}
+	}
+
+	return m
+}
+
+// addName - add the normalized name and its CJK/Latin aliases to the index.
+func (m *OrgMatcher) addName(name string, org model.Organization) {
+	for _, candidate := range orgNameCandidates(name) {
+		if _, ok := m.name2org[candidate]; !ok {
+			m.indexName(candidate, org)
+		}
+	}
+}
+
+// indexName - map the normalized name to the organization, and index it by its bigrams.
+func (m *OrgMatcher) indexName(name string, org model.Organization) {
+	m.name2org[name] = org
+
+	runes := []rune(name)
+	if len(runes) <= maxShortNameLen {
+		m.shortNames[name] = struct{}{}
+	}
+	for _, bigram := range nameBigrams(runes) {
+		names, ok := m.bigram2names[bigram]
+		if !ok {
+			names = make(map[string]struct{})
+			m.bigram2names[bigram] = names
+		}
+		names[name] = struct{}{}
+	}
+}
+
+// similarNames - get the indexed names which may reach minSuggestSimilarity with the candidate.
+func (m *OrgMatcher) similarNames(candidate string) map[string]struct{} {
+	runes := []rune(candidate)
+	names := make(map[string]struct{})
+	for _, bigram := range nameBigrams(runes) {
+		for name := range m.bigram2names[bigram] {
+			names[name] = struct{}{}
+		}
+	}
+	if len(runes) <= maxShortNameLen {
+		for name := range m.shortNames {
+			names[name] = struct{}{}
+		}
+	}
+	return names
+}
+
+func nameBigrams(runes []rune) []string {
+	bigrams := make([]string, 0, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		bigrams = append(bigrams, string(runes[i:i+2]))
+	}
+	return bigrams
+}
+
+// matchOrg - find the organization of the name without creating any organization, the similarity is 1 if the
+// organization is matched by pattern or normalized name.
+func (m *OrgMatcher) matchOrg(orgName string) (*model.Organization, float64) {
+	if org := matchOrgByPattern(m.pattern2org, orgName); org != nil {
+		return org, 1
+	}
+
+	candidates := orgNameCandidates(orgName)
+	for _, candidate := range candidates {
+		if org, ok := m.name2org[candidate]; ok {
+			return &org, 1
+		}
+	}
+
+	var bestOrg *model.Organization
+	bestName := ""
+	bestSimilarity := 0.0
+	for _, candidate := range candidates {
+		for name := range m.similarNames(candidate) {
+			similarity := nameSimilarity(candidate, name)
+			// The names with the same similarity are compared by name, so that the result is stable.
+			if similarity > bestSimilarity || (similarity == bestSimilarity && bestOrg != nil && name < bestName) {
+				o := m.name2org[name]
+				bestOrg = &o
+				bestName = name
+				bestSimilarity = similarity
+			}
+		}
+	}
+
+	return bestOrg, bestSimilarity
+}
+
+// mapNameToOrg - map the company name to organization, if there is no matched organization, the name is put into
+// the pending-review queue, the organizations are only created by the reviewers.
+func (m *OrgMatcher) mapNameToOrg(orgName string) *model.Organization {
+	orgName = strings.TrimSpace(orgName)
+
+	// orgName requires at least two characters.
+	if len(orgName) < 2 {
+		return nil
+	}
+
+	org, similarity := m.matchOrg(orgName)
+	if org != nil && similarity >= m.similarityThreshold {
+		return org
+	}
+
+	normalizedName := normalizeOrgName(orgName)
+	if len(normalizedName) < 2 {
+		return nil
+	}
+
+	m.enqueuePendingOrg(orgName, normalizedName, org, similarity)
+	return nil
+}
+
+// enqueuePendingOrg - put the unmatched name into the pending-review queue, the most similar organization is
+// recorded as the suggestion for the reviewers, the occurrences are only counted for the undecided names.
+func (m *OrgMatcher) enqueuePendingOrg(
+	orgName, normalizedName string, suggestedOrg *model.Organization, similarity float64,
+) {
+	pendingOrg := model.PendingOrganization{
+		Name:           orgName,
+		NormalizedName: normalizedName,
+		Occurrences:    1,
+		Status:         model.PendingOrgPending,
+	}
+	if suggestedOrg != nil && similarity >= minSuggestSimilarity {
+		pendingOrg.SuggestedOrgID = &suggestedOrg.ID
+		pendingOrg.Similarity = similarity
+	}
+
+	m.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "normalized_name"},
+		},
+		// Notice: The approved or rejected names are left alone.
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"occurrences": gorm.Expr(
+				"case when pending_organizations.status = ? "+
+					"then pending_organizations.occurrences + 1 else pending_organizations.occurrences end",
+				model.PendingOrgPending,
+			),
+		}),
+	}).Create(&pendingOrg)
+	m.report.orgQueued()
+}
+
+// ListPendingOrgs - output the pending company names ordered by the occurrences, with the suggested organizations.
+func ListPendingOrgs(db *gorm.DB, out io.Writer) error {
+	var pendingOrgs []model.PendingOrganization
+	err := db.Where("status = ?", model.PendingOrgPending).
+		Order("occurrences desc, id").Find(&pendingOrgs).Error
+	if err != nil {
+		return err
+	}
+
+	var organizations []model.Organization
+	db.Find(&organizations)
+	id2name := make(map[uint]string)
+	for _, org := range organizations {
+		id2name[org.ID] = org.Name
+	}
+
+	_, _ = fmt.Fprintf(out, "Found %d pending organizations.\n", len(pendingOrgs))
+	for _, pendingOrg := range pendingOrgs {
+		suggestion := "-"
+		if pendingOrg.SuggestedOrgID != nil {
+			suggestion = fmt.Sprintf("%s (%.2f)", id2name[*pendingOrg.SuggestedOrgID], pendingOrg.Similarity)
+		}
+		_, _ = fmt.Fprintf(
+			out, "%6d  %6d  %s => %s\n", pendingOrg.ID, pendingOrg.Occurrences, pendingOrg.Name, suggestion,
+		)
+	}
+
+	return nil
+}
+
+// ApprovePendingOrg - approve the pending company name, it is mapped to the organization named orgName, or the
+// suggested organization if orgName is empty, a new organization is created if there is no such organization.
+func ApprovePendingOrg(db *gorm.DB, id uint, orgName string) (*model.Organization, error) {
+	var pendingOrg model.PendingOrganization
+	err := db.First(&pendingOrg, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var org model.Organization
+	switch {
+	case len(orgName) != 0:
+		org, err = findOrCreateOrgByName(db, orgName)
+	case pendingOrg.SuggestedOrgID != nil:
+		err = db.First(&org, *pendingOrg.SuggestedOrgID).Error
+	default:
+		return nil, errors.New("no suggested organization, the organization name is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if org.Invalid {
+		return nil, fmt.Errorf("organization %s is invalid", org.Name)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&pendingOrg).Updates(map[string]interface{}{
+			"status": model.PendingOrgApproved,
+			"org_id": org.ID,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// The pattern makes the name mapped to the organization by the other tools, such as the conflicts report.
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "org_id"},
+				{Name: "pattern"},
+			},
+			DoNothing: true,
+		}).Create(&model.OrgPattern{
+			OrgID:   org.ID,
+			Pattern: fmt.Sprintf("^\\s*%s\\s*$", escapeRegexStr(pendingOrg.Name)),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// RejectPendingOrg - reject the pending company name, it will not be mapped to any organization.
+func RejectPendingOrg(db *gorm.DB, id uint) error {
+	result := db.Model(&model.PendingOrganization{}).Where("id = ?", id).
+		Update("status", model.PendingOrgRejected)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("pending organization %d not found", id)
+	}
+	return nil
+}
+
+// normalizeOrgName - strip the `@`, legal suffixes, punctuation and case of the company name.
+func normalizeOrgName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimLeft(name, "@")
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return ' '
+		}
+		return r
+	}, name)
+
+	tokens := strings.Fields(name)
+	for i, token := range tokens {
+		for _, suffix := range cjkLegalSuffixes {
+			if strings.HasSuffix(token, suffix) && token != suffix {
+				tokens[i] = strings.TrimSuffix(token, suffix)
+				break
+			}
+		}
+	}
+
+	// Notice: The first token is always kept, so that the name only consists of suffixes will not be empty.
+	for len(tokens) > 1 && legalSuffixes[tokens[len(tokens)-1]] {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+// orgNameCandidates - get the normalized name and its Latin and CJK parts, such as "PingCAP 平凯星辰" has the
+// candidates "pingcap 平凯星辰", "pingcap" and "平凯星辰".
+func orgNameCandidates(name string) []string {
+	normalized := normalizeOrgName(name)
+	if len(normalized) == 0 {
+		return nil
+	}
+
+	latinTokens := make([]string, 0)
+	cjkTokens := make([]string, 0)
+	for _, token := range strings.Fields(normalized) {
+		if containsCJK(token) {
+			cjkTokens = append(cjkTokens, token)
+		} else {
+			latinTokens = append(latinTokens, token)
+		}
+	}
+
+	candidates := []string{normalized}
+	if len(latinTokens) > 0 && len(cjkTokens) > 0 {
+		if latin := strings.Join(latinTokens, " "); len(latin) >= 2 {
+			candidates = append(candidates, latin)
+		}
+		candidates = append(candidates, strings.Join(cjkTokens, ""))
+	}
+
+	return candidates
+}
+
+func containsCJK(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameSimilarity - the similarity of two names based on the Levenshtein distance, range from 0 to 1.
+func nameSimilarity(a, b string) float64 {
+	ra := []rune(a)
+	rb := []rune(b)
+	maxLen := len(ra)
+	if len(rb) > maxLen {
+		maxLen = len(rb)
+	}
+	if maxLen == 0 {
+		return 1
+	}
+
+	// Skip the names whose length differs too much, they can never reach the threshold.
+	diff := len(ra) - len(rb)
+	if diff < 0 {
+		diff = -diff
+	}
+	if float64(diff)/float64(maxLen) > 1-minSuggestSimilarity {
+		return 0
+	}
+
+	return 1 - float64(levenshteinDistance(ra, rb))/float64(maxLen)
+}
+
+func levenshteinDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
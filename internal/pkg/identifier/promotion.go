@@ -0,0 +1,282 @@
+package identifier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gopkg.in/yaml.v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPromotionPeriodDays is used when the rules file does not set period_days.
+	DefaultPromotionPeriodDays = 365
+	// promotionEvidencePRs is the max number of the merged PRs listed in the evidence of a candidate.
+	promotionEvidencePRs = 5
+)
+
+// PromotionRules is the data structure of promotion_rules.yaml file, the thresholds of the team override the
+// defaults level by level.
+type PromotionRules struct {
+	// PeriodDays is the number of days of the activity counted towards the thresholds.
+	PeriodDays int                                            `yaml:"period_days"`
+	Defaults   map[model.TeamLevel]LadderThreshold            `yaml:"defaults"`
+	Teams      map[string]map[model.TeamLevel]LadderThreshold `yaml:"teams"`
+}
+
+// LadderThreshold - the activity required on the team repositories to become a member of the level.
+type LadderThreshold struct {
+	MergedPRs   int `yaml:"merged_prs" json:"merged_prs"`
+	ReviewedPRs int `yaml:"reviewed_prs" json:"reviewed_prs"`
+}
+
+// PromotionReport - the contributors of the team repositories eligible for a higher level.
+type PromotionReport struct {
+	Team       string                              `json:"team"`
+	Project    string                              `json:"project"`
+	From       time.Time                           `json:"from"`
+	To         time.Time                           `json:"to"`
+	Thresholds map[model.TeamLevel]LadderThreshold `json:"thresholds"`
+	Candidates []PromotionCandidate                `json:"candidates"`
+}
+
+// PromotionCandidate - a contributor eligible for the level with the evidence.
+type PromotionCandidate struct {
+	GitHubID      uint              `json:"github_id"`
+	GitHubLogin   string            `json:"github_login"`
+	CurrentLevel  model.TeamLevel   `json:"current_level"`
+	EligibleLevel model.TeamLevel   `json:"eligible_level"`
+	Evidence      PromotionEvidence `json:"evidence"`
+}
+
+// PromotionEvidence - the activity of the contributor on the team repositories in the period.
+type PromotionEvidence struct {
+	MergedPRs   int `json:"merged_prs"`
+	ReviewedPRs int `json:"reviewed_prs"`
+	// RecentMergedPRs are the URLs of the latest merged PRs.
+	RecentMergedPRs []string `json:"recent_merged_prs"`
+}
+
+// LoadPromotionRules - read the promotion rules from the YAML file and validate them.
+func LoadPromotionRules(filepath string) (PromotionRules, error) {
+	var rules PromotionRules
+
+	bytesYaml, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return rules, err
+	}
+
+	err = yaml.UnmarshalStrict(bytesYaml, &rules)
+	if err != nil {
+		return rules, err
+	}
+
+	if rules.PeriodDays == 0 {
+		rules.PeriodDays = DefaultPromotionPeriodDays
+	}
+
+	return rules, rules.Validate()
+}
+
+// Validate - check the levels and the thresholds, all the problems are reported at once.
+func (r PromotionRules) Validate() error {
+	problems := make([]string, 0)
+	if r.PeriodDays < 0 {
+		problems = append(problems, fmt.Sprintf("period_days %d must be positive", r.PeriodDays))
+	}
+
+	validate := func(scope string, thresholds map[model.TeamLevel]LadderThreshold) {
+		for level, threshold := range thresholds {
+			if teamLevelRank(level) == 0 {
+				problems = append(problems, fmt.Sprintf("%s: unknown level %q", scope, level))
+			}
+			if threshold.MergedPRs < 0 || threshold.ReviewedPRs < 0 {
+				problems = append(problems, fmt.Sprintf("%s: thresholds of %s must be non-negative", scope, level))
+			}
+		}
+	}
+	validate("defaults", r.Defaults)
+	for team, thresholds := range r.Teams {
+		validate(team, thresholds)
+	}
+
+	if len(problems) != 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid promotion rules: %v", problems)
+	}
+	return nil
+}
+
+// TeamThresholds - the thresholds of the levels applied to the team.
+func (r PromotionRules) TeamThresholds(teamName string) map[model.TeamLevel]LadderThreshold {
+	thresholds := make(map[model.TeamLevel]LadderThreshold)
+	for level, threshold := range r.Defaults {
+		thresholds[level] = threshold
+	}
+	for level, threshold := range r.Teams[teamName] {
+		thresholds[level] = threshold
+	}
+	return thresholds
+}
+
+// eligibleLevel - get the highest level above the current level whose thresholds are met by the evidence.
+func eligibleLevel(
+	thresholds map[model.TeamLevel]LadderThreshold, currentLevel model.TeamLevel, evidence PromotionEvidence,
+) (model.TeamLevel, bool) {
+	var eligible model.TeamLevel
+	for level, threshold := range thresholds {
+		if teamLevelRank(level) <= teamLevelRank(currentLevel) || teamLevelRank(level) <= teamLevelRank(eligible) {
+			continue
+		}
+		if evidence.MergedPRs >= threshold.MergedPRs && evidence.ReviewedPRs >= threshold.ReviewedPRs {
+			eligible = level
+		}
+	}
+	return eligible, len(eligible) != 0
+}
+
+// ComputePromotionCandidates - evaluate every contributor of the team repositories against the promotion rules,
+// the contributors already at or above the eligible level are not candidates.
+func ComputePromotionCandidates(
+	db *gorm.DB, projectDBs map[string]*gorm.DB, rules PromotionRules, projectName, teamName string, now time.Time,
+) (*PromotionReport, error) {
+	team, err := FindTeam(db, projectName, teamName)
+	if err != nil {
+		return nil, err
+	}
+
+	projectDB, ok := projectDBs[team.Project.Name]
+	if !ok {
+		return nil, fmt.Errorf("there are no project database named %s", team.Project.Name)
+	}
+
+	var teamMembers []model.TeamMember
+	err = db.Where("team_id = ? and leave_date is null", team.ID).Find(&teamMembers).Error
+	if err != nil {
+		return nil, err
+	}
+	id2level := make(map[uint]model.TeamLevel)
+	for _, teamMember := range teamMembers {
+		id2level[teamMember.DupGitHubID] = teamMember.Level
+	}
+
+	report := &PromotionReport{
+		Team:       team.Name,
+		Project:    team.Project.Name,
+		From:       now.AddDate(0, 0, -rules.PeriodDays),
+		To:         now,
+		Thresholds: rules.TeamThresholds(team.Name),
+		Candidates: make([]PromotionCandidate, 0),
+	}
+
+	repoIDs := make([]uint, 0, len(team.Repositories))
+	for _, repository := range team.Repositories {
+		repoIDs = append(repoIDs, repository.ID)
+	}
+	if len(repoIDs) == 0 || len(report.Thresholds) == 0 {
+		return report, nil
+	}
+
+	// The merged PRs of the authors and the reviewed PRs of the reviewers.
+	var merged []struct {
+		UserID   uint
+		Login    string
+		RepoName string
+		Number   int
+		MergedAt time.Time
+	}
+	err = projectDB.Raw(`
+select distinct on (id) user_id, dup_user_login as login, dup_repo_name as repo_name, number, merged_at
+from gha_pull_requests
+where merged_at >= ? and merged_at < ? and dup_repo_id in ?
+order by id, dup_created_at desc
+`, report.From, report.To, repoIDs).Scan(&merged).Error
+	if err != nil {
+		return nil, err
+	}
+	var reviewed []struct {
+		ActorID     uint
+		Login       string
+		ReviewedPRs int
+	}
+	err = projectDB.Raw(`
+select dup_actor_id as actor_id, max(dup_actor_login) as login, count(distinct id) as reviewed_prs
+from gha_pull_requests
+where
+    dup_type in ? and dup_actor_id != user_id
+    and dup_created_at >= ? and dup_created_at < ?
+    and dup_repo_id in ?
+group by dup_actor_id
+`, reviewEventTypes, report.From, report.To, repoIDs).Scan(&reviewed).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].MergedAt.After(merged[j].MergedAt)
+	})
+	id2login := make(map[uint]string)
+	id2evidence := make(map[uint]PromotionEvidence)
+	for _, pr := range merged {
+		evidence := id2evidence[pr.UserID]
+		evidence.MergedPRs++
+		if len(evidence.RecentMergedPRs) < promotionEvidencePRs {
+			evidence.RecentMergedPRs = append(
+				evidence.RecentMergedPRs, fmt.Sprintf("https://github.com/%s/pull/%d", pr.RepoName, pr.Number),
+			)
+		}
+		id2evidence[pr.UserID] = evidence
+		id2login[pr.UserID] = pr.Login
+	}
+	for _, review := range reviewed {
+		evidence := id2evidence[review.ActorID]
+		evidence.ReviewedPRs = review.ReviewedPRs
+		id2evidence[review.ActorID] = evidence
+		id2login[review.ActorID] = review.Login
+	}
+
+	for id, evidence := range id2evidence {
+		login := id2login[id]
+		if strings.HasSuffix(login, "[bot]") {
+			continue
+		}
+		level, ok := eligibleLevel(report.Thresholds, id2level[id], evidence)
+		if !ok {
+			continue
+		}
+		if evidence.RecentMergedPRs == nil {
+			evidence.RecentMergedPRs = make([]string, 0)
+		}
+		report.Candidates = append(report.Candidates, PromotionCandidate{
+			GitHubID:      id,
+			GitHubLogin:   login,
+			CurrentLevel:  id2level[id],
+			EligibleLevel: level,
+			Evidence:      evidence,
+		})
+	}
+	sortPromotionCandidates(report.Candidates)
+
+	return report, nil
+}
+
+// sortPromotionCandidates - sort the candidates by the eligible level, then by the merged and reviewed PRs.
+func sortPromotionCandidates(candidates []PromotionCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if teamLevelRank(a.EligibleLevel) != teamLevelRank(b.EligibleLevel) {
+			return teamLevelRank(a.EligibleLevel) > teamLevelRank(b.EligibleLevel)
+		}
+		if a.Evidence.MergedPRs != b.Evidence.MergedPRs {
+			return a.Evidence.MergedPRs > b.Evidence.MergedPRs
+		}
+		if a.Evidence.ReviewedPRs != b.Evidence.ReviewedPRs {
+			return a.Evidence.ReviewedPRs > b.Evidence.ReviewedPRs
+		}
+		return a.GitHubLogin < b.GitHubLogin
+	})
+}
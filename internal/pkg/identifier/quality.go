@@ -0,0 +1,351 @@
+package identifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+// DataQualityReport - the coverage of the identity data for the active contributors.
+type DataQualityReport struct {
+	GeneratedAt        time.Time `json:"generated_at"`
+	ActiveDays         int       `json:"active_days"`
+	StaleDays          int       `json:"stale_days"`
+	ActiveContributors int       `json:"active_contributors"`
+	// MissingIdentities is the number of active contributors who have not been imported into the identifier DB.
+	MissingIdentities      int                  `json:"missing_identities"`
+	StaleProfiles          int                  `json:"stale_profiles"`
+	Name                   FieldCoverage        `json:"name"`
+	Country                FieldCoverage        `json:"country"`
+	Organization           FieldCoverage        `json:"organization"`
+	TopUnknownContributors []UnknownContributor `json:"top_unknown_contributors"`
+}
+
+// FieldCoverage - how many active contributors have the field known, broken down by the profile source.
+type FieldCoverage struct {
+	Known    int            `json:"known"`
+	Ratio    float64        `json:"ratio"`
+	BySource map[string]int `json:"by_source"`
+}
+
+// UnknownContributor - the active contributor without known organization.
+type UnknownContributor struct {
+	GitHubID uint     `json:"github_id"`
+	Login    string   `json:"login"`
+	Events   int      `json:"events"`
+	Projects []string `json:"projects"`
+}
+
+type activeContributor struct {
+	GitHubID uint
+	Login    string
+	Events   int
+	Projects []string
+}
+
+type qualityIdentity struct {
+	GitHubID       uint `gorm:"column:github_id"`
+	UUID           string
+	Name           string
+	NameSource     model.ProfileSource
+	CountryCode    *string
+	CountrySource  model.ProfileSource
+	IsBot          bool
+	ProfileUpdated time.Time
+}
+
+const qualityQueryBatchSize = 1000
+
+const (
+	// MaxQualityActiveDays is the upper bound of the active days, the events of the period are scanned in every
+	// project database.
+	MaxQualityActiveDays = 365
+	// MaxQualityTopN is the upper bound of the number of top unknown contributors.
+	MaxQualityTopN = 500
+)
+
+// ComputeDataQuality - compute the share of active contributors with known organization, country or name.
+func ComputeDataQuality(
+	db *gorm.DB, projectDBs map[string]*gorm.DB, activeDays, staleDays, topN int,
+) (*DataQualityReport, error) {
+	now := time.Now()
+
+	// Get active contributors from project databases.
+	projectNames := make([]string, 0, len(projectDBs))
+	for projectName := range projectDBs {
+		projectNames = append(projectNames, projectName)
+	}
+	sort.Strings(projectNames)
+
+	id2contributor := make(map[uint]*activeContributor)
+	since := now.AddDate(0, 0, -activeDays)
+	for _, projectName := range projectNames {
+		var items []struct {
+			GitHubID uint `gorm:"column:github_id"`
+			Login    string
+			Events   int
+		}
+		err := projectDBs[projectName].Raw(`
+select
+    actor_id as github_id,
+    max(dup_actor_login) as login,
+    count(*) as events
+from gha_events
+where created_at >= ? and actor_id > 0
+group by actor_id
+`, since).Scan(&items).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			contributor, ok := id2contributor[item.GitHubID]
+			if !ok {
+				contributor = &activeContributor{GitHubID: item.GitHubID, Login: item.Login}
+				id2contributor[item.GitHubID] = contributor
+			}
+			contributor.Events += item.Events
+			contributor.Projects = append(contributor.Projects, projectName)
+		}
+	}
+
+	githubIDs := make([]uint, 0, len(id2contributor))
+	for githubID := range id2contributor {
+		githubIDs = append(githubIDs, githubID)
+	}
+
+	// Get the identities of active contributors.
+	id2identity := make(map[uint]qualityIdentity)
+	uuid2orgSource := make(map[string]model.ProfileSource)
+	for start := 0; start < len(githubIDs); start += qualityQueryBatchSize {
+		end := start + qualityQueryBatchSize
+		if end > len(githubIDs) {
+			end = len(githubIDs)
+		}
+
+		var identities []qualityIdentity
+		err := db.Raw(`
+select
+    gu.id as github_id, ui.uuid as uuid, ui.name as name, ui.name_source as name_source,
+    ui.country_code as country_code, ui.country_source as country_source, ui.is_bot as is_bot,
+    gu.updated_at as profile_updated
+from
+    github_users gu
+    inner join unique_identities ui on ui.uuid = gu.uuid
+where gu.id in ?
+`, githubIDs[start:end]).Scan(&identities).Error
+		if err != nil {
+			return nil, err
+		}
+
+		uuids := make([]string, 0, len(identities))
+		for _, identity := range identities {
+			id2identity[identity.GitHubID] = identity
+			uuids = append(uuids, identity.UUID)
+		}
+		if len(uuids) == 0 {
+			continue
+		}
+
+		// Current enrollments, the source with the highest priority is used.
+		var enrollments []struct {
+			UUID   string
+			Source model.ProfileSource
+		}
+		err = db.Raw(`
+select e.uuid as uuid, e.source as source
+from
+    enrollments e
+    inner join organizations o on e.org_id = o.id
+where e.uuid in ? and e.invalid = ? and o.invalid = ? and e.start_date <= ? and e.end_date > ?
+`, uuids, false, false, now, now).Scan(&enrollments).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, enrollment := range enrollments {
+			oldSource, ok := uuid2orgSource[enrollment.UUID]
+			if !ok || profileSource2priority[enrollment.Source] > profileSource2priority[oldSource] {
+				uuid2orgSource[enrollment.UUID] = enrollment.Source
+			}
+		}
+	}
+
+	return summarizeDataQuality(
+		now, activeDays, staleDays, topN, id2contributor, id2identity, uuid2orgSource,
+	), nil
+}
+
+// summarizeDataQuality - compute the coverage of the fields for the active contributors, the bots are not counted.
+func summarizeDataQuality(
+	now time.Time, activeDays, staleDays, topN int, id2contributor map[uint]*activeContributor,
+	id2identity map[uint]qualityIdentity, uuid2orgSource map[string]model.ProfileSource,
+) *DataQualityReport {
+	report := &DataQualityReport{
+		GeneratedAt:            now,
+		ActiveDays:             activeDays,
+		StaleDays:              staleDays,
+		Name:                   FieldCoverage{BySource: make(map[string]int)},
+		Country:                FieldCoverage{BySource: make(map[string]int)},
+		Organization:           FieldCoverage{BySource: make(map[string]int)},
+		TopUnknownContributors: make([]UnknownContributor, 0),
+	}
+
+	githubIDs := make([]uint, 0, len(id2contributor))
+	for githubID := range id2contributor {
+		githubIDs = append(githubIDs, githubID)
+	}
+
+	// Compute the coverage.
+	unknownContributors := make([]UnknownContributor, 0)
+	staleBefore := now.AddDate(0, 0, -staleDays)
+	for _, githubID := range githubIDs {
+		contributor := id2contributor[githubID]
+		identity, ok := id2identity[githubID]
+		if ok && identity.IsBot {
+			continue
+		}
+		report.ActiveContributors++
+
+		if !ok {
+			report.MissingIdentities++
+		} else {
+			if identity.ProfileUpdated.Before(staleBefore) {
+				report.StaleProfiles++
+			}
+			if len(identity.Name) != 0 {
+				report.Name.Known++
+				report.Name.BySource[sourceName(identity.NameSource)]++
+			}
+			if identity.CountryCode != nil && len(*identity.CountryCode) != 0 {
+				report.Country.Known++
+				report.Country.BySource[sourceName(identity.CountrySource)]++
+			}
+			if orgSource, ok := uuid2orgSource[identity.UUID]; ok {
+				report.Organization.Known++
+				report.Organization.BySource[sourceName(orgSource)]++
+				continue
+			}
+		}
+
+		unknownContributors = append(unknownContributors, UnknownContributor{
+			GitHubID: contributor.GitHubID,
+			Login:    contributor.Login,
+			Events:   contributor.Events,
+			Projects: contributor.Projects,
+		})
+	}
+
+	for _, coverage := range []*FieldCoverage{&report.Name, &report.Country, &report.Organization} {
+		if report.ActiveContributors > 0 {
+			coverage.Ratio = float64(coverage.Known) / float64(report.ActiveContributors)
+		}
+	}
+
+	sort.Slice(unknownContributors, func(i, j int) bool {
+		if unknownContributors[i].Events == unknownContributors[j].Events {
+			return strings.Compare(unknownContributors[i].Login, unknownContributors[j].Login) < 0
+		}
+		return unknownContributors[i].Events > unknownContributors[j].Events
+	})
+	if len(unknownContributors) > topN {
+		unknownContributors = unknownContributors[:topN]
+	}
+	report.TopUnknownContributors = unknownContributors
+
+	return report
+}
+
+func sourceName(source model.ProfileSource) string {
+	if len(source) == 0 {
+		return "unknown"
+	}
+	return string(source)
+}
+
+// ReportDataQuality - compute the data quality report, and output it to Markdown or JSON file according to the
+// file extension.
+func ReportDataQuality(log *logrus.Entry, ctx *Ctx, db *gorm.DB, projectDBs map[string]*gorm.DB) {
+	report, err := ComputeDataQuality(db, projectDBs, ctx.QualityActiveDays, ctx.QualityStaleDays, ctx.QualityTopN)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to compute the data quality report.")
+		return
+	}
+
+	var bf bytes.Buffer
+	filename := ctx.QualityReportPath
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		encoder := json.NewEncoder(&bf)
+		encoder.SetIndent("", "\t")
+		encoder.SetEscapeHTML(false)
+		err = encoder.Encode(report)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to encode the data quality report.")
+			return
+		}
+	} else {
+		writeDataQualityMarkdown(&bf, report)
+	}
+
+	err = ioutil.WriteFile(filename, bf.Bytes(), 0666)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to output the data quality report.")
+		return
+	}
+	log.Infof("Output %s successfully.", filename)
+}
+
+func writeDataQualityMarkdown(bf *bytes.Buffer, report *DataQualityReport) {
+	bf.WriteString("# Identity Data Quality Report\n\n")
+	bf.WriteString(fmt.Sprintf("Generated at %s.\n\n", report.GeneratedAt.Format("2006-01-02 15:04:05")))
+	bf.WriteString(fmt.Sprintf(
+		"- Active contributors in the last %d days: %d\n", report.ActiveDays, report.ActiveContributors,
+	))
+	bf.WriteString(fmt.Sprintf("- Not imported into the identifier DB: %d\n", report.MissingIdentities))
+	bf.WriteString(fmt.Sprintf(
+		"- Profiles not updated in the last %d days: %d\n\n", report.StaleDays, report.StaleProfiles,
+	))
+
+	bf.WriteString("## Coverage\n\n")
+	bf.WriteString("| Field | Known | Ratio | By Source |\n")
+	bf.WriteString("| --- | ---: | ---: | --- |\n")
+	for _, field := range []struct {
+		name     string
+		coverage FieldCoverage
+	}{
+		{"Organization", report.Organization},
+		{"Country", report.Country},
+		{"Name", report.Name},
+	} {
+		sources := make([]string, 0, len(field.coverage.BySource))
+		for source := range field.coverage.BySource {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		bySource := make([]string, 0, len(sources))
+		for _, source := range sources {
+			bySource = append(bySource, fmt.Sprintf("%s: %d", source, field.coverage.BySource[source]))
+		}
+		bf.WriteString(fmt.Sprintf(
+			"| %s | %d | %.2f%% | %s |\n",
+			field.name, field.coverage.Known, field.coverage.Ratio*100, strings.Join(bySource, ", "),
+		))
+	}
+
+	bf.WriteString("\n## Top Unknown Contributors\n\n")
+	bf.WriteString("| Login | GitHub ID | Events | Projects |\n")
+	bf.WriteString("| --- | ---: | ---: | --- |\n")
+	for _, contributor := range report.TopUnknownContributors {
+		bf.WriteString(fmt.Sprintf(
+			"| %s | %d | %d | %s |\n",
+			contributor.Login, contributor.GitHubID, contributor.Events, strings.Join(contributor.Projects, ", "),
+		))
+	}
+}
@@ -0,0 +1,294 @@
+package identifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// GitHubUserAPI is the GitHub API used to get the user profile.
+	GitHubUserAPI = "github_user"
+	// GeocodingAPI is the Google Maps API used to format the location.
+	GeocodingAPI = "geocoding"
+	// LarkContactAPI is the Lark API used to get the GitHub logins of employees.
+	LarkContactAPI = "lark_contact"
+)
+
+// RunReport - the structured report of an AutoImportProfile run, it is used to find out what has been changed by
+// the run at a glance, such as a lot of people moving to the same organization suddenly.
+type RunReport struct {
+	StartedAt  time.Time                 `json:"started_at"`
+	FinishedAt time.Time                 `json:"finished_at"`
+	Status     model.IdentifierRunStatus `json:"status"`
+	Error      string                    `json:"error,omitempty"`
+
+	IdentitiesProcessed int `json:"identities_processed"`
+	IdentitiesCreated   int `json:"identities_created"`
+	IdentitiesFailed    int `json:"identities_failed"`
+	// ProfileUpdates is the number of existing identities whose field is changed, keyed by the field.
+	ProfileUpdates map[string]int `json:"profile_updates"`
+
+	EnrollmentsOpened int `json:"enrollments_opened"`
+	EnrollmentsClosed int `json:"enrollments_closed"`
+	// EnrollmentsOpenedByOrg and EnrollmentsClosedByOrg are keyed by the organization name.
+	EnrollmentsOpenedByOrg map[string]int `json:"enrollments_opened_by_org"`
+	EnrollmentsClosedByOrg map[string]int `json:"enrollments_closed_by_org"`
+
+	OrgsQueued int `json:"orgs_queued"`
+
+	// APIFailures is keyed by the API, such as github_user and geocoding.
+	APIFailures map[string]int        `json:"api_failures"`
+	CacheStats  map[string]CacheStats `json:"cache_stats"`
+
+	mtx          sync.Mutex
+	orgOpened    map[uint]int
+	orgClosed    map[uint]int
+	orgIDsToName map[uint]string
+}
+
+func newRunReport() *RunReport {
+	return &RunReport{
+		StartedAt:              time.Now(),
+		Status:                 model.IdentifierRunFailed,
+		ProfileUpdates:         make(map[string]int),
+		EnrollmentsOpenedByOrg: make(map[string]int),
+		EnrollmentsClosedByOrg: make(map[string]int),
+		APIFailures:            make(map[string]int),
+		CacheStats:             make(map[string]CacheStats),
+		orgOpened:              make(map[uint]int),
+		orgClosed:              make(map[uint]int),
+		orgIDsToName:           make(map[uint]string),
+	}
+}
+
+// identityProcessed - record the identity processed successfully, the changed fields are ignored when the
+// identity is newly created.
+func (r *RunReport) identityProcessed(created bool, changedFields []string, opened, closed []model.Enrollment) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.IdentitiesProcessed++
+	if created {
+		r.IdentitiesCreated++
+	} else {
+		for _, field := range changedFields {
+			r.ProfileUpdates[field]++
+		}
+	}
+	for _, enrollment := range opened {
+		r.EnrollmentsOpened++
+		r.orgOpened[enrollment.OrgID]++
+	}
+	for _, enrollment := range closed {
+		r.EnrollmentsClosed++
+		r.orgClosed[enrollment.OrgID]++
+	}
+}
+
+func (r *RunReport) identityFailed() {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.IdentitiesFailed++
+}
+
+func (r *RunReport) apiFailed(api string) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.APIFailures[api]++
+}
+
+func (r *RunReport) orgQueued() {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.OrgsQueued++
+}
+
+// fail - mark the run failed because of the error.
+func (r *RunReport) fail(err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.Status = model.IdentifierRunFailed
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// finish - fill the organization names and the cache stats, the status is unchanged.
+func (r *RunReport) finish(db *gorm.DB, cache Cache) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.FinishedAt = time.Now()
+
+	orgIDs := make([]uint, 0)
+	for orgID := range r.orgOpened {
+		if _, ok := r.orgIDsToName[orgID]; !ok {
+			orgIDs = append(orgIDs, orgID)
+		}
+	}
+	for orgID := range r.orgClosed {
+		if _, ok := r.orgIDsToName[orgID]; !ok {
+			orgIDs = append(orgIDs, orgID)
+		}
+	}
+	if len(orgIDs) != 0 {
+		var orgs []model.Organization
+		db.Unscoped().Where("id in ?", orgIDs).Find(&orgs)
+		for _, org := range orgs {
+			r.orgIDsToName[org.ID] = org.Name
+		}
+	}
+	for orgID, n := range r.orgOpened {
+		r.EnrollmentsOpenedByOrg[r.orgIDsToName[orgID]] += n
+	}
+	for orgID, n := range r.orgClosed {
+		r.EnrollmentsClosedByOrg[r.orgIDsToName[orgID]] += n
+	}
+
+	if statsCache, ok := cache.(*StatsCache); ok {
+		r.CacheStats = statsCache.Stats()
+	}
+}
+
+// cacheHitRate - the overall hit rate of all kinds of cache keys.
+func (r *RunReport) cacheHitRate() float64 {
+	hits, total := 0, 0
+	for _, stats := range r.CacheStats {
+		hits += stats.Hits
+		total += stats.Hits + stats.Misses
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// saveRunReport - persist the run report to the identifier_runs table, and write it to the JSON file if the
+// path is configured.
+func saveRunReport(log *logrus.Entry, ctx *Ctx, db *gorm.DB, report *RunReport) {
+	report.mtx.Lock()
+	defer report.mtx.Unlock()
+
+	bytesJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.WithError(err).Errorf("Failed to encode the run report.")
+		return
+	}
+
+	nProfilesUpdated := 0
+	for _, n := range report.ProfileUpdates {
+		nProfilesUpdated += n
+	}
+	nAPIFailures := 0
+	for _, n := range report.APIFailures {
+		nAPIFailures += n
+	}
+
+	run := model.IdentifierRun{
+		StartedAt:           report.StartedAt,
+		FinishedAt:          report.FinishedAt,
+		Status:              report.Status,
+		Error:               report.Error,
+		IdentitiesProcessed: report.IdentitiesProcessed,
+		IdentitiesCreated:   report.IdentitiesCreated,
+		IdentitiesFailed:    report.IdentitiesFailed,
+		ProfilesUpdated:     nProfilesUpdated,
+		EnrollmentsOpened:   report.EnrollmentsOpened,
+		EnrollmentsClosed:   report.EnrollmentsClosed,
+		OrgsQueued:          report.OrgsQueued,
+		APIFailures:         nAPIFailures,
+		CacheHitRate:        report.cacheHitRate(),
+		Report:              string(bytesJSON),
+	}
+	err = db.Create(&run).Error
+	if err != nil {
+		log.WithError(err).Errorf("Failed to save the run report.")
+	}
+
+	if len(ctx.RunReportPath) != 0 {
+		err = ioutil.WriteFile(ctx.RunReportPath, bytesJSON, 0644)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to write the run report to %s.", ctx.RunReportPath)
+		}
+	}
+
+	log.Infof(
+		"Run %s: %d identities processed (%d created, %d failed), %d profile fields updated, "+
+			"%d enrollments opened, %d enrollments closed, %d orgs queued, %d API failures, cache hit rate %.2f.",
+		run.Status, run.IdentitiesProcessed, run.IdentitiesCreated, run.IdentitiesFailed, run.ProfilesUpdated,
+		run.EnrollmentsOpened, run.EnrollmentsClosed, run.OrgsQueued, run.APIFailures, run.CacheHitRate,
+	)
+}
+
+// diffProfile - get the changed fields of the unique identity profile.
+func diffProfile(before, after model.UniqueIdentity) []string {
+	fields := make([]string, 0)
+	if before.Name != after.Name {
+		fields = append(fields, "name")
+	}
+	if before.Email != after.Email {
+		fields = append(fields, "email")
+	}
+	if before.Location != after.Location {
+		fields = append(fields, "location")
+	}
+	if stringValue(before.CountryCode) != stringValue(after.CountryCode) {
+		fields = append(fields, "country_code")
+	}
+	return fields
+}
+
+// diffEnrollments - get the enrollments which become active and the enrollments which are no longer active at
+// the time, the enrollments are matched by the organization.
+func diffEnrollments(before, after []model.Enrollment, now time.Time) (opened, closed []model.Enrollment) {
+	isActive := func(enrollment model.Enrollment) bool {
+		return !enrollment.Invalid && !enrollment.StartDate.After(now) && enrollment.EndDate.After(now)
+	}
+
+	activeBefore := make(map[uint]bool)
+	for _, enrollment := range before {
+		if isActive(enrollment) {
+			activeBefore[enrollment.OrgID] = true
+		}
+	}
+	activeAfter := make(map[uint]bool)
+	for _, enrollment := range after {
+		if isActive(enrollment) {
+			activeAfter[enrollment.OrgID] = true
+			if !activeBefore[enrollment.OrgID] {
+				opened = append(opened, enrollment)
+			}
+		}
+	}
+	for _, enrollment := range before {
+		if isActive(enrollment) && !activeAfter[enrollment.OrgID] {
+			closed = append(closed, enrollment)
+		}
+	}
+	return opened, closed
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
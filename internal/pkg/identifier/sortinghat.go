@@ -0,0 +1,649 @@
+package identifier
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// SortingHatGitHubSource is the source of the GitHub identities in SortingHat.
+	SortingHatGitHubSource = "github"
+	// SortingHatGitSource is the source of the identities of git commit authors in SortingHat.
+	SortingHatGitSource = "git"
+
+	sortingHatTimeFormat       = "2006-01-02 15:04:05.000000"
+	sortingHatEnrollmentFormat = "2006-01-02T15:04:05"
+)
+
+// SortingHatData is the JSON format used by `sortinghat export` and `sortinghat load` of GrimoireLab.
+type SortingHatData struct {
+	Blacklist     []interface{}                  `json:"blacklist"`
+	Organizations map[string][]SortingHatDomain  `json:"organizations"`
+	Source        string                         `json:"source,omitempty"`
+	Time          string                         `json:"time"`
+	UIdentities   map[string]SortingHatUIdentity `json:"uidentities"`
+}
+
+type SortingHatDomain struct {
+	Domain string `json:"domain"`
+	IsTop  bool   `json:"is_top"`
+}
+
+type SortingHatUIdentity struct {
+	UUID        string                 `json:"uuid"`
+	Profile     *SortingHatProfile     `json:"profile"`
+	Identities  []SortingHatIdentity   `json:"identities"`
+	Enrollments []SortingHatEnrollment `json:"enrollments"`
+}
+
+type SortingHatProfile struct {
+	UUID  string  `json:"uuid"`
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+	// Gender is "male" or "female", GenderAcc is between 0 and 100.
+	Gender    *string            `json:"gender"`
+	GenderAcc *int               `json:"gender_acc"`
+	IsBot     bool               `json:"is_bot"`
+	Country   *SortingHatCountry `json:"country"`
+}
+
+type SortingHatCountry struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Alpha3 string `json:"alpha3"`
+}
+
+type SortingHatIdentity struct {
+	ID       string  `json:"id"`
+	Name     *string `json:"name"`
+	Email    *string `json:"email"`
+	Username *string `json:"username"`
+	Source   string  `json:"source"`
+	UUID     string  `json:"uuid"`
+}
+
+type SortingHatEnrollment struct {
+	Organization string `json:"organization"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	UUID         string `json:"uuid"`
+}
+
+// SortingHatImportResult - the summary of importing the SortingHat data.
+type SortingHatImportResult struct {
+	Organizations int `json:"organizations"`
+	// PendingOrgs is the number of the organizations which are not known, they are put into the pending-review
+	// queue, and their domains and enrollments are skipped.
+	PendingOrgs int `json:"pending_orgs"`
+	Domains     int `json:"domains"`
+	// Matched is the number of the unique identities matched by the GitHub logins or the linked accounts, the
+	// others are skipped because the identifier does not create identities from SortingHat.
+	Matched   int `json:"matched"`
+	Skipped   int `json:"skipped"`
+	Conflicts int `json:"conflicts"`
+	// Ambiguous maps the UUID of the SortingHat unique identity to the UUIDs of the unique identities it matched,
+	// the identities matching more than one unique identity are skipped for manual review.
+	Ambiguous   map[string][]string `json:"ambiguous,omitempty"`
+	Profiles    int                 `json:"profiles"`
+	Enrollments int                 `json:"enrollments"`
+}
+
+// sortingHatIdentityID - the identity ID generated in the same way as SortingHat, which is the SHA1 of the
+// source, email, unaccented name and username, the empty values are regarded as None.
+func sortingHatIdentityID(source, email, name, username string) string {
+	values := []string{source, email, unaccent(name), username}
+	for i, value := range values {
+		if len(value) == 0 {
+			values[i] = "None"
+		}
+	}
+	sum := sha1.Sum([]byte(strings.ToLower(strings.Join(values, ":"))))
+	return hex.EncodeToString(sum[:])
+}
+
+// unaccent - remove the accents like SortingHat does, the string is decomposed and the nonspacing marks are
+// removed, the string is not composed again.
+func unaccent(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)))
+	result, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return result
+}
+
+// ExportSortingHat - export the unique identities, organizations, domains and enrollments in SortingHat format,
+// the opted out identities are excluded.
+func ExportSortingHat(log *logrus.Entry, ctx *Ctx, db *gorm.DB, out io.Writer) error {
+	data := SortingHatData{
+		Blacklist:     make([]interface{}, 0),
+		Organizations: make(map[string][]SortingHatDomain),
+		Source:        "devstats-identifier",
+		Time:          time.Now().UTC().Format(sortingHatTimeFormat),
+		UIdentities:   make(map[string]SortingHatUIdentity),
+	}
+
+	var organizations []model.Organization
+	err := db.Preload("Domains").Where("invalid = ?", false).Find(&organizations).Error
+	if err != nil {
+		return err
+	}
+	for _, org := range organizations {
+		domains := make([]SortingHatDomain, 0)
+		for _, domain := range org.Domains {
+			if domain.Common || len(domain.Name) == 0 {
+				continue
+			}
+			domains = append(domains, SortingHatDomain{Domain: domain.Name, IsTop: domain.IsTop})
+		}
+		data.Organizations[org.Name] = domains
+	}
+
+	demographicEnabled := len(ctx.DemographicEnricher) != 0 && ctx.DemographicEnricher != NoopDemographicEnricher
+
+	var uniqueIdentities []model.UniqueIdentity
+	err = db.Preload("Country").Where("opted_out = ?", false).Find(&uniqueIdentities).Error
+	if err != nil {
+		return err
+	}
+
+	// The GitHub users, accounts and enrollments are loaded at once rather than by every identity.
+	var githubUsers []model.GitHubUser
+	err = db.Preload("Emails").Preload("Logins").Where("uuid != ''").Find(&githubUsers).Error
+	if err != nil {
+		return err
+	}
+	uuid2githubUsers := make(map[string][]model.GitHubUser)
+	for _, githubUser := range githubUsers {
+		uuid2githubUsers[githubUser.UUID] = append(uuid2githubUsers[githubUser.UUID], githubUser)
+	}
+
+	var accounts []model.Account
+	err = db.Where("uuid != '' and provider != ?", model.GitHubProvider).Order("id").Find(&accounts).Error
+	if err != nil {
+		return err
+	}
+	uuid2accounts := make(map[string][]model.Account)
+	for _, account := range accounts {
+		uuid2accounts[account.UUID] = append(uuid2accounts[account.UUID], account)
+	}
+
+	uuid2enrollments, err := getAllEnrollmentsWithOrg(db)
+	if err != nil {
+		return err
+	}
+
+	for _, uniqueIdentity := range uniqueIdentities {
+		u := uniqueIdentity.UUID
+		// Notice: The gender follows the same rule as the exported github_users.json.
+		exportGender := demographicEnabled ||
+			uniqueIdentity.GenderSource == model.ManualSource || uniqueIdentity.GenderSource == model.UserManualSource
+		uidentity := toSortingHatUIdentity(
+			uniqueIdentity, uuid2githubUsers[u], uuid2accounts[u], uuid2enrollments[u], exportGender,
+		)
+		data.UIdentities[uidentity.UUID] = uidentity
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "    ")
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(&data)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Exported %d unique identities and %d organizations in SortingHat format.",
+		len(data.UIdentities), len(data.Organizations))
+	return nil
+}
+
+// toSortingHatUIdentity - map the unique identity and its accounts and enrollments to SortingHat format, the
+// accounts are the ones of other providers, because the GitHub accounts are taken from the GitHub users.
+func toSortingHatUIdentity(
+	uniqueIdentity model.UniqueIdentity, githubUsers []model.GitHubUser, accounts []model.Account,
+	enrollments []EnrollmentWithOrg, exportGender bool,
+) SortingHatUIdentity {
+	u := uniqueIdentity.UUID
+	profile := &SortingHatProfile{
+		UUID:  u,
+		IsBot: uniqueIdentity.IsBot,
+	}
+	if len(uniqueIdentity.Name) != 0 {
+		name := uniqueIdentity.Name
+		profile.Name = &name
+	}
+	if len(uniqueIdentity.Email) != 0 {
+		email := uniqueIdentity.Email
+		profile.Email = &email
+	}
+	if exportGender && (uniqueIdentity.Gender == "m" || uniqueIdentity.Gender == "f") {
+		gender := "male"
+		if uniqueIdentity.Gender == "f" {
+			gender = "female"
+		}
+		genderAcc := int(uniqueIdentity.GenderAcc*100 + 0.5)
+		profile.Gender, profile.GenderAcc = &gender, &genderAcc
+	}
+	if uniqueIdentity.CountryCode != nil && len(uniqueIdentity.Country.Code) != 0 {
+		profile.Country = &SortingHatCountry{
+			Code:   uniqueIdentity.Country.Code,
+			Name:   uniqueIdentity.Country.Name,
+			Alpha3: uniqueIdentity.Country.Alpha3,
+		}
+	}
+
+	uidentity := SortingHatUIdentity{
+		UUID:        u,
+		Profile:     profile,
+		Identities:  make([]SortingHatIdentity, 0),
+		Enrollments: make([]SortingHatEnrollment, 0),
+	}
+
+	for _, githubUser := range githubUsers {
+		name := ""
+		if githubUser.Name != nil {
+			name = *githubUser.Name
+		}
+		emails := make([]string, 0, len(githubUser.Emails))
+		for _, email := range githubUser.Emails {
+			emails = append(emails, email.Email)
+		}
+		if len(emails) == 0 {
+			emails = append(emails, githubUser.Email)
+		}
+		logins := make([]string, 0, len(githubUser.Logins))
+		for _, login := range githubUser.Logins {
+			logins = append(logins, login.Login)
+		}
+		if len(logins) == 0 {
+			logins = append(logins, githubUser.Login)
+		}
+
+		for _, login := range logins {
+			for _, email := range emails {
+				identity := SortingHatIdentity{
+					ID:       sortingHatIdentityID(SortingHatGitHubSource, email, name, login),
+					Name:     optionalString(name),
+					Email:    optionalString(email),
+					Username: optionalString(login),
+					Source:   SortingHatGitHubSource,
+					UUID:     u,
+				}
+				uidentity.Identities = append(uidentity.Identities, identity)
+			}
+		}
+	}
+
+	for _, account := range accounts {
+		source := string(account.Provider)
+		username := account.Username
+		if account.Provider == model.EmailProvider {
+			// Notice: The email-only identities come from the git commits in GrimoireLab.
+			source, username = SortingHatGitSource, ""
+		}
+		name := ""
+		if account.Name != nil {
+			name = *account.Name
+		}
+		uidentity.Identities = append(uidentity.Identities, SortingHatIdentity{
+			ID:       sortingHatIdentityID(source, account.Email, name, username),
+			Name:     optionalString(name),
+			Email:    optionalString(account.Email),
+			Username: optionalString(username),
+			Source:   source,
+			UUID:     u,
+		})
+	}
+
+	for _, enrollment := range enrollments {
+		uidentity.Enrollments = append(uidentity.Enrollments, SortingHatEnrollment{
+			Organization: enrollment.OrgName,
+			Start:        enrollment.StartDate.UTC().Format(sortingHatEnrollmentFormat),
+			End:          enrollment.EndDate.UTC().Format(sortingHatEnrollmentFormat),
+			UUID:         u,
+		})
+	}
+
+	return uidentity
+}
+
+// ImportSortingHat - import the SortingHat data, the unique identities are matched with the existing ones by the
+// GitHub logins, the linked GitLab and Gitee accounts and the emails, and only the empty profile fields are filled.
+// The organizations are matched like the company names of profiles, the unknown ones are put into the
+// pending-review queue. The enrollments of the identities which have manual enrollments are not changed.
+func ImportSortingHat(log *logrus.Entry, ctx *Ctx, db *gorm.DB, r io.Reader) (*SortingHatImportResult, error) {
+	var data SortingHatData
+	err := json.NewDecoder(r).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SortingHatImportResult{Ambiguous: make(map[string][]string)}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		orgNames := make([]string, 0, len(data.Organizations))
+		for orgName := range data.Organizations {
+			orgNames = append(orgNames, orgName)
+		}
+		sort.Strings(orgNames)
+
+		pattern2org, _ := loadOrgMappings(log, tx)
+		orgMatcher := newOrgMatcher(tx, pattern2org, ctx.OrgSimilarityThreshold)
+		name2org := make(map[string]*model.Organization)
+		for _, orgName := range orgNames {
+			org := orgMatcher.mapNameToOrg(orgName)
+			name2org[orgName] = org
+			if org == nil {
+				result.PendingOrgs++
+				continue
+			}
+			result.Organizations++
+
+			for _, domain := range data.Organizations[orgName] {
+				if len(domain.Domain) == 0 {
+					continue
+				}
+				res := tx.Clauses(clause.OnConflict{
+					Columns: []clause.Column{
+						{Name: "org_id"}, {Name: "name"},
+					},
+					DoNothing: true,
+				}).Create(&model.OrgDomain{Name: strings.ToLower(domain.Domain), IsTop: domain.IsTop, OrgID: org.ID})
+				if res.Error != nil {
+					return res.Error
+				}
+				result.Domains += int(res.RowsAffected)
+			}
+		}
+
+		for _, uidentity := range data.UIdentities {
+			uuids, err := matchSortingHatUIdentity(tx, uidentity)
+			if err != nil {
+				return err
+			}
+			if len(uuids) == 0 {
+				result.Skipped++
+				continue
+			}
+			if len(uuids) > 1 {
+				// Notice: Merging the unique identities needs manual review, writing to any of them may attach
+				// the profile and enrollments of one person to another.
+				log.Warnf("SortingHat identity %s matches %d unique identities %s, skipped.",
+					uidentity.UUID, len(uuids), strings.Join(uuids, ", "))
+				result.Ambiguous[uidentity.UUID] = uuids
+				result.Conflicts++
+				continue
+			}
+			u := uuids[0]
+			result.Matched++
+
+			var uniqueIdentity model.UniqueIdentity
+			err = tx.Where("uuid = ?", u).First(&uniqueIdentity).Error
+			if err != nil {
+				return err
+			}
+			if uniqueIdentity.OptedOut {
+				continue
+			}
+
+			updates := sortingHatProfileUpdates(uniqueIdentity, uidentity.Profile)
+			if len(updates) != 0 {
+				err = tx.Model(&model.UniqueIdentity{}).Where("uuid = ?", u).Updates(updates).Error
+				if err != nil {
+					return err
+				}
+				result.Profiles++
+			}
+
+			n, err := importSortingHatEnrollments(tx, u, uidentity.Enrollments, orgMatcher, name2org)
+			if err != nil {
+				return err
+			}
+			result.Enrollments += n
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof(
+		"Imported %d organizations with %d new domains (%d pending review), %d unique identities matched "+
+			"(%d profiles, %d enrollments), %d skipped, %d skipped for matching more than one identity.",
+		result.Organizations, result.Domains, result.PendingOrgs, result.Matched, result.Profiles,
+		result.Enrollments, result.Skipped, result.Conflicts,
+	)
+	return result, nil
+}
+
+// matchSortingHatUIdentity - find the sorted UUIDs of the existing unique identities by the GitHub logins, the
+// GitLab and Gitee usernames and the emails of the identities.
+func matchSortingHatUIdentity(db *gorm.DB, uidentity SortingHatUIdentity) ([]string, error) {
+	logins := make([]string, 0)
+	provider2userIDs := make(map[model.AccountProvider][]string)
+	emails := make([]string, 0)
+	for _, identity := range uidentity.Identities {
+		if identity.Username != nil && len(strings.TrimSpace(*identity.Username)) != 0 {
+			username := strings.TrimSpace(*identity.Username)
+			switch provider := model.AccountProvider(strings.ToLower(identity.Source)); provider {
+			case model.GitHubProvider:
+				logins = append(logins, username)
+			case model.GitLabProvider, model.GiteeProvider:
+				provider2userIDs[provider] = append(provider2userIDs[provider], strings.ToLower(username))
+			}
+		}
+		if identity.Email != nil && strings.Contains(*identity.Email, "@") {
+			email := strings.ToLower(strings.TrimSpace(*identity.Email))
+			emails = append(emails, email)
+			provider2userIDs[model.EmailProvider] = append(provider2userIDs[model.EmailProvider], emailAccountID(email))
+		}
+	}
+
+	uuidSet := make(map[string]struct{})
+	addUUIDs := func(query string, args ...interface{}) error {
+		var uuids []string
+		err := db.Raw(query, args...).Scan(&uuids).Error
+		for _, u := range uuids {
+			uuidSet[u] = struct{}{}
+		}
+		return err
+	}
+
+	if len(logins) != 0 {
+		err := addUUIDs(`
+select distinct gu.uuid
+from
+    github_users gu
+    left join github_user_logins gul on gul.github_user_id = gu.id
+where (gu.login in ? or gul.login in ?) and gu.uuid != ''
+`, logins, logins)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for provider, userIDs := range provider2userIDs {
+		err := addUUIDs(`
+select distinct uuid from accounts
+where provider = ? and provider_user_id in ? and uuid != '' and deleted_at is null
+`, provider, userIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(emails) != 0 {
+		err := addUUIDs(`
+select distinct gu.uuid
+from
+    github_users gu
+    inner join github_user_emails gue on gue.github_user_id = gu.id
+where lower(gue.email) in ? and gu.uuid != ''
+`, emails)
+		if err != nil {
+			return nil, err
+		}
+	}
+	uuids := make([]string, 0, len(uuidSet))
+	for u := range uuidSet {
+		uuids = append(uuids, u)
+	}
+	sort.Strings(uuids)
+	return uuids, nil
+}
+
+// sortingHatProfileUpdates - get the updates of the empty profile fields from the SortingHat profile.
+func sortingHatProfileUpdates(uniqueIdentity model.UniqueIdentity, profile *SortingHatProfile) map[string]interface{} {
+	updates := make(map[string]interface{})
+	if profile == nil {
+		return updates
+	}
+
+	if len(uniqueIdentity.Name) == 0 && profile.Name != nil && len(*profile.Name) != 0 {
+		updates["name"] = *profile.Name
+		updates["name_source"] = model.SortingHatSource
+	}
+	if len(uniqueIdentity.Email) == 0 && profile.Email != nil && len(*profile.Email) != 0 {
+		updates["email"] = *profile.Email
+		updates["email_source"] = model.SortingHatSource
+	}
+	if len(uniqueIdentity.Gender) == 0 && profile.Gender != nil {
+		gender := ""
+		switch strings.ToLower(*profile.Gender) {
+		case "male":
+			gender = "m"
+		case "female":
+			gender = "f"
+		}
+		if len(gender) != 0 {
+			updates["gender"] = gender
+			updates["gender_source"] = model.SortingHatSource
+			updates["gender_provenance"] = fmt.Sprintf("%s@%s", model.SortingHatSource, time.Now().Format("2006-01-02"))
+			if profile.GenderAcc != nil {
+				updates["gender_acc"] = float64(*profile.GenderAcc) / 100
+			}
+		}
+	}
+	if uniqueIdentity.CountryCode == nil && profile.Country != nil && len(profile.Country.Code) == 2 {
+		updates["country_code"] = strings.ToUpper(profile.Country.Code)
+		updates["country_source"] = model.SortingHatSource
+	}
+	if profile.IsBot && !uniqueIdentity.IsBot {
+		updates["is_bot"] = true
+	}
+	return updates
+}
+
+// importSortingHatEnrollments - save the enrollments of the unique identity, the enrollments of the same
+// organization are merged into one, because an identity can only enroll in an organization once. The enrollments
+// of the organizations pending review are skipped, and so are the organizations whose existing enrollments come
+// from the sources of higher priority, such as the manual verification.
+func importSortingHatEnrollments(
+	db *gorm.DB, u string, shEnrollments []SortingHatEnrollment,
+	orgMatcher *OrgMatcher, name2org map[string]*model.Organization,
+) (int, error) {
+	var enrollments []model.Enrollment
+	err := db.Where("uuid = ?", u).Find(&enrollments).Error
+	if err != nil {
+		return 0, err
+	}
+	orgID2existing := make(map[uint]model.Enrollment)
+	for _, enrollment := range enrollments {
+		if isManualSource(enrollment.Source) && !enrollment.Invalid {
+			return 0, nil
+		}
+		orgID2existing[enrollment.OrgID] = enrollment
+	}
+
+	orgID2enrollment := make(map[uint]*model.Enrollment)
+	orgIDs := make([]uint, 0)
+	for _, shEnrollment := range shEnrollments {
+		org, ok := name2org[shEnrollment.Organization]
+		if !ok {
+			org = orgMatcher.mapNameToOrg(shEnrollment.Organization)
+			name2org[shEnrollment.Organization] = org
+		}
+		if org == nil || org.ID == 0 || org.Invalid {
+			continue
+		}
+		if existing, ok := orgID2existing[org.ID]; ok && !canOverrideSource(model.SortingHatSource, existing.Source) {
+			continue
+		}
+
+		start, err := parseSortingHatDate(shEnrollment.Start, model.DefaultStartDate)
+		if err != nil {
+			return 0, err
+		}
+		end, err := parseSortingHatDate(shEnrollment.End, model.DefaultEndDate)
+		if err != nil {
+			return 0, err
+		}
+
+		if enrollment, ok := orgID2enrollment[org.ID]; ok {
+			if start.Before(enrollment.StartDate) {
+				enrollment.StartDate = start
+			}
+			if end.After(enrollment.EndDate) {
+				enrollment.EndDate = end
+			}
+			continue
+		}
+		orgID2enrollment[org.ID] = &model.Enrollment{
+			UUID:      u,
+			OrgID:     org.ID,
+			StartDate: start,
+			EndDate:   end,
+			Source:    model.SortingHatSource,
+		}
+		orgIDs = append(orgIDs, org.ID)
+	}
+
+	for _, orgID := range orgIDs {
+		err = db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "uuid"}, {Name: "org_id"},
+			},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"start_date", "end_date", "source",
+			}),
+		}).Create(orgID2enrollment[orgID]).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(orgIDs), nil
+}
+
+// parseSortingHatDate - parse the date of SortingHat enrollment, the empty date means the default date.
+func parseSortingHatDate(date string, defaultDate time.Time) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if len(date) == 0 {
+		return defaultDate, nil
+	}
+	for _, layout := range []string{sortingHatEnrollmentFormat, "2006-01-02 15:04:05", time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date of SortingHat enrollment: %s", date)
+}
+
+func optionalString(s string) *string {
+	if len(s) == 0 {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,84 @@
+package identifier
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/lib"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RetireTeamMembers - mark the active members who are not present in the membership file of the commit as left,
+// and add the changelogs without the level to. All the members are retired if nothing is present, for example the
+// team is deleted.
+func RetireTeamMembers(
+	log *logrus.Entry, db *gorm.DB, teamID uint, teamName string, presentUUIDs, presentLogins lib.StringSet,
+	commit *object.Commit,
+) error {
+	var activeMembers []model.TeamMember
+	err := db.Where("team_id = ? and leave_date is null", teamID).Find(&activeMembers).Error
+	if err != nil {
+		return err
+	}
+
+	for _, teamMember := range removedTeamMembers(activeMembers, presentUUIDs, presentLogins) {
+		leaveDate := commit.Committer.When
+		oldLevel := teamMember.Level
+		log.Infof(
+			"[Retirement] %s retired from the %s team as %s on %s.",
+			teamMember.DupGitHubLogin, teamName, oldLevel, leaveDate.Format("2006-01-02"),
+		)
+
+		err = db.Model(&model.TeamMember{}).
+			Where("team_id = ? and uuid = ?", teamID, teamMember.UUID).
+			Updates(map[string]interface{}{
+				"leave_date":       leaveDate,
+				"last_update_date": leaveDate,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		changeLog := model.TeamMemberChangeLog{
+			TeamID:         teamID,
+			UUID:           teamMember.UUID,
+			CommitSHA:      commit.Hash.String(),
+			CommitMessage:  commit.Message,
+			ChangedAt:      leaveDate,
+			LevelFrom:      &oldLevel,
+			LevelTo:        nil,
+			DupGitHubID:    teamMember.DupGitHubID,
+			DupGitHubLogin: teamMember.DupGitHubLogin,
+		}
+		err = db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "team_id"}, {Name: "uuid"}, {Name: "commit_sha"},
+			},
+			DoNothing: true,
+		}).Create(&changeLog).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removedTeamMembers - get the active members who are neither matched by the unique identity nor by the login
+// of the members in the membership file, the login is also checked so that the member whose GitHub user failed
+// to be resolved is not retired by mistake.
+func removedTeamMembers(activeMembers []model.TeamMember, presentUUIDs, presentLogins lib.StringSet) []model.TeamMember {
+	removed := make([]model.TeamMember, 0)
+	for _, teamMember := range activeMembers {
+		if _, ok := presentUUIDs[teamMember.UUID]; ok {
+			continue
+		}
+		if _, ok := presentLogins[strings.ToLower(teamMember.DupGitHubLogin)]; ok {
+			continue
+		}
+		removed = append(removed, teamMember)
+	}
+	return removed
+}
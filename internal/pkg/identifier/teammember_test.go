@@ -0,0 +1,120 @@
+package identifier
+
+import (
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/lib"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+)
+
+func TestRemovedTeamMembers(t *testing.T) {
+	activeMembers := []model.TeamMember{
+		{UUID: "uuid-1", DupGitHubLogin: "alice"},
+		{UUID: "uuid-2", DupGitHubLogin: "Bob"},
+		{UUID: "uuid-3", DupGitHubLogin: "carol"},
+	}
+
+	var testcases = []struct {
+		name          string
+		presentUUIDs  lib.StringSet
+		presentLogins lib.StringSet
+		expectUUIDs   []string
+	}{
+		{
+			name:          "all present",
+			presentUUIDs:  lib.StringSet{"uuid-1": {}, "uuid-2": {}, "uuid-3": {}},
+			presentLogins: lib.StringSet{},
+			expectUUIDs:   []string{},
+		},
+		{
+			name:          "matched by the login case-insensitively",
+			presentUUIDs:  lib.StringSet{"uuid-1": {}},
+			presentLogins: lib.StringSet{"bob": {}},
+			expectUUIDs:   []string{"uuid-3"},
+		},
+		{
+			name:          "team deleted",
+			presentUUIDs:  lib.StringSet{},
+			presentLogins: lib.StringSet{},
+			expectUUIDs:   []string{"uuid-1", "uuid-2", "uuid-3"},
+		},
+	}
+	for _, testcase := range testcases {
+		tc := testcase
+		t.Run(tc.name, func(t *testing.T) {
+			removed := removedTeamMembers(activeMembers, tc.presentUUIDs, tc.presentLogins)
+			uuids := make([]string, 0)
+			for _, member := range removed {
+				uuids = append(uuids, member.UUID)
+			}
+			if len(uuids) != len(tc.expectUUIDs) {
+				t.Fatalf("Expect removed %v, but got %v", tc.expectUUIDs, uuids)
+			}
+			for i := range uuids {
+				if uuids[i] != tc.expectUUIDs[i] {
+					t.Errorf("Expect removed %v, but got %v", tc.expectUUIDs, uuids)
+				}
+			}
+		})
+	}
+}
+
+func TestRetireTeamMembers(t *testing.T) {
+	db, fake := openFakeDB(t, []fakeResult{
+		{
+			pattern: `FROM "team_members"`,
+			columns: []string{"team_id", "uuid", "level", "dup_github_id", "dup_github_login"},
+			rows: [][]driver.Value{
+				{int64(1), "uuid-1", "reviewer", int64(10), "alice"},
+				{int64(1), "uuid-2", "committer", int64(20), "bob"},
+			},
+		},
+	})
+
+	leaveDate := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	commit := &object.Commit{
+		Hash:      plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"),
+		Message:   "remove bob",
+		Committer: object.Signature{When: leaveDate},
+	}
+	log := logrus.NewEntry(logrus.New())
+	log.Logger.SetOutput(io.Discard)
+	err := RetireTeamMembers(log, db, 1, "sig-a", lib.StringSet{"uuid-1": {}}, lib.StringSet{"alice": {}}, commit)
+	if err != nil {
+		t.Fatalf("Failed to retire the team members: %v", err)
+	}
+
+	updates := fake.executed(`UPDATE "team_members"`, `"leave_date"=`)
+	if len(updates) != 1 {
+		t.Fatalf("Expect only bob is retired, but got %v", updates)
+	}
+	retired := false
+	for _, arg := range updates[0].args {
+		if arg == "uuid-2" {
+			retired = true
+		}
+	}
+	if !retired {
+		t.Errorf("Expect bob is retired, but got %s %v", updates[0].query, updates[0].args)
+	}
+
+	changeLogs := fake.executed(`INSERT INTO "team_member_change_logs"`)
+	if len(changeLogs) != 1 {
+		t.Fatalf("Expect 1 changelog, but got %v", changeLogs)
+	}
+	hasCommit := false
+	for _, arg := range changeLogs[0].args {
+		if arg == commit.Hash.String() {
+			hasCommit = true
+		}
+	}
+	if !hasCommit {
+		t.Errorf("Expect the changelog of commit %s, but got %v", commit.Hash, changeLogs[0].args)
+	}
+}
@@ -0,0 +1,320 @@
+package identifier
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+const teamMetricsDateLayout = "2006-01-02"
+
+// MaxTeamMetricsDays is the upper bound of the period of the team metrics, the pull requests of the period are
+// scanned in the project database.
+const MaxTeamMetricsDays = 366
+
+// ErrTeamNotFound is returned when the team of the metrics is not found.
+var ErrTeamNotFound = errors.New("team is not found")
+
+// ErrTeamAmbiguous is returned when the project is not given and more than one project has a team of the name.
+var ErrTeamAmbiguous = errors.New("team name is ambiguous, the project is required")
+
+// reviewEventTypes are the event types of gha_pull_requests regarded as the reviews.
+var reviewEventTypes = []string{"PullRequestReviewEvent", "PullRequestReviewCommentEvent"}
+
+// TeamMetrics - the activity of a team in the period [From, To), the activity comes from the project database of
+// the team, the PRs and the reviews are limited to the repositories of the team.
+type TeamMetrics struct {
+	Team         string    `json:"team"`
+	Project      string    `json:"project"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	InactiveDays int       `json:"inactive_days"`
+
+	MergedPRs    int                     `json:"merged_prs"`
+	Repositories []TeamRepositoryMetrics `json:"repositories"`
+	// Members are ordered by the number of reviewed PRs in descending order, so the overloaded reviewers come first.
+	Members []TeamMemberMetrics `json:"members"`
+	// InactiveMembers are the logins of the members without any activity in the project for InactiveDays days.
+	InactiveMembers []string `json:"inactive_members"`
+}
+
+// TeamRepositoryMetrics - the activity of a repository of the team.
+type TeamRepositoryMetrics struct {
+	ID        uint   `json:"id"`
+	Owner     string `json:"owner"`
+	Name      string `json:"name"`
+	MergedPRs int    `json:"merged_prs"`
+}
+
+// TeamMemberMetrics - the review load and the activity of a team member.
+type TeamMemberMetrics struct {
+	GitHubID     uint            `json:"github_id"`
+	GitHubLogin  string          `json:"github_login"`
+	Level        model.TeamLevel `json:"level"`
+	ReviewedPRs  int             `json:"reviewed_prs"`
+	ReviewEvents int             `json:"review_events"`
+	// MedianResponseHours is the median hours from the creation of the PRs to the first review of the member, it
+	// is nil if the member has not reviewed any PR created in the period.
+	MedianResponseHours *float64   `json:"median_response_hours"`
+	LastActivityAt      *time.Time `json:"last_activity_at"`
+	Inactive            bool       `json:"inactive"`
+}
+
+// ParseTeamMetricsPeriod - parse the period of the team metrics from the dates in the format of YYYY-MM-DD, both
+// dates are inclusive. The period ends now if to date is empty, and lasts defaultDays if from date is empty, the
+// period can not be longer than MaxTeamMetricsDays.
+func ParseTeamMetricsPeriod(fromDate, toDate string, defaultDays int, now time.Time) (time.Time, time.Time, error) {
+	var from, to time.Time
+	if defaultDays > MaxTeamMetricsDays {
+		defaultDays = MaxTeamMetricsDays
+	}
+	to = now.UTC()
+	if len(toDate) != 0 {
+		date, err := time.Parse(teamMetricsDateLayout, toDate)
+		if err != nil {
+			return from, to, fmt.Errorf("wrong to date %s, it must be YYYY-MM-DD", toDate)
+		}
+		to = date.AddDate(0, 0, 1)
+	}
+	from = to.AddDate(0, 0, -defaultDays)
+	if len(fromDate) != 0 {
+		date, err := time.Parse(teamMetricsDateLayout, fromDate)
+		if err != nil {
+			return from, to, fmt.Errorf("wrong from date %s, it must be YYYY-MM-DD", fromDate)
+		}
+		from = date
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from date %s is after to date %s", fromDate, toDate)
+	}
+	if to.Sub(from) > MaxTeamMetricsDays*24*time.Hour {
+		return from, to, fmt.Errorf("the period must not be longer than %d days", MaxTeamMetricsDays)
+	}
+	return from, to, nil
+}
+
+// reviewLoad - the reviews of a member on the team repositories.
+type reviewLoad struct {
+	ActorID      uint
+	ReviewedPRs  int
+	ReviewEvents int
+}
+
+// FindTeam - find the team with the project and the repositories by the names, the team names are only unique in
+// a project, so the project can only be omitted when no other project has a team of the name.
+func FindTeam(db *gorm.DB, projectName, teamName string) (*model.Team, error) {
+	query := db.Preload("Project").Preload("Repositories").Where("name = ?", teamName)
+	if len(projectName) != 0 {
+		query = query.Where("project_id in (?)", db.Model(&model.Project{}).Select("id").Where("name = ?", projectName))
+	}
+
+	var teams []model.Team
+	err := query.Order("id").Limit(2).Find(&teams).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(teams) == 0 {
+		if len(projectName) != 0 {
+			return nil, fmt.Errorf("%w: %s/%s", ErrTeamNotFound, projectName, teamName)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrTeamNotFound, teamName)
+	}
+	if len(teams) > 1 {
+		return nil, fmt.Errorf("%w: %s", ErrTeamAmbiguous, teamName)
+	}
+	return &teams[0], nil
+}
+
+// ComputeTeamMetrics - compute the activity metrics of the team, which is used to find the inactive members and the
+// overloaded reviewers.
+func ComputeTeamMetrics(
+	db *gorm.DB, projectDBs map[string]*gorm.DB, projectName, teamName string, from, to time.Time, inactiveDays int,
+) (*TeamMetrics, error) {
+	team, err := FindTeam(db, projectName, teamName)
+	if err != nil {
+		return nil, err
+	}
+
+	projectDB, ok := projectDBs[team.Project.Name]
+	if !ok {
+		return nil, fmt.Errorf("there are no project database named %s", team.Project.Name)
+	}
+
+	var teamMembers []model.TeamMember
+	err = db.Where("team_id = ? and leave_date is null", team.ID).Find(&teamMembers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := &TeamMetrics{
+		Team:         team.Name,
+		Project:      team.Project.Name,
+		From:         from,
+		To:           to,
+		InactiveDays: inactiveDays,
+		Repositories: make([]TeamRepositoryMetrics, 0),
+	}
+
+	repoIDs := make([]uint, 0, len(team.Repositories))
+	for _, repository := range team.Repositories {
+		repoIDs = append(repoIDs, repository.ID)
+	}
+	memberIDs := make([]uint, 0, len(teamMembers))
+	for _, teamMember := range teamMembers {
+		memberIDs = append(memberIDs, teamMember.DupGitHubID)
+	}
+
+	// Merged PRs of the team repositories.
+	repoID2merged := make(map[uint]int)
+	if len(repoIDs) != 0 {
+		var rows []struct {
+			RepoID    uint
+			MergedPRs int
+		}
+		err = projectDB.Raw(`
+select dup_repo_id as repo_id, count(distinct id) as merged_prs
+from gha_pull_requests
+where merged_at >= ? and merged_at < ? and dup_repo_id in ?
+group by dup_repo_id
+`, from, to, repoIDs).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			repoID2merged[row.RepoID] = row.MergedPRs
+		}
+	}
+	for _, repository := range team.Repositories {
+		metrics.Repositories = append(metrics.Repositories, TeamRepositoryMetrics{
+			ID:        repository.ID,
+			Owner:     repository.Owner,
+			Name:      repository.Name,
+			MergedPRs: repoID2merged[repository.ID],
+		})
+		metrics.MergedPRs += repoID2merged[repository.ID]
+	}
+
+	// Review load and response time of the members on the team repositories.
+	type responseTime struct {
+		ActorID     uint
+		MedianHours float64
+	}
+	var reviewLoads []reviewLoad
+	var responseTimes []responseTime
+	if len(repoIDs) != 0 && len(memberIDs) != 0 {
+		err = projectDB.Raw(`
+select dup_actor_id as actor_id, count(distinct id) as reviewed_prs, count(distinct event_id) as review_events
+from gha_pull_requests
+where
+    dup_type in ? and dup_actor_id != user_id
+    and dup_created_at >= ? and dup_created_at < ?
+    and dup_repo_id in ? and dup_actor_id in ?
+group by dup_actor_id
+`, reviewEventTypes, from, to, repoIDs, memberIDs).Scan(&reviewLoads).Error
+		if err != nil {
+			return nil, err
+		}
+
+		err = projectDB.Raw(`
+with prs as (
+    select id, min(created_at) as created_at
+    from gha_pull_requests
+    where created_at >= ? and created_at < ? and dup_repo_id in ?
+    group by id
+), first_reviews as (
+    select r.dup_actor_id as actor_id, pr.id, min(r.dup_created_at) - min(pr.created_at) as response_time
+    from prs pr join gha_pull_requests r on r.id = pr.id
+    where r.dup_type in ? and r.dup_actor_id != r.user_id and r.dup_actor_id in ?
+    group by r.dup_actor_id, pr.id
+)
+select
+    actor_id,
+    percentile_cont(0.5) within group (order by extract(epoch from response_time) / 3600) as median_hours
+from first_reviews
+group by actor_id
+`, from, to, repoIDs, reviewEventTypes, memberIDs).Scan(&responseTimes).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	id2load := make(map[uint]reviewLoad)
+	for _, load := range reviewLoads {
+		id2load[load.ActorID] = load
+	}
+	id2response := make(map[uint]float64)
+	for _, response := range responseTimes {
+		id2response[response.ActorID] = math.Round(response.MedianHours*100) / 100
+	}
+
+	// The last activity of the members in the whole project.
+	id2lastActivity := make(map[uint]time.Time)
+	if len(memberIDs) != 0 {
+		var rows []struct {
+			ActorID        uint
+			LastActivityAt time.Time
+		}
+		err = projectDB.Raw(`
+select actor_id, max(created_at) as last_activity_at
+from gha_events
+where actor_id in ?
+group by actor_id
+`, memberIDs).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			id2lastActivity[row.ActorID] = row.LastActivityAt
+		}
+	}
+
+	metrics.Members, metrics.InactiveMembers = summarizeTeamMembers(
+		teamMembers, id2load, id2response, id2lastActivity, to.AddDate(0, 0, -inactiveDays),
+	)
+
+	return metrics, nil
+}
+
+// summarizeTeamMembers - merge the review loads, the response times and the last activities into the metrics of the
+// members, the members without any activity since inactiveSince are inactive.
+func summarizeTeamMembers(
+	teamMembers []model.TeamMember, id2load map[uint]reviewLoad, id2response map[uint]float64,
+	id2lastActivity map[uint]time.Time, inactiveSince time.Time,
+) ([]TeamMemberMetrics, []string) {
+	members := make([]TeamMemberMetrics, 0, len(teamMembers))
+	inactiveMembers := make([]string, 0)
+	for _, teamMember := range teamMembers {
+		memberMetrics := TeamMemberMetrics{
+			GitHubID:     teamMember.DupGitHubID,
+			GitHubLogin:  teamMember.DupGitHubLogin,
+			Level:        teamMember.Level,
+			ReviewedPRs:  id2load[teamMember.DupGitHubID].ReviewedPRs,
+			ReviewEvents: id2load[teamMember.DupGitHubID].ReviewEvents,
+			Inactive:     true,
+		}
+		if hours, ok := id2response[teamMember.DupGitHubID]; ok {
+			memberMetrics.MedianResponseHours = &hours
+		}
+		if lastActivityAt, ok := id2lastActivity[teamMember.DupGitHubID]; ok {
+			memberMetrics.LastActivityAt = &lastActivityAt
+			memberMetrics.Inactive = lastActivityAt.Before(inactiveSince)
+		}
+		if memberMetrics.Inactive {
+			inactiveMembers = append(inactiveMembers, memberMetrics.GitHubLogin)
+		}
+		members = append(members, memberMetrics)
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].ReviewedPRs != members[j].ReviewedPRs {
+			return members[i].ReviewedPRs > members[j].ReviewedPRs
+		}
+		return members[i].GitHubLogin < members[j].GitHubLogin
+	})
+	sort.Strings(inactiveMembers)
+	return members, inactiveMembers
+}
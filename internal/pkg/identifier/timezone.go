@@ -0,0 +1,150 @@
+package identifier
+
+import (
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+// localActivityCenterHour is the local time (in hours) around which the activities of contributors are centered,
+// most of the activities happen between the morning and the late evening.
+const localActivityCenterHour = 15.5
+
+// The activities are counted by the quarter of an hour, so that the offsets like +05:30 and +05:45 are inferred.
+const (
+	slotMinutes = 15
+	slotsPerDay = 24 * 60 / slotMinutes
+)
+
+// activitySlots - the time-of-day (UTC) distribution of the activities of a GitHub user, the slot is the index of
+// the quarter of an hour in the day.
+type activitySlots struct {
+	ActorID uint
+	Slot    int
+	N       int
+}
+
+// inferUTCOffset - infer the UTC offset in minutes from the time-of-day (UTC) distribution of the activities.
+// The circular mean of the slots is regarded as the local activity center, and the confidence is the
+// concentration of the slots, between 0 (evenly distributed) and 1 (all in the same slot).
+func inferUTCOffset(slots [slotsPerDay]int, minActivities int) (offset int, confidence float64, ok bool) {
+	n := 0
+	var x, y float64
+	for slot, count := range slots {
+		// The middle of the slot is used, the activities are evenly distributed in the slot.
+		angle := 2 * math.Pi * (float64(slot) + 0.5) / slotsPerDay
+		x += float64(count) * math.Cos(angle)
+		y += float64(count) * math.Sin(angle)
+		n += count
+	}
+	if n == 0 || n < minActivities {
+		return 0, 0, false
+	}
+
+	confidence = math.Hypot(x, y) / float64(n)
+	utcCenterHour := math.Atan2(y, x) * 24 / (2 * math.Pi)
+
+	// Notice: The offset is rounded to the quarter of an hour and normalized to (-12:00, +12:00], so +13:00 and
+	// +14:00 are indistinguishable from -11:00 and -10:00.
+	offsetSlots := int(math.Round((localActivityCenterHour - utcCenterHour) * 60 / slotMinutes))
+	offsetSlots = ((offsetSlots % slotsPerDay) + slotsPerDay) % slotsPerDay
+	if offsetSlots > slotsPerDay/2 {
+		offsetSlots -= slotsPerDay
+	}
+
+	return offsetSlots * slotMinutes, math.Round(confidence*100) / 100, true
+}
+
+// InferTimezones - infer the UTC offsets of the identities from the time-of-day distribution of their GitHub
+// events, the manually provided values, bots and the opted out identities are skipped.
+func InferTimezones(log *logrus.Entry, ctx *Ctx, db *gorm.DB, dataSource *gorm.DB) error {
+	// Notice: gha_commits only keeps the time of the push event rather than the author date with the offset of
+	// the committer, so the commits are already counted by the push events.
+	query := `
+select
+    actor_id,
+    cast(extract(hour from created_at) * 4 + floor(extract(minute from created_at) / 15) as int) as slot,
+    count(*) as n
+from gha_events
+where actor_id > 0`
+	args := make([]interface{}, 0)
+	if ctx.TzInferenceDays > 0 {
+		query += " and created_at >= ?"
+		args = append(args, time.Now().AddDate(0, 0, -ctx.TzInferenceDays))
+	}
+	query += " group by actor_id, slot"
+
+	var rows []activitySlots
+	err := dataSource.Raw(query, args...).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	githubID2slots := make(map[uint]*[slotsPerDay]int)
+	for _, row := range rows {
+		if row.Slot < 0 || row.Slot >= slotsPerDay {
+			continue
+		}
+		slots, ok := githubID2slots[row.ActorID]
+		if !ok {
+			slots = &[slotsPerDay]int{}
+			githubID2slots[row.ActorID] = slots
+		}
+		slots[row.Slot] += row.N
+	}
+
+	var githubUsers []model.GitHubUser
+	err = db.Select("id", "uuid").Where("uuid != ''").Find(&githubUsers).Error
+	if err != nil {
+		return err
+	}
+	uuid2slots := make(map[string]*[slotsPerDay]int)
+	for _, githubUser := range githubUsers {
+		slots, ok := githubID2slots[githubUser.ID]
+		if !ok {
+			continue
+		}
+		merged, ok := uuid2slots[githubUser.UUID]
+		if !ok {
+			merged = &[slotsPerDay]int{}
+			uuid2slots[githubUser.UUID] = merged
+		}
+		for slot, count := range slots {
+			merged[slot] += count
+		}
+	}
+
+	var uniqueIdentities []model.UniqueIdentity
+	db.Where(
+		"is_bot = ? and opted_out = ? and (tz_source is null or tz_source not in ?)",
+		false, false, []model.ProfileSource{model.ManualSource, model.UserManualSource},
+	).Find(&uniqueIdentities)
+
+	nInferred := 0
+	for _, uniqueIdentity := range uniqueIdentities {
+		slots, ok := uuid2slots[uniqueIdentity.UUID]
+		if !ok {
+			continue
+		}
+		offset, confidence, ok := inferUTCOffset(*slots, ctx.TzMinActivities)
+		if !ok {
+			continue
+		}
+
+		err = db.Model(&model.UniqueIdentity{}).Where("uuid = ?", uniqueIdentity.UUID).Updates(map[string]interface{}{
+			"tz_offset":     offset,
+			"tz_confidence": confidence,
+			"tz_source":     model.ActivityHoursSource,
+		}).Error
+		if err != nil {
+			return err
+		}
+		nInferred++
+	}
+
+	log.Infof("Inferred the timezones of %d/%d identities.", nInferred, len(uniqueIdentities))
+	return nil
+}
@@ -0,0 +1,294 @@
+package identifier
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ti-community-infra/devstats/internal/pkg/storage/model"
+	"gorm.io/gorm"
+)
+
+const (
+	OrgIssueError   = "error"
+	OrgIssueWarning = "warning"
+)
+
+// OrgConfigIssue - a problem found in the organizations.yaml file.
+type OrgConfigIssue struct {
+	Level   string
+	OrgName string
+	Message string
+}
+
+// OrgSampleMapping - the organizations that a company name of the GitHub profile maps to.
+type OrgSampleMapping struct {
+	Company  string
+	Count    int
+	OrgNames []string
+}
+
+// compiledOrgPattern is the org pattern compiled in the same way as the identifier does.
+type compiledOrgPattern struct {
+	orgName string
+	pattern string
+	reg     *regexp.Regexp
+}
+
+var validOrgTypes = map[model.OrganizationType]bool{
+	"":                      true,
+	model.OrgTypeCompany:    true,
+	model.OrgTypeEducation:  true,
+	model.OrgTypeOpenSource: true,
+	model.OrgTypeIndividual: true,
+}
+
+// ValidateOrgs - validate the organizations.yaml file, and show which organizations the sample company names
+// of GitHub profiles map to, return false if there are any errors.
+func ValidateOrgs(log *logrus.Entry, ctx *Ctx, db *gorm.DB, out io.Writer) bool {
+	orgConfig, err := loadOrgMappingsFromYaml(ctx.OrganizationsFilePath)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to load org mapping config from yaml file: %s", ctx.OrganizationsFilePath)
+		return false
+	}
+
+	issues, patterns := validateOrgConfig(orgConfig)
+
+	var samples []OrgSampleMapping
+	if db != nil && ctx.ValidateOrgsSampleSize > 0 {
+		var companies []struct {
+			Company string
+			Count   int
+		}
+		db.Raw(`
+select company, count(*) as count from github_users
+where company is not null and company != ''
+group by company order by count desc, company limit ?
+`, ctx.ValidateOrgsSampleSize).Scan(&companies)
+
+		for _, company := range companies {
+			orgNames := matchOrgNames(patterns, company.Company)
+			samples = append(samples, OrgSampleMapping{
+				Company:  company.Company,
+				Count:    company.Count,
+				OrgNames: orgNames,
+			})
+			if len(orgNames) > 1 {
+				issues = append(issues, OrgConfigIssue{
+					Level:   OrgIssueWarning,
+					OrgName: strings.Join(orgNames, ", "),
+					Message: fmt.Sprintf("company %q matches the patterns of %d orgs", company.Company, len(orgNames)),
+				})
+			}
+		}
+	}
+
+	nErrors := 0
+	_, _ = fmt.Fprintf(out, "Validated %d organizations in %s.\n", len(orgConfig.OrgMappings), ctx.OrganizationsFilePath)
+	for _, issue := range issues {
+		if issue.Level == OrgIssueError {
+			nErrors++
+		}
+		_, _ = fmt.Fprintf(out, "[%s] %s: %s\n", issue.Level, issue.OrgName, issue.Message)
+	}
+
+	if len(samples) > 0 {
+		_, _ = fmt.Fprintf(out, "\nMapping of %d sample companies from GitHub profiles:\n", len(samples))
+		for _, sample := range samples {
+			// The unmatched companies of GitHub profiles are put into the pending-review queue.
+			orgName := "(pending review)"
+			if len(sample.OrgNames) != 0 {
+				orgName = strings.Join(sample.OrgNames, " | ")
+			}
+			_, _ = fmt.Fprintf(out, "%6d  %s => %s\n", sample.Count, sample.Company, orgName)
+		}
+	}
+
+	_, _ = fmt.Fprintf(out, "\nFound %d errors and %d warnings.\n", nErrors, len(issues)-nErrors)
+	return nErrors == 0
+}
+
+// validateOrgConfig - check the org config for invalid patterns, duplicate domains, overlapping patterns and
+// unknown organization types, the compiled patterns are returned for the further mapping.
+func validateOrgConfig(orgConfig OrgConfig) ([]OrgConfigIssue, []compiledOrgPattern) {
+	issues := make([]OrgConfigIssue, 0)
+	patterns := make([]compiledOrgPattern, 0)
+	orgNames := make(map[string]string)
+	domain2orgs := make(map[string][]string)
+	pattern2orgs := make(map[string][]string)
+
+	for _, mapping := range orgConfig.OrgMappings {
+		if len(strings.TrimSpace(mapping.Name)) == 0 {
+			issues = append(issues, OrgConfigIssue{
+				Level: OrgIssueError, OrgName: "(empty)", Message: "organization name is empty",
+			})
+			continue
+		}
+
+		// Notice: The organization names only differ in case are regarded as the same one by MySQL.
+		lowerName := strings.ToLower(mapping.Name)
+		if definedName, ok := orgNames[lowerName]; ok {
+			issue := OrgConfigIssue{
+				Level:   OrgIssueError,
+				OrgName: mapping.Name,
+				Message: "organization is defined more than once",
+			}
+			if definedName != mapping.Name {
+				issue.Level = OrgIssueWarning
+				issue.Message = fmt.Sprintf("organization name only differs in case from %q", definedName)
+			}
+			issues = append(issues, issue)
+		} else {
+			orgNames[lowerName] = mapping.Name
+		}
+
+		if !validOrgTypes[mapping.Type] {
+			issues = append(issues, OrgConfigIssue{
+				Level:   OrgIssueError,
+				OrgName: mapping.Name,
+				Message: fmt.Sprintf("unknown organization type %q", mapping.Type),
+			})
+		}
+
+		for _, pattern := range mapping.Patterns {
+			if len(pattern) == 0 {
+				continue
+			}
+			reg, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				issues = append(issues, OrgConfigIssue{
+					Level:   OrgIssueError,
+					OrgName: mapping.Name,
+					Message: fmt.Sprintf("invalid pattern %q: %v", pattern, err),
+				})
+				continue
+			}
+			patterns = append(patterns, compiledOrgPattern{orgName: mapping.Name, pattern: pattern, reg: reg})
+			pattern2orgs[pattern] = append(pattern2orgs[pattern], mapping.Name)
+		}
+
+		for _, domain := range mapping.Domains {
+			name := strings.ToLower(strings.TrimSpace(domain.Name))
+			if len(name) == 0 {
+				continue
+			}
+			domain2orgs[name] = append(domain2orgs[name], mapping.Name)
+		}
+	}
+
+	for _, domain := range sortedKeys(domain2orgs) {
+		if orgs := uniqueStrings(domain2orgs[domain]); len(orgs) > 1 {
+			issues = append(issues, OrgConfigIssue{
+				Level:   OrgIssueError,
+				OrgName: strings.Join(orgs, ", "),
+				Message: fmt.Sprintf("domain %q is used by %d orgs", domain, len(orgs)),
+			})
+		}
+	}
+
+	for _, pattern := range sortedKeys(pattern2orgs) {
+		if orgs := uniqueStrings(pattern2orgs[pattern]); len(orgs) > 1 {
+			issues = append(issues, OrgConfigIssue{
+				Level:   OrgIssueError,
+				OrgName: strings.Join(orgs, ", "),
+				Message: fmt.Sprintf("pattern %q is used by %d orgs", pattern, len(orgs)),
+			})
+		}
+	}
+
+	// The parents of an organization should be valid relations.
+	for _, mapping := range orgConfig.OrgMappings {
+		for _, parent := range mapping.Parents {
+			issues = append(issues, validateOrgParent(mapping.Name, parent, orgNames)...)
+		}
+	}
+
+	// The name of an organization should not be matched by the patterns of other organizations.
+	for _, mapping := range orgConfig.OrgMappings {
+		for _, name := range []string{mapping.Name, mapping.Fullname} {
+			if len(name) == 0 {
+				continue
+			}
+			for _, orgName := range matchOrgNames(patterns, name) {
+				if orgName == mapping.Name {
+					continue
+				}
+				issues = append(issues, OrgConfigIssue{
+					Level:   OrgIssueWarning,
+					OrgName: mapping.Name,
+					Message: fmt.Sprintf("name %q matches the patterns of org %q", name, orgName),
+				})
+			}
+		}
+	}
+
+	return issues, patterns
+}
+
+func validateOrgParent(orgName string, parent OrgParentMapping, orgNames map[string]string) []OrgConfigIssue {
+	issues := make([]OrgConfigIssue, 0)
+	newIssue := func(level, format string, a ...interface{}) {
+		issues = append(issues, OrgConfigIssue{Level: level, OrgName: orgName, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if strings.EqualFold(parent.Name, orgName) {
+		newIssue(OrgIssueError, "organization can not be the parent of itself")
+	} else if _, ok := orgNames[strings.ToLower(parent.Name)]; !ok {
+		newIssue(OrgIssueWarning, "parent %q is not defined, it will be created", parent.Name)
+	}
+	if len(parent.Type) != 0 && parent.Type != model.OrgRelationSubsidiary && parent.Type != model.OrgRelationAcquisition {
+		newIssue(OrgIssueError, "unknown relation type %q of parent %q", parent.Type, parent.Name)
+	}
+
+	startDate, err := parseOrgRelationDate(parent.StartDate, model.DefaultStartDate)
+	if err != nil {
+		newIssue(OrgIssueError, "invalid start date %q of parent %q", parent.StartDate, parent.Name)
+		return issues
+	}
+	endDate, err := parseOrgRelationDate(parent.EndDate, model.DefaultEndDate)
+	if err != nil {
+		newIssue(OrgIssueError, "invalid end date %q of parent %q", parent.EndDate, parent.Name)
+		return issues
+	}
+	if !startDate.Before(endDate) {
+		newIssue(OrgIssueError, "start date of parent %q is not before the end date", parent.Name)
+	}
+
+	return issues
+}
+
+// matchOrgNames - get the names of all the organizations whose patterns match the company name.
+func matchOrgNames(patterns []compiledOrgPattern, company string) []string {
+	company = strings.TrimSpace(company)
+	orgNames := make([]string, 0)
+	for _, pattern := range patterns {
+		if pattern.reg.MatchString(company) {
+			orgNames = append(orgNames, pattern.orgName)
+		}
+	}
+	return uniqueStrings(orgNames)
+}
+
+func uniqueStrings(arr []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(arr))
+	for _, item := range arr {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// maxBatchArgs - Postgres limit of the number of arguments in a single statement
+const maxBatchArgs = 65535
+
+// BatchInsert - rows accumulated per table and inserted by multi-row insert statements
+// Rows are inserted in the order they were added, so "insert ignore" keeps the first of the conflicting rows, the
+// same way as when the rows are inserted one by one
+type BatchInsert struct {
+	tables []*batchTable
+	index  map[string]*batchTable
+	rows   int
+}
+
+// batchTable - rows of "table(col1, col2, ..., colN)" with or without "on conflict do nothing"
+// keys are the groups of the rows, empty key means the row does not belong to any group
+type batchTable struct {
+	into   string
+	ignore bool
+	ncols  int
+	rows   [][]interface{}
+	keys   []string
+}
+
+// Add - add a row into "table(col1, col2, ..., colN)", ignore means "on conflict do nothing"
+func (b *BatchInsert) Add(into string, ignore bool, args ...interface{}) {
+	b.AddKeyed("", into, ignore, args...)
+}
+
+// AddKeyed - add a row of the group given by key (for example event ID), so all rows of the group can be dropped
+func (b *BatchInsert) AddKeyed(key, into string, ignore bool, args ...interface{}) {
+	index := into
+	if ignore {
+		index = "ignore " + into
+	}
+	table, ok := b.index[index]
+	if !ok {
+		if b.index == nil {
+			b.index = make(map[string]*batchTable)
+		}
+		table = &batchTable{into: into, ignore: ignore, ncols: len(args)}
+		b.index[index] = table
+		b.tables = append(b.tables, table)
+	}
+	if len(args) != table.ncols {
+		Fatalf("%s: expected %d values, got %d", into, table.ncols, len(args))
+	}
+	table.rows = append(table.rows, args)
+	table.keys = append(table.keys, key)
+	b.rows++
+}
+
+// Drop - remove all rows of the given groups, returns the number of rows removed
+func (b *BatchInsert) Drop(keys map[string]struct{}) (n int) {
+	if len(keys) == 0 {
+		return
+	}
+	for _, table := range b.tables {
+		rows := table.rows[:0]
+		tableKeys := table.keys[:0]
+		for i, row := range table.rows {
+			if _, drop := keys[table.keys[i]]; drop && table.keys[i] != "" {
+				n++
+				continue
+			}
+			rows = append(rows, row)
+			tableKeys = append(tableKeys, table.keys[i])
+		}
+		table.rows = rows
+		table.keys = tableKeys
+	}
+	b.rows -= n
+	return
+}
+
+// Len - number of rows not inserted yet
+func (b *BatchInsert) Len() int {
+	return b.rows
+}
+
+// Statements - multi-row insert statements of all the rows with their arguments, tables are in the order of their
+// first row, each statement is limited to maxBatchArgs arguments
+func (b *BatchInsert) Statements() (queries []string, args [][]interface{}) {
+	for _, table := range b.tables {
+		perStatement := maxBatchArgs / table.ncols
+		for from := 0; from < len(table.rows); from += perStatement {
+			to := from + perStatement
+			if to > len(table.rows) {
+				to = len(table.rows)
+			}
+			values := make([]string, 0, to-from)
+			stmtArgs := make([]interface{}, 0, (to-from)*table.ncols)
+			for i, row := range table.rows[from:to] {
+				values = append(values, NArray(table.ncols, i*table.ncols))
+				stmtArgs = append(stmtArgs, row...)
+			}
+			query := "into " + table.into + " values" + strings.Join(values, ",")
+			if table.ignore {
+				query = InsertIgnore(query)
+			} else {
+				query = "insert " + query
+			}
+			queries = append(queries, query)
+			args = append(args, stmtArgs)
+		}
+	}
+	return
+}
+
+// Flush - insert all the rows in a single transaction and reset the batch
+func (b *BatchInsert) Flush(db *sql.DB, ctx *Ctx) {
+	b.FlushWith(db, ctx, nil)
+}
+
+// FlushWith - same as Flush, but prepare is called in the transaction before the rows are inserted, so it can Drop
+// the rows which must not be inserted anymore
+func (b *BatchInsert) FlushWith(db *sql.DB, ctx *Ctx, prepare func(con *sql.Tx)) {
+	if b.rows == 0 {
+		return
+	}
+	con, err := db.Begin()
+	FatalOnError(err)
+	if prepare != nil {
+		prepare(con)
+	}
+	queries, args := b.Statements()
+	for i, query := range queries {
+		ExecSQLTxWithErr(con, ctx, query, args[i]...)
+	}
+	FatalOnError(con.Commit())
+	b.tables = nil
+	b.index = nil
+	b.rows = 0
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchInsertStatements(t *testing.T) {
+	var batch BatchInsert
+	batch.Add("gha_events(id, type)", false, "1", "PushEvent")
+	batch.Add("gha_actors(id, login)", true, 1, "alice")
+	batch.Add("gha_events(id, type)", false, "2", "IssuesEvent")
+	batch.Add("gha_actors(id, login)", true, 1, "alice-renamed")
+	if batch.Len() != 4 {
+		t.Errorf("expected 4 rows, got %d", batch.Len())
+	}
+
+	queries, args := batch.Statements()
+	expectedQueries := []string{
+		"insert into gha_events(id, type) values($1, $2),($3, $4)",
+		"insert into gha_actors(id, login) values($1, $2),($3, $4) on conflict do nothing",
+	}
+	expectedArgs := [][]interface{}{
+		{"1", "PushEvent", "2", "IssuesEvent"},
+		{1, "alice", 1, "alice-renamed"},
+	}
+	if !reflect.DeepEqual(queries, expectedQueries) {
+		t.Errorf("expected queries %v, got %v", expectedQueries, queries)
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected args %v, got %v", expectedArgs, args)
+	}
+}
+
+func TestBatchInsertArgsLimit(t *testing.T) {
+	var batch BatchInsert
+	n := maxBatchArgs/3 + 10
+	for i := 0; i < n; i++ {
+		batch.Add("gha_labels(id, name, color)", true, i, "bug", "ff0000")
+	}
+	queries, args := batch.Statements()
+	if len(queries) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(queries))
+	}
+	if len(args[0]) > maxBatchArgs || len(args[0])+len(args[1]) != 3*n {
+		t.Errorf("expected %d args split within the limit, got %d and %d", 3*n, len(args[0]), len(args[1]))
+	}
+	if args[1][0] != maxBatchArgs/3 {
+		t.Errorf("expected the second statement to start from row %d, got %v", maxBatchArgs/3, args[1][0])
+	}
+}
+
+func TestBatchInsertDrop(t *testing.T) {
+	// Event "1" was written by the previous batch of another process after this batch checked it
+	var batch BatchInsert
+	batch.AddKeyed("1", "gha_events(id, type)", false, "1", "PushEvent")
+	batch.Add("gha_actors(id, login)", true, 1, "alice")
+	batch.AddKeyed("1", "gha_payloads(event_id, push_id)", false, "1", 10)
+	batch.AddKeyed("2", "gha_events(id, type)", false, "2", "IssuesEvent")
+	batch.AddKeyed("2", "gha_payloads(event_id, push_id)", false, "2", nil)
+
+	if n := batch.Drop(map[string]struct{}{"1": {}, "": {}}); n != 2 {
+		t.Errorf("expected 2 rows dropped, got %d", n)
+	}
+	if batch.Len() != 3 {
+		t.Errorf("expected 3 rows, got %d", batch.Len())
+	}
+
+	queries, args := batch.Statements()
+	expectedQueries := []string{
+		"insert into gha_events(id, type) values($1, $2)",
+		"insert into gha_actors(id, login) values($1, $2) on conflict do nothing",
+		"insert into gha_payloads(event_id, push_id) values($1, $2)",
+	}
+	expectedArgs := [][]interface{}{
+		{"2", "IssuesEvent"},
+		{1, "alice"},
+		{"2", nil},
+	}
+	if !reflect.DeepEqual(queries, expectedQueries) {
+		t.Errorf("expected queries %v, got %v", expectedQueries, queries)
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected args %v, got %v", expectedArgs, args)
+	}
+}
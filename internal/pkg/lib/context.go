@@ -106,7 +106,7 @@ type Ctx struct {
 	ActorsForbid             *regexp.Regexp               // From GHA2DB_ACTORS_FORBID, gha2db tool, process JSON if actor doesn't match this regexp, default "" which means skip this check
 	SkipMetrics              map[string]bool              // From GHA2DB_SKIP_METRICS, gha2db_sync tool, default "" - comma separated list of metrics to skip, as given by "sql: name" in the "metrics.yaml" file. Those metrics will be skipped.
 	OnlyMetrics              map[string]bool              // From GHA2DB_ONLY_METRICS, gha2db_sync tool, default "" - comma separated list of metrics to process, as given by "sql: name" in the "metrics.yaml" file. Only those metrics will be calculated.
-	AllowBrokenJSON          bool                         // From GHA2DB_ALLOW_BROKEN_JSON, gha2db tool, default false. If set then gha2db skips broken jsons and saves them as jsons/error_YYYY-MM-DD-h-n-m.json (n is the JSON number (1-m) of m JSONS array)
+	AllowBrokenJSON          bool                         // From GHA2DB_ALLOW_BROKEN_JSON, gha2db tool, default false. If set then gha2db skips broken jsons after they are dead-lettered, otherwise it stops on them
 	JSONsDir                 string                       // From GHA2DB_JSONS_DIR, website_data tool, default "./jsons/"
 	WebsiteData              bool                         // From GHA2DB_WEBSITEDATA, devstats tool, run website_data just after sync is complete, default false.
 	SkipUpdateEvents         bool                         // From GHA2DB_SKIP_UPDATE_EVENTS, ghapi2db tool, drop and recreate artificial events if their state differs, default false
@@ -138,6 +138,15 @@ type Ctx struct {
 	ESBulkSize               int                          // From GHA2DB_ES_BULK_SIZE, calc_metric and gha2es tools, default 10000
 	HTTPTimeout              int                          // From GHA2DB_HTTP_TIMEOUT, gha2db - data.gharchive.org timeout value in minutes, default 2
 	HTTPRetry                int                          // From GHA2DB_HTTP_RETRY, gha2db - data.gharchive.org data fetch retries, default 4 (each retry takes 1*timeout*N), so in default config it will try timeouts: 1min, 2min, 3min, but if timeout is 3 and retry is 2, it will try 3min, 6min
+	GHASource                string                       // From GHA2DB_GHA_SOURCE, gha2db - source of GHA files: "http", "dir" or "s3", default "http"
+	GHAURL                   string                       // From GHA2DB_GHA_URL, gha2db - base URL of GHA files used by "http" source, default "http://data.gharchive.org/"
+	GHADir                   string                       // From GHA2DB_GHA_DIR, gha2db - directory (or mounted mirror) of YYYY-MM-DD-H.json.gz GHA files used by "dir" source
+	GHAS3Bucket              string                       // From GHA2DB_GHA_S3_BUCKET, gha2db - bucket of GHA files used by "s3" source
+	GHAS3Prefix              string                       // From GHA2DB_GHA_S3_PREFIX, gha2db - key prefix of GHA files in the bucket, for example "gharchive/"
+	GHAS3Endpoint            string                       // From GHA2DB_GHA_S3_ENDPOINT, gha2db - endpoint of S3-compatible storage, default "" - AWS S3
+	GHACacheDir              string                       // From GHA2DB_GHA_CACHE_DIR, gha2db - shared on-disk cache of GHA files, so projects syncing the same hour fetch it once, default "" - no cache
+	GHACacheDays             int                          // From GHA2DB_GHA_CACHE_DAYS, gha2db - cached GHA files fetched more than this many days ago are evicted, default 7, 0 - never evicted
+	WriteBatch               int                          // From GHA2DB_WRITE_BATCH, gha2db - number of events written to the database by multi-row inserts in a single transaction, default 500
 	ProjectScale             float64                      // From GHA2DB_PROJECT_SCALE, calc_metric tool, project scale (default 1), some metrics can use this to adapt their SQLs to bigger/smaller projects
 	PidFileRoot              string                       // From GHA2DB_PID_FILE_ROOT, devstats tool, use '/tmp/PidFileRoot.pid' as PID file, default 'devstats' -> '/tmp/devstats.pid'
 	SharedDB                 string                       // Currently annotations tool read this from projects.yaml:shared_db and if set, outputs annotations data to the sharded DB in addition to the current DB
@@ -675,6 +684,41 @@ func (ctx *Ctx) Init() {
 		ctx.HTTPRetry = retry
 	}
 
+	// GHA source
+	ctx.GHASource = os.Getenv("GHA2DB_GHA_SOURCE")
+	if ctx.GHASource == "" {
+		ctx.GHASource = GHASourceHTTP
+	}
+	ctx.GHAURL = os.Getenv("GHA2DB_GHA_URL")
+	if ctx.GHAURL == "" {
+		ctx.GHAURL = DefaultGHAURL
+	}
+	ctx.GHADir = os.Getenv("GHA2DB_GHA_DIR")
+	ctx.GHAS3Bucket = os.Getenv("GHA2DB_GHA_S3_BUCKET")
+	ctx.GHAS3Prefix = os.Getenv("GHA2DB_GHA_S3_PREFIX")
+	ctx.GHAS3Endpoint = os.Getenv("GHA2DB_GHA_S3_ENDPOINT")
+	ctx.GHACacheDir = os.Getenv("GHA2DB_GHA_CACHE_DIR")
+	if os.Getenv("GHA2DB_GHA_CACHE_DAYS") == "" {
+		ctx.GHACacheDays = 7
+	} else {
+		days, err := strconv.Atoi(os.Getenv("GHA2DB_GHA_CACHE_DAYS"))
+		FatalNoLog(err)
+		if days >= 0 {
+			ctx.GHACacheDays = days
+		}
+	}
+
+	// Events written in a single batch
+	if os.Getenv("GHA2DB_WRITE_BATCH") == "" {
+		ctx.WriteBatch = 500
+	} else {
+		batch, err := strconv.Atoi(os.Getenv("GHA2DB_WRITE_BATCH"))
+		FatalNoLog(err)
+		if batch > 0 {
+			ctx.WriteBatch = batch
+		}
+	}
+
 	// Skip writing to shared_db from projects.yaml
 	ctx.SkipSharedDB = os.Getenv("GHA2DB_SKIP_SHAREDDB") != ""
 
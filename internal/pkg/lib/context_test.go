@@ -141,6 +141,15 @@ func copyContext(in *Ctx) *Ctx {
 		ESBulkSize:               in.ESBulkSize,
 		HTTPTimeout:              in.HTTPTimeout,
 		HTTPRetry:                in.HTTPRetry,
+		GHASource:                in.GHASource,
+		GHAURL:                   in.GHAURL,
+		GHADir:                   in.GHADir,
+		GHAS3Bucket:              in.GHAS3Bucket,
+		GHAS3Prefix:              in.GHAS3Prefix,
+		GHAS3Endpoint:            in.GHAS3Endpoint,
+		GHACacheDir:              in.GHACacheDir,
+		GHACacheDays:             in.GHACacheDays,
+		WriteBatch:               in.WriteBatch,
 		ProjectScale:             in.ProjectScale,
 		CanReconnect:             in.CanReconnect,
 		CommitsFilesStatsEnabled: in.CommitsFilesStatsEnabled,
@@ -411,6 +420,10 @@ func TestInit(t *testing.T) {
 		ESBulkSize:               10000,
 		HTTPTimeout:              3,
 		HTTPRetry:                5,
+		GHASource:                "http",
+		GHAURL:                   "http://data.gharchive.org/",
+		GHACacheDays:             7,
+		WriteBatch:               500,
 		ProjectScale:             1.0,
 		CanReconnect:             true,
 		CommitsFilesStatsEnabled: true,
@@ -491,6 +504,40 @@ func TestInit(t *testing.T) {
 				},
 			),
 		},
+		{
+			"Setting GHA source and cache",
+			map[string]string{
+				"GHA2DB_GHA_SOURCE":     "s3",
+				"GHA2DB_GHA_S3_BUCKET":  "gharchive",
+				"GHA2DB_GHA_S3_PREFIX":  "hourly/",
+				"GHA2DB_GHA_CACHE_DIR":  "/var/cache/gha",
+				"GHA2DB_GHA_CACHE_DAYS": "2",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"GHASource":    "s3",
+					"GHAS3Bucket":  "gharchive",
+					"GHAS3Prefix":  "hourly/",
+					"GHACacheDir":  "/var/cache/gha",
+					"GHACacheDays": 2,
+				},
+			),
+		},
+		{
+			"Setting write batch",
+			map[string]string{
+				"GHA2DB_WRITE_BATCH": "100",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"WriteBatch": 100,
+				},
+			),
+		},
 		{
 			"Setting project scale factor",
 			map[string]string{
@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxErrorClassLen - error classes of errors not coming from the JSON decoder are truncated to this length
+const maxErrorClassLen = 64
+
+// decoderPathRE - "lib.Type.Field" or "[]lib.Type" element of the JSON decoder error path
+var decoderPathRE = regexp.MustCompile(`^(\[\]|\*|map\[\w+\])*[\w]+(\.\w+)+$`)
+
+// DeadLetter - GHA event that cannot be unmarshalled, kept in the gha_dead_letters table of the devstats database
+// Line is the 1-based JSON line number in the hour file
+type DeadLetter struct {
+	ID         int
+	Project    string
+	Hour       time.Time
+	Line       int
+	ErrorClass string
+	Error      string
+	Event      []byte
+	Attempts   int
+}
+
+// DeadLetterClass - dead-lettered events of the project with the same error class
+type DeadLetterClass struct {
+	Project    string
+	ErrorClass string
+	Events     int
+	Pending    int
+	FirstHour  time.Time
+	LastHour   time.Time
+}
+
+// DeadLetterProject - project of the dead-lettered events, the database name when GHA2DB_PROJECT is not set
+func DeadLetterProject(ctx *Ctx) string {
+	if ctx.Project != "" {
+		return ctx.Project
+	}
+	return ctx.PgDB
+}
+
+// DeadLetterErrorClass - the class of the unmarshal error: the path of the field and the decoder that failed,
+// for example "Payload.Issue.Title: ReadString", values and offsets are dropped so the same problems group together
+func DeadLetterErrorClass(err error) string {
+	msg := err.Error()
+	if i := strings.Index(msg, ", error found in #"); i >= 0 {
+		msg = msg[:i]
+	}
+	parts := strings.Split(msg, ": ")
+	fields := []string{}
+	decoder := ""
+	for _, part := range parts[:len(parts)-1] {
+		if !decoderPathRE.MatchString(part) {
+			decoder = part
+			break
+		}
+		names := strings.Split(part, ".")
+		name := names[len(names)-1]
+		if unicode.IsLower([]rune(name)[0]) {
+			// Syntax errors are reported by the decoder of the enclosing type: "lib.Event.isObjectEnd"
+			decoder = name
+			break
+		}
+		if len(names) >= 3 && !strings.HasPrefix(part, "[]") && !strings.HasPrefix(part, "map[") {
+			fields = append(fields, name)
+		}
+	}
+	if decoder == "" {
+		return TruncToBytes(strings.ToValidUTF8(msg, "?"), maxErrorClassLen)
+	}
+	if len(fields) == 0 {
+		return decoder
+	}
+	return strings.Join(fields, ".") + ": " + decoder
+}
+
+// WriteDeadLetter - store the event that cannot be unmarshalled, the same line of the hour is only stored once
+// The error is returned, for example when the gha_dead_letters table is not created yet
+func WriteDeadLetter(con *sql.DB, ctx *Ctx, hour time.Time, line int, event []byte, err error) error {
+	_, errSQL := ExecSQL(
+		con,
+		ctx,
+		InsertIgnore("into gha_dead_letters(proj, hour, line, error_class, error, event) "+NValues(6)),
+		DeadLetterProject(ctx),
+		hour,
+		line,
+		DeadLetterErrorClass(err),
+		strings.ToValidUTF8(CleanUTF8(err.Error()), "?"),
+		event,
+	)
+	return errSQL
+}
+
+// PendingDeadLetters - dead-lettered events of the project not replayed yet, in the order of the hours and lines
+func PendingDeadLetters(con *sql.DB, ctx *Ctx, project string) (letters []DeadLetter) {
+	rows := QuerySQLWithErr(
+		con,
+		ctx,
+		"select id, proj, hour, line, error_class, error, event, attempts from gha_dead_letters "+
+			"where proj = "+NValue(1)+" and replayed_at is null order by hour, line",
+		project,
+	)
+	defer func() { FatalOnError(rows.Close()) }()
+	for rows.Next() {
+		var letter DeadLetter
+		FatalOnError(
+			rows.Scan(
+				&letter.ID,
+				&letter.Project,
+				&letter.Hour,
+				&letter.Line,
+				&letter.ErrorClass,
+				&letter.Error,
+				&letter.Event,
+				&letter.Attempts,
+			),
+		)
+		letters = append(letters, letter)
+	}
+	FatalOnError(rows.Err())
+	return
+}
+
+// DeadLetterReplayed - mark the dead-lettered event as replayed
+func DeadLetterReplayed(con *sql.DB, ctx *Ctx, id int) {
+	ExecSQLWithErr(con, ctx, "update gha_dead_letters set replayed_at = now() where id = "+NValue(1), id)
+}
+
+// DeadLetterFailed - record the error of another unsuccessful replay of the dead-lettered event
+func DeadLetterFailed(con *sql.DB, ctx *Ctx, id int, err error) {
+	ExecSQLWithErr(
+		con,
+		ctx,
+		"update gha_dead_letters set attempts = attempts + 1, error_class = "+NValue(1)+", error = "+NValue(2)+
+			" where id = "+NValue(3),
+		DeadLetterErrorClass(err),
+		strings.ToValidUTF8(CleanUTF8(err.Error()), "?"),
+		id,
+	)
+}
+
+// DeadLetterSummary - dead-lettered events by project and error class, all projects when project is empty
+// Most frequent classes come first
+func DeadLetterSummary(con *sql.DB, ctx *Ctx, project string) (classes []DeadLetterClass) {
+	rows := QuerySQLWithErr(
+		con,
+		ctx,
+		"select proj, error_class, count(*), count(*) filter (where replayed_at is null), min(hour), max(hour) "+
+			"from gha_dead_letters where "+NValue(1)+" = '' or proj = "+NValue(1)+" "+
+			"group by proj, error_class order by count(*) desc, proj, error_class",
+		project,
+	)
+	defer func() { FatalOnError(rows.Close()) }()
+	for rows.Next() {
+		var class DeadLetterClass
+		FatalOnError(
+			rows.Scan(
+				&class.Project,
+				&class.ErrorClass,
+				&class.Events,
+				&class.Pending,
+				&class.FirstHour,
+				&class.LastHour,
+			),
+		)
+		classes = append(classes, class)
+	}
+	FatalOnError(rows.Err())
+	return
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestDeadLetterErrorClass(t *testing.T) {
+	var testCases = []struct {
+		json     string
+		expected string
+	}{
+		{json: `{"id":"1","payload":{"issue":{"title":123}}}`, expected: "Payload.Issue.Title: ReadString"},
+		{json: `{"id":"2","payload":{"issue":{"title":456}}}`, expected: "Payload.Issue.Title: ReadString"},
+		{json: `{"id":"1","payload":{"issue":{"labels":[{"id":1.5}]}}}`, expected: "Payload.Issue.Labels.ID: assertInteger"},
+		{json: `{"id":"1","created_at":"yesterday"}`, expected: "CreatedAt: unmarshalerDecoder"},
+		{json: `{"id":"1","actor":{"id":12345678901234567890}}`, expected: "Actor.ID: ReadInt64"},
+		{json: `{"id":1}`, expected: "ID: ReadString"},
+		{json: `{"id":"1","payload":{"commits":{}}}`, expected: "Payload.Commits: decode slice"},
+		{json: `{"id":"1"`, expected: "isObjectEnd"},
+		{json: `garbage`, expected: "readObjectStart"},
+	}
+	for index, test := range testCases {
+		var ev Event
+		err := jsoniter.Unmarshal([]byte(test.json), &ev)
+		if err == nil {
+			t.Errorf("test number %d, expected unmarshal error for %s", index+1, test.json)
+			continue
+		}
+		got := DeadLetterErrorClass(err)
+		if got != test.expected {
+			t.Errorf("test number %d, expected '%s', got '%s' (error: %v)", index+1, test.expected, got, err)
+		}
+	}
+
+	// Errors not coming from the decoder are classified by their message
+	got := DeadLetterErrorClass(errors.New("unexpected EOF"))
+	if got != "unexpected EOF" {
+		t.Errorf("expected 'unexpected EOF', got '%s'", got)
+	}
+}
+
+func TestDeadLetterProject(t *testing.T) {
+	ctx := Ctx{PgDB: "gha"}
+	if got := DeadLetterProject(&ctx); got != "gha" {
+		t.Errorf("expected database name 'gha', got '%s'", got)
+	}
+	ctx.Project = "tidb"
+	if got := DeadLetterProject(&ctx); got != "tidb" {
+		t.Errorf("expected project 'tidb', got '%s'", got)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	jsoniter "github.com/json-iterator/go"
 )
 
 // Int64Ary - sortable Int64 array
@@ -366,6 +368,30 @@ func ActorHit(ctx *Ctx, actorName string) bool {
 	return false
 }
 
+// EventHit - cheap check of GHA event JSON repo name and actor login, without unmarshalling the full event
+// It only returns false when the event surely doesn't match, events that cannot be checked (old format, missing
+// or broken fields) return true and are left to the full unmarshal, which reports broken JSONs
+func EventHit(ctx *Ctx, jsonStr []byte, forg, frepo map[string]struct{}, orgRE, repoRE *regexp.Regexp) bool {
+	if ctx.OldFormat {
+		return true
+	}
+	repo := jsoniter.Get(jsonStr, "repo", "name")
+	if repo.LastError() != nil || repo.ValueType() != jsoniter.StringValue {
+		return true
+	}
+	if !RepoHit(ctx, repo.ToString(), forg, frepo, orgRE, repoRE) {
+		return false
+	}
+	if !ctx.ActorsFilter {
+		return true
+	}
+	actor := jsoniter.Get(jsonStr, "actor", "login")
+	if actor.LastError() != nil || actor.ValueType() != jsoniter.StringValue {
+		return true
+	}
+	return ActorHit(ctx, actor.ToString())
+}
+
 // RepoHit - are we interested in this org/repo ?
 func RepoHit(ctx *Ctx, fullName string, forg, frepo map[string]struct{}, orgRE, repoRE *regexp.Regexp) bool {
 	// Return false if no repo name
@@ -0,0 +1,410 @@
+package lib
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// GHA source types, set by GHA2DB_GHA_SOURCE
+const (
+	GHASourceHTTP = "http"
+	GHASourceDir  = "dir"
+	GHASourceS3   = "s3"
+)
+
+// DefaultGHAURL - the base URL of the GHArchive files
+const DefaultGHAURL = "http://data.gharchive.org/"
+
+// ghaCacheTmpTimeout - temporary and lock files older than this are left by crashed processes and are evicted
+const ghaCacheTmpTimeout = 30 * time.Minute
+
+// ghaCacheLockPollInterval - how often the lock of the GHA file fetched by another process is retried
+const ghaCacheLockPollInterval = time.Second
+
+var (
+	// ErrGHANotAvailable - the GHA file of the hour does not exist (yet) in the source
+	ErrGHANotAvailable = errors.New("GHA file not available")
+	// ErrGHAInvalid - the GHA file cannot be read or decompressed, usually it is not completely published yet
+	ErrGHAInvalid = errors.New("invalid GHA file")
+)
+
+// GHASource - source of the hourly GHArchive files
+// Open returns the gzipped JSON lines of the hour, timeout limits fetching from a remote source (0 - no limit)
+type GHASource interface {
+	Open(dt time.Time, timeout time.Duration) (io.ReadCloser, error)
+	Location(dt time.Time) string
+}
+
+// GHAFileName - return the GHArchive file name of the hour: YYYY-MM-DD-H.json.gz
+func GHAFileName(dt time.Time) string {
+	return ToGHADate(dt) + ".json.gz"
+}
+
+// NewGHASource - create the GHA source configured in the context, wrapped by the on-disk cache when it is set
+func NewGHASource(ctx *Ctx) (GHASource, error) {
+	var src GHASource
+	switch ctx.GHASource {
+	case GHASourceHTTP:
+		src = &HTTPGHASource{BaseURL: ctx.GHAURL}
+	case GHASourceDir:
+		if ctx.GHADir == "" {
+			return nil, fmt.Errorf("GHA2DB_GHA_DIR is required by the %s GHA source", GHASourceDir)
+		}
+		src = &DirGHASource{Dir: ctx.GHADir}
+	case GHASourceS3:
+		if ctx.GHAS3Bucket == "" {
+			return nil, fmt.Errorf("GHA2DB_GHA_S3_BUCKET is required by the %s GHA source", GHASourceS3)
+		}
+		s3Src, err := NewS3GHASource(ctx.GHAS3Bucket, ctx.GHAS3Prefix, ctx.GHAS3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		src = s3Src
+	default:
+		return nil, fmt.Errorf("unknown GHA source: '%s', supported: %s, %s, %s", ctx.GHASource, GHASourceHTTP, GHASourceDir, GHASourceS3)
+	}
+	if ctx.GHACacheDir != "" {
+		src = &CachedGHASource{
+			Source: src,
+			Dir:    ctx.GHACacheDir,
+			MaxAge: time.Duration(ctx.GHACacheDays) * 24 * time.Hour,
+		}
+	} else if ctx.GHASource != GHASourceDir {
+		src = &SpooledGHASource{Source: src}
+	}
+	return src, nil
+}
+
+// HTTPGHASource - download GHA files via HTTP, from data.gharchive.org by default
+type HTTPGHASource struct {
+	BaseURL string
+}
+
+// Location - URL of the GHA file
+func (s *HTTPGHASource) Location(dt time.Time) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + GHAFileName(dt)
+}
+
+// Open - get the GHA file, the timeout includes reading the response body
+func (s *HTTPGHASource) Open(dt time.Time, timeout time.Duration) (io.ReadCloser, error) {
+	httpClient := &http.Client{Timeout: timeout}
+	response, err := httpClient.Get(s.Location(dt))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		_ = response.Body.Close()
+		if response.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s: %w", s.Location(dt), ErrGHANotAvailable)
+		}
+		return nil, fmt.Errorf("%s: unexpected HTTP status %s", s.Location(dt), response.Status)
+	}
+	return response.Body, nil
+}
+
+// DirGHASource - read GHA files from a local directory or a mounted mirror of data.gharchive.org
+type DirGHASource struct {
+	Dir string
+}
+
+// Location - path of the GHA file
+func (s *DirGHASource) Location(dt time.Time) string {
+	return filepath.Join(s.Dir, GHAFileName(dt))
+}
+
+// Open - open the GHA file, timeout is not used
+func (s *DirGHASource) Open(dt time.Time, timeout time.Duration) (io.ReadCloser, error) {
+	file, err := os.Open(s.Location(dt))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", s.Location(dt), ErrGHANotAvailable)
+	}
+	return file, err
+}
+
+// S3GHASource - read GHA files from an S3-compatible bucket, the objects are named <prefix>YYYY-MM-DD-H.json.gz
+type S3GHASource struct {
+	Bucket string
+	Prefix string
+	svc    *s3.S3
+}
+
+// NewS3GHASource - create the S3 GHA source, endpoint is used for the S3-compatible storages, empty means AWS S3
+// Credentials and region are taken from the standard AWS environment variables and shared config
+func NewS3GHASource(bucket, prefix, endpoint string) (*S3GHASource, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	cfg := &aws.Config{}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	return &S3GHASource{Bucket: bucket, Prefix: prefix, svc: s3.New(sess, cfg)}, nil
+}
+
+// Location - S3 URL of the GHA file
+func (s *S3GHASource) Location(dt time.Time) string {
+	return "s3://" + s.Bucket + "/" + s.Prefix + GHAFileName(dt)
+}
+
+// Open - get the GHA object, the timeout includes reading the object body
+func (s *S3GHASource) Open(dt time.Time, timeout time.Duration) (io.ReadCloser, error) {
+	c, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		c, cancel = context.WithTimeout(c, timeout)
+	}
+	output, err := s.svc.GetObjectWithContext(c, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + GHAFileName(dt)),
+	})
+	if err != nil {
+		cancel()
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, fmt.Errorf("%s: %w", s.Location(dt), ErrGHANotAvailable)
+		}
+		return nil, err
+	}
+	return &cancelReadCloser{ReadCloser: output.Body, cancel: cancel}, nil
+}
+
+// cancelReadCloser - cancel the request context when the body is closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// SpooledGHASource - download GHA files of a remote source into temporary files, which are removed on close
+// The events are streamed while they are written to the database, so the timeout of the remote source must not
+// include processing them, only the compressed file is kept on the disk
+type SpooledGHASource struct {
+	Source GHASource
+	Dir    string // Default "" - the system temporary directory
+}
+
+// Location - location of the GHA file in the remote source
+func (s *SpooledGHASource) Location(dt time.Time) string {
+	return s.Source.Location(dt)
+}
+
+// Open - download the GHA file into a temporary file and open it
+func (s *SpooledGHASource) Open(dt time.Time, timeout time.Duration) (io.ReadCloser, error) {
+	body, err := s.Source.Open(dt, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+
+	tmp, err := ioutil.TempFile(s.Dir, GHAFileName(dt)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(tmp, body)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("%s: %w: %v", s.Source.Location(dt), ErrGHAInvalid, err)
+	}
+	return &removeOnClose{File: tmp}, nil
+}
+
+// removeOnClose - remove the temporary file when it is closed
+type removeOnClose struct {
+	*os.File
+}
+
+func (f *removeOnClose) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.File.Name())
+	return err
+}
+
+// CachedGHASource - shared on-disk cache of GHA files in front of another source
+// Projects syncing the same hour fetch the file from the source once, the file is only stored when it is a
+// complete gzip stream, so a partial download is never served from the cache
+// The fetching process holds an flock on the lock file of the hour, so the lock of a crashed process is released
+// by the kernel, files fetched more than MaxAge ago are evicted after each fetch
+type CachedGHASource struct {
+	Source GHASource
+	Dir    string
+	MaxAge time.Duration // Default 0 - the files are never evicted
+}
+
+// Location - path of the cached GHA file
+func (s *CachedGHASource) Location(dt time.Time) string {
+	return filepath.Join(s.Dir, GHAFileName(dt))
+}
+
+// Open - open the cached GHA file, fetch it from the source first if it is not cached yet
+// Waiting for another process fetching the same file counts into the timeout
+func (s *CachedGHASource) Open(dt time.Time, timeout time.Duration) (io.ReadCloser, error) {
+	path := s.Location(dt)
+	file, err := os.Open(path)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	err = os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one process fetches the file, others wait for it
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	lockPath := path + ".lock"
+	lock, err := lockGHACacheFile(lockPath, deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// The lock file is removed before it is unlocked, so the waiters holding the removed file try again
+		_ = os.Remove(lockPath)
+		_ = lock.Close()
+	}()
+
+	// The file may have been fetched by the former holder of the lock
+	file, err = os.Open(path)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !deadline.IsZero() {
+		timeout = time.Until(deadline)
+	}
+	err = s.fetch(dt, timeout, path)
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxAge > 0 {
+		s.evict(time.Now())
+	}
+	file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// lockGHACacheFile - take the exclusive flock of the lock file, wait for the other holder until the deadline
+// (zero - no limit), the lock is released by closing the returned file
+func lockGHACacheFile(lockPath string, deadline time.Time) (*os.File, error) {
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			// The former holder may have removed the lock file before this process got the lock
+			lockInfo, lockErr := lock.Stat()
+			pathInfo, pathErr := os.Stat(lockPath)
+			if lockErr == nil && pathErr == nil && os.SameFile(lockInfo, pathInfo) {
+				return lock, nil
+			}
+			_ = lock.Close()
+			continue
+		}
+		_ = lock.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for the GHA cache lock %s", lockPath)
+		}
+		time.Sleep(ghaCacheLockPollInterval)
+	}
+}
+
+// evict - remove the GHA files fetched more than MaxAge ago, and the temporary and lock files left by crashed
+// processes, errors are ignored because the files may be evicted by other processes at the same time
+func (s *CachedGHASource) evict(now time.Time) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+	for _, info := range files {
+		if info.IsDir() {
+			continue
+		}
+		age := now.Sub(info.ModTime())
+		name := info.Name()
+		switch {
+		case strings.HasSuffix(name, ".json.gz") && age > s.MaxAge:
+		case strings.Contains(name, ".json.gz.") && age > ghaCacheTmpTimeout:
+		default:
+			continue
+		}
+		_ = os.Remove(filepath.Join(s.Dir, name))
+	}
+}
+
+// fetch - copy the GHA file from the source into the cache, the gzip stream is validated before it is stored
+func (s *CachedGHASource) fetch(dt time.Time, timeout time.Duration, path string) error {
+	body, err := s.Source.Open(dt, timeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+
+	tmp, err := ioutil.TempFile(s.Dir, GHAFileName(dt)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	tee := io.TeeReader(body, tmp)
+	reader, err := gzip.NewReader(tee)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, reader)
+		_ = reader.Close()
+	}
+	if err == nil {
+		// Anything after the gzip stream is kept as it is
+		_, err = io.Copy(tmp, body)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("%s: %w: %v", s.Source.Location(dt), ErrGHAInvalid, err)
+	}
+	err = tmp.Chmod(0644)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
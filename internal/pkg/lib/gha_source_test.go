@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gzipLines - gzip the JSON lines
+func gzipLines(t *testing.T, lines string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(lines))
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// readGHA - open the GHA file of the source and return the decompressed content
+func readGHA(src GHASource, dt time.Time) (string, error) {
+	body, err := src.Open(dt, time.Minute)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = body.Close() }()
+	reader, err := gzip.NewReader(body)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadAll(reader)
+	return string(data), err
+}
+
+// countingGHASource - GHA source serving files from memory and counting opens per hour
+type countingGHASource struct {
+	mtx   sync.Mutex
+	files map[string][]byte
+	opens map[string]int
+}
+
+func (s *countingGHASource) Location(dt time.Time) string {
+	return "memory://" + GHAFileName(dt)
+}
+
+func (s *countingGHASource) Open(dt time.Time, timeout time.Duration) (io.ReadCloser, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.opens[GHAFileName(dt)]++
+	data, ok := s.files[GHAFileName(dt)]
+	if !ok {
+		return nil, ErrGHANotAvailable
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestGHASources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gha")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	dt := time.Date(2021, 8, 3, 7, 0, 0, 0, time.UTC)
+	missing := dt.Add(time.Hour)
+	lines := "{\"id\":\"1\"}\n{\"id\":\"2\"}\n"
+	data := gzipLines(t, lines)
+	if GHAFileName(dt) != "2021-08-03-7.json.gz" {
+		t.Errorf("expected file name 2021-08-03-7.json.gz, got %s", GHAFileName(dt))
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, GHAFileName(dt)), data, 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	var testCases = []struct {
+		name string
+		src  GHASource
+	}{
+		{name: "dir", src: &DirGHASource{Dir: dir}},
+		{name: "http", src: &HTTPGHASource{BaseURL: server.URL + "/"}},
+	}
+	for _, test := range testCases {
+		got, err := readGHA(test.src, dt)
+		if err != nil || got != lines {
+			t.Errorf("%s source: expected '%s', got '%s', error: %v", test.name, lines, got, err)
+		}
+		_, err = readGHA(test.src, missing)
+		if !errors.Is(err, ErrGHANotAvailable) {
+			t.Errorf("%s source: expected not available error, got: %v", test.name, err)
+		}
+	}
+}
+
+func TestCachedGHASource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gha_cache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	dt := time.Date(2021, 8, 3, 7, 0, 0, 0, time.UTC)
+	broken := dt.Add(time.Hour)
+	missing := dt.Add(2 * time.Hour)
+	lines := "{\"id\":\"1\"}\n"
+	data := gzipLines(t, lines)
+	src := &countingGHASource{
+		files: map[string][]byte{
+			GHAFileName(dt):     data,
+			GHAFileName(broken): data[:len(data)/2],
+		},
+		opens: make(map[string]int),
+	}
+	cache := &CachedGHASource{Source: src, Dir: filepath.Join(dir, "cache")}
+
+	// Projects syncing the same hour concurrently fetch it once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := readGHA(cache, dt)
+			if err != nil || got != lines {
+				t.Errorf("expected '%s', got '%s', error: %v", lines, got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if src.opens[GHAFileName(dt)] != 1 {
+		t.Errorf("expected the source opened once, got %d", src.opens[GHAFileName(dt)])
+	}
+
+	// Truncated file is not cached
+	_, err = readGHA(cache, broken)
+	if !errors.Is(err, ErrGHAInvalid) {
+		t.Errorf("expected invalid file error, got: %v", err)
+	}
+	if _, err = os.Stat(cache.Location(broken)); !os.IsNotExist(err) {
+		t.Errorf("expected truncated file not cached, got: %v", err)
+	}
+
+	_, err = readGHA(cache, missing)
+	if !errors.Is(err, ErrGHANotAvailable) {
+		t.Errorf("expected not available error, got: %v", err)
+	}
+	files, err := ioutil.ReadDir(cache.Dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("expected only the complete file in the cache, got %d files, error: %v", len(files), err)
+	}
+
+	// Waiting for the lock held by another process counts into the timeout
+	lock, err := lockGHACacheFile(cache.Location(missing)+".lock", time.Time{})
+	if err != nil {
+		t.Fatalf("lockGHACacheFile: %v", err)
+	}
+	_, err = cache.Open(missing, 100*time.Millisecond)
+	if err == nil || errors.Is(err, ErrGHANotAvailable) {
+		t.Errorf("expected lock timeout error, got: %v", err)
+	}
+	_ = lock.Close()
+	_, err = readGHA(cache, missing)
+	if !errors.Is(err, ErrGHANotAvailable) {
+		t.Errorf("expected not available error after the lock is released, got: %v", err)
+	}
+}
+
+func TestCachedGHASourceEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gha_cache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	dt := time.Date(2021, 8, 3, 7, 0, 0, 0, time.UTC)
+	old := dt.Add(-24 * time.Hour)
+	recent := dt.Add(-time.Hour)
+	lines := "{\"id\":\"1\"}\n"
+	src := &countingGHASource{
+		files: map[string][]byte{GHAFileName(dt): gzipLines(t, lines)},
+		opens: make(map[string]int),
+	}
+	cache := &CachedGHASource{Source: src, Dir: dir, MaxAge: 7 * 24 * time.Hour}
+
+	var testCases = []struct {
+		name    string
+		age     time.Duration
+		evicted bool
+	}{
+		{name: GHAFileName(old), age: 8 * 24 * time.Hour, evicted: true},
+		{name: GHAFileName(recent), age: 6 * 24 * time.Hour, evicted: false},
+		{name: GHAFileName(recent) + ".tmp123", age: time.Hour, evicted: true},
+		{name: GHAFileName(old) + ".lock", age: time.Minute, evicted: false},
+	}
+	for _, test := range testCases {
+		path := filepath.Join(dir, test.name)
+		if err = ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		mtime := time.Now().Add(-test.age)
+		if err = os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+	}
+
+	// Files are evicted after a new file is fetched
+	got, err := readGHA(cache, dt)
+	if err != nil || got != lines {
+		t.Errorf("expected '%s', got '%s', error: %v", lines, got, err)
+	}
+	for _, test := range testCases {
+		_, err = os.Stat(filepath.Join(dir, test.name))
+		if evicted := os.IsNotExist(err); evicted != test.evicted {
+			t.Errorf("%s: expected evicted %v, got %v, error: %v", test.name, test.evicted, evicted, err)
+		}
+	}
+	if _, err = os.Stat(cache.Location(dt)); err != nil {
+		t.Errorf("expected the fetched file cached, got: %v", err)
+	}
+}
+
+func TestSpooledGHASource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gha_spool")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	dt := time.Date(2021, 8, 3, 7, 0, 0, 0, time.UTC)
+	lines := "{\"id\":\"1\"}\n"
+	src := &countingGHASource{
+		files: map[string][]byte{GHAFileName(dt): gzipLines(t, lines)},
+		opens: make(map[string]int),
+	}
+	spool := &SpooledGHASource{Source: src, Dir: dir}
+	got, err := readGHA(spool, dt)
+	if err != nil || got != lines {
+		t.Errorf("expected '%s', got '%s', error: %v", lines, got, err)
+	}
+	_, err = readGHA(spool, dt.Add(time.Hour))
+	if !errors.Is(err, ErrGHANotAvailable) {
+		t.Errorf("expected not available error, got: %v", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 0 {
+		t.Errorf("expected temporary files removed, got %d files, error: %v", len(files), err)
+	}
+}
@@ -944,3 +944,39 @@ func TestCompareFloat64Ptr(t *testing.T) {
 		t.Errorf("testlib &f1, &f4 case: expected true, got %v", result)
 	}
 }
+
+func TestEventHit(t *testing.T) {
+	// Test cases
+	event := func(repo, actor string) []byte {
+		return []byte(`{"id":"1","type":"PushEvent","actor":{"id":1,"login":"` + actor +
+			`"},"repo":{"id":2,"name":"` + repo + `"},"payload":{"repo":{"name":"other/repo"}}}`)
+	}
+	var testCases = []struct {
+		oldFormat    bool
+		actorsForbid *regexp.Regexp
+		jsonStr      []byte
+		expected     bool
+	}{
+		{jsonStr: event("pingcap/tidb", "alice"), expected: true},
+		{jsonStr: event("kubernetes/kubernetes", "alice"), expected: false},
+		{jsonStr: event("pingcap/tidb", "bot"), actorsForbid: regexp.MustCompile("bot"), expected: false},
+		{jsonStr: event("pingcap/tidb", "alice"), actorsForbid: regexp.MustCompile("bot"), expected: true},
+		{jsonStr: []byte(`{"id":"1","repo":{"id":2}}`), expected: true},
+		{jsonStr: []byte(`{"id":"1","repo":{"name":"pingcap`), expected: true},
+		{jsonStr: event("kubernetes/kubernetes", "alice"), oldFormat: true, expected: true},
+	}
+	// Execute test cases
+	forg := map[string]struct{}{"pingcap": {}}
+	for index, test := range testCases {
+		ctx := Ctx{OldFormat: test.oldFormat}
+		ctx.ActorsFilter = test.actorsForbid != nil
+		ctx.ActorsForbid = test.actorsForbid
+		got := EventHit(&ctx, test.jsonStr, forg, map[string]struct{}{}, nil, nil)
+		if got != test.expected {
+			t.Errorf(
+				"test number %d, expected '%v', got '%v', test case: %+v",
+				index+1, test.expected, got, test,
+			)
+		}
+	}
+}
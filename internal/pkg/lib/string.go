@@ -92,6 +92,50 @@ func GetHidden(configFile string) (shaMap map[string]string) {
 	return
 }
 
+// AddHidden - add SHA1 of given values to the hidden config file, returns the values that were already added
+func AddHidden(configFile string, values []string) (skipped []string, err error) {
+	shaMap := GetHidden(configFile)
+	added := false
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		hash := sha1.New()
+		_, err = hash.Write([]byte(value))
+		if err != nil {
+			return
+		}
+		sha := hex.EncodeToString(hash.Sum(nil))
+		_, ok := shaMap[sha]
+		if ok {
+			skipped = append(skipped, value)
+			continue
+		}
+		shaMap[sha] = ""
+		added = true
+	}
+	if !added {
+		return
+	}
+	oFile, err := os.Create(configFile)
+	if err != nil {
+		return
+	}
+	defer func() { _ = oFile.Close() }()
+	writer := csv.NewWriter(oFile)
+	err = writer.Write([]string{"sha1"})
+	if err != nil {
+		return
+	}
+	for sha := range shaMap {
+		err = writer.Write([]string{sha})
+		if err != nil {
+			return
+		}
+	}
+	writer.Flush()
+	err = writer.Error()
+	return
+}
+
 // MaybeHideFunc - use closure as a data storage
 func MaybeHideFunc(shas map[string]string) (f func(string) string) {
 	cache := make(map[string]string)
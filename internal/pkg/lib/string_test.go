@@ -1,6 +1,10 @@
 package lib
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -262,3 +266,46 @@ func TestPrepareQuickRangeQuery(t *testing.T) {
 		}
 	}
 }
+
+func TestAddHidden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	configFile := filepath.Join(dir, "hide.csv")
+
+	// Test cases
+	var testCases = []struct {
+		values  []string
+		skipped []string
+		hidden  []string
+	}{
+		{
+			values:  []string{"a", " b "},
+			skipped: nil,
+			hidden:  []string{"a", "b"},
+		},
+		{
+			values:  []string{"b", "c"},
+			skipped: []string{"b"},
+			hidden:  []string{"a", "b", "c"},
+		},
+	}
+	// Execute testlib cases
+	for index, test := range testCases {
+		skipped, err := AddHidden(configFile, test.values)
+		if err != nil {
+			t.Fatalf("testlib number %d, unexpected error: %v", index+1, err)
+		}
+		if !reflect.DeepEqual(skipped, test.skipped) {
+			t.Errorf("testlib number %d, expected skipped '%v', got '%v'", index+1, test.skipped, skipped)
+		}
+		f := MaybeHideFunc(GetHidden(configFile))
+		for _, value := range test.hidden {
+			if f(value) == value {
+				t.Errorf("testlib number %d, expected '%v' to be hidden", index+1, value)
+			}
+		}
+	}
+}
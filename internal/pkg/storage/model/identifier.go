@@ -1,6 +1,9 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -20,7 +23,9 @@ type TeamLevel string
 const (
 	TeamMaintainer TeamLevel = "maintainer"
 	TeamCommitter  TeamLevel = "committer"
-	TeamReviewer   TeamLevel = "reviewer"
+	// TeamApprover is the approver in the Kubernetes-style OWNERS files and the owner in the CODEOWNERS file.
+	TeamApprover TeamLevel = "approver"
+	TeamReviewer TeamLevel = "reviewer"
 )
 
 type ProfileSource string
@@ -38,6 +43,16 @@ const (
 	ManualSource ProfileSource = "manual"
 	// UserManualSource means information is provided through manual verification by user.
 	UserManualSource ProfileSource = "user_manual"
+	// DemographicDatasetSource means information is inferred by the opt-in demographic enrichment stage.
+	DemographicDatasetSource ProfileSource = "demographic_dataset"
+	// ActivityHoursSource means information is inferred from the hour-of-day distribution of the activities.
+	ActivityHoursSource ProfileSource = "activity_hours"
+	// SortingHatSource means information is imported from the SortingHat data of GrimoireLab.
+	SortingHatSource ProfileSource = "sortinghat"
+	// AccountProfileSource means information is fetched from the profile of a GitLab or Gitee account.
+	AccountProfileSource ProfileSource = "account_profile"
+	// GitCommitSource means information is taken from the author of the git commits.
+	GitCommitSource ProfileSource = "git_commit"
 )
 
 type Country struct {
@@ -56,6 +71,9 @@ type Project struct {
 	DisplayName string `gorm:"type:varchar(128);not null;"`
 	Name        string `gorm:"type:varchar(128);uniqueIndex;not null;"`
 
+	// CommunitySyncCommit is the last commit of the community repository processed by sync_teams.
+	CommunitySyncCommit string `gorm:"type:varchar(40);"`
+
 	Teams []Team `gorm:"foreignKey:project_id"`
 }
 
@@ -66,9 +84,11 @@ func (Project) TableName() string {
 type Team struct {
 	gorm.Model
 
-	Name         string `gorm:"uniqueIndex"`
+	// Name is unique in the project, the teams of different projects may have the same name.
+	Name         string `gorm:"uniqueIndex:uniq_team_project_name,priority:2"`
 	Description  string
-	ProjectID    uint             `gorm:"project_id"`
+	Metadata     TeamMetadata     `gorm:"type:text"`
+	ProjectID    uint             `gorm:"project_id;uniqueIndex:uniq_team_project_name,priority:1"`
 	Members      []UniqueIdentity `gorm:"many2many:team_members;foreignKey:ID;joinForeignKey:team_id;References:UUID;JoinReferences:uuid"`
 	Repositories []Repository     `gorm:"many2many:team_repositories;foreignKey:ID;joinForeignKey:team_id;References:ID;JoinReferences:repo_id"`
 	Project      Project          `gorm:"foreignKey:project_id"`
@@ -78,6 +98,60 @@ func (Team) TableName() string {
 	return "teams"
 }
 
+// TeamMetadata - the optional information of the team besides the membership, it is stored as JSON.
+type TeamMetadata struct {
+	// Charter is the URL of the charter document.
+	Charter  string        `json:"charter,omitempty"`
+	Channels []TeamChannel `json:"channels,omitempty"`
+	Meetings []TeamMeeting `json:"meetings,omitempty"`
+	// Mentors are the GitHub logins of the mentors.
+	Mentors []string `json:"mentors,omitempty"`
+}
+
+// TeamChannel - the chat channel or the mailing list of the team, such as the "#sig-sql" channel of Slack.
+type TeamChannel struct {
+	Platform string `json:"platform"`
+	Name     string `json:"name"`
+	URL      string `json:"url,omitempty"`
+}
+
+// TeamMeeting - the regular meeting of the team.
+type TeamMeeting struct {
+	Description string `json:"description,omitempty"`
+	// Schedule is the human readable schedule, such as "Biweekly on Tuesday 14:00 UTC+8".
+	Schedule   string `json:"schedule,omitempty"`
+	URL        string `json:"url,omitempty"`
+	ArchiveURL string `json:"archive_url,omitempty"`
+}
+
+func (m TeamMetadata) Value() (driver.Value, error) {
+	bytesJSON, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(bytesJSON), nil
+}
+
+func (m *TeamMetadata) Scan(value interface{}) error {
+	var bytesJSON []byte
+	switch v := value.(type) {
+	case nil:
+		*m = TeamMetadata{}
+		return nil
+	case []byte:
+		bytesJSON = v
+	case string:
+		bytesJSON = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type %T of team metadata", value)
+	}
+	if len(bytesJSON) == 0 {
+		*m = TeamMetadata{}
+		return nil
+	}
+	return json.Unmarshal(bytesJSON, m)
+}
+
 type TeamMember struct {
 	TeamID uint   `gorm:"primaryKey;"`
 	UUID   string `gorm:"primaryKey;type:varchar(128)"`
@@ -92,6 +166,8 @@ type TeamMember struct {
 
 	JoinDate       time.Time
 	LastUpdateDate time.Time
+	// LeaveDate is set when the member is removed from the team, the level is kept as the last level before leaving.
+	LeaveDate *time.Time
 }
 
 func (TeamMember) TableName() string {
@@ -141,9 +217,25 @@ type UniqueIdentity struct {
 	CountrySource  ProfileSource `gorm:"type:varchar(32)"`
 	IsBot          bool          `gorm:"default:0"`
 
+	// GenderProvenance describes which enricher and dataset inferred the gender, and when.
+	GenderProvenance string `gorm:"type:varchar(255)"`
+
+	// TzOffset is the UTC offset in minutes, TzConfidence is between 0 and 1.
+	TzOffset     *int
+	TzConfidence float64       `gorm:"type:float"`
+	TzSource     ProfileSource `gorm:"type:varchar(32)"`
+
+	// OptedOut means the person asked to remove the personal information, the PII fields are scrubbed and
+	// never imported again, and the person is excluded from the exported data.
+	OptedOut   bool `gorm:"default:0"`
+	OptedOutAt *time.Time
+
 	// One person can have multiple GitHub accounts.
 	GitHubUsers []GitHubUser `gorm:"foreignKey:uuid"`
 
+	// One person can have accounts from multiple providers, the GitHub accounts are mirrored from github_users.
+	Accounts []Account `gorm:"foreignKey:uuid"`
+
 	// One person can belong to multiple organizations.
 	Organizations []Organization `gorm:"many2many:enrollments;foreignKey:UUID;joinForeignKey:uuid;References:ID;JoinReferences:org_id"`
 
@@ -178,6 +270,29 @@ func (Organization) TableName() string {
 	return "organizations"
 }
 
+type OrgRelationType string
+
+const (
+	OrgRelationSubsidiary  OrgRelationType = "subsidiary"
+	OrgRelationAcquisition OrgRelationType = "acquisition"
+)
+
+// OrgRelation is the relationship between an organization and its parent organization, the organization is
+// rolled up to the parent between the start date and the end date.
+type OrgRelation struct {
+	gorm.Model
+
+	OrgID       uint            `gorm:"uniqueIndex:uniq_org_relation"`
+	ParentOrgID uint            `gorm:"uniqueIndex:uniq_org_relation"`
+	Type        OrgRelationType `gorm:"type:varchar(32);default:'subsidiary';not null"`
+	StartDate   time.Time
+	EndDate     time.Time
+}
+
+func (OrgRelation) TableName() string {
+	return "organization_relations"
+}
+
 type OrgPattern struct {
 	gorm.Model
 
@@ -273,3 +388,166 @@ type GitHubUserName struct {
 func (GitHubUserName) TableName() string {
 	return "github_user_names"
 }
+
+type AccountProvider string
+
+const (
+	GitHubProvider AccountProvider = "github"
+	GitLabProvider AccountProvider = "gitlab"
+	GiteeProvider  AccountProvider = "gitee"
+	// EmailProvider is used by the commit authors which are not linked to any account.
+	EmailProvider AccountProvider = "email"
+)
+
+// Account is an account of the person on a provider, the GitHub specific details are kept in github_users.
+type Account struct {
+	gorm.Model
+
+	UUID     string          `gorm:"type:varchar(128);index:idx_account_uuid"`
+	Provider AccountProvider `gorm:"type:varchar(32);not null;uniqueIndex:uniq_account_provider_user"`
+	// ProviderUserID is the GitHub ID for GitHub, the lower case username for GitLab and Gitee, and the SHA1 of
+	// the lower case address for email, so that the address can be scrubbed without losing the account.
+	ProviderUserID string  `gorm:"type:varchar(255);not null;uniqueIndex:uniq_account_provider_user"`
+	Username       string  `gorm:"type:varchar(128)"`
+	Email          string  `gorm:"type:varchar(255)"`
+	Name           *string `gorm:"type:varchar(128)"`
+	Company        *string `gorm:"type:varchar(255)"`
+	Location       *string `gorm:"type:varchar(255)"`
+}
+
+func (Account) TableName() string {
+	return "accounts"
+}
+
+// ProfileObservation records the affiliation related information observed when fetching a GitHub profile,
+// the enrollment timeline of a unique identity is inferred from these observations.
+type ProfileObservation struct {
+	gorm.Model
+
+	UUID           string `gorm:"type:varchar(128);index:idx_profile_observation_uuid"`
+	GitHubUserID   uint   `gorm:"column:github_user_id;"`
+	AccountID      uint
+	Company        string `gorm:"type:varchar(255);"`
+	EmailDomain    string `gorm:"type:varchar(255);"`
+	IsLarkEmployee bool   `gorm:"default:0"`
+
+	// OrgID is the organization resolved from the observation, it is zero when no organization is resolved.
+	OrgID      uint
+	Source     ProfileSource `gorm:"type:varchar(32)"`
+	ObservedAt time.Time
+	// LastSeenAt is the time of the latest fetch that observed the same information.
+	LastSeenAt time.Time
+}
+
+func (ProfileObservation) TableName() string {
+	return "profile_observations"
+}
+
+// CacheEntry is the entry of the identifier cache stored in the database, the value is gob encoded.
+type CacheEntry struct {
+	CacheKey  string `gorm:"primaryKey;type:varchar(512)"`
+	Value     []byte
+	ExpireAt  *time.Time `gorm:"index:idx_identifier_cache_expire_at"`
+	UpdatedAt time.Time
+}
+
+func (CacheEntry) TableName() string {
+	return "identifier_caches"
+}
+
+type PendingOrgStatus string
+
+const (
+	PendingOrgPending  PendingOrgStatus = "pending"
+	PendingOrgApproved PendingOrgStatus = "approved"
+	PendingOrgRejected PendingOrgStatus = "rejected"
+)
+
+// PendingOrganization is the company name which can not be matched to any existing organization, it is waiting
+// for review instead of becoming an organization immediately.
+type PendingOrganization struct {
+	gorm.Model
+
+	// NormalizedName is the company name without `@`, legal suffixes, punctuation and case.
+	NormalizedName string `gorm:"type:varchar(255);not null;uniqueIndex:uniq_pending_org_normalized_name"`
+	Name           string `gorm:"type:varchar(255);not null"`
+	Occurrences    int    `gorm:"default:1"`
+
+	// SuggestedOrgID is the most similar existing organization, which is below the similarity threshold.
+	SuggestedOrgID *uint
+	Similarity     float64
+	Status         PendingOrgStatus `gorm:"type:varchar(32);default:'pending';not null"`
+
+	// OrgID is the organization which the name is approved to be mapped to.
+	OrgID *uint
+}
+
+func (PendingOrganization) TableName() string {
+	return "pending_organizations"
+}
+
+type AuditAction string
+
+const (
+	AuditOptOut AuditAction = "opt_out"
+)
+
+// IdentityAuditLog records the operations on the unique identity which must be traceable, such as opt-out.
+type IdentityAuditLog struct {
+	gorm.Model
+
+	UUID     string      `gorm:"type:varchar(128);index:idx_identity_audit_log_uuid"`
+	Action   AuditAction `gorm:"type:varchar(32);not null"`
+	Operator string      `gorm:"type:varchar(128)"`
+	Reason   string      `gorm:"type:varchar(512)"`
+	// Detail describes what has been changed, it must not contain the scrubbed PII.
+	Detail string `gorm:"type:text"`
+}
+
+func (IdentityAuditLog) TableName() string {
+	return "identity_audit_logs"
+}
+
+// HideDataRequest is the GitHub login waiting to be anonymized in the project databases by hide_data.
+type HideDataRequest struct {
+	gorm.Model
+
+	Login      string `gorm:"type:varchar(128);not null;uniqueIndex:uniq_hide_data_request_login"`
+	UUID       string `gorm:"type:varchar(128)"`
+	ExportedAt *time.Time
+}
+
+func (HideDataRequest) TableName() string {
+	return "hide_data_requests"
+}
+
+type IdentifierRunStatus string
+
+const (
+	IdentifierRunSucceeded IdentifierRunStatus = "succeeded"
+	IdentifierRunFailed    IdentifierRunStatus = "failed"
+)
+
+// IdentifierRun is the summary of an AutoImportProfile run, the full report is kept in JSON.
+type IdentifierRun struct {
+	gorm.Model
+
+	StartedAt           time.Time
+	FinishedAt          time.Time
+	Status              IdentifierRunStatus `gorm:"type:varchar(32);not null"`
+	Error               string              `gorm:"type:text"`
+	IdentitiesProcessed int
+	IdentitiesCreated   int
+	IdentitiesFailed    int
+	ProfilesUpdated     int
+	EnrollmentsOpened   int
+	EnrollmentsClosed   int
+	OrgsQueued          int
+	APIFailures         int
+	CacheHitRate        float64
+	Report              string `gorm:"type:text"`
+}
+
+func (IdentifierRun) TableName() string {
+	return "identifier_runs"
+}